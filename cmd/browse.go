@@ -0,0 +1,179 @@
+package cmd
+
+import (
+	"context"
+	"log"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/pipe"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/cli-runtime/pkg/genericiooptions"
+)
+
+// ls, stat, du and cat share the helper flags, paths are relative to the mount path.
+
+func newLsCmd(ctx context.Context, cfg *genericclioptions.ConfigFlags, streams genericiooptions.IOStreams) *cobra.Command {
+	browseOptions := dto.BrowseOpts{}
+
+	cmd := &cobra.Command{
+		Use:   "ls [path...]",
+		Short: "List files of a PVC via temporary pod",
+		Long: `
+Examples:
+
+kubectl syncpod ls pgdata \
+  --namespace vault \
+  --pvc postgresql \
+  --mount-path /var/lib/postgresql/data
+`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(_ *cobra.Command, args []string) error {
+			runOpts, err := browseRunOpts(cfg, &browseOptions, streams)
+			if err != nil {
+				return err
+			}
+			return pipe.RunLs(ctx, runOpts, args, browseOptions.Output)
+		},
+	}
+	addBrowseFlags(cmd, &browseOptions, true)
+	return cmd
+}
+
+func newStatCmd(ctx context.Context, cfg *genericclioptions.ConfigFlags, streams genericiooptions.IOStreams) *cobra.Command {
+	browseOptions := dto.BrowseOpts{}
+
+	cmd := &cobra.Command{
+		Use:   "stat <path...>",
+		Short: "Show attributes of files on a PVC via temporary pod, fails when a path does not exist",
+		Long: `
+Examples:
+
+kubectl syncpod stat pgdata/PG_VERSION \
+  --namespace vault \
+  --pvc postgresql \
+  --mount-path /var/lib/postgresql/data \
+  -o json
+`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args:          cobra.MinimumNArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			runOpts, err := browseRunOpts(cfg, &browseOptions, streams)
+			if err != nil {
+				return err
+			}
+			return pipe.RunStat(ctx, runOpts, args, browseOptions.Output)
+		},
+	}
+	addBrowseFlags(cmd, &browseOptions, true)
+	return cmd
+}
+
+func newDuCmd(ctx context.Context, cfg *genericclioptions.ConfigFlags, streams genericiooptions.IOStreams) *cobra.Command {
+	browseOptions := dto.BrowseOpts{}
+	duOptions := dto.DuOpts{}
+
+	cmd := &cobra.Command{
+		Use:   "du [path...]",
+		Short: "Show the size of directories on a PVC via temporary pod, largest first",
+		Long: `
+Examples:
+
+kubectl syncpod du \
+  --namespace vault \
+  --pvc postgresql \
+  --mount-path /var/lib/postgresql/data \
+  --depth 2 \
+  --top 10
+`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(_ *cobra.Command, args []string) error {
+			runOpts, err := browseRunOpts(cfg, &browseOptions, streams)
+			if err != nil {
+				return err
+			}
+			return pipe.RunDu(ctx, runOpts, args, &duOptions, browseOptions.Output)
+		},
+	}
+	addBrowseFlags(cmd, &browseOptions, true)
+	cmd.Flags().IntVar(&duOptions.Depth, "depth", 1, "Print directories up to this depth below each path")
+	cmd.Flags().IntVar(&duOptions.Top, "top", 0, "Print only the N largest directories (0 for all)")
+	cmd.Flags().IntVarP(&duOptions.Workers, "workers", "w", 8, "Concurrent directory readers")
+	return cmd
+}
+
+func newCatCmd(ctx context.Context, cfg *genericclioptions.ConfigFlags, streams genericiooptions.IOStreams) *cobra.Command {
+	browseOptions := dto.BrowseOpts{}
+
+	cmd := &cobra.Command{
+		Use:   "cat <path...>",
+		Short: "Print files of a PVC via temporary pod",
+		Long: `
+Examples:
+
+kubectl syncpod cat pgdata/PG_VERSION \
+  --namespace vault \
+  --pvc postgresql \
+  --mount-path /var/lib/postgresql/data
+`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args:          cobra.MinimumNArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			runOpts, err := browseRunOpts(cfg, &browseOptions, streams)
+			if err != nil {
+				return err
+			}
+			return pipe.RunCat(ctx, runOpts, args)
+		},
+	}
+	addBrowseFlags(cmd, &browseOptions, false)
+	return cmd
+}
+
+func addBrowseFlags(cmd *cobra.Command, browseOptions *dto.BrowseOpts, withOutput bool) {
+	cmd.Flags().StringVar(&browseOptions.MountPath, "mount-path", "", "Mount path inside helper pod")
+	cmd.Flags().StringVar(&browseOptions.PVC, "pvc", "", "PVC name")
+	addTransportFlag(cmd, &browseOptions.Transport)
+	cmd.Flags().StringVar(&browseOptions.AttachPod, "attach-pod", "", "Attach to a running pod via an ephemeral container instead of mounting the PVC (implies exec transport)")
+	cmd.Flags().StringVar(&browseOptions.Container, "container", "", "Container of --attach-pod whose volume mounts are shared (default: first container)")
+	if withOutput {
+		cmd.Flags().StringVarP(&browseOptions.Output, "output", "o", dto.OutputTable, "Output format (table, json)")
+	}
+
+	if err := cmd.MarkFlagRequired("mount-path"); err != nil {
+		log.Fatal(err)
+	}
+	cmd.MarkFlagsOneRequired("pvc", "attach-pod")
+	cmd.MarkFlagsMutuallyExclusive("pvc", "attach-pod")
+}
+
+func browseRunOpts(cfg *genericclioptions.ConfigFlags, browseOptions *dto.BrowseOpts, streams genericiooptions.IOStreams) (*dto.RunOpts, error) {
+	// cat has no --output
+	if browseOptions.Output != "" {
+		if err := pipe.ValidateOutput(browseOptions.Output); err != nil {
+			return nil, err
+		}
+	}
+	browseOptions.Namespace = kub.ResolveNamespace(cfg)
+	browseOptions.KubeContext = kub.ResolveKubeContext(cfg)
+	return &dto.RunOpts{
+		PVC:         browseOptions.PVC,
+		Namespace:   browseOptions.Namespace,
+		KubeContext: browseOptions.KubeContext,
+		Remote:      ".",
+		MountPath:   browseOptions.MountPath,
+		ObjName:     kub.NewObjName(),
+		Transport:   browseOptions.Transport,
+		AttachPod:   browseOptions.AttachPod,
+		Container:   browseOptions.Container,
+		Out:         streams.Out,
+	}, nil
+}
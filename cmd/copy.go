@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"context"
+	"log"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/pipe"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/cli-runtime/pkg/genericiooptions"
+)
+
+func newCopyCmd(ctx context.Context, cfg *genericclioptions.ConfigFlags, _ genericiooptions.IOStreams) *cobra.Command {
+	copyOptions := dto.CopyOpts{}
+	bwFlags := bwLimitFlags{}
+
+	cmd := &cobra.Command{
+		Use:   "copy",
+		Short: "Copy files from one PVC to another, in-cluster",
+		Long: `
+Examples:
+
+kubectl syncpod copy \
+  --from-namespace prod \
+  --from-pvc data-postgres-0 \
+  --to-namespace staging \
+  --to-pvc data-postgres-0 \
+  --src pgdata \
+  --dst pgdata
+`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args:          cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			namespace := kub.ResolveNamespace(cfg)
+			copyOptions.KubeContext = kub.ResolveKubeContext(cfg)
+			if copyOptions.FromNamespace == "" {
+				copyOptions.FromNamespace = namespace
+			}
+			if copyOptions.ToNamespace == "" {
+				copyOptions.ToNamespace = namespace
+			}
+			limiter, stopBWLimit, err := bwFlags.start(ctx)
+			if err != nil {
+				return err
+			}
+			defer stopBWLimit()
+			copyOptions.Limiter = limiter
+			return pipe.RunCopy(ctx, &copyOptions)
+		},
+	}
+
+	cmd.Flags().StringVar(&copyOptions.FromPVC, "from-pvc", "", "Source PVC name")
+	cmd.Flags().StringVar(&copyOptions.ToPVC, "to-pvc", "", "Destination PVC name")
+	cmd.Flags().StringVar(&copyOptions.FromNamespace, "from-namespace", "", "Namespace of the source PVC (default: --namespace)")
+	cmd.Flags().StringVar(&copyOptions.ToNamespace, "to-namespace", "", "Namespace of the destination PVC (default: --namespace)")
+	cmd.Flags().StringVar(&copyOptions.Src, "src", ".", "Source path inside the source PVC")
+	cmd.Flags().StringVar(&copyOptions.Dst, "dst", ".", "Destination path inside the destination PVC")
+	cmd.Flags().IntVarP(&copyOptions.Workers, "workers", "w", 4, "Concurrent file workers (relay mode)")
+	cmd.Flags().BoolVar(&copyOptions.AllowOverwrite, "allow-overwrite", false, "Allow overwrite of existing destination")
+	cmd.Flags().StringVar(&copyOptions.Owner, "owner", "", "Optional owner (uid:gid or user:group)")
+	addTransportFlag(cmd, &copyOptions.Transport)
+	cmd.Flags().BoolVar(&copyOptions.Relay, "relay", false, "Relay data through the CLI (two SFTP sessions) instead of pulling in-cluster")
+	cmd.Flags().BoolVar(&copyOptions.Verify, "verify", false, "Compare the copy with the source by checksum, fails when they differ")
+	addBWLimitFlags(cmd, &bwFlags)
+
+	for _, rf := range []string{"from-pvc", "to-pvc"} {
+		if err := cmd.MarkFlagRequired(rf); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	return cmd
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"context"
+	"log"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/pipe"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/cli-runtime/pkg/genericiooptions"
+)
+
+func newDiffCmd(ctx context.Context, cfg *genericclioptions.ConfigFlags, streams genericiooptions.IOStreams) *cobra.Command {
+	browseOptions := dto.BrowseOpts{}
+	diffOptions := dto.DiffOpts{}
+
+	cmd := &cobra.Command{
+		Use:   "diff",
+		Short: "Compare a local directory with a directory of a PVC, fails when they differ",
+		Long: `
+Exits with status 2 when the trees differ, and 1 on any other failure.
+
+Examples:
+
+kubectl syncpod diff \
+  --namespace vault \
+  --pvc postgresql \
+  --mount-path /var/lib/postgresql/data \
+  --src pgdata \
+  --local ./backup \
+  --checksum
+`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args:          cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			runOpts, err := browseRunOpts(cfg, &browseOptions, streams)
+			if err != nil {
+				return err
+			}
+			return pipe.RunDiff(ctx, runOpts, &diffOptions, browseOptions.Output)
+		},
+	}
+	addBrowseFlags(cmd, &browseOptions, true)
+	cmd.Flags().StringVar(&diffOptions.Src, "src", "", "Source path inside mount")
+	cmd.Flags().StringVar(&diffOptions.Local, "local", "", "Local directory to compare with")
+	cmd.Flags().BoolVar(&diffOptions.Checksum, "checksum", false, "Compare the contents (sha256) of files of the same size, instead of their mtime")
+	cmd.Flags().IntVarP(&diffOptions.Workers, "workers", "w", 4, "Concurrent local file hashers")
+
+	if err := cmd.MarkFlagRequired("local"); err != nil {
+		log.Fatal(err)
+	}
+	return cmd
+}
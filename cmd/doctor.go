@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/pipe"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/cli-runtime/pkg/genericiooptions"
+)
+
+func newDoctorCmd(ctx context.Context, cfg *genericclioptions.ConfigFlags, streams genericiooptions.IOStreams) *cobra.Command {
+	doctorOptions := dto.DoctorOpts{}
+
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check the permissions, the helper image and NodePort reachability before a transfer",
+		Long: `
+Examples:
+
+kubectl syncpod doctor --namespace vault
+
+# start the test helper on the node of the PVC
+kubectl syncpod doctor --namespace vault --pvc data-vault-0
+`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args:          cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			if err := pipe.ValidateOutput(doctorOptions.Output); err != nil {
+				return err
+			}
+			if doctorOptions.Transport != dto.TransportNodePort && doctorOptions.Transport != dto.TransportExec {
+				return fmt.Errorf("unknown transport: %s", doctorOptions.Transport)
+			}
+			doctorOptions.Namespace = kub.ResolveNamespace(cfg)
+			doctorOptions.KubeContext = kub.ResolveKubeContext(cfg)
+			doctorOptions.Out = streams.Out
+			return pipe.RunDoctor(ctx, &doctorOptions)
+		},
+	}
+
+	cmd.Flags().StringVar(&doctorOptions.PVC, "pvc", "", "Check the helper image and the NodePort on the node of this PVC")
+	addTransportFlag(cmd, &doctorOptions.Transport)
+	cmd.Flags().DurationVar(&doctorOptions.Timeout, "timeout", 2*time.Minute, "How long to wait for the helper image to be pulled")
+	cmd.Flags().StringVarP(&doctorOptions.Output, "output", "o", dto.OutputTable, "Output format (table, json)")
+	return cmd
+}
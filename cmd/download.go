@@ -15,8 +15,10 @@ import (
 	"k8s.io/cli-runtime/pkg/genericiooptions"
 )
 
-func newDownloadCmd(ctx context.Context, cfg *genericclioptions.ConfigFlags, _ genericiooptions.IOStreams) *cobra.Command {
+func newDownloadCmd(ctx context.Context, cfg *genericclioptions.ConfigFlags, streams genericiooptions.IOStreams) *cobra.Command {
 	downloadOptions := dto.DownloadOpts{}
+	bwFlags := bwLimitFlags{}
+	dryRun := dryRunFlags{}
 
 	cmd := &cobra.Command{
 		Use:   "download",
@@ -30,21 +32,59 @@ kubectl syncpod download \
   --mount-path /var/lib/postgresql/data \
   --src pgdata \
   --dst backups
+
+kubectl syncpod download \
+  --namespace vault \
+  --pvc postgresql \
+  --mount-path /var/lib/postgresql/data \
+  --src pgdata \
+  --dst - | zstd > pgdata.tar.zst
 `,
 		SilenceUsage:  true,
 		SilenceErrors: true,
+		Args:          cobra.NoArgs,
 		RunE: func(_ *cobra.Command, _ []string) error {
 			downloadOptions.Namespace = kub.ResolveNamespace(cfg)
-			return pipe.Run(ctx, &dto.RunOpts{
-				Mode:      "download",
-				PVC:       downloadOptions.PVC,
-				Namespace: downloadOptions.Namespace,
-				Remote:    downloadOptions.Src,
-				Local:     downloadOptions.Dst,
-				MountPath: downloadOptions.MountPath,
-				Workers:   downloadOptions.Workers,
-				ObjName:   kub.NewObjName(),
-			})
+			downloadOptions.KubeContext = kub.ResolveKubeContext(cfg)
+			if downloadOptions.Dst == "-" && downloadOptions.Format == "" {
+				downloadOptions.Format = dto.FormatTar
+			}
+			if err := pipe.ValidateFormat(downloadOptions.Format); err != nil {
+				return err
+			}
+			if err := pipe.ValidateDryRun(dryRun.mode, dryRun.output, nil); err != nil {
+				return err
+			}
+			runOpts := &dto.RunOpts{
+				Mode:        "download",
+				PVC:         downloadOptions.PVC,
+				Namespace:   downloadOptions.Namespace,
+				KubeContext: downloadOptions.KubeContext,
+				Remote:      downloadOptions.Src,
+				Local:       downloadOptions.Dst,
+				MountPath:   downloadOptions.MountPath,
+				Workers:     downloadOptions.Workers,
+				ObjName:     kub.NewObjName(),
+				Transport:   downloadOptions.Transport,
+				AttachPod:   downloadOptions.AttachPod,
+				Container:   downloadOptions.Container,
+				Format:      downloadOptions.Format,
+				Compress:    downloadOptions.Compress,
+				In:          streams.In,
+				Out:         streams.Out,
+			}
+			if dryRun.enabled() {
+				plan, err := pipe.PlanRun(ctx, runOpts, dryRun.mode)
+				return dryRun.print(streams.Out, plan, err)
+			}
+
+			limiter, stopBWLimit, err := bwFlags.start(ctx)
+			if err != nil {
+				return err
+			}
+			defer stopBWLimit()
+			runOpts.Limiter = limiter
+			return pipe.Run(ctx, runOpts)
 		},
 	}
 
@@ -52,13 +92,22 @@ kubectl syncpod download \
 	cmd.Flags().StringVar(&downloadOptions.MountPath, "mount-path", "", "Mount path inside helper pod")
 	cmd.Flags().StringVar(&downloadOptions.PVC, "pvc", "", "PVC name")
 	cmd.Flags().StringVar(&downloadOptions.Src, "src", "", "Source path inside mount")
-	cmd.Flags().StringVar(&downloadOptions.Dst, "dst", "", "Local destination path")
+	cmd.Flags().StringVar(&downloadOptions.Dst, "dst", "", "Local destination path (an archive file with --format, \"-\" for stdout)")
+	cmd.Flags().StringVar(&downloadOptions.Format, "format", "", "Write a single archive instead of a directory tree (tar, tar.gz, tar.zst)")
+	addTransportFlag(cmd, &downloadOptions.Transport)
+	addCompressFlag(cmd, &downloadOptions.Compress)
+	addBWLimitFlags(cmd, &bwFlags)
+	addDryRunFlags(cmd, &dryRun)
+	cmd.Flags().StringVar(&downloadOptions.AttachPod, "attach-pod", "", "Attach to a running pod via an ephemeral container instead of mounting the PVC (implies exec transport)")
+	cmd.Flags().StringVar(&downloadOptions.Container, "container", "", "Container of --attach-pod whose volume mounts are shared (default: first container)")
 
-	for _, rf := range []string{"mount-path", "pvc", "src", "dst"} {
+	for _, rf := range []string{"mount-path", "src", "dst"} {
 		if err := cmd.MarkFlagRequired(rf); err != nil {
 			log.Fatal(err)
 		}
 	}
+	cmd.MarkFlagsOneRequired("pvc", "attach-pod")
+	cmd.MarkFlagsMutuallyExclusive("pvc", "attach-pod")
 
 	return cmd
 }
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/pipe"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/cli-runtime/pkg/genericiooptions"
+)
+
+func newDownloadNSCmd(ctx context.Context, cfg *genericclioptions.ConfigFlags, _ genericiooptions.IOStreams) *cobra.Command {
+	downloadNSOptions := dto.DownloadNSOpts{}
+	bwFlags := bwLimitFlags{}
+
+	cmd := &cobra.Command{
+		Use:   "download-ns",
+		Short: "Download all bound PVCs of a namespace",
+		Long: `
+Examples:
+
+kubectl syncpod download-ns \
+  --namespace vault \
+  --dst ./backup
+`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args:          cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			downloadNSOptions.Namespace = kub.ResolveNamespace(cfg)
+			downloadNSOptions.KubeContext = kub.ResolveKubeContext(cfg)
+			limiter, stopBWLimit, err := bwFlags.start(ctx)
+			if err != nil {
+				return err
+			}
+			defer stopBWLimit()
+			downloadNSOptions.Limiter = limiter
+			return pipe.RunDownloadNS(ctx, &downloadNSOptions)
+		},
+	}
+
+	cmd.Flags().StringVar(&downloadNSOptions.Dst, "dst", "", "Local destination root")
+	cmd.Flags().IntVar(&downloadNSOptions.VolumeWorkers, "volume-workers", 2, "Concurrent PVC download jobs")
+	cmd.Flags().IntVar(&downloadNSOptions.FileWorkers, "file-workers", 2, "Concurrent file workers per PVC")
+	addTransportFlag(cmd, &downloadNSOptions.Transport)
+	addCompressFlag(cmd, &downloadNSOptions.Compress)
+	addBWLimitFlags(cmd, &bwFlags)
+	//nolint:errcheck
+	_ = cmd.MarkFlagRequired("dst")
+
+	return cmd
+}
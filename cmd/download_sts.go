@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"context"
+	"time"
 
 	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
 
@@ -13,8 +14,10 @@ import (
 	"k8s.io/cli-runtime/pkg/genericiooptions"
 )
 
-func newDownloadSTSCmd(ctx context.Context, cfg *genericclioptions.ConfigFlags, _ genericiooptions.IOStreams) *cobra.Command {
+func newDownloadSTSCmd(ctx context.Context, cfg *genericclioptions.ConfigFlags, streams genericiooptions.IOStreams) *cobra.Command {
 	downloadSTSOptions := dto.DownloadSTSOpts{}
+	bwFlags := bwLimitFlags{}
+	dryRun := dryRunFlags{}
 
 	cmd := &cobra.Command{
 		Use:   "download-sts",
@@ -25,22 +28,64 @@ Examples:
 kubectl syncpod download-sts rabbitmq \
   --namespace mq \
   --dst ./backup
+
+kubectl syncpod download-sts rabbitmq \
+  --namespace mq \
+  --archive ./rabbitmq.tar.zst
+
+# only the data volume of rabbitmq-0
+kubectl syncpod download-sts rabbitmq \
+  --namespace mq \
+  --ordinals 0 \
+  --volumes data \
+  --dst ./backup
 `,
 		SilenceUsage:  true,
 		SilenceErrors: true,
 		Args:          cobra.ExactArgs(1),
 		RunE: func(_ *cobra.Command, args []string) error {
 			downloadSTSOptions.Namespace = kub.ResolveNamespace(cfg)
+			downloadSTSOptions.KubeContext = kub.ResolveKubeContext(cfg)
 			downloadSTSOptions.StsName = args[0]
+			if downloadSTSOptions.Archive != "" {
+				if _, err := pipe.ArchiveFormatFromPath(downloadSTSOptions.Archive); err != nil {
+					return err
+				}
+			}
+			if err := pipe.ValidateDryRun(dryRun.mode, dryRun.output, args); err != nil {
+				return err
+			}
+			if dryRun.enabled() {
+				plan, err := pipe.PlanDownloadSTS(ctx, &downloadSTSOptions, dryRun.mode)
+				return dryRun.print(streams.Out, plan, err)
+			}
+
+			limiter, stopBWLimit, err := bwFlags.start(ctx)
+			if err != nil {
+				return err
+			}
+			defer stopBWLimit()
+			downloadSTSOptions.Limiter = limiter
 			return pipe.RunDownloadSTS(ctx, &downloadSTSOptions)
 		},
 	}
 
 	cmd.Flags().StringVar(&downloadSTSOptions.Dst, "dst", "", "Local destination root")
+	cmd.Flags().StringVar(&downloadSTSOptions.Archive, "archive", "", "Write a single backup archive instead (.tar, .tar.gz, .tar.zst)")
 	cmd.Flags().IntVar(&downloadSTSOptions.VolumeWorkers, "volume-workers", 2, "Concurrent PVC download jobs")
 	cmd.Flags().IntVar(&downloadSTSOptions.FileWorkers, "file-workers", 2, "Concurrent file workers per PVC")
-	//nolint:errcheck
-	_ = cmd.MarkFlagRequired("dst")
+	cmd.Flags().BoolVar(&downloadSTSOptions.Quiesce, "quiesce", false, "Scale the StatefulSet to zero during the download, and restore replicas afterwards")
+	cmd.Flags().DurationVar(&downloadSTSOptions.QuiesceTimeout, "quiesce-timeout", 5*time.Minute, "How long to wait for pods to terminate after scale-down")
+	addTransportFlag(cmd, &downloadSTSOptions.Transport)
+	cmd.Flags().IntSliceVar(&downloadSTSOptions.Selection.Ordinals, "ordinals", nil, "Only back up the pods with these ordinals, e.g. 0,2")
+	cmd.Flags().StringSliceVar(&downloadSTSOptions.Selection.Pods, "pods", nil, "Only back up these pods, e.g. rabbitmq-0")
+	cmd.Flags().StringSliceVar(&downloadSTSOptions.Selection.Volumes, "volumes", nil, "Only back up these volumes (names in the pod spec)")
+	cmd.Flags().StringSliceVar(&downloadSTSOptions.Selection.ExcludeVolumes, "exclude-volumes", nil, "Do not back up these volumes (names in the pod spec)")
+	addCompressFlag(cmd, &downloadSTSOptions.Compress)
+	addBWLimitFlags(cmd, &bwFlags)
+	addDryRunFlags(cmd, &dryRun)
+	cmd.MarkFlagsOneRequired("dst", "archive")
+	cmd.MarkFlagsMutuallyExclusive("dst", "archive")
 
 	return cmd
 }
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/pipe"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/cli-runtime/pkg/genericiooptions"
+)
+
+func newDownloadWorkloadCmd(ctx context.Context, cfg *genericclioptions.ConfigFlags, _ genericiooptions.IOStreams) *cobra.Command {
+	downloadWorkloadOptions := dto.DownloadWorkloadOpts{}
+	bwFlags := bwLimitFlags{}
+
+	cmd := &cobra.Command{
+		Use:   "download-workload (KIND/NAME | -l SELECTOR)",
+		Short: "Download all PVC-backed volumes of a workload, or all PVCs matching a label selector",
+		Long: `
+Supported kinds: sts, deploy, ds, cj, pod.
+
+Examples:
+
+kubectl syncpod download-workload deploy/grafana \
+  --namespace monitoring \
+  --dst ./backup
+
+kubectl syncpod download-workload -l backup=true \
+  --namespace apps \
+  --dst ./backup
+`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args:          cobra.MaximumNArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			if len(args) == 0 && downloadWorkloadOptions.Selector == "" {
+				return fmt.Errorf("either KIND/NAME or --selector is required")
+			}
+			downloadWorkloadOptions.Namespace = kub.ResolveNamespace(cfg)
+			downloadWorkloadOptions.KubeContext = kub.ResolveKubeContext(cfg)
+			if len(args) > 0 {
+				downloadWorkloadOptions.Workload = args[0]
+			}
+			limiter, stopBWLimit, err := bwFlags.start(ctx)
+			if err != nil {
+				return err
+			}
+			defer stopBWLimit()
+			downloadWorkloadOptions.Limiter = limiter
+			return pipe.RunDownloadWorkload(ctx, &downloadWorkloadOptions)
+		},
+	}
+
+	cmd.Flags().StringVarP(&downloadWorkloadOptions.Selector, "selector", "l", "", "PVC label selector, e.g. backup=true")
+	cmd.Flags().StringVar(&downloadWorkloadOptions.Dst, "dst", "", "Local destination root")
+	cmd.Flags().IntVar(&downloadWorkloadOptions.VolumeWorkers, "volume-workers", 2, "Concurrent PVC download jobs")
+	cmd.Flags().IntVar(&downloadWorkloadOptions.FileWorkers, "file-workers", 2, "Concurrent file workers per PVC")
+	addTransportFlag(cmd, &downloadWorkloadOptions.Transport)
+	addCompressFlag(cmd, &downloadWorkloadOptions.Compress)
+	addBWLimitFlags(cmd, &bwFlags)
+	//nolint:errcheck
+	_ = cmd.MarkFlagRequired("dst")
+
+	return cmd
+}
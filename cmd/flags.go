@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"log/slog"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/bwlimit"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/pipe"
+
+	"github.com/spf13/cobra"
+)
+
+// addCompressFlag registers --compress, a bare --compress means "on".
+func addCompressFlag(cmd *cobra.Command, p *string) {
+	cmd.Flags().StringVar(p, "compress", dto.CompressOff, "Compress file contents on the wire (off, on, auto: skip small and already compressed files)")
+	cmd.Flags().Lookup("compress").NoOptDefVal = dto.CompressOn
+}
+
+type bwLimitFlags struct {
+	rate   string
+	socket string
+}
+
+func addBWLimitFlags(cmd *cobra.Command, f *bwLimitFlags) {
+	cmd.Flags().StringVar(&f.rate, "bwlimit", "", "Limit the total bandwidth of all workers, e.g. 50MiB/s (while running: SIGUSR1 halves, SIGUSR2 doubles)")
+	cmd.Flags().StringVar(&f.socket, "bwlimit-socket", "", "Unix socket to change --bwlimit while running (echo 20MiB/s | nc -U <path>)")
+}
+
+// start returns a nil limiter when nothing is limited, and nothing can be changed while running.
+func (f *bwLimitFlags) start(ctx context.Context) (*bwlimit.Limiter, func(), error) {
+	bps, err := bwlimit.ParseRate(f.rate)
+	if err != nil {
+		return nil, nil, err
+	}
+	if bps == 0 && f.socket == "" {
+		return nil, func() {}, nil
+	}
+
+	limiter := bwlimit.New(bps)
+	if bps > 0 {
+		slog.Info("bandwidth is limited", slog.String("bwlimit", bwlimit.FormatRate(bps)))
+	}
+	bwlimit.WatchSignals(ctx, limiter)
+
+	stop := func() {}
+	if f.socket != "" {
+		stop, err = bwlimit.ServeControl(ctx, limiter, f.socket)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return limiter, stop, nil
+}
+
+type dryRunFlags struct {
+	mode   string
+	output string
+}
+
+// addTransportFlag registers --transport, how the CLI reaches its helper pods.
+func addTransportFlag(cmd *cobra.Command, p *string) {
+	cmd.Flags().StringVar(p, "transport", dto.TransportNodePort, "How to reach the helper pods (nodeport, exec)")
+}
+
+// addDryRunFlags registers --dry-run and --output, a bare --dry-run means "client".
+func addDryRunFlags(cmd *cobra.Command, f *dryRunFlags) {
+	cmd.Flags().StringVar(&f.mode, "dry-run", dto.DryRunNone, "Only print what would be transferred (--dry-run=client: no changes in the cluster, --dry-run=server: also start the helpers to inspect the remote side)")
+	cmd.Flags().Lookup("dry-run").NoOptDefVal = dto.DryRunClient
+	cmd.Flags().StringVarP(&f.output, "output", "o", dto.OutputTable, "Output format of --dry-run (table, json)")
+}
+
+func (f *dryRunFlags) enabled() bool {
+	return f.mode != dto.DryRunNone
+}
+
+// print writes the plan, a failed plan is printed as far as it got.
+func (f *dryRunFlags) print(w io.Writer, plan *dto.Plan, err error) error {
+	if plan != nil {
+		if printErr := pipe.PrintPlan(w, plan, f.output); printErr != nil {
+			return printErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/pipe"
+
+	"github.com/spf13/cobra"
+)
+
+func newManifestCmd(ctx context.Context) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "manifest",
+		Short: "Work with the manifests of backups",
+	}
+	cmd.AddCommand(newManifestUpgradeCmd(ctx))
+	return cmd
+}
+
+func newManifestUpgradeCmd(ctx context.Context) *cobra.Command {
+	var out string
+	var workers int
+
+	cmd := &cobra.Command{
+		Use:   "upgrade <backup-dir|manifest.json>",
+		Short: "Rewrite a backup manifest in the current version",
+		Long: `
+Volumes without a file inventory in the manifest are hashed in the backup directory (next to
+manifest.json) to build one, byte totals, file counts and digests are computed from the
+inventories. Specs of the claims are not known offline and stay empty.
+
+Examples:
+
+kubectl syncpod manifest upgrade ./backup
+`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args:          cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			return pipe.UpgradeManifest(ctx, args[0], out, workers)
+		},
+	}
+	cmd.Flags().StringVar(&out, "out", "", "Write the upgraded manifest to this path instead of in place")
+	cmd.Flags().IntVar(&workers, "file-workers", 4, "Concurrent local file hashers")
+	return cmd
+}
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"context"
+	"log"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/pipe"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/cli-runtime/pkg/genericiooptions"
+)
+
+func newMigrateCmd(ctx context.Context, cfg *genericclioptions.ConfigFlags, _ genericiooptions.IOStreams) *cobra.Command {
+	migrateOptions := dto.MigrateOpts{}
+
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Migrate PVC contents between clusters",
+		Long: `
+Examples:
+
+kubectl syncpod migrate \
+  --from-context old-cluster \
+  --to-context new-cluster \
+  --namespace mq \
+  --sts rabbitmq \
+  --storage-class fast-ssd
+
+kubectl syncpod migrate \
+  --from-context old-cluster \
+  --to-context new-cluster \
+  --namespace vault \
+  --pvc postgresql
+`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args:          cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			namespace := kub.ResolveNamespace(cfg)
+			if migrateOptions.FromNamespace == "" {
+				migrateOptions.FromNamespace = namespace
+			}
+			if migrateOptions.ToNamespace == "" {
+				migrateOptions.ToNamespace = namespace
+			}
+			kubeContext := kub.ResolveKubeContext(cfg)
+			if migrateOptions.FromContext == "" {
+				migrateOptions.FromContext = kubeContext
+			}
+			if migrateOptions.ToContext == "" {
+				migrateOptions.ToContext = kubeContext
+			}
+			return pipe.RunMigrate(ctx, &migrateOptions)
+		},
+	}
+
+	cmd.Flags().StringVar(&migrateOptions.FromContext, "from-context", "", "Kubeconfig context of the source cluster (default: --context)")
+	cmd.Flags().StringVar(&migrateOptions.ToContext, "to-context", "", "Kubeconfig context of the destination cluster (default: --context)")
+	cmd.Flags().StringVar(&migrateOptions.FromNamespace, "from-namespace", "", "Source namespace (default: --namespace)")
+	cmd.Flags().StringVar(&migrateOptions.ToNamespace, "to-namespace", "", "Destination namespace (default: --namespace)")
+	cmd.Flags().StringVar(&migrateOptions.PVC, "pvc", "", "Source PVC name")
+	cmd.Flags().StringVar(&migrateOptions.StsName, "sts", "", "Source StatefulSet name, all its PVCs are migrated")
+	cmd.Flags().StringVar(&migrateOptions.StorageClass, "storage-class", "", "Storage class of created destination PVCs (default: same as source)")
+	cmd.Flags().IntVar(&migrateOptions.VolumeWorkers, "volume-workers", 1, "Concurrent PVC migration jobs")
+	cmd.Flags().IntVar(&migrateOptions.FileWorkers, "file-workers", 4, "Concurrent file workers per PVC")
+	cmd.Flags().BoolVar(&migrateOptions.AllowOverwrite, "allow-overwrite", false, "Allow overwrite of existing destination files")
+	cmd.Flags().StringVar(&migrateOptions.Owner, "owner", "", "Optional owner (uid:gid or user:group)")
+	addTransportFlag(cmd, &migrateOptions.Transport)
+
+	for _, rf := range []string{"from-context", "to-context"} {
+		if err := cmd.MarkFlagRequired(rf); err != nil {
+			log.Fatal(err)
+		}
+	}
+	cmd.MarkFlagsOneRequired("pvc", "sts")
+	cmd.MarkFlagsMutuallyExclusive("pvc", "sts")
+
+	return cmd
+}
@@ -2,15 +2,29 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"flag"
 
 	"github.com/hashmap-kz/kubectl-syncpod/internal/logger"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/pipe"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/version"
 
 	"github.com/spf13/cobra"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/cli-runtime/pkg/genericiooptions"
 )
 
+// ExitDiffer is the exit status of diff when the trees differ, other failures exit with 1.
+const ExitDiffer = 2
+
+// ExitCode returns the exit status for an error returned by the root command.
+func ExitCode(err error) int {
+	if errors.Is(err, pipe.ErrTreesDiffer) {
+		return ExitDiffer
+	}
+	return 1
+}
+
 func NewRootCmd(ctx context.Context, streams genericiooptions.IOStreams) *cobra.Command {
 	cfg := genericclioptions.NewConfigFlags(true)
 	logOpts := logger.Opts{}
@@ -19,12 +33,14 @@ func NewRootCmd(ctx context.Context, streams genericiooptions.IOStreams) *cobra.
 		Use:          "kubectl syncpod",
 		Short:        "Download/Upload files from a PVC via temporary pod",
 		SilenceUsage: true,
+		Version:      version.String(),
 		PersistentPreRun: func(_ *cobra.Command, _ []string) {
 			logger.Init(&logOpts)
 		},
 	}
 
 	rootCmd.CompletionOptions.DisableDefaultCmd = true
+	rootCmd.SetVersionTemplate("kubectl-syncpod {{.Version}}\n")
 	rootCmd.SetHelpCommand(&cobra.Command{
 		Use:    "no-help",
 		Hidden: true,
@@ -40,5 +56,21 @@ func NewRootCmd(ctx context.Context, streams genericiooptions.IOStreams) *cobra.
 	rootCmd.AddCommand(newUploadCmd(ctx, cfg, streams))
 	rootCmd.AddCommand(newDownloadSTSCmd(ctx, cfg, streams))
 	rootCmd.AddCommand(newUploadSTSCmd(ctx, cfg, streams))
+	rootCmd.AddCommand(newDownloadWorkloadCmd(ctx, cfg, streams))
+	rootCmd.AddCommand(newUploadWorkloadCmd(ctx, cfg, streams))
+	rootCmd.AddCommand(newDownloadNSCmd(ctx, cfg, streams))
+	rootCmd.AddCommand(newUploadNSCmd(ctx, cfg, streams))
+	rootCmd.AddCommand(newCopyCmd(ctx, cfg, streams))
+	rootCmd.AddCommand(newMigrateCmd(ctx, cfg, streams))
+	rootCmd.AddCommand(newShellCmd(ctx, cfg, streams))
+	rootCmd.AddCommand(newServeCmd(ctx, cfg, streams))
+	rootCmd.AddCommand(newLsCmd(ctx, cfg, streams))
+	rootCmd.AddCommand(newStatCmd(ctx, cfg, streams))
+	rootCmd.AddCommand(newDuCmd(ctx, cfg, streams))
+	rootCmd.AddCommand(newCatCmd(ctx, cfg, streams))
+	rootCmd.AddCommand(newDiffCmd(ctx, cfg, streams))
+	rootCmd.AddCommand(newVerifyCmd(ctx, cfg, streams))
+	rootCmd.AddCommand(newManifestCmd(ctx))
+	rootCmd.AddCommand(newDoctorCmd(ctx, cfg, streams))
 	return rootCmd
 }
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/pipe"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/cli-runtime/pkg/genericiooptions"
+)
+
+func newServeCmd(ctx context.Context, cfg *genericclioptions.ConfigFlags, streams genericiooptions.IOStreams) *cobra.Command {
+	serveOptions := dto.ServeOpts{}
+
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve a PVC on a local SSH/SFTP endpoint for third-party tools",
+		Long: `
+Examples:
+
+kubectl syncpod serve \
+  --namespace vault \
+  --pvc postgresql \
+  --mount-path /var/lib/postgresql/data \
+  --listen 127.0.0.1:2222
+
+kubectl syncpod serve \
+  --namespace vault \
+  --pvc postgresql \
+  --mount-path /var/lib/postgresql/data \
+  --authorized-keys ~/.ssh/id_ed25519.pub \
+  --rsync
+
+rsync -av -e "ssh -p 2222" ./pgdata/ root@127.0.0.1:/var/lib/postgresql/data/pgdata/
+`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args:          cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			serveOptions.Namespace = kub.ResolveNamespace(cfg)
+			serveOptions.KubeContext = kub.ResolveKubeContext(cfg)
+			objName := kub.NewObjName()
+			if serveOptions.AuthorizedKeys == "" && serveOptions.IdentityFile == "" {
+				serveOptions.IdentityFile = filepath.Join(os.TempDir(), objName+".key")
+			}
+			return pipe.RunServe(ctx, &dto.RunOpts{
+				Mode:        "serve",
+				PVC:         serveOptions.PVC,
+				Namespace:   serveOptions.Namespace,
+				KubeContext: serveOptions.KubeContext,
+				Remote:      ".",
+				MountPath:   serveOptions.MountPath,
+				ObjName:     objName,
+				Transport:   serveOptions.Transport,
+				AttachPod:   serveOptions.AttachPod,
+				Container:   serveOptions.Container,
+				Out:         streams.Out,
+			}, &serveOptions)
+		},
+	}
+
+	cmd.Flags().StringVar(&serveOptions.MountPath, "mount-path", "", "Mount path inside helper pod")
+	cmd.Flags().StringVar(&serveOptions.PVC, "pvc", "", "PVC name")
+	addTransportFlag(cmd, &serveOptions.Transport)
+	cmd.Flags().StringVar(&serveOptions.AttachPod, "attach-pod", "", "Attach to a running pod via an ephemeral container instead of mounting the PVC (implies exec transport)")
+	cmd.Flags().StringVar(&serveOptions.Container, "container", "", "Container of --attach-pod whose volume mounts are shared (default: first container)")
+	cmd.Flags().StringVar(&serveOptions.Listen, "listen", "127.0.0.1:2222", "Local address of the SSH/SFTP endpoint")
+	cmd.Flags().StringVar(&serveOptions.AuthorizedKeys, "authorized-keys", "", "Public keys allowed to connect (authorized_keys format), a key pair is generated when empty")
+	cmd.Flags().StringVar(&serveOptions.IdentityFile, "identity-file", "", "Where to write the generated private key (default: a temp file, removed on exit)")
+	cmd.Flags().StringVar(&serveOptions.HostKey, "host-key", "", "Host key file, generated on first use, so clients can trust it between runs")
+	cmd.Flags().DurationVar(&serveOptions.IdleTimeout, "idle-timeout", 30*time.Minute, "Stop when no client was connected for this long (0 to run until interrupted)")
+	cmd.Flags().BoolVar(&serveOptions.InstallRsync, "rsync", false, "Install rsync in the helper, for rsync -e ssh")
+
+	if err := cmd.MarkFlagRequired("mount-path"); err != nil {
+		log.Fatal(err)
+	}
+	cmd.MarkFlagsOneRequired("pvc", "attach-pod")
+	cmd.MarkFlagsMutuallyExclusive("pvc", "attach-pod")
+	cmd.MarkFlagsMutuallyExclusive("authorized-keys", "identity-file")
+
+	return cmd
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"context"
+	"log"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/pipe"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/cli-runtime/pkg/genericiooptions"
+)
+
+func newShellCmd(ctx context.Context, cfg *genericclioptions.ConfigFlags, streams genericiooptions.IOStreams) *cobra.Command {
+	shellOptions := dto.ShellOpts{}
+
+	cmd := &cobra.Command{
+		Use:   "shell",
+		Short: "Interactive SFTP shell into a PVC via temporary pod",
+		Long: `
+Examples:
+
+kubectl syncpod shell \
+  --namespace vault \
+  --pvc postgresql \
+  --mount-path /var/lib/postgresql/data
+
+echo "du pgdata" | kubectl syncpod shell \
+  --namespace vault \
+  --pvc postgresql \
+  --mount-path /var/lib/postgresql/data
+`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args:          cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			shellOptions.Namespace = kub.ResolveNamespace(cfg)
+			shellOptions.KubeContext = kub.ResolveKubeContext(cfg)
+			return pipe.RunShell(ctx, &dto.RunOpts{
+				Mode:        "shell",
+				PVC:         shellOptions.PVC,
+				Namespace:   shellOptions.Namespace,
+				KubeContext: shellOptions.KubeContext,
+				Remote:      ".",
+				MountPath:   shellOptions.MountPath,
+				Workers:     shellOptions.Workers,
+				ObjName:     kub.NewObjName(),
+				Transport:   shellOptions.Transport,
+				AttachPod:   shellOptions.AttachPod,
+				Container:   shellOptions.Container,
+				In:          streams.In,
+				Out:         streams.Out,
+			})
+		},
+	}
+
+	cmd.Flags().IntVarP(&shellOptions.Workers, "workers", "w", 4, "Concurrent file workers of get/put")
+	cmd.Flags().StringVar(&shellOptions.MountPath, "mount-path", "", "Mount path inside helper pod")
+	cmd.Flags().StringVar(&shellOptions.PVC, "pvc", "", "PVC name")
+	addTransportFlag(cmd, &shellOptions.Transport)
+	cmd.Flags().StringVar(&shellOptions.AttachPod, "attach-pod", "", "Attach to a running pod via an ephemeral container instead of mounting the PVC (implies exec transport)")
+	cmd.Flags().StringVar(&shellOptions.Container, "container", "", "Container of --attach-pod whose volume mounts are shared (default: first container)")
+
+	if err := cmd.MarkFlagRequired("mount-path"); err != nil {
+		log.Fatal(err)
+	}
+	cmd.MarkFlagsOneRequired("pvc", "attach-pod")
+	cmd.MarkFlagsMutuallyExclusive("pvc", "attach-pod")
+
+	return cmd
+}
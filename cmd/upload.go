@@ -15,8 +15,10 @@ import (
 	"k8s.io/cli-runtime/pkg/genericiooptions"
 )
 
-func newUploadCmd(ctx context.Context, cfg *genericclioptions.ConfigFlags, _ genericiooptions.IOStreams) *cobra.Command {
+func newUploadCmd(ctx context.Context, cfg *genericclioptions.ConfigFlags, streams genericiooptions.IOStreams) *cobra.Command {
 	uploadOptions := dto.UploadOpts{}
+	bwFlags := bwLimitFlags{}
+	dryRun := dryRunFlags{}
 
 	cmd := &cobra.Command{
 		Use:   "upload",
@@ -30,15 +32,34 @@ kubectl syncpod upload \
   --mount-path /var/lib/rabbitmq \
   --src ./restore \
   --dst .
+
+zstd -dc pgdata.tar.zst | kubectl syncpod upload \
+  --namespace vault \
+  --pvc postgresql \
+  --mount-path /var/lib/postgresql/data \
+  --src - \
+  --dst pgdata
 `,
 		SilenceUsage:  true,
 		SilenceErrors: true,
+		Args:          cobra.NoArgs,
 		RunE: func(_ *cobra.Command, _ []string) error {
 			uploadOptions.Namespace = kub.ResolveNamespace(cfg)
-			return pipe.Run(ctx, &dto.RunOpts{
+			uploadOptions.KubeContext = kub.ResolveKubeContext(cfg)
+			if uploadOptions.Src == "-" && uploadOptions.Format == "" {
+				uploadOptions.Format = dto.FormatTar
+			}
+			if err := pipe.ValidateFormat(uploadOptions.Format); err != nil {
+				return err
+			}
+			if err := pipe.ValidateDryRun(dryRun.mode, dryRun.output, nil); err != nil {
+				return err
+			}
+			runOpts := &dto.RunOpts{
 				Mode:           "upload",
 				PVC:            uploadOptions.PVC,
 				Namespace:      uploadOptions.Namespace,
+				KubeContext:    uploadOptions.KubeContext,
 				Local:          uploadOptions.Src,
 				Remote:         uploadOptions.Dst,
 				MountPath:      uploadOptions.MountPath,
@@ -46,23 +67,53 @@ kubectl syncpod upload \
 				AllowOverwrite: uploadOptions.AllowOverwrite,
 				Owner:          uploadOptions.Owner,
 				ObjName:        kub.NewObjName(),
-			})
+				Transport:      uploadOptions.Transport,
+				AttachPod:      uploadOptions.AttachPod,
+				Container:      uploadOptions.Container,
+				Format:         uploadOptions.Format,
+				Compress:       uploadOptions.Compress,
+				Annotate:       uploadOptions.Annotate,
+				In:             streams.In,
+				Out:            streams.Out,
+			}
+			if dryRun.enabled() {
+				plan, err := pipe.PlanRun(ctx, runOpts, dryRun.mode)
+				return dryRun.print(streams.Out, plan, err)
+			}
+
+			limiter, stopBWLimit, err := bwFlags.start(ctx)
+			if err != nil {
+				return err
+			}
+			defer stopBWLimit()
+			runOpts.Limiter = limiter
+			return pipe.Run(ctx, runOpts)
 		},
 	}
 
 	cmd.Flags().IntVarP(&uploadOptions.Workers, "workers", "w", 4, "Concurrent file workers")
 	cmd.Flags().StringVar(&uploadOptions.MountPath, "mount-path", "", "Mount path inside helper pod")
 	cmd.Flags().StringVar(&uploadOptions.PVC, "pvc", "", "PVC name")
-	cmd.Flags().StringVar(&uploadOptions.Src, "src", "", "Local source path")
+	cmd.Flags().StringVar(&uploadOptions.Src, "src", "", "Local source path (an archive file with --format, \"-\" for stdin)")
 	cmd.Flags().StringVar(&uploadOptions.Dst, "dst", "", "Destination path inside mount")
 	cmd.Flags().BoolVar(&uploadOptions.AllowOverwrite, "allow-overwrite", false, "Allow overwrite of existing destination")
+	cmd.Flags().BoolVar(&uploadOptions.Annotate, "annotate", false, "Record the time, source and user of the restore as annotations of the PVC")
 	cmd.Flags().StringVar(&uploadOptions.Owner, "owner", "", "Optional owner (uid:gid or user:group)")
+	cmd.Flags().StringVar(&uploadOptions.Format, "format", "", "Read a single archive instead of a directory tree (tar, tar.gz, tar.zst)")
+	addTransportFlag(cmd, &uploadOptions.Transport)
+	addCompressFlag(cmd, &uploadOptions.Compress)
+	addBWLimitFlags(cmd, &bwFlags)
+	addDryRunFlags(cmd, &dryRun)
+	cmd.Flags().StringVar(&uploadOptions.AttachPod, "attach-pod", "", "Attach to a running pod via an ephemeral container instead of mounting the PVC (implies exec transport)")
+	cmd.Flags().StringVar(&uploadOptions.Container, "container", "", "Container of --attach-pod whose volume mounts are shared (default: first container)")
 
-	for _, rf := range []string{"mount-path", "pvc", "src", "dst"} {
+	for _, rf := range []string{"mount-path", "src", "dst"} {
 		if err := cmd.MarkFlagRequired(rf); err != nil {
 			log.Fatal(err)
 		}
 	}
+	cmd.MarkFlagsOneRequired("pvc", "attach-pod")
+	cmd.MarkFlagsMutuallyExclusive("pvc", "attach-pod")
 
 	return cmd
 }
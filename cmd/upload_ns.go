@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/pipe"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/cli-runtime/pkg/genericiooptions"
+)
+
+func newUploadNSCmd(ctx context.Context, cfg *genericclioptions.ConfigFlags, _ genericiooptions.IOStreams) *cobra.Command {
+	uploadNSOptions := dto.UploadNSOpts{}
+	bwFlags := bwLimitFlags{}
+
+	cmd := &cobra.Command{
+		Use:   "upload-ns",
+		Short: "Upload a backup made by download-ns into the PVCs of a namespace",
+		Long: `
+Examples:
+
+kubectl syncpod upload-ns \
+  --namespace vault-restore \
+  --src ./backup \
+  --create-missing
+`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args:          cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			uploadNSOptions.Namespace = kub.ResolveNamespace(cfg)
+			uploadNSOptions.KubeContext = kub.ResolveKubeContext(cfg)
+			limiter, stopBWLimit, err := bwFlags.start(ctx)
+			if err != nil {
+				return err
+			}
+			defer stopBWLimit()
+			uploadNSOptions.Limiter = limiter
+			return pipe.RunUploadNS(ctx, &uploadNSOptions)
+		},
+	}
+
+	cmd.Flags().StringVar(&uploadNSOptions.Src, "src", "", "Local source root, e.g. ./backup")
+	cmd.Flags().IntVar(&uploadNSOptions.VolumeWorkers, "volume-workers", 2, "Concurrent PVC upload jobs")
+	cmd.Flags().IntVar(&uploadNSOptions.FileWorkers, "file-workers", 2, "Concurrent file workers per PVC")
+	cmd.Flags().BoolVar(&uploadNSOptions.AllowOverwrite, "allow-overwrite", false, "Allow overwrite of existing target volume contents")
+	cmd.Flags().BoolVar(&uploadNSOptions.Annotate, "annotate", false, "Record the time, source and user of the restore as annotations of the PVC")
+	cmd.Flags().StringVar(&uploadNSOptions.Owner, "owner", "", "Optional owner (uid:gid or user:group)")
+	cmd.Flags().BoolVar(&uploadNSOptions.SkipMissing, "skip-missing", false, "Skip missing local PVC directories instead of failing")
+	cmd.Flags().BoolVar(&uploadNSOptions.CreateMissing, "create-missing", false, "Create PVCs that do not exist, from the specs recorded in the manifest")
+	addTransportFlag(cmd, &uploadNSOptions.Transport)
+	addCompressFlag(cmd, &uploadNSOptions.Compress)
+	addBWLimitFlags(cmd, &bwFlags)
+
+	//nolint:errcheck
+	_ = cmd.MarkFlagRequired("src")
+	return cmd
+}
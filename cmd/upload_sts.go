@@ -2,6 +2,9 @@ package cmd
 
 import (
 	"context"
+	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
 
@@ -13,8 +16,11 @@ import (
 	"k8s.io/cli-runtime/pkg/genericiooptions"
 )
 
-func newUploadSTSCmd(ctx context.Context, cfg *genericclioptions.ConfigFlags, _ genericiooptions.IOStreams) *cobra.Command {
+func newUploadSTSCmd(ctx context.Context, cfg *genericclioptions.ConfigFlags, streams genericiooptions.IOStreams) *cobra.Command {
 	uploadSTSOptions := dto.UploadSTSOpts{}
+	bwFlags := bwLimitFlags{}
+	dryRun := dryRunFlags{}
+	mapOrdinals := map[string]int{}
 
 	cmd := &cobra.Command{
 		Use:   "upload-sts",
@@ -25,25 +31,118 @@ Examples:
 kubectl syncpod upload-sts rabbitmq \
   --namespace mq \
   --src ./backup
+
+kubectl syncpod upload-sts rabbitmq \
+  --namespace mq \
+  --src ./rabbitmq.tar.zst
+
+# restore a prod backup of rabbitmq into staging/rabbitmq-copy
+kubectl syncpod upload-sts rabbitmq-copy \
+  --from-sts rabbitmq \
+  --target-namespace staging \
+  --src ./backup
+
+# seed a single replica with the data of pod-2
+kubectl syncpod upload-sts rabbitmq \
+  --ordinals 2 \
+  --map-ordinal 2=0 \
+  --src ./backup
+
+# restore the data of pod-0 into every replica
+kubectl syncpod upload-sts rabbitmq \
+  --fan-out-from 0 \
+  --src ./backup
 `,
 		SilenceUsage:  true,
 		SilenceErrors: true,
 		Args:          cobra.ExactArgs(1),
-		RunE: func(_ *cobra.Command, args []string) error {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			uploadSTSOptions.Namespace = kub.ResolveNamespace(cfg)
+			uploadSTSOptions.KubeContext = kub.ResolveKubeContext(cfg)
+			if uploadSTSOptions.TargetNamespace != "" {
+				uploadSTSOptions.Namespace = uploadSTSOptions.TargetNamespace
+			}
 			uploadSTSOptions.StsName = args[0]
+			uploadSTSOptions.FanOut = cmd.Flags().Changed("fan-out-from")
+			var err error
+			uploadSTSOptions.MapOrdinals, err = parseOrdinalMap(mapOrdinals)
+			if err != nil {
+				return err
+			}
+			if err = validateOrdinals(uploadSTSOptions.Ordinals); err != nil {
+				return err
+			}
+			if uploadSTSOptions.FanOut && uploadSTSOptions.FanOutFrom < 0 {
+				return fmt.Errorf("invalid --fan-out-from: %d", uploadSTSOptions.FanOutFrom)
+			}
+			if err := pipe.ValidateDryRun(dryRun.mode, dryRun.output, args); err != nil {
+				return err
+			}
+			if dryRun.enabled() {
+				plan, err := pipe.PlanUploadSTS(ctx, &uploadSTSOptions, dryRun.mode)
+				return dryRun.print(streams.Out, plan, err)
+			}
+
+			limiter, stopBWLimit, err := bwFlags.start(ctx)
+			if err != nil {
+				return err
+			}
+			defer stopBWLimit()
+			uploadSTSOptions.Limiter = limiter
 			return pipe.RunUploadSTS(ctx, &uploadSTSOptions)
 		},
 	}
 
-	cmd.Flags().StringVar(&uploadSTSOptions.Src, "src", "", "Local source root, e.g. ./backup")
+	cmd.Flags().StringVar(&uploadSTSOptions.Src, "src", "", "Local source root, e.g. ./backup, or a backup archive written by download-sts --archive")
 	cmd.Flags().IntVar(&uploadSTSOptions.VolumeWorkers, "volume-workers", 2, "Concurrent PVC upload jobs")
 	cmd.Flags().IntVar(&uploadSTSOptions.FileWorkers, "file-workers", 2, "Concurrent file workers per PVC")
 	cmd.Flags().BoolVar(&uploadSTSOptions.AllowOverwrite, "allow-overwrite", false, "Allow overwrite of existing target volume contents")
+	cmd.Flags().BoolVar(&uploadSTSOptions.Annotate, "annotate", false, "Record the time, source and user of the restore as annotations of the PVC")
 	cmd.Flags().StringVar(&uploadSTSOptions.Owner, "owner", "", "Optional owner (uid:gid or user:group)")
 	cmd.Flags().BoolVar(&uploadSTSOptions.SkipMissing, "skip-missing", false, "Skip missing local pod/volume directories instead of failing")
+	cmd.Flags().BoolVar(&uploadSTSOptions.Quiesce, "quiesce", false, "Scale the StatefulSet to zero during the upload, and restore replicas afterwards")
+	cmd.Flags().DurationVar(&uploadSTSOptions.QuiesceTimeout, "quiesce-timeout", 5*time.Minute, "How long to wait for pods to terminate after scale-down")
+	addTransportFlag(cmd, &uploadSTSOptions.Transport)
+	cmd.Flags().StringVar(&uploadSTSOptions.FromSts, "from-sts", "", "StatefulSet the backup was taken from, when restoring into another one")
+	cmd.Flags().StringVar(&uploadSTSOptions.TargetNamespace, "target-namespace", "", "Namespace to restore into (default: --namespace); volumes are mapped to the target's PVCs")
+	cmd.Flags().IntSliceVar(&uploadSTSOptions.Ordinals, "ordinals", nil, "Only restore these pod ordinals of the backup, e.g. 0,2")
+	cmd.Flags().StringToIntVar(&mapOrdinals, "map-ordinal", nil, "Restore a pod ordinal of the backup into another replica, e.g. 2=0 (repeatable)")
+	cmd.Flags().IntVar(&uploadSTSOptions.FanOutFrom, "fan-out-from", 0, "Restore the volumes of this pod ordinal of the backup into every replica")
+	addCompressFlag(cmd, &uploadSTSOptions.Compress)
+	addBWLimitFlags(cmd, &bwFlags)
+	addDryRunFlags(cmd, &dryRun)
+
+	cmd.MarkFlagsMutuallyExclusive("fan-out-from", "ordinals")
+	cmd.MarkFlagsMutuallyExclusive("fan-out-from", "map-ordinal")
 
 	//nolint:errcheck
 	_ = cmd.MarkFlagRequired("src")
 	return cmd
 }
+
+// parseOrdinalMap converts --map-ordinal from=to pairs, a target ordinal can be used only once.
+func parseOrdinalMap(pairs map[string]int) (map[int]int, error) {
+	result := make(map[int]int, len(pairs))
+	used := make(map[int]int, len(pairs))
+	for k, to := range pairs {
+		from, err := strconv.Atoi(k)
+		if err != nil || from < 0 || to < 0 {
+			return nil, fmt.Errorf("invalid --map-ordinal %s=%d: expected from=to with non-negative ordinals", k, to)
+		}
+		if prev, ok := used[to]; ok {
+			return nil, fmt.Errorf("--map-ordinal: ordinals %d and %d are both mapped to %d", min(prev, from), max(prev, from), to)
+		}
+		used[to] = from
+		result[from] = to
+	}
+	return result, nil
+}
+
+func validateOrdinals(ordinals []int) error {
+	for _, o := range ordinals {
+		if o < 0 {
+			return fmt.Errorf("invalid ordinal: %d", o)
+		}
+	}
+	return nil
+}
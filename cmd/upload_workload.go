@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/pipe"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/cli-runtime/pkg/genericiooptions"
+)
+
+func newUploadWorkloadCmd(ctx context.Context, cfg *genericclioptions.ConfigFlags, _ genericiooptions.IOStreams) *cobra.Command {
+	uploadWorkloadOptions := dto.UploadWorkloadOpts{}
+	bwFlags := bwLimitFlags{}
+
+	cmd := &cobra.Command{
+		Use:   "upload-workload (KIND/NAME | -l SELECTOR)",
+		Short: "Upload a backup made by download-workload into the PVC-backed volumes of a workload",
+		Long: `
+Supported kinds: sts, deploy, ds, cj, pod.
+
+Examples:
+
+kubectl syncpod upload-workload deploy/grafana \
+  --namespace monitoring \
+  --src ./backup
+
+kubectl syncpod upload-workload -l backup=true \
+  --namespace apps \
+  --src ./backup
+`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args:          cobra.MaximumNArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			if len(args) == 0 && uploadWorkloadOptions.Selector == "" {
+				return fmt.Errorf("either KIND/NAME or --selector is required")
+			}
+			uploadWorkloadOptions.Namespace = kub.ResolveNamespace(cfg)
+			uploadWorkloadOptions.KubeContext = kub.ResolveKubeContext(cfg)
+			if len(args) > 0 {
+				uploadWorkloadOptions.Workload = args[0]
+			}
+			limiter, stopBWLimit, err := bwFlags.start(ctx)
+			if err != nil {
+				return err
+			}
+			defer stopBWLimit()
+			uploadWorkloadOptions.Limiter = limiter
+			return pipe.RunUploadWorkload(ctx, &uploadWorkloadOptions)
+		},
+	}
+
+	cmd.Flags().StringVarP(&uploadWorkloadOptions.Selector, "selector", "l", "", "PVC label selector, e.g. backup=true")
+	cmd.Flags().StringVar(&uploadWorkloadOptions.Src, "src", "", "Local source root, e.g. ./backup")
+	cmd.Flags().IntVar(&uploadWorkloadOptions.VolumeWorkers, "volume-workers", 2, "Concurrent PVC upload jobs")
+	cmd.Flags().IntVar(&uploadWorkloadOptions.FileWorkers, "file-workers", 2, "Concurrent file workers per PVC")
+	cmd.Flags().BoolVar(&uploadWorkloadOptions.AllowOverwrite, "allow-overwrite", false, "Allow overwrite of existing target volume contents")
+	cmd.Flags().BoolVar(&uploadWorkloadOptions.Annotate, "annotate", false, "Record the time, source and user of the restore as annotations of the PVC")
+	cmd.Flags().StringVar(&uploadWorkloadOptions.Owner, "owner", "", "Optional owner (uid:gid or user:group)")
+	cmd.Flags().BoolVar(&uploadWorkloadOptions.SkipMissing, "skip-missing", false, "Skip missing local volume directories instead of failing")
+	addTransportFlag(cmd, &uploadWorkloadOptions.Transport)
+	addCompressFlag(cmd, &uploadWorkloadOptions.Compress)
+	addBWLimitFlags(cmd, &bwFlags)
+
+	//nolint:errcheck
+	_ = cmd.MarkFlagRequired("src")
+	return cmd
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/pipe"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/cli-runtime/pkg/genericiooptions"
+)
+
+func newVerifyCmd(ctx context.Context, cfg *genericclioptions.ConfigFlags, streams genericiooptions.IOStreams) *cobra.Command {
+	verifyOptions := dto.VerifyOpts{}
+
+	cmd := &cobra.Command{
+		Use:   "verify <backup>",
+		Short: "Check a backup directory or archive against the file inventories of its manifest",
+		Long: `
+Volumes without a file inventory in the manifest (backups taken by older versions) cannot be
+checked offline, and fail the verification. With --against-cluster an inventory is built for
+them by hashing the backup, and compared with the live PVCs.
+
+Examples:
+
+# re-hash the backup offline
+kubectl syncpod verify ./backup
+
+# also compare with the live PVCs
+kubectl syncpod verify ./backup.tar.zst --against-cluster
+`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args:          cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := pipe.ValidateOutput(verifyOptions.Output); err != nil {
+				return err
+			}
+			verifyOptions.Src = args[0]
+			// the namespace of the manifest is used, unless one is given explicitly
+			if cmd.Flags().Changed("namespace") {
+				verifyOptions.Namespace = *cfg.Namespace
+			}
+			verifyOptions.KubeContext = kub.ResolveKubeContext(cfg)
+			verifyOptions.Out = streams.Out
+			return pipe.RunVerify(ctx, &verifyOptions)
+		},
+	}
+
+	cmd.Flags().BoolVar(&verifyOptions.AgainstCluster, "against-cluster", false, "Also compare the inventories with the live PVCs (hashed inside helper pods)")
+	cmd.Flags().IntVar(&verifyOptions.VolumeWorkers, "volume-workers", 2, "Concurrent PVC checks with --against-cluster")
+	cmd.Flags().IntVar(&verifyOptions.FileWorkers, "file-workers", 4, "Concurrent local file hashers")
+	addTransportFlag(cmd, &verifyOptions.Transport)
+	cmd.Flags().StringVarP(&verifyOptions.Output, "output", "o", dto.OutputTable, "Output format (table, json)")
+	return cmd
+}
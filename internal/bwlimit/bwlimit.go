@@ -0,0 +1,178 @@
+package bwlimit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// minBurst keeps the bucket large enough for a single SFTP packet at low rates.
+const minBurst = 32 * 1024
+
+var units = []struct {
+	suffix string
+	mult   float64
+}{
+	{"kib", 1 << 10}, {"mib", 1 << 20}, {"gib", 1 << 30},
+	{"kb", 1e3}, {"mb", 1e6}, {"gb", 1e9},
+	// rsync style, single letters are binary
+	{"k", 1 << 10}, {"m", 1 << 20}, {"g", 1 << 30},
+	{"b", 1},
+}
+
+// ParseRate parses a rate like 50MiB/s, 10M, 1.5GB/s or 8192 (bytes per second).
+// Empty, "0" and "off" mean unlimited, reported as 0.
+func ParseRate(s string) (float64, error) {
+	v := strings.ToLower(strings.TrimSpace(s))
+	if v == "" || v == "0" || v == "off" {
+		return 0, nil
+	}
+	v = strings.TrimSuffix(v, "/s")
+
+	mult := 1.0
+	for _, u := range units {
+		if strings.HasSuffix(v, u.suffix) {
+			v = strings.TrimSpace(strings.TrimSuffix(v, u.suffix))
+			mult = u.mult
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n < 0 || math.IsInf(n, 0) || math.IsNaN(n) {
+		return 0, fmt.Errorf("invalid rate %q (expected e.g. 50MiB/s, 10M, off)", s)
+	}
+	return n * mult, nil
+}
+
+// FormatRate is the inverse of ParseRate, in binary units.
+func FormatRate(bps float64) string {
+	switch {
+	case bps <= 0:
+		return "off"
+	case bps >= 1<<30:
+		return strconv.FormatFloat(bps/(1<<30), 'g', 4, 64) + "GiB/s"
+	case bps >= 1<<20:
+		return strconv.FormatFloat(bps/(1<<20), 'g', 4, 64) + "MiB/s"
+	case bps >= 1<<10:
+		return strconv.FormatFloat(bps/(1<<10), 'g', 4, 64) + "KiB/s"
+	default:
+		return strconv.FormatFloat(bps, 'g', 4, 64) + "B/s"
+	}
+}
+
+// Limiter is a token bucket shared by all transfers of a command. The rate can be changed while running.
+// A nil *Limiter does not limit anything.
+type Limiter struct {
+	mu  sync.Mutex
+	bps float64
+	lim *rate.Limiter
+}
+
+func New(bps float64) *Limiter {
+	l := &Limiter{lim: rate.NewLimiter(rate.Inf, minBurst)}
+	l.SetRate(bps)
+	return l
+}
+
+// SetRate changes the rate in bytes per second, 0 means unlimited.
+func (l *Limiter) SetRate(bps float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.bps = bps
+	if bps <= 0 {
+		l.lim.SetLimit(rate.Inf)
+		return
+	}
+	// ~100ms worth of data
+	burst := int(bps / 10)
+	if burst < minBurst {
+		burst = minBurst
+	}
+	l.lim.SetBurst(burst)
+	l.lim.SetLimit(rate.Limit(bps))
+}
+
+// Rate returns the current rate in bytes per second, 0 means unlimited.
+func (l *Limiter) Rate() float64 {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.bps
+}
+
+func (l *Limiter) wait(ctx context.Context, n int) error {
+	for n > 0 {
+		chunk := min(n, l.burst())
+		if err := l.lim.WaitN(ctx, chunk); err != nil {
+			// the rate was lowered since the burst was read, retry with the new one
+			if ctx.Err() == nil && chunk > l.burst() {
+				continue
+			}
+			return err
+		}
+		n -= chunk
+	}
+	return nil
+}
+
+// burst is read with the lock held, SetRate changes the burst and the limit together.
+func (l *Limiter) burst() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lim.Burst()
+}
+
+// Reader limits reads from r.
+func (l *Limiter) Reader(ctx context.Context, r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &reader{ctx: ctx, l: l, r: r}
+}
+
+// Writer limits writes into w.
+func (l *Limiter) Writer(ctx context.Context, w io.Writer) io.Writer {
+	if l == nil {
+		return w
+	}
+	return &writer{ctx: ctx, l: l, w: w}
+}
+
+type reader struct {
+	ctx context.Context
+	l   *Limiter
+	r   io.Reader
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if waitErr := r.l.wait(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+type writer struct {
+	ctx context.Context
+	l   *Limiter
+	w   io.Writer
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if err := w.l.wait(w.ctx, len(p)); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
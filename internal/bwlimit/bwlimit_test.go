@@ -0,0 +1,61 @@
+package bwlimit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    float64
+		wantErr bool
+	}{
+		{in: "", want: 0},
+		{in: "0", want: 0},
+		{in: "off", want: 0},
+		{in: " OFF ", want: 0},
+		{in: "8192", want: 8192},
+		{in: "100b", want: 100},
+		{in: "50MiB/s", want: 50 << 20},
+		{in: "50mib", want: 50 << 20},
+		{in: "10M", want: 10 << 20},
+		{in: "10k", want: 10 << 10},
+		{in: "1.5GB/s", want: 1.5e9},
+		{in: "2 KB", want: 2e3},
+		{in: "1g", want: 1 << 30},
+		{in: "fast", wantErr: true},
+		{in: "-1M", wantErr: true},
+		{in: "MiB/s", wantErr: true},
+		{in: "infM", wantErr: true},
+		{in: "NaN", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseRate(tt.in)
+		if tt.wantErr {
+			require.Error(t, err, tt.in)
+			continue
+		}
+		require.NoError(t, err, tt.in)
+		require.InDelta(t, tt.want, got, 0.001, tt.in)
+	}
+}
+
+func TestFormatRate(t *testing.T) {
+	for _, s := range []string{"off", "512B/s", "20KiB/s", "50MiB/s", "1.5GiB/s"} {
+		bps, err := ParseRate(s)
+		require.NoError(t, err, s)
+		require.Equal(t, s, FormatRate(bps))
+	}
+}
+
+func TestNilLimiter(t *testing.T) {
+	var l *Limiter
+	require.Zero(t, l.Rate())
+	r := bytes.NewReader([]byte("data"))
+	require.Same(t, io.Reader(r), l.Reader(context.Background(), r))
+}
@@ -0,0 +1,84 @@
+package bwlimit
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// controlTimeout bounds how long a client of the control socket may take to send its line.
+const controlTimeout = 5 * time.Second
+
+// ServeControl listens on a unix socket, so the rate of a running command can be changed:
+//
+//	echo 20MiB/s | nc -U /tmp/syncpod.sock
+//
+// Each connection sends one line: a new rate (or "off"), or an empty line to query the current one.
+// Only the owner can connect (the socket is 0600). The returned func stops the listener and removes the socket.
+func ServeControl(ctx context.Context, l *Limiter, socketPath string) (func(), error) {
+	// a stale socket of a previous run
+	if st, err := os.Stat(socketPath); err == nil && st.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(socketPath)
+	}
+
+	var lc net.ListenConfig
+	ln, err := lc.Listen(ctx, "unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("listen on control socket: %w", err)
+	}
+	if err := os.Chmod(socketPath, 0o600); err != nil {
+		_ = ln.Close()
+		_ = os.Remove(socketPath)
+		return nil, fmt.Errorf("restrict control socket: %w", err)
+	}
+	slog.Info("bandwidth control socket is ready", slog.String("path", socketPath))
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					slog.Error("control socket accept", slog.Any("err", err))
+				}
+				return
+			}
+			// a client that never writes must not block the next ones
+			go handleControlConn(l, conn)
+		}
+	}()
+
+	return func() {
+		_ = ln.Close()
+		_ = os.Remove(socketPath)
+	}, nil
+}
+
+func handleControlConn(l *Limiter, conn net.Conn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(controlTimeout))
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		fmt.Fprintf(conn, "error: %v\n", err)
+		return
+	}
+
+	line = strings.TrimSpace(line)
+	if line != "" {
+		bps, err := ParseRate(line)
+		if err != nil {
+			fmt.Fprintf(conn, "error: %v\n", err)
+			return
+		}
+		l.SetRate(bps)
+		slog.Info("bandwidth limit changed", slog.String("bwlimit", FormatRate(bps)))
+	}
+	fmt.Fprintf(conn, "%s\n", FormatRate(l.Rate()))
+}
@@ -0,0 +1,59 @@
+package bwlimit
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeControl(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix socket permissions")
+	}
+	// short path, unix socket paths are limited to ~100 bytes
+	dir, err := os.MkdirTemp("", "bw")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "ctl.sock")
+
+	l := New(1 << 20)
+	stop, err := ServeControl(context.Background(), l, socket)
+	require.NoError(t, err)
+	defer stop()
+
+	st, err := os.Stat(socket)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), st.Mode().Perm())
+
+	// an idle client does not block the next one
+	idle, err := net.Dial("unix", socket)
+	require.NoError(t, err)
+	defer idle.Close()
+
+	send := func(line string) string {
+		conn, err := net.Dial("unix", socket)
+		require.NoError(t, err)
+		defer conn.Close()
+		require.NoError(t, conn.SetDeadline(time.Now().Add(time.Second)))
+		_, err = conn.Write([]byte(line + "\n"))
+		require.NoError(t, err)
+		reply, err := bufio.NewReader(conn).ReadString('\n')
+		require.NoError(t, err)
+		return reply
+	}
+	require.Equal(t, "2MiB/s\n", send("2MiB/s"))
+	require.InDelta(t, float64(2<<20), l.Rate(), 0)
+	require.Equal(t, "2MiB/s\n", send(""))
+	require.Contains(t, send("fast"), "error:")
+
+	stop()
+	_, err = os.Stat(socket)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
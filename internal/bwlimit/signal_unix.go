@@ -0,0 +1,41 @@
+//go:build !windows
+
+package bwlimit
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// WatchSignals halves the rate on SIGUSR1, and doubles it on SIGUSR2.
+// An unlimited rate is not affected.
+func WatchSignals(ctx context.Context, l *Limiter) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	go func() {
+		defer signal.Stop(ch)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-ch:
+				bps := l.Rate()
+				if bps <= 0 {
+					slog.Info("bandwidth is not limited, signal ignored", slog.String("signal", sig.String()))
+					continue
+				}
+				if sig == syscall.SIGUSR1 {
+					bps /= 2
+				} else {
+					bps *= 2
+				}
+				l.SetRate(bps)
+				slog.Info("bandwidth limit changed", slog.String("bwlimit", FormatRate(bps)))
+			}
+		}
+	}()
+}
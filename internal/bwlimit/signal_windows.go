@@ -0,0 +1,8 @@
+//go:build windows
+
+package bwlimit
+
+import "context"
+
+// WatchSignals is a no-op, there are no user signals on windows; use the control socket instead.
+func WatchSignals(_ context.Context, _ *Limiter) {}
@@ -52,3 +52,12 @@ func (k *KeyPair) PrivateKeyToPEM() ([]byte, error) {
 
 	return keyBuf.Bytes(), nil
 }
+
+// PrivateKeyToOpenSSH encodes the private key in the OpenSSH format, as expected by the ssh client binary.
+func (k *KeyPair) PrivateKeyToOpenSSH() ([]byte, error) {
+	block, err := ssh.MarshalPrivateKey(k.PrivateKey, "")
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(block), nil
+}
@@ -2,6 +2,7 @@ package clients
 
 import (
 	"fmt"
+	"io"
 	"net"
 	"os"
 
@@ -25,6 +26,7 @@ type SFTPClient struct {
 	sshClient  *ssh.Client
 	sftpClient *sftp.Client
 	config     *SFTPConfig
+	onClose    func() error
 }
 
 func NewSFTPClient(cfg *SFTPConfig) (*SFTPClient, error) {
@@ -61,10 +63,47 @@ func NewSFTPClient(cfg *SFTPConfig) (*SFTPClient, error) {
 	}, nil
 }
 
+// NewSFTPClientPipe speaks SFTP over an already established byte stream
+// (e.g. stdin/stdout of a remote sftp-server). onClose is invoked after the
+// SFTP session is closed, and may be nil.
+func NewSFTPClientPipe(rd io.Reader, wr io.WriteCloser, onClose func() error) (*SFTPClient, error) {
+	sftpClient, err := sftp.NewClientPipe(rd, wr)
+	if err != nil {
+		return nil, err
+	}
+	return &SFTPClient{
+		sftpClient: sftpClient,
+		onClose:    onClose,
+	}, nil
+}
+
 func (s *SFTPClient) SFTPClient() *sftp.Client {
 	return s.sftpClient
 }
 
+// Run executes a command over a new SSH session. Only available for clients
+// created with NewSFTPClient.
+func (s *SFTPClient) Run(cmd string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if s.sshClient == nil {
+		return fmt.Errorf("no ssh connection to run commands on")
+	}
+	session, err := s.sshClient.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	session.Stdin = stdin
+	session.Stdout = stdout
+	session.Stderr = stderr
+	return session.Run(cmd)
+}
+
+// CanRun reports whether Run is available.
+func (s *SFTPClient) CanRun() bool {
+	return s.sshClient != nil
+}
+
 func (s *SFTPClient) Close() error {
 	var err error
 	if s.sftpClient != nil {
@@ -73,6 +112,11 @@ func (s *SFTPClient) Close() error {
 	if s.sshClient != nil {
 		err = s.sshClient.Close()
 	}
+	if s.onClose != nil {
+		if closeErr := s.onClose(); closeErr != nil {
+			err = closeErr
+		}
+	}
 	return err
 }
 
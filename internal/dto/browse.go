@@ -0,0 +1,41 @@
+package dto
+
+import "time"
+
+type BrowseOpts struct {
+	Namespace   string
+	KubeContext string // kubeconfig context, empty for the current one
+	MountPath   string
+	PVC         string
+	Transport   string
+	AttachPod   string
+	Container   string
+	Output      string
+}
+
+// RemoteEntry is a file on the PVC, as printed by ls and stat.
+type RemoteEntry struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"`
+	Type    string    `json:"type"` // file, dir, symlink, other
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"`
+	UID     uint32    `json:"uid"`
+	GID     uint32    `json:"gid"`
+	ModTime time.Time `json:"mtime"`
+	Link    string    `json:"link,omitempty"` // target of a symlink
+}
+
+// DiskUsage is the total size of a directory tree, as printed by du.
+type DiskUsage struct {
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+	Files int64  `json:"files"`
+	Dirs  int64  `json:"dirs"`
+}
+
+type DuOpts struct {
+	Depth   int // directories deeper than this are only counted in their parents
+	Workers int // concurrent directory readers
+	Top     int // print only the largest directories, 0 for all
+}
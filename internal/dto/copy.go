@@ -0,0 +1,20 @@
+package dto
+
+import "github.com/hashmap-kz/kubectl-syncpod/internal/bwlimit"
+
+type CopyOpts struct {
+	FromNamespace  string
+	ToNamespace    string
+	KubeContext    string // kubeconfig context of both PVCs, empty for the current one
+	FromPVC        string
+	ToPVC          string
+	Src            string
+	Dst            string
+	Workers        int
+	AllowOverwrite bool
+	Owner          string
+	Transport      string
+	Relay          bool
+	Verify         bool             // compare the trees by checksum after the copy
+	Limiter        *bwlimit.Limiter // relays through the CLI, the stream between helpers cannot be limited
+}
@@ -0,0 +1,38 @@
+package dto
+
+import "time"
+
+// Statuses of a diff entry, the local tree is the old side and the PVC the new one.
+const (
+	DiffAdded   = "added"   // only on the PVC
+	DiffRemoved = "removed" // only in the local tree
+	DiffChanged = "changed"
+)
+
+type DiffOpts struct {
+	Src      string
+	Local    string
+	Checksum bool
+	Workers  int
+}
+
+type DiffEntry struct {
+	Path        string    `json:"path"`
+	Status      string    `json:"status"`
+	Reason      string    `json:"reason,omitempty"` // size, mtime, checksum or type, for changed entries
+	IsDir       bool      `json:"is_dir,omitempty"`
+	LocalSize   int64     `json:"local_size,omitempty"`
+	RemoteSize  int64     `json:"remote_size,omitempty"`
+	LocalMtime  time.Time `json:"local_mtime,omitzero"`
+	RemoteMtime time.Time `json:"remote_mtime,omitzero"`
+}
+
+type Diff struct {
+	Local    string      `json:"local"`
+	Remote   string      `json:"remote"`
+	Checksum bool        `json:"checksum"`
+	Added    int         `json:"added"`
+	Removed  int         `json:"removed"`
+	Changed  int         `json:"changed"`
+	Entries  []DiffEntry `json:"entries"`
+}
@@ -0,0 +1,38 @@
+package dto
+
+import (
+	"io"
+	"time"
+)
+
+// Results of a doctor check.
+const (
+	DoctorPass = "pass"
+	DoctorFail = "fail"
+	DoctorWarn = "warn" // optional prerequisite, only some commands or flags need it
+	DoctorSkip = "skip"
+)
+
+type DoctorOpts struct {
+	Namespace   string
+	KubeContext string // kubeconfig context, empty for the current one
+	PVC         string // the image and the NodePort are checked on the node of this PVC
+	Transport   string
+	Timeout     time.Duration // for the helper image to be pulled
+	Output      string
+	Out         io.Writer
+}
+
+type DoctorCheck struct {
+	Name   string `json:"name"`
+	Result string `json:"result"`
+	Detail string `json:"detail,omitempty"`
+	Hint   string `json:"hint,omitempty"` // how to fix a failed check
+}
+
+type DoctorReport struct {
+	Namespace string        `json:"namespace"`
+	User      string        `json:"user"`
+	Node      string        `json:"node,omitempty"`
+	Checks    []DoctorCheck `json:"checks"`
+}
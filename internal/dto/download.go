@@ -1,18 +1,64 @@
 package dto
 
+import (
+	"time"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/bwlimit"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
+)
+
 type DownloadOpts struct {
-	Namespace string
-	MountPath string
-	PVC       string
-	Workers   int
-	Dst       string
-	Src       string
+	Namespace   string
+	KubeContext string // kubeconfig context, empty for the current one
+	MountPath   string
+	PVC         string
+	Workers     int
+	Dst         string
+	Src         string
+	Transport   string
+	Compress    string
+	Limiter     *bwlimit.Limiter
+	AttachPod   string
+	Container   string
+	Format      string
 }
 
 type DownloadSTSOpts struct {
+	Namespace      string
+	KubeContext    string // kubeconfig context, empty for the current one
+	Dst            string
+	Archive        string // write a single backup archive instead of a directory tree
+	VolumeWorkers  int
+	FileWorkers    int
+	StsName        string
+	Transport      string
+	Compress       string
+	Limiter        *bwlimit.Limiter
+	Quiesce        bool
+	QuiesceTimeout time.Duration
+	Selection      kub.VolumeSelection // only back up these pods/volumes
+}
+
+type DownloadWorkloadOpts struct {
+	Namespace     string
+	KubeContext   string // kubeconfig context, empty for the current one
+	Dst           string
+	VolumeWorkers int
+	FileWorkers   int
+	Workload      string // kind/name
+	Selector      string // PVC label selector
+	Transport     string
+	Compress      string
+	Limiter       *bwlimit.Limiter
+}
+
+type DownloadNSOpts struct {
 	Namespace     string
+	KubeContext   string // kubeconfig context, empty for the current one
 	Dst           string
 	VolumeWorkers int
 	FileWorkers   int
-	StsName       string
+	Transport     string
+	Compress      string
+	Limiter       *bwlimit.Limiter
 }
@@ -1,7 +1,13 @@
 package dto
 
 import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/bwlimit"
 	"github.com/hashmap-kz/kubectl-syncpod/internal/clients"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
@@ -9,7 +15,11 @@ import (
 type WorkerJob struct {
 	LocalPath  string
 	RemotePath string
+	RelPath    string // relative to the walked root, "." for the root itself
 	IsDir      bool
+	Size       int64
+	Mode       os.FileMode
+	ModTime    time.Time
 }
 
 type JobOpts struct {
@@ -22,8 +32,21 @@ type JobOpts struct {
 	KeyPair        *clients.KeyPair
 	AllowOverwrite bool
 	ObjName        string
+	PodName        string // pod to exec into (the helper pod, or the attached one)
+	Container      string // container to exec into
 	Namespace      string
 	Owner          string
+	Transport      string
+	Format         string
+	Compress       string
+	Limiter        *bwlimit.Limiter
+	Inventory      *kub.FileInventory
+	In             io.Reader
+	Out            io.Writer
+	PVC            string         // PVC of the transfer, empty when attached to a pod
+	User           string         // kubeconfig user, recorded in the audit events
+	Annotate       bool           // record the last restore on the PVC after an upload
+	Stats          *TransferStats // counts what was moved, nil when not needed
 
 	Client     kubernetes.Interface
 	RestConfig *rest.Config
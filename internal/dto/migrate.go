@@ -0,0 +1,16 @@
+package dto
+
+type MigrateOpts struct {
+	FromContext    string
+	ToContext      string
+	FromNamespace  string
+	ToNamespace    string
+	PVC            string
+	StsName        string
+	StorageClass   string // storage class of created PVCs, empty to keep the source one
+	VolumeWorkers  int
+	FileWorkers    int
+	AllowOverwrite bool
+	Owner          string
+	Transport      string
+}
@@ -0,0 +1,48 @@
+package dto
+
+// Dry-run modes of the transfer commands.
+const (
+	DryRunNone = "none"
+	// DryRunClient only reads the cluster, no helper objects are created
+	DryRunClient = "client"
+	// DryRunServer also starts the helpers, to inspect the remote side without changing it
+	DryRunServer = "server"
+)
+
+// Output formats of a plan.
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+)
+
+// Plan is what a transfer command would do, printed instead of running it with --dry-run.
+type Plan struct {
+	Command   string       `json:"command"`
+	DryRun    string       `json:"dry_run"`
+	Namespace string       `json:"namespace"`
+	Notes     []string     `json:"notes,omitempty"`
+	Volumes   []VolumePlan `json:"volumes"`
+}
+
+type VolumePlan struct {
+	Name      string `json:"name,omitempty"` // pod/volume of a multi-volume command
+	PVC       string `json:"pvc,omitempty"`
+	Pod       string `json:"pod,omitempty"` // attach mode
+	Node      string `json:"node,omitempty"`
+	MountPath string `json:"mount_path"`
+	Remote    string `json:"remote"`
+	Local     string `json:"local"`
+
+	// Listed is set when the source was listed, and Files, Dirs, Bytes are known
+	Listed bool  `json:"listed"`
+	Files  int   `json:"files"`
+	Dirs   int   `json:"dirs"`
+	Bytes  int64 `json:"bytes"`
+
+	// Checked is set when the destination was inspected for Conflicts and Rename
+	Checked   bool     `json:"checked"`
+	Conflicts []string `json:"conflicts,omitempty"`
+	Rename    string   `json:"rename,omitempty"` // an existing remote dir would be moved here
+
+	Error string `json:"error,omitempty"`
+}
@@ -1,5 +1,35 @@
 package dto
 
+import (
+	"io"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/bwlimit"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
+)
+
+// Transports used to reach the SFTP server inside the helper pod.
+const (
+	// TransportNodePort runs sshd in the helper pod and exposes it via a NodePort service.
+	TransportNodePort = "nodeport"
+	// TransportExec runs sftp-server over the stdin/stdout of a pods/exec stream.
+	TransportExec = "exec"
+)
+
+// Archive formats of a download/upload, an empty format means a plain directory tree.
+const (
+	FormatTar    = "tar"
+	FormatTarGz  = "tar.gz"
+	FormatTarZst = "tar.zst"
+)
+
+// Wire compression modes of file contents.
+const (
+	CompressOff = "off"
+	CompressOn  = "on"
+	// CompressAuto skips small and already compressed files
+	CompressAuto = "auto"
+)
+
 type RunOpts struct {
 	Mode           string
 	PVC            string
@@ -11,4 +41,15 @@ type RunOpts struct {
 	AllowOverwrite bool
 	Owner          string
 	ObjName        string
+	Transport      string
+	AttachPod      string // run the SFTP server in an ephemeral container of this pod instead of a helper pod
+	Container      string // target container of AttachPod, whose volume mounts are shared
+	KubeContext    string // kubeconfig context, empty for the current one
+	Format         string // archive format, Local is a file (or "-" for In/Out)
+	In             io.Reader
+	Compress       string
+	Limiter        *bwlimit.Limiter   // shared by all transfers of a command, nil for unlimited
+	Inventory      *kub.FileInventory // records downloaded files, nil to skip hashing
+	Out            io.Writer
+	Annotate       bool // record the last restore on the PVC after an upload
 }
@@ -0,0 +1,19 @@
+package dto
+
+import "time"
+
+type ServeOpts struct {
+	Namespace      string
+	KubeContext    string // kubeconfig context, empty for the current one
+	MountPath      string
+	PVC            string
+	Transport      string
+	AttachPod      string
+	Container      string
+	Listen         string
+	AuthorizedKeys string // clients allowed to connect, a key pair is generated when empty
+	IdentityFile   string // where the generated private key is written
+	HostKey        string // persisted host key, generated for each run when empty
+	IdleTimeout    time.Duration
+	InstallRsync   bool
+}
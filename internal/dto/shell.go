@@ -0,0 +1,12 @@
+package dto
+
+type ShellOpts struct {
+	Namespace   string
+	KubeContext string // kubeconfig context, empty for the current one
+	MountPath   string
+	PVC         string
+	Workers     int
+	Transport   string
+	AttachPod   string
+	Container   string
+}
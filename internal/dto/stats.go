@@ -0,0 +1,31 @@
+package dto
+
+import "sync/atomic"
+
+// TransferStats counts the files and bytes moved by a transfer, all methods are nil-safe.
+type TransferStats struct {
+	files atomic.Int64
+	bytes atomic.Int64
+}
+
+func (s *TransferStats) Add(files, bytes int64) {
+	if s == nil {
+		return
+	}
+	s.files.Add(files)
+	s.bytes.Add(bytes)
+}
+
+func (s *TransferStats) Files() int64 {
+	if s == nil {
+		return 0
+	}
+	return s.files.Load()
+}
+
+func (s *TransferStats) Bytes() int64 {
+	if s == nil {
+		return 0
+	}
+	return s.bytes.Load()
+}
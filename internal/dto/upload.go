@@ -1,7 +1,14 @@
 package dto
 
+import (
+	"time"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/bwlimit"
+)
+
 type UploadOpts struct {
 	Namespace      string
+	KubeContext    string // kubeconfig context, empty for the current one
 	MountPath      string
 	PVC            string
 	Workers        int
@@ -9,10 +16,18 @@ type UploadOpts struct {
 	Dst            string
 	AllowOverwrite bool
 	Owner          string
+	Transport      string
+	Compress       string
+	Limiter        *bwlimit.Limiter
+	AttachPod      string
+	Container      string
+	Format         string
+	Annotate       bool
 }
 
 type UploadSTSOpts struct {
 	Namespace      string
+	KubeContext    string // kubeconfig context, empty for the current one
 	Src            string
 	VolumeWorkers  int
 	FileWorkers    int
@@ -20,4 +35,53 @@ type UploadSTSOpts struct {
 	Owner          string
 	SkipMissing    bool
 	StsName        string
+	Transport      string
+	Compress       string
+	Limiter        *bwlimit.Limiter
+	Quiesce        bool
+	QuiesceTimeout time.Duration
+	Annotate       bool
+	// FromSts and TargetNamespace restore a backup into another StatefulSet/namespace,
+	// entries are mapped to the PVCs of the target by ordinal and volume name
+	FromSts         string
+	TargetNamespace string
+	// Ordinals keeps the given pods of the backup, MapOrdinals restores a pod of the backup
+	// into another replica, and FanOut restores the volumes of FanOutFrom into every replica
+	Ordinals    []int
+	MapOrdinals map[int]int
+	FanOut      bool
+	FanOutFrom  int
+}
+
+type UploadWorkloadOpts struct {
+	Namespace      string
+	KubeContext    string // kubeconfig context, empty for the current one
+	Src            string
+	VolumeWorkers  int
+	FileWorkers    int
+	AllowOverwrite bool
+	Owner          string
+	SkipMissing    bool
+	Workload       string // kind/name
+	Selector       string // PVC label selector
+	Transport      string
+	Compress       string
+	Limiter        *bwlimit.Limiter
+	Annotate       bool
+}
+
+type UploadNSOpts struct {
+	Namespace      string
+	KubeContext    string // kubeconfig context, empty for the current one
+	Src            string
+	VolumeWorkers  int
+	FileWorkers    int
+	AllowOverwrite bool
+	Owner          string
+	SkipMissing    bool
+	CreateMissing  bool
+	Transport      string
+	Compress       string
+	Limiter        *bwlimit.Limiter
+	Annotate       bool
 }
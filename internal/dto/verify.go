@@ -0,0 +1,40 @@
+package dto
+
+import "io"
+
+// Problems found by verify.
+const (
+	VerifyMissing    = "missing"
+	VerifyUnexpected = "unexpected" // not in the inventory
+	VerifySize       = "size"
+	VerifyChecksum   = "checksum"
+	VerifyType       = "type"
+)
+
+type VerifyOpts struct {
+	Src            string
+	AgainstCluster bool
+	Namespace      string // overrides the namespace of the manifest for --against-cluster
+	KubeContext    string // kubeconfig context of --against-cluster, empty for the current one
+	VolumeWorkers  int
+	FileWorkers    int
+	Transport      string
+	Output         string
+	Out            io.Writer
+}
+
+type VerifyProblem struct {
+	Volume  string `json:"volume"`
+	Where   string `json:"where"` // backup or cluster
+	Path    string `json:"path"`
+	Problem string `json:"problem"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+type VerifyReport struct {
+	Source   string          `json:"source"`
+	Volumes  int             `json:"volumes"`
+	Files    int             `json:"files"`
+	Notes    []string        `json:"notes,omitempty"`
+	Problems []VerifyProblem `json:"problems"`
+}
@@ -16,6 +16,14 @@ func ResolveNamespace(cfg *genericclioptions.ConfigFlags) string {
 	return namespace
 }
 
+// ResolveKubeContext returns the kubeconfig context given by --context, empty for the current one.
+func ResolveKubeContext(cfg *genericclioptions.ConfigFlags) string {
+	if cfg.Context != nil {
+		return strings.TrimSpace(*cfg.Context)
+	}
+	return ""
+}
+
 func NewObjName() string {
 	return "syncpod-" + uuid.NewString()
 }
@@ -0,0 +1,124 @@
+package kub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// EventComponent is the source of the events recorded by the tool.
+const EventComponent = "kubectl-syncpod"
+
+// Reasons of the transfer events.
+const (
+	ReasonTransferStarted   = "TransferStarted"
+	ReasonTransferSucceeded = "TransferSucceeded"
+	ReasonTransferFailed    = "TransferFailed"
+)
+
+// Annotations recorded on a PVC by `--annotate` after a restore.
+const (
+	AnnotationLastRestoreAt     = "kubectl-syncpod/last-restore-at"
+	AnnotationLastRestoreSource = "kubectl-syncpod/last-restore-source"
+	AnnotationLastRestoreBy     = "kubectl-syncpod/last-restore-by"
+)
+
+// events messages are limited by the API server
+const maxEventMessage = 1024
+
+// PVCRef returns the reference of a claim for its events.
+func PVCRef(ctx context.Context, client kubernetes.Interface, namespace, name string) (*corev1.ObjectReference, error) {
+	pvc, err := client.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("get pvc: %w", err)
+	}
+	return &corev1.ObjectReference{
+		APIVersion:      "v1",
+		Kind:            "PersistentVolumeClaim",
+		Namespace:       namespace,
+		Name:            name,
+		UID:             pvc.UID,
+		ResourceVersion: pvc.ResourceVersion,
+	}, nil
+}
+
+// PodRef returns the reference of a pod for its events.
+func PodRef(ctx context.Context, client kubernetes.Interface, namespace, name string) (*corev1.ObjectReference, error) {
+	pod, err := client.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("get pod: %w", err)
+	}
+	return &corev1.ObjectReference{
+		APIVersion:      "v1",
+		Kind:            "Pod",
+		Namespace:       namespace,
+		Name:            name,
+		UID:             pod.UID,
+		ResourceVersion: pod.ResourceVersion,
+	}, nil
+}
+
+// StatefulSetRef returns the reference of a StatefulSet for its events.
+func StatefulSetRef(ctx context.Context, client kubernetes.Interface, namespace, name string) (*corev1.ObjectReference, error) {
+	sts, err := client.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("get statefulset: %w", err)
+	}
+	return &corev1.ObjectReference{
+		APIVersion:      "apps/v1",
+		Kind:            KindStatefulSet,
+		Namespace:       namespace,
+		Name:            name,
+		UID:             sts.UID,
+		ResourceVersion: sts.ResourceVersion,
+	}, nil
+}
+
+// RecordEvent creates an event of the given object, eventType is corev1.EventTypeNormal or corev1.EventTypeWarning.
+func RecordEvent(ctx context.Context, client kubernetes.Interface, ref *corev1.ObjectReference, eventType, reason, action, message string) error {
+	if len(message) > maxEventMessage {
+		message = message[:maxEventMessage-3] + "..."
+	}
+	now := metav1.NewTime(time.Now())
+	ev := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: ref.Name + ".",
+			Namespace:    ref.Namespace,
+		},
+		InvolvedObject:      *ref,
+		Reason:              reason,
+		Message:             message,
+		Type:                eventType,
+		Action:              action,
+		Source:              corev1.EventSource{Component: EventComponent},
+		ReportingController: EventComponent,
+		FirstTimestamp:      now,
+		LastTimestamp:       now,
+		Count:               1,
+	}
+	if _, err := client.CoreV1().Events(ref.Namespace).Create(ctx, ev, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("create event: %w", err)
+	}
+	return nil
+}
+
+// AnnotatePVC merges the annotations into a claim.
+func AnnotatePVC(ctx context.Context, client kubernetes.Interface, namespace, name string, annotations map[string]string) error {
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{"annotations": annotations},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = client.CoreV1().PersistentVolumeClaims(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("annotate pvc: %w", err)
+	}
+	return nil
+}
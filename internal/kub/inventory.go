@@ -0,0 +1,71 @@
+package kub
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ManifestFile is an entry of the file inventory of a volume, the path is relative to the volume root.
+type ManifestFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Mode   string `json:"mode"`
+	SHA256 string `json:"sha256,omitempty"` // regular files only
+}
+
+// FileInventory collects the files of a volume while it is downloaded.
+// It is safe for concurrent use, a nil inventory records nothing.
+type FileInventory struct {
+	mu    sync.Mutex
+	files []ManifestFile
+}
+
+func (inv *FileInventory) Add(f ManifestFile) {
+	if inv == nil {
+		return
+	}
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	inv.files = append(inv.files, f)
+}
+
+// Files returns the recorded files sorted by path.
+func (inv *FileInventory) Files() []ManifestFile {
+	if inv == nil {
+		return nil
+	}
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	files := append([]ManifestFile(nil), inv.files...)
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files
+}
+
+// SetEntryInventory stores the inventory of a volume in its manifest entry, with its totals and digest.
+func SetEntryInventory(e *StatefulSetVolume, files []ManifestFile) {
+	e.Files = files
+	e.Bytes, e.FileCount = 0, 0
+	for i := range files {
+		if files[i].SHA256 != "" {
+			e.Bytes += files[i].Size
+			e.FileCount++
+		}
+	}
+	e.Digest = InventoryDigest(files)
+}
+
+// InventoryDigest is the sha256 of the paths, sizes and checksums of the inventory (modes
+// are left out), so two volumes with the same contents have the same digest.
+func InventoryDigest(files []ManifestFile) string {
+	sorted := append([]ManifestFile(nil), files...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Path < sorted[j].Path })
+
+	h := sha256.New()
+	for i := range sorted {
+		fmt.Fprintf(h, "%s\t%d\t%s\n", sorted[i].Path, sorted[i].Size, sorted[i].SHA256)
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,156 @@
+package kub
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// PVCSpec is the part of a PersistentVolumeClaim needed to recreate it.
+type PVCSpec struct {
+	StorageClass string   `json:"storage_class,omitempty"`
+	Size         string   `json:"size"`
+	AccessModes  []string `json:"access_modes"`
+	VolumeMode   string   `json:"volume_mode,omitempty"`
+	// Labels are recorded by manifest version 2
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+func PVCSpecFromClaim(pvc *corev1.PersistentVolumeClaim) *PVCSpec {
+	spec := &PVCSpec{}
+	if pvc.Spec.StorageClassName != nil {
+		spec.StorageClass = *pvc.Spec.StorageClassName
+	}
+	if size, ok := pvc.Spec.Resources.Requests[corev1.ResourceStorage]; ok {
+		spec.Size = size.String()
+	}
+	for _, m := range pvc.Spec.AccessModes {
+		spec.AccessModes = append(spec.AccessModes, string(m))
+	}
+	if pvc.Spec.VolumeMode != nil {
+		spec.VolumeMode = string(*pvc.Spec.VolumeMode)
+	}
+	spec.Labels = pvc.Labels
+	return spec
+}
+
+func NewClaimFromSpec(namespace, name string, spec *PVCSpec) (*corev1.PersistentVolumeClaim, error) {
+	size, err := resource.ParseQuantity(spec.Size)
+	if err != nil {
+		return nil, fmt.Errorf("parse size of pvc %q: %w", name, err)
+	}
+	if len(spec.AccessModes) == 0 {
+		return nil, fmt.Errorf("no access modes recorded for pvc %q", name)
+	}
+
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    spec.Labels,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: size,
+				},
+			},
+		},
+	}
+	for _, m := range spec.AccessModes {
+		pvc.Spec.AccessModes = append(pvc.Spec.AccessModes, corev1.PersistentVolumeAccessMode(m))
+	}
+	if spec.StorageClass != "" {
+		sc := spec.StorageClass
+		pvc.Spec.StorageClassName = &sc
+	}
+	if spec.VolumeMode != "" {
+		vm := corev1.PersistentVolumeMode(spec.VolumeMode)
+		pvc.Spec.VolumeMode = &vm
+	}
+	return pvc, nil
+}
+
+// EnsurePVC creates the claim from spec unless it already exists. Reports whether it was created.
+func EnsurePVC(ctx context.Context, client kubernetes.Interface, namespace, name string, spec *PVCSpec) (bool, error) {
+	_, err := client.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err == nil {
+		return false, nil
+	}
+	if !errors.IsNotFound(err) {
+		return false, fmt.Errorf("get pvc: %w", err)
+	}
+	if spec == nil {
+		return false, fmt.Errorf("pvc %q does not exist, and the manifest has no spec to create it from", name)
+	}
+
+	pvc, err := NewClaimFromSpec(namespace, name, spec)
+	if err != nil {
+		return false, err
+	}
+	_, err = client.CoreV1().PersistentVolumeClaims(namespace).Create(ctx, pvc, metav1.CreateOptions{})
+	if err != nil {
+		return false, fmt.Errorf("create pvc: %w", err)
+	}
+	return true, nil
+}
+
+// isBlockClaim reports whether a claim is a raw block device, which cannot be mounted into a helper pod.
+func isBlockClaim(pvc *corev1.PersistentVolumeClaim) bool {
+	return pvc.Spec.VolumeMode != nil && *pvc.Spec.VolumeMode == corev1.PersistentVolumeBlock
+}
+
+// DiscoverNamespacePVCs lists all bound filesystem PVCs of the namespace, mounted or not.
+func DiscoverNamespacePVCs(ctx context.Context, client kubernetes.Interface, namespace string) ([]PodVolume, error) {
+	return discoverSelectedPVCs(ctx, client, namespace, "")
+}
+
+func GetPVCSpec(ctx context.Context, client kubernetes.Interface, namespace, name string) (*PVCSpec, error) {
+	pvc, err := client.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("get pvc: %w", err)
+	}
+	return PVCSpecFromClaim(pvc), nil
+}
+
+// GetStatefulSetClaimTemplates returns the volumeClaimTemplates of a StatefulSet.
+func GetStatefulSetClaimTemplates(ctx context.Context, client kubernetes.Interface, namespace, stsName string) ([]ClaimTemplate, error) {
+	sts, err := client.AppsV1().StatefulSets(namespace).Get(ctx, stsName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("get statefulset: %w", err)
+	}
+	templates := make([]ClaimTemplate, 0, len(sts.Spec.VolumeClaimTemplates))
+	for i := range sts.Spec.VolumeClaimTemplates {
+		tpl := &sts.Spec.VolumeClaimTemplates[i]
+		templates = append(templates, ClaimTemplate{Name: tpl.Name, Spec: PVCSpecFromClaim(tpl)})
+	}
+	return templates, nil
+}
+
+// TargetClaimCapacity returns the capacity of a claim (its requested size until it is bound), or the size
+// of spec when the claim does not exist yet, as it would be created from it. ok is false when neither is known.
+func TargetClaimCapacity(ctx context.Context, client kubernetes.Interface, namespace, name string, spec *PVCSpec) (resource.Quantity, bool, error) {
+	pvc, err := client.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case err == nil:
+		if capacity, ok := pvc.Status.Capacity[corev1.ResourceStorage]; ok {
+			return capacity, true, nil
+		}
+		size, ok := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+		return size, ok, nil
+	case !errors.IsNotFound(err):
+		return resource.Quantity{}, false, fmt.Errorf("get pvc: %w", err)
+	case spec == nil:
+		return resource.Quantity{}, false, nil
+	}
+	size, err := resource.ParseQuantity(spec.Size)
+	if err != nil {
+		return resource.Quantity{}, false, nil //nolint:nilerr // unknown size, nothing to check
+	}
+	return size, true, nil
+}
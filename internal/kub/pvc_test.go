@@ -0,0 +1,37 @@
+package kub
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDiscoverNamespacePVCs(t *testing.T) {
+	block := testClaim("ns", "raw", corev1.ClaimBound)
+	mode := corev1.PersistentVolumeBlock
+	block.Spec.VolumeMode = &mode
+	filesystem := testClaim("ns", "logs", corev1.ClaimBound)
+	fsMode := corev1.PersistentVolumeFilesystem
+	filesystem.Spec.VolumeMode = &fsMode
+
+	pod := testPod("ns", "db-0", nil)
+	pod.Spec = sidecarSpec("data-db-0")
+	client := fake.NewClientset(pod, block, filesystem,
+		testClaim("ns", "data-db-0", corev1.ClaimBound),
+		testClaim("ns", "data-db-1", corev1.ClaimPending),
+		testClaim("other", "data", corev1.ClaimBound),
+	)
+
+	vols, err := DiscoverNamespacePVCs(context.Background(), client, "ns")
+	require.NoError(t, err)
+	names := make([]string, 0, len(vols))
+	for i := range vols {
+		names = append(names, vols[i].PVCName)
+	}
+	require.Equal(t, []string{"data-db-0", "logs"}, names, "block-mode and pending claims are skipped")
+	require.Equal(t, "/var/lib/data", vols[0].MountPath)
+	require.Equal(t, DefaultMountPath, vols[1].MountPath)
+}
@@ -0,0 +1,79 @@
+package kub
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+func GetStatefulSetReplicas(ctx context.Context, client kubernetes.Interface, namespace, stsName string) (int32, error) {
+	scale, err := client.AppsV1().
+		StatefulSets(namespace).
+		GetScale(ctx, stsName, metav1.GetOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("get statefulset scale: %w", err)
+	}
+	return scale.Spec.Replicas, nil
+}
+
+func ScaleStatefulSet(ctx context.Context, client kubernetes.Interface, namespace, stsName string, replicas int32) error {
+	scale, err := client.AppsV1().
+		StatefulSets(namespace).
+		GetScale(ctx, stsName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("get statefulset scale: %w", err)
+	}
+
+	scale.Spec.Replicas = replicas
+
+	_, err = client.AppsV1().
+		StatefulSets(namespace).
+		UpdateScale(ctx, stsName, scale, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("update statefulset scale: %w", err)
+	}
+	return nil
+}
+
+// WaitStatefulSetPodsGone blocks until no pods matched by the StatefulSet selector exist,
+// so their volumes are detached and safe to mount elsewhere.
+func WaitStatefulSetPodsGone(ctx context.Context, client kubernetes.Interface, namespace, stsName string, timeout time.Duration) error {
+	sts, err := client.AppsV1().
+		StatefulSets(namespace).
+		Get(ctx, stsName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("get statefulset: %w", err)
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(sts.Spec.Selector)
+	if err != nil {
+		return fmt.Errorf("statefulset selector: %w", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		pods, err := client.CoreV1().
+			Pods(namespace).
+			List(ctx, metav1.ListOptions{
+				LabelSelector: selector.String(),
+			})
+		if err != nil {
+			return fmt.Errorf("list pods: %w", err)
+		}
+		if len(pods.Items) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("statefulset %q still has %d pod(s) after %v", stsName, len(pods.Items), timeout)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
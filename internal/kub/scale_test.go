@@ -0,0 +1,36 @@
+package kub
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testPod(namespace, name string, podLabels map[string]string) *corev1.Pod {
+	return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: podLabels}}
+}
+
+func TestWaitStatefulSetPodsGoneMatchExpressions(t *testing.T) {
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "db"},
+		Spec: appsv1.StatefulSetSpec{
+			Selector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "app", Operator: metav1.LabelSelectorOpIn, Values: []string{"db"}},
+				},
+			},
+		},
+	}
+
+	// a pod of another app does not keep the wait going
+	client := fake.NewClientset(sts, testPod("ns", "web-0", map[string]string{"app": "web"}))
+	require.NoError(t, WaitStatefulSetPodsGone(context.Background(), client, "ns", "db", 0))
+
+	client = fake.NewClientset(sts, testPod("ns", "db-0", map[string]string{"app": "db"}))
+	require.ErrorContains(t, WaitStatefulSetPodsGone(context.Background(), client, "ns", "db", 0), "still has 1 pod(s)")
+}
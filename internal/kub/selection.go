@@ -0,0 +1,66 @@
+package kub
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// VolumeSelection narrows the volumes of a StatefulSet to back up. A manifest of a partial
+// backup records it, the volumes it leaves out were not captured (and are not missing).
+type VolumeSelection struct {
+	Ordinals       []int    `json:"ordinals,omitempty"`
+	Pods           []string `json:"pods,omitempty"`
+	Volumes        []string `json:"volumes,omitempty"`
+	ExcludeVolumes []string `json:"exclude_volumes,omitempty"`
+}
+
+// IsZero reports whether nothing is filtered.
+func (s *VolumeSelection) IsZero() bool {
+	return s == nil || (len(s.Ordinals) == 0 && len(s.Pods) == 0 && len(s.Volumes) == 0 && len(s.ExcludeVolumes) == 0)
+}
+
+// SelectPodVolumes returns the volumes matching the selection. Ordinals, pods and volumes
+// that match nothing are an error, so a typo does not end up as an empty backup.
+func SelectPodVolumes(vols []PodVolume, s *VolumeSelection) ([]PodVolume, error) {
+	if s.IsZero() {
+		return vols, nil
+	}
+
+	var missing []string
+	for _, o := range s.Ordinals {
+		if !slices.ContainsFunc(vols, func(v PodVolume) bool { return v.Ordinal == o }) {
+			missing = append(missing, fmt.Sprintf("ordinal %d", o))
+		}
+	}
+	for _, p := range s.Pods {
+		if !slices.ContainsFunc(vols, func(v PodVolume) bool { return v.PodName == p }) {
+			missing = append(missing, fmt.Sprintf("pod %q", p))
+		}
+	}
+	for _, n := range slices.Concat(s.Volumes, s.ExcludeVolumes) {
+		if !slices.ContainsFunc(vols, func(v PodVolume) bool { return v.VolumeName == n }) {
+			missing = append(missing, fmt.Sprintf("volume %q", n))
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("no PVC-backed volumes found for %s", strings.Join(missing, ", "))
+	}
+
+	var result []PodVolume
+	for i := range vols {
+		v := &vols[i]
+		switch {
+		case len(s.Ordinals) > 0 && !slices.Contains(s.Ordinals, v.Ordinal):
+		case len(s.Pods) > 0 && !slices.Contains(s.Pods, v.PodName):
+		case len(s.Volumes) > 0 && !slices.Contains(s.Volumes, v.VolumeName):
+		case slices.Contains(s.ExcludeVolumes, v.VolumeName):
+		default:
+			result = append(result, *v)
+		}
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("the selection leaves no volumes to back up")
+	}
+	return result, nil
+}
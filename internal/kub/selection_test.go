@@ -0,0 +1,68 @@
+package kub
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelectPodVolumes(t *testing.T) {
+	vols := []PodVolume{
+		{PodName: "db-0", Ordinal: 0, VolumeName: "data"},
+		{PodName: "db-0", Ordinal: 0, VolumeName: "wal"},
+		{PodName: "db-1", Ordinal: 1, VolumeName: "data"},
+		{PodName: "db-1", Ordinal: 1, VolumeName: "wal"},
+	}
+	names := func(vols []PodVolume) []string {
+		var result []string
+		for i := range vols {
+			result = append(result, VolumeLocalPath(&vols[i]))
+		}
+		return result
+	}
+
+	tests := []struct {
+		name      string
+		selection *VolumeSelection
+		want      []string
+		wantErr   string
+	}{
+		{name: "nil", want: []string{"db-0/data", "db-0/wal", "db-1/data", "db-1/wal"}},
+		{name: "empty", selection: &VolumeSelection{}, want: []string{"db-0/data", "db-0/wal", "db-1/data", "db-1/wal"}},
+		{name: "ordinal", selection: &VolumeSelection{Ordinals: []int{1}}, want: []string{"db-1/data", "db-1/wal"}},
+		{name: "pod", selection: &VolumeSelection{Pods: []string{"db-0"}}, want: []string{"db-0/data", "db-0/wal"}},
+		{name: "volume", selection: &VolumeSelection{Volumes: []string{"data"}}, want: []string{"db-0/data", "db-1/data"}},
+		{name: "exclude", selection: &VolumeSelection{ExcludeVolumes: []string{"wal"}}, want: []string{"db-0/data", "db-1/data"}},
+		{
+			name:      "combined",
+			selection: &VolumeSelection{Ordinals: []int{0}, Volumes: []string{"wal"}},
+			want:      []string{"db-0/wal"},
+		},
+		{
+			name:      "typos",
+			selection: &VolumeSelection{Ordinals: []int{2}, Pods: []string{"db-9"}, Volumes: []string{"dta"}},
+			wantErr:   `no PVC-backed volumes found for ordinal 2, pod "db-9", volume "dta"`,
+		},
+		{
+			name:      "nothing left",
+			selection: &VolumeSelection{Ordinals: []int{0}, Pods: []string{"db-1"}},
+			wantErr:   "leaves no volumes",
+		},
+		{
+			name:      "all excluded",
+			selection: &VolumeSelection{ExcludeVolumes: []string{"data", "wal"}},
+			wantErr:   "leaves no volumes",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SelectPodVolumes(vols, tt.selection)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, names(got))
+		})
+	}
+}
@@ -4,15 +4,17 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
-	"path/filepath"
-	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/hashmap-kz/kubectl-syncpod/internal/version"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
 )
 
@@ -24,6 +26,7 @@ type PodVolume struct {
 	MountPath  string
 	Container  string
 	ReadOnly   bool
+	PVCSpec    *PVCSpec // set when discovered via PVCs (selector, namespace)
 }
 
 func DiscoverStatefulSetPVCs(
@@ -38,7 +41,10 @@ func DiscoverStatefulSetPVCs(
 		return nil, fmt.Errorf("get statefulset: %w", err)
 	}
 
-	selector := labels.Set(sts.Spec.Selector.MatchLabels).AsSelector()
+	selector, err := metav1.LabelSelectorAsSelector(sts.Spec.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("statefulset selector: %w", err)
+	}
 
 	pods, err := client.CoreV1().
 		Pods(namespace).
@@ -49,69 +55,133 @@ func DiscoverStatefulSetPVCs(
 		return nil, fmt.Errorf("list pods: %w", err)
 	}
 
+	// claims of the volumeClaimTemplates outlive their pods, so replicas that are scaled down
+	// or pending are found by the names of the claims
+	claims, err := client.CoreV1().
+		PersistentVolumeClaims(namespace).
+		List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("list pvcs: %w", err)
+	}
+
 	var result []PodVolume
+	seen := map[string]bool{}
 
 	for i := range len(pods.Items) {
 		pod := pods.Items[i]
-		ordinal := extractOrdinal(pod.Name)
-
-		// Map volumeName -> PVCName
-		pvcVolumes := map[string]string{}
-
-		for i := range len(pod.Spec.Volumes) {
-			v := pod.Spec.Volumes[i]
-			if v.PersistentVolumeClaim != nil {
-				pvcVolumes[v.Name] = v.PersistentVolumeClaim.ClaimName
-			}
+		// one entry per mount, as the StatefulSet backups always had
+		for _, v := range podSpecMounts(pod.Name, extractOrdinal(pod.Name), &pod.Spec) {
+			seen[v.PodName+"/"+v.VolumeName] = true
+			result = append(result, v)
 		}
+	}
 
-		if len(pvcVolumes) == 0 {
-			continue
+	for _, v := range claimTemplateVolumes(sts, claims.Items) {
+		if !seen[v.PodName+"/"+v.VolumeName] {
+			result = append(result, v)
 		}
+	}
+
+	// Optional: sort for deterministic output
+	return sortPodVolumes(result), nil
+}
+
+// claimTemplateVolumes lists the existing claims of the volumeClaimTemplates, named
+// <template>-<sts>-<ordinal>, with the mounts of the pod template.
+func claimTemplateVolumes(sts *appsv1.StatefulSet, claims []corev1.PersistentVolumeClaim) []PodVolume {
+	var result []PodVolume
+	for i := range sts.Spec.VolumeClaimTemplates {
+		tmpl := sts.Spec.VolumeClaimTemplates[i].Name
+		mount, container := templateMount(&sts.Spec.Template.Spec, tmpl)
+		prefix := tmpl + "-" + sts.Name + "-"
+
+		for j := range claims {
+			claim := &claims[j]
+			suffix, ok := strings.CutPrefix(claim.Name, prefix)
+			if !ok {
+				continue
+			}
+			ordinal, err := strconv.Atoi(suffix)
+			if err != nil || ordinal < 0 || strconv.Itoa(ordinal) != suffix {
+				continue
+			}
+			if claim.Status.Phase != corev1.ClaimBound {
+				slog.Warn("skipping PVC that is not bound",
+					slog.String("pvc", claim.Name),
+					slog.String("phase", string(claim.Status.Phase)),
+				)
+				continue
+			}
+			if isBlockClaim(claim) {
+				slog.Warn("skipping PVC in block volume mode", slog.String("pvc", claim.Name))
+				continue
+			}
 
-		for i := range len(pod.Spec.Containers) {
-			c := pod.Spec.Containers[i]
-			for _, m := range c.VolumeMounts {
-				pvcName, ok := pvcVolumes[m.Name]
-				if !ok {
-					continue // not a PVC-backed volume
-				}
-
-				result = append(result, PodVolume{
-					PodName:    pod.Name,
-					Ordinal:    ordinal,
-					VolumeName: m.Name,
-					PVCName:    pvcName,
-					MountPath:  m.MountPath,
-					Container:  c.Name,
-					ReadOnly:   m.ReadOnly,
-				})
+			vol := PodVolume{
+				PodName:    sts.Name + "-" + suffix,
+				Ordinal:    ordinal,
+				VolumeName: tmpl,
+				PVCName:    claim.Name,
+				MountPath:  DefaultMountPath,
+				PVCSpec:    PVCSpecFromClaim(claim),
+			}
+			if mount != nil {
+				vol.MountPath = mount.MountPath
+				vol.Container = container
+				vol.ReadOnly = mount.ReadOnly
 			}
+			result = append(result, vol)
 		}
 	}
+	return result
+}
 
-	// Optional: sort for deterministic output
-	sort.Slice(result, func(i, j int) bool {
-		if result[i].PodName != result[j].PodName {
-			return result[i].PodName < result[j].PodName
+// templateMount returns the first mount of the named volume in the containers of the spec.
+func templateMount(spec *corev1.PodSpec, volumeName string) (mount *corev1.VolumeMount, container string) {
+	for i := range spec.Containers {
+		c := &spec.Containers[i]
+		for j := range c.VolumeMounts {
+			if c.VolumeMounts[j].Name == volumeName {
+				return &c.VolumeMounts[j], c.Name
+			}
 		}
-		return result[i].VolumeName < result[j].VolumeName
-	})
-
-	return result, nil
+	}
+	return nil, ""
 }
 
 // manifest
 
+// ManifestVersion is the version of written manifests, version 1 manifests are still read.
+const ManifestVersion = 2
+
+const (
+	ManifestKindStatefulSet = "StatefulSetBackupManifest"
+	ManifestKindWorkload    = "WorkloadBackupManifest"
+	ManifestKindNamespace   = "NamespaceBackupManifest"
+)
+
 type StatefulSetBackupManifest struct {
-	Version     int                 `json:"version"`
-	Kind        string              `json:"kind"`
-	Namespace   string              `json:"namespace"`
-	StatefulSet string              `json:"statefulset"`
+	Version     int    `json:"version"`
+	Kind        string `json:"kind"`
+	Namespace   string `json:"namespace"`
+	StatefulSet string `json:"statefulset"`
+	// Workload is set for manifests of kind WorkloadBackupManifest
+	Workload *WorkloadRef `json:"workload,omitempty"`
+	// VolumeClaimTemplates of the StatefulSet, so it can be recreated with the same claims
+	VolumeClaimTemplates []ClaimTemplate `json:"volume_claim_templates,omitempty"`
+	// Selection is set for a partial backup, only the selected volumes were captured
+	Selection   *VolumeSelection    `json:"selection,omitempty"`
+	ToolVersion string              `json:"tool_version,omitempty"`
 	CapturedAt  time.Time           `json:"captured_at"`
+	FinishedAt  time.Time           `json:"finished_at,omitzero"`
 	Entries     []StatefulSetVolume `json:"entries"`
 }
 
+type ClaimTemplate struct {
+	Name string   `json:"name"`
+	Spec *PVCSpec `json:"spec"`
+}
+
 type StatefulSetVolume struct {
 	PodName    string `json:"pod_name"`
 	Ordinal    int    `json:"ordinal"`
@@ -121,12 +191,61 @@ type StatefulSetVolume struct {
 	Container  string `json:"container,omitempty"`
 	ReadOnly   bool   `json:"read_only,omitempty"`
 	LocalPath  string `json:"local_path"`
+	// PVC is the spec of the claim, so missing claims can be recreated, and capacity checked on upload
+	PVC *PVCSpec `json:"pvc,omitempty"`
+	// Bytes, FileCount and Digest summarize the inventory (version 2)
+	Bytes     int64  `json:"bytes,omitempty"`
+	FileCount int    `json:"file_count,omitempty"`
+	Digest    string `json:"digest,omitempty"`
+	// Files is the inventory of the volume, recorded while it is downloaded (used by verify)
+	Files []ManifestFile `json:"files,omitempty"`
 }
 
 func BuildStatefulSetBackupManifest(namespace, sts string, vols []PodVolume) *StatefulSetBackupManifest {
+	return &StatefulSetBackupManifest{
+		Version:     ManifestVersion,
+		Kind:        ManifestKindStatefulSet,
+		Namespace:   namespace,
+		StatefulSet: sts,
+		ToolVersion: version.String(),
+		CapturedAt:  time.Now().UTC(),
+		Entries:     buildManifestEntries(vols),
+	}
+}
+
+func BuildWorkloadBackupManifest(namespace string, ref *WorkloadRef, vols []PodVolume) *StatefulSetBackupManifest {
+	m := &StatefulSetBackupManifest{
+		Version:     ManifestVersion,
+		Kind:        ManifestKindWorkload,
+		Namespace:   namespace,
+		Workload:    ref,
+		ToolVersion: version.String(),
+		CapturedAt:  time.Now().UTC(),
+		Entries:     buildManifestEntries(vols),
+	}
+	// keeps the manifest usable by upload-sts
+	if ref.Kind == KindStatefulSet {
+		m.StatefulSet = ref.Name
+	}
+	return m
+}
+
+func BuildNamespaceBackupManifest(namespace string, vols []PodVolume) *StatefulSetBackupManifest {
+	return &StatefulSetBackupManifest{
+		Version:     ManifestVersion,
+		Kind:        ManifestKindNamespace,
+		Namespace:   namespace,
+		ToolVersion: version.String(),
+		CapturedAt:  time.Now().UTC(),
+		Entries:     buildManifestEntries(vols),
+	}
+}
+
+func buildManifestEntries(vols []PodVolume) []StatefulSetVolume {
 	entries := make([]StatefulSetVolume, 0, len(vols))
 
-	for _, v := range vols {
+	for i := range vols {
+		v := &vols[i]
 		entries = append(entries, StatefulSetVolume{
 			PodName:    v.PodName,
 			Ordinal:    v.Ordinal,
@@ -135,18 +254,35 @@ func BuildStatefulSetBackupManifest(namespace, sts string, vols []PodVolume) *St
 			MountPath:  v.MountPath,
 			Container:  v.Container,
 			ReadOnly:   v.ReadOnly,
-			LocalPath:  filepath.ToSlash(filepath.Join(v.PodName, v.VolumeName)),
+			LocalPath:  VolumeLocalPath(v),
+			PVC:        v.PVCSpec,
 		})
 	}
+	return entries
+}
 
-	return &StatefulSetBackupManifest{
-		Version:     1,
-		Kind:        "StatefulSetBackupManifest",
-		Namespace:   namespace,
-		StatefulSet: sts,
-		CapturedAt:  time.Now().UTC(),
-		Entries:     entries,
+// ManifestWorkload returns the workload a manifest was captured from,
+// manifests of kind StatefulSetBackupManifest are mapped to sts/<name>.
+func ManifestWorkload(m *StatefulSetBackupManifest) *WorkloadRef {
+	if m.Workload != nil {
+		return m.Workload
 	}
+	return &WorkloadRef{Kind: KindStatefulSet, Name: m.StatefulSet}
+}
+
+// UpgradeManifest converts a manifest to the current version in place, the totals and digests
+// of entries are computed from their inventories. Reports whether the manifest was changed.
+func UpgradeManifest(m *StatefulSetBackupManifest) bool {
+	if m.Version == ManifestVersion {
+		return false
+	}
+	for i := range m.Entries {
+		if len(m.Entries[i].Files) > 0 {
+			SetEntryInventory(&m.Entries[i], m.Entries[i].Files)
+		}
+	}
+	m.Version = ManifestVersion
+	return true
 }
 
 func WriteStatefulSetBackupManifest(path string, m *StatefulSetBackupManifest) error {
@@ -156,7 +292,11 @@ func WriteStatefulSetBackupManifest(path string, m *StatefulSetBackupManifest) e
 	}
 	defer f.Close()
 
-	enc := json.NewEncoder(f)
+	return EncodeStatefulSetBackupManifest(f, m)
+}
+
+func EncodeStatefulSetBackupManifest(w io.Writer, m *StatefulSetBackupManifest) error {
+	enc := json.NewEncoder(w)
 	enc.SetIndent("", "  ")
 
 	if err := enc.Encode(m); err != nil {
@@ -172,15 +312,19 @@ func ReadStatefulSetBackupManifest(path string) (*StatefulSetBackupManifest, err
 	}
 	defer f.Close()
 
+	return DecodeStatefulSetBackupManifest(f)
+}
+
+func DecodeStatefulSetBackupManifest(r io.Reader) (*StatefulSetBackupManifest, error) {
 	var m StatefulSetBackupManifest
-	if err := json.NewDecoder(f).Decode(&m); err != nil {
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
 		return nil, fmt.Errorf("decode manifest: %w", err)
 	}
 
-	if m.Kind != "StatefulSetBackupManifest" {
+	if m.Kind != ManifestKindStatefulSet && m.Kind != ManifestKindWorkload && m.Kind != ManifestKindNamespace {
 		return nil, fmt.Errorf("unexpected manifest kind %q", m.Kind)
 	}
-	if m.Version != 1 {
+	if m.Version < 1 || m.Version > ManifestVersion {
 		return nil, fmt.Errorf("unsupported manifest version %d", m.Version)
 	}
 
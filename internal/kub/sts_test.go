@@ -0,0 +1,149 @@
+package kub
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testClaim(namespace, name string, phase corev1.PersistentVolumeClaimPhase) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Status:     corev1.PersistentVolumeClaimStatus{Phase: phase},
+	}
+}
+
+func testPodSpec(container string, mounts map[string]string) corev1.PodSpec {
+	spec := corev1.PodSpec{Containers: []corev1.Container{{Name: container}}}
+	for volume, claim := range mounts {
+		spec.Volumes = append(spec.Volumes, corev1.Volume{
+			Name: volume,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim},
+			},
+		})
+		spec.Containers[0].VolumeMounts = append(spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      volume,
+			MountPath: "/var/lib/" + volume,
+		})
+	}
+	return spec
+}
+
+func testStatefulSet(selector *metav1.LabelSelector) *appsv1.StatefulSet {
+	return &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "db"},
+		Spec: appsv1.StatefulSetSpec{
+			Selector: selector,
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{
+					Name:         "db",
+					VolumeMounts: []corev1.VolumeMount{{Name: "data", MountPath: "/var/lib/data"}},
+				}}},
+			},
+			VolumeClaimTemplates: []corev1.PersistentVolumeClaim{
+				{ObjectMeta: metav1.ObjectMeta{Name: "data"}},
+			},
+		},
+	}
+}
+
+func TestDiscoverStatefulSetPVCs(t *testing.T) {
+	sts := testStatefulSet(&metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "app", Operator: metav1.LabelSelectorOpIn, Values: []string{"db"}},
+		},
+	})
+	db0 := testPod("ns", "db-0", map[string]string{"app": "db"})
+	db0.Spec = testPodSpec("db", map[string]string{"data": "data-db-0"})
+	web0 := testPod("ns", "web-0", map[string]string{"app": "web"})
+	web0.Spec = testPodSpec("web", map[string]string{"data": "data-web-0"})
+
+	client := fake.NewClientset(sts, db0, web0,
+		testClaim("ns", "data-db-0", corev1.ClaimBound),
+		// db-1 is scaled down, db-2 is pending
+		testClaim("ns", "data-db-1", corev1.ClaimBound),
+		testClaim("ns", "data-db-2", corev1.ClaimPending),
+		testClaim("ns", "data-web-0", corev1.ClaimBound),
+	)
+
+	vols, err := DiscoverStatefulSetPVCs(context.Background(), client, "ns", "db")
+	require.NoError(t, err)
+	require.Len(t, vols, 2)
+
+	require.Equal(t, "db-0", vols[0].PodName)
+	require.Equal(t, "data-db-0", vols[0].PVCName)
+	require.Nil(t, vols[0].PVCSpec, "running pods are found through their spec")
+
+	require.Equal(t, "db-1", vols[1].PodName)
+	require.Equal(t, 1, vols[1].Ordinal)
+	require.Equal(t, "data-db-1", vols[1].PVCName)
+	require.Equal(t, "/var/lib/data", vols[1].MountPath)
+	require.Equal(t, "db", vols[1].Container)
+	require.NotNil(t, vols[1].PVCSpec)
+}
+
+func TestDiscoverStatefulSetPVCsPerMount(t *testing.T) {
+	sts := testStatefulSet(&metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}})
+	db0 := testPod("ns", "db-0", map[string]string{"app": "db"})
+	db0.Spec = sidecarSpec("data-db-0")
+	client := fake.NewClientset(sts, db0, testClaim("ns", "data-db-0", corev1.ClaimBound))
+
+	// a volume mounted by two containers is listed per mount, as it was before workloads
+	vols, err := DiscoverStatefulSetPVCs(context.Background(), client, "ns", "db")
+	require.NoError(t, err)
+	require.Len(t, vols, 2)
+	for i := range vols {
+		require.Equal(t, "data-db-0", vols[i].PVCName)
+	}
+	require.ElementsMatch(t, []string{"db", "backup"}, []string{vols[0].Container, vols[1].Container})
+}
+
+func TestClaimTemplateVolumes(t *testing.T) {
+	sts := testStatefulSet(&metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}})
+	sts.Spec.VolumeClaimTemplates = append(sts.Spec.VolumeClaimTemplates,
+		corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "scratch"}})
+	block := testClaim("ns", "data-db-4", corev1.ClaimBound)
+	mode := corev1.PersistentVolumeBlock
+	block.Spec.VolumeMode = &mode
+
+	claims := []corev1.PersistentVolumeClaim{
+		*testClaim("ns", "data-db-0", corev1.ClaimBound),
+		*testClaim("ns", "scratch-db-2", corev1.ClaimBound),
+		*testClaim("ns", "data-db-3", corev1.ClaimPending),
+		*block,
+		// not claims of the template: another StatefulSet, no ordinal, a padded ordinal
+		*testClaim("ns", "data-db-backup-0", corev1.ClaimBound),
+		*testClaim("ns", "data-db-", corev1.ClaimBound),
+		*testClaim("ns", "data-db-01", corev1.ClaimBound),
+		*testClaim("ns", "data-web-0", corev1.ClaimBound),
+	}
+
+	vols := sortPodVolumes(claimTemplateVolumes(sts, claims))
+	require.Equal(t, []PodVolume{
+		{
+			PodName:    "db-0",
+			Ordinal:    0,
+			VolumeName: "data",
+			PVCName:    "data-db-0",
+			MountPath:  "/var/lib/data",
+			Container:  "db",
+			PVCSpec:    vols[0].PVCSpec,
+		},
+		{
+			// not mounted by the pod template
+			PodName:    "db-2",
+			Ordinal:    2,
+			VolumeName: "scratch",
+			PVCName:    "scratch-db-2",
+			MountPath:  DefaultMountPath,
+			PVCSpec:    vols[1].PVCSpec,
+		},
+	}, vols)
+	require.NotNil(t, vols[0].PVCSpec)
+}
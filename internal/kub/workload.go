@@ -0,0 +1,258 @@
+package kub
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	KindStatefulSet = "StatefulSet"
+	KindDeployment  = "Deployment"
+	KindDaemonSet   = "DaemonSet"
+	KindCronJob     = "CronJob"
+	KindPod         = "Pod"
+
+	// DefaultMountPath is used for PVCs that are not mounted by any pod, the helper pod
+	// is free to mount them anywhere.
+	DefaultMountPath = "/data"
+)
+
+var workloadKindAliases = map[string]string{
+	"sts":          KindStatefulSet,
+	"statefulset":  KindStatefulSet,
+	"statefulsets": KindStatefulSet,
+	"deploy":       KindDeployment,
+	"deployment":   KindDeployment,
+	"deployments":  KindDeployment,
+	"ds":           KindDaemonSet,
+	"daemonset":    KindDaemonSet,
+	"daemonsets":   KindDaemonSet,
+	"cj":           KindCronJob,
+	"cronjob":      KindCronJob,
+	"cronjobs":     KindCronJob,
+	"po":           KindPod,
+	"pod":          KindPod,
+	"pods":         KindPod,
+}
+
+// WorkloadRef is either a kind/name pair, or a label selector of PVCs.
+type WorkloadRef struct {
+	Kind     string `json:"kind,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Selector string `json:"selector,omitempty"`
+}
+
+func (w *WorkloadRef) String() string {
+	if w.Selector != "" {
+		return "pvc -l " + w.Selector
+	}
+	return w.Kind + "/" + w.Name
+}
+
+func (w *WorkloadRef) Equal(other *WorkloadRef) bool {
+	if w == nil || other == nil {
+		return w == other
+	}
+	return w.Kind == other.Kind && w.Name == other.Name && w.Selector == other.Selector
+}
+
+// ParseWorkloadRef accepts `kind/name` (sts, deploy, ds, cj, pod and their long forms),
+// or a PVC label selector. Exactly one of them must be given.
+func ParseWorkloadRef(arg, selector string) (*WorkloadRef, error) {
+	arg = strings.TrimSpace(arg)
+	selector = strings.TrimSpace(selector)
+
+	if arg != "" && selector != "" {
+		return nil, fmt.Errorf("either kind/name or a label selector is expected, not both")
+	}
+	if selector != "" {
+		return &WorkloadRef{Selector: selector}, nil
+	}
+
+	kind, name, ok := strings.Cut(arg, "/")
+	if !ok || kind == "" || name == "" {
+		return nil, fmt.Errorf("expected kind/name (e.g. sts/rabbitmq, deploy/app), got %q", arg)
+	}
+	resolved, ok := workloadKindAliases[strings.ToLower(kind)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported workload kind %q", kind)
+	}
+	return &WorkloadRef{Kind: resolved, Name: name}, nil
+}
+
+// DiscoverWorkloadPVCs resolves all PVC-backed volumes of a workload.
+//
+// StatefulSets and Pods are resolved via pods, so volumes are reported per pod.
+// Deployments, DaemonSets and CronJobs share the same claims across replicas,
+// so they are resolved via the pod template, and reported once per volume.
+// A label selector matches bound PVCs directly (mounted or not).
+func DiscoverWorkloadPVCs(ctx context.Context, client kubernetes.Interface, namespace string, ref *WorkloadRef) ([]PodVolume, error) {
+	if ref.Selector != "" {
+		return discoverSelectedPVCs(ctx, client, namespace, ref.Selector)
+	}
+
+	switch ref.Kind {
+	case KindStatefulSet:
+		return DiscoverStatefulSetPVCs(ctx, client, namespace, ref.Name)
+	case KindPod:
+		pod, err := client.CoreV1().Pods(namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("get pod: %w", err)
+		}
+		return sortPodVolumes(podSpecVolumes(pod.Name, extractOrdinal(pod.Name), &pod.Spec)), nil
+	case KindDeployment:
+		deploy, err := client.AppsV1().Deployments(namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("get deployment: %w", err)
+		}
+		return sortPodVolumes(podSpecVolumes("", -1, &deploy.Spec.Template.Spec)), nil
+	case KindDaemonSet:
+		ds, err := client.AppsV1().DaemonSets(namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("get daemonset: %w", err)
+		}
+		return sortPodVolumes(podSpecVolumes("", -1, &ds.Spec.Template.Spec)), nil
+	case KindCronJob:
+		cj, err := client.BatchV1().CronJobs(namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("get cronjob: %w", err)
+		}
+		return sortPodVolumes(podSpecVolumes("", -1, &cj.Spec.JobTemplate.Spec.Template.Spec)), nil
+	default:
+		return nil, fmt.Errorf("unsupported workload kind %q", ref.Kind)
+	}
+}
+
+// VolumeLocalPath is the slash-separated path of a volume inside a backup root:
+// <pod>/<volume> for per-pod volumes, <volume> otherwise.
+func VolumeLocalPath(v *PodVolume) string {
+	if v.PodName == "" {
+		return v.VolumeName
+	}
+	return v.PodName + "/" + v.VolumeName
+}
+
+func discoverSelectedPVCs(ctx context.Context, client kubernetes.Interface, namespace, selector string) ([]PodVolume, error) {
+	pvcs, err := client.CoreV1().
+		PersistentVolumeClaims(namespace).
+		List(ctx, metav1.ListOptions{
+			LabelSelector: selector,
+		})
+	if err != nil {
+		return nil, fmt.Errorf("list pvcs: %w", err)
+	}
+
+	pods, err := client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("list pods: %w", err)
+	}
+
+	// the mount path is informational here, take it from any pod that mounts the claim
+	mountPaths := map[string]PodVolume{}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		for _, v := range podSpecVolumes(pod.Name, extractOrdinal(pod.Name), &pod.Spec) {
+			if _, ok := mountPaths[v.PVCName]; !ok {
+				mountPaths[v.PVCName] = v
+			}
+		}
+	}
+
+	result := make([]PodVolume, 0, len(pvcs.Items))
+	for i := range pvcs.Items {
+		pvc := &pvcs.Items[i]
+		if pvc.Status.Phase != corev1.ClaimBound {
+			slog.Warn("skipping PVC that is not bound",
+				slog.String("pvc", pvc.Name),
+				slog.String("phase", string(pvc.Status.Phase)),
+			)
+			continue
+		}
+		if isBlockClaim(pvc) {
+			slog.Warn("skipping PVC in block volume mode", slog.String("pvc", pvc.Name))
+			continue
+		}
+		vol := PodVolume{
+			Ordinal:    -1,
+			VolumeName: pvc.Name,
+			PVCName:    pvc.Name,
+			MountPath:  DefaultMountPath,
+			PVCSpec:    PVCSpecFromClaim(pvc),
+		}
+		if mounted, ok := mountPaths[pvc.Name]; ok {
+			vol.MountPath = mounted.MountPath
+			vol.Container = mounted.Container
+			vol.ReadOnly = mounted.ReadOnly
+		}
+		result = append(result, vol)
+	}
+
+	return sortPodVolumes(result), nil
+}
+
+// podSpecVolumes lists the PVC-backed volumes of the given spec, one entry per volume
+// with its first mount.
+func podSpecVolumes(podName string, ordinal int, spec *corev1.PodSpec) []PodVolume {
+	var result []PodVolume
+	seen := map[string]bool{}
+	for _, v := range podSpecMounts(podName, ordinal, spec) {
+		if !seen[v.VolumeName] {
+			seen[v.VolumeName] = true
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// podSpecMounts lists the PVC-backed volume mounts of the given spec, one entry per mount.
+func podSpecMounts(podName string, ordinal int, spec *corev1.PodSpec) []PodVolume {
+	// Map volumeName -> PVCName
+	pvcVolumes := map[string]string{}
+	for i := range spec.Volumes {
+		v := spec.Volumes[i]
+		if v.PersistentVolumeClaim != nil {
+			pvcVolumes[v.Name] = v.PersistentVolumeClaim.ClaimName
+		}
+	}
+	if len(pvcVolumes) == 0 {
+		return nil
+	}
+
+	var result []PodVolume
+	for i := range spec.Containers {
+		c := spec.Containers[i]
+		for _, m := range c.VolumeMounts {
+			pvcName, ok := pvcVolumes[m.Name]
+			if !ok {
+				continue
+			}
+			result = append(result, PodVolume{
+				PodName:    podName,
+				Ordinal:    ordinal,
+				VolumeName: m.Name,
+				PVCName:    pvcName,
+				MountPath:  m.MountPath,
+				Container:  c.Name,
+				ReadOnly:   m.ReadOnly,
+			})
+		}
+	}
+	return result
+}
+
+func sortPodVolumes(vols []PodVolume) []PodVolume {
+	sort.Slice(vols, func(i, j int) bool {
+		if vols[i].PodName != vols[j].PodName {
+			return vols[i].PodName < vols[j].PodName
+		}
+		return vols[i].VolumeName < vols[j].VolumeName
+	})
+	return vols
+}
@@ -0,0 +1,126 @@
+package kub
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseWorkloadRef(t *testing.T) {
+	tests := []struct {
+		arg      string
+		selector string
+		want     *WorkloadRef
+		wantErr  string
+	}{
+		{arg: "sts/rabbitmq", want: &WorkloadRef{Kind: KindStatefulSet, Name: "rabbitmq"}},
+		{arg: " Deployment/app ", want: &WorkloadRef{Kind: KindDeployment, Name: "app"}},
+		{arg: "ds/agent", want: &WorkloadRef{Kind: KindDaemonSet, Name: "agent"}},
+		{arg: "cronjobs/report", want: &WorkloadRef{Kind: KindCronJob, Name: "report"}},
+		{arg: "po/web-0", want: &WorkloadRef{Kind: KindPod, Name: "web-0"}},
+		{selector: "app=db", want: &WorkloadRef{Selector: "app=db"}},
+		{arg: "sts/db", selector: "app=db", wantErr: "not both"},
+		{arg: "rabbitmq", wantErr: "expected kind/name"},
+		{arg: "sts/", wantErr: "expected kind/name"},
+		{arg: "", wantErr: "expected kind/name"},
+		{arg: "rs/app", wantErr: `unsupported workload kind "rs"`},
+	}
+	for _, tt := range tests {
+		got, err := ParseWorkloadRef(tt.arg, tt.selector)
+		if tt.wantErr != "" {
+			require.ErrorContains(t, err, tt.wantErr, tt.arg)
+			continue
+		}
+		require.NoError(t, err, tt.arg)
+		require.Equal(t, tt.want, got, tt.arg)
+	}
+}
+
+// sidecarSpec mounts the data volume in two containers.
+func sidecarSpec(claim string) corev1.PodSpec {
+	spec := testPodSpec("db", map[string]string{"data": claim})
+	spec.Containers = append(spec.Containers, corev1.Container{
+		Name:         "backup",
+		VolumeMounts: []corev1.VolumeMount{{Name: "data", MountPath: "/backup", ReadOnly: true}},
+	})
+	return spec
+}
+
+func TestPodSpecVolumes(t *testing.T) {
+	spec := sidecarSpec("data-db-0")
+	spec.Volumes = append(spec.Volumes, corev1.Volume{
+		Name:         "tmp",
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	})
+	spec.Containers[0].VolumeMounts = append(spec.Containers[0].VolumeMounts, corev1.VolumeMount{Name: "tmp", MountPath: "/tmp"})
+
+	mounts := podSpecMounts("db-0", 0, &spec)
+	require.Len(t, mounts, 2)
+	require.Equal(t, "/backup", mounts[1].MountPath)
+	require.True(t, mounts[1].ReadOnly)
+
+	vols := podSpecVolumes("db-0", 0, &spec)
+	require.Equal(t, []PodVolume{{
+		PodName:    "db-0",
+		Ordinal:    0,
+		VolumeName: "data",
+		PVCName:    "data-db-0",
+		MountPath:  "/var/lib/data",
+		Container:  "db",
+	}}, vols)
+
+	require.Empty(t, podSpecVolumes("db-0", 0, &corev1.PodSpec{}))
+}
+
+func TestDiscoverWorkloadPVCs(t *testing.T) {
+	pod := testPod("ns", "db-0", nil)
+	pod.Spec = sidecarSpec("data-db-0")
+	client := fake.NewClientset(pod)
+
+	// a pod reports each volume once
+	vols, err := DiscoverWorkloadPVCs(context.Background(), client, "ns", &WorkloadRef{Kind: KindPod, Name: "db-0"})
+	require.NoError(t, err)
+	require.Len(t, vols, 1)
+	require.Equal(t, "data-db-0", vols[0].PVCName)
+
+	_, err = DiscoverWorkloadPVCs(context.Background(), client, "ns", &WorkloadRef{Kind: "ReplicaSet", Name: "x"})
+	require.ErrorContains(t, err, "unsupported workload kind")
+}
+
+func TestDiscoverSelectedPVCs(t *testing.T) {
+	labeled := testClaim("ns", "data-db-0", corev1.ClaimBound)
+	labeled.Labels = map[string]string{"backup": "yes"}
+	pending := testClaim("ns", "data-db-1", corev1.ClaimPending)
+	pending.Labels = map[string]string{"backup": "yes"}
+	block := testClaim("ns", "raw", corev1.ClaimBound)
+	block.Labels = map[string]string{"backup": "yes"}
+	mode := corev1.PersistentVolumeBlock
+	block.Spec.VolumeMode = &mode
+
+	pod := testPod("ns", "db-0", nil)
+	pod.Spec = sidecarSpec("data-db-0")
+	client := fake.NewClientset(pod, labeled, pending, block, testClaim("ns", "other", corev1.ClaimBound))
+
+	vols, err := DiscoverWorkloadPVCs(context.Background(), client, "ns", &WorkloadRef{Selector: "backup=yes"})
+	require.NoError(t, err)
+	require.Len(t, vols, 1)
+	require.Equal(t, PodVolume{
+		Ordinal:    -1,
+		VolumeName: "data-db-0",
+		PVCName:    "data-db-0",
+		MountPath:  "/var/lib/data",
+		Container:  "db",
+		PVCSpec:    vols[0].PVCSpec,
+	}, vols[0])
+	require.NotNil(t, vols[0].PVCSpec)
+
+	// unmounted claims get the default mount path
+	client = fake.NewClientset(labeled)
+	vols, err = DiscoverWorkloadPVCs(context.Background(), client, "ns", &WorkloadRef{Selector: "backup=yes"})
+	require.NoError(t, err)
+	require.Equal(t, DefaultMountPath, vols[0].MountPath)
+	require.Empty(t, vols[0].PodName)
+}
@@ -0,0 +1,506 @@
+package pipe
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/bwlimit"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/sftp"
+)
+
+// stdioPath is the Local path that stands for stdin/stdout of the CLI.
+const stdioPath = "-"
+
+// ValidateFormat checks an archive format given by the user, an empty format is a plain directory tree.
+func ValidateFormat(format string) error {
+	switch format {
+	case "", dto.FormatTar, dto.FormatTarGz, dto.FormatTarZst:
+		return nil
+	default:
+		return fmt.Errorf("unknown format: %s (expected %s, %s or %s)", format, dto.FormatTar, dto.FormatTarGz, dto.FormatTarZst)
+	}
+}
+
+// download
+
+// downloadArchive builds a tar stream from the SFTP walk of remotePath, and writes it into opts.Local.
+func downloadArchive(ctx context.Context, client *sftp.Client, remotePath string, opts *dto.JobOpts) (err error) {
+	sink, err := openArchiveSink(opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := sink.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+
+	out := &countingWriter{w: sink}
+	tw := tar.NewWriter(out)
+	files, err := writeTarTree(ctx, client, opts.Limiter, opts.Inventory, tw, remotePath, "")
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("finish tar stream: %w", err)
+	}
+	opts.Stats.Add(int64(files), out.n)
+
+	slog.Info("archive written",
+		slog.String("format", opts.Format),
+		slog.String("dst", opts.Local),
+		slog.Int("files", files),
+	)
+	return nil
+}
+
+// writeTarTree appends the SFTP walk of remotePath to tw. Entry names are relative to remotePath,
+// under prefix when given (the prefix itself is written as the entry of remotePath).
+// Entries are recorded in inv relative to remotePath.
+func writeTarTree(
+	ctx context.Context,
+	client *sftp.Client,
+	lim *bwlimit.Limiter,
+	inv *kub.FileInventory,
+	tw *tar.Writer,
+	remotePath, prefix string,
+) (int, error) {
+	files := 0
+	walker := client.Walk(remotePath)
+	for walker.Step() {
+		if ctx.Err() != nil {
+			return files, ctx.Err()
+		}
+		if err := walker.Err(); err != nil {
+			return files, err
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), remotePath), "/")
+		name := rel
+		if prefix != "" {
+			name = path.Join(prefix, rel)
+		} else if name == "" {
+			continue
+		}
+		if rel == "" {
+			rel = "."
+		}
+		if err := writeTarEntry(ctx, client, lim, inv, tw, walker.Path(), name, rel, walker.Stat()); err != nil {
+			return files, err
+		}
+		files++
+	}
+	return files, nil
+}
+
+func writeTarEntry(
+	ctx context.Context,
+	client *sftp.Client,
+	lim *bwlimit.Limiter,
+	inv *kub.FileInventory,
+	tw *tar.Writer,
+	remotePath, name, rel string,
+	fi os.FileInfo,
+) error {
+	var link string
+	if fi.Mode()&os.ModeSymlink != 0 {
+		target, err := client.ReadLink(remotePath)
+		if err != nil {
+			return fmt.Errorf("read link %s: %w", remotePath, err)
+		}
+		link = target
+	}
+
+	hdr, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		return fmt.Errorf("tar header %s: %w", remotePath, err)
+	}
+	hdr.Name = name
+	if fi.IsDir() {
+		hdr.Name += "/"
+	}
+	if st, ok := fi.Sys().(*sftp.FileStat); ok {
+		hdr.Uid = int(st.UID)
+		hdr.Gid = int(st.GID)
+	}
+
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("write tar header %s: %w", name, err)
+	}
+	if !fi.Mode().IsRegular() {
+		inv.Add(kub.ManifestFile{Path: rel, Mode: fi.Mode().String()})
+		return nil
+	}
+
+	slog.Debug("archive file", slog.String("remote", remotePath))
+
+	f, err := client.Open(remotePath)
+	if err != nil {
+		return fmt.Errorf("open remote: %w", err)
+	}
+	defer f.Close()
+
+	var w io.Writer = tw
+	var h hash.Hash
+	if inv != nil {
+		h = sha256.New()
+		w = io.MultiWriter(tw, h)
+	}
+	n, err := io.Copy(w, lim.Reader(ctx, f))
+	if err != nil {
+		return fmt.Errorf("copy file %s: %w", remotePath, err)
+	}
+	if inv != nil {
+		inv.Add(kub.ManifestFile{Path: rel, Size: n, Mode: fi.Mode().String(), SHA256: hex.EncodeToString(h.Sum(nil))})
+	}
+	return nil
+}
+
+// upload
+
+// uploadArchive unpacks the tar stream from opts.Local into remotePath through SFTP.
+func uploadArchive(ctx context.Context, client *sftp.Client, remotePath string, opts *dto.JobOpts) (err error) {
+	src, err := openArchiveSource(opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := src.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+
+	in := &countingReader{r: src}
+	files, err := extractTarStream(ctx, client, tar.NewReader(opts.Limiter.Reader(ctx, in)), remotePath, opts.AllowOverwrite)
+	if err != nil {
+		return err
+	}
+	opts.Stats.Add(int64(files), in.n)
+
+	slog.Info("archive unpacked",
+		slog.String("format", opts.Format),
+		slog.String("src", opts.Local),
+		slog.Int("files", files),
+	)
+	return nil
+}
+
+// extractTarStream unpacks all entries of tr into remotePath through SFTP.
+func extractTarStream(ctx context.Context, client *sftp.Client, tr *tar.Reader, remotePath string, allowOverwrite bool) (int, error) {
+	if err := client.MkdirAll(remotePath); err != nil {
+		return 0, fmt.Errorf("mkdir remote: %w", err)
+	}
+
+	// mtimes of directories are restored at the end, since creating the entries inside updates them
+	type dirTime struct {
+		path  string
+		mtime time.Time
+	}
+	var dirs []dirTime
+
+	files := 0
+	for {
+		if ctx.Err() != nil {
+			return files, ctx.Err()
+		}
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return files, fmt.Errorf("read tar stream: %w", err)
+		}
+
+		target, err := archiveTarget(remotePath, hdr.Name)
+		if err != nil {
+			return files, err
+		}
+		if target == remotePath {
+			continue
+		}
+		if err := extractTarEntry(client, tr, hdr, remotePath, target, allowOverwrite); err != nil {
+			return files, err
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			dirs = append(dirs, dirTime{path: target, mtime: hdr.ModTime})
+		}
+		files++
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := client.Chtimes(dirs[i].path, dirs[i].mtime, dirs[i].mtime); err != nil {
+			return files, fmt.Errorf("chtimes %s: %w", dirs[i].path, err)
+		}
+	}
+	return files, nil
+}
+
+// archiveTarget resolves an entry name inside root, entries that escape it are rejected.
+func archiveTarget(root, name string) (string, error) {
+	cleaned := path.Clean("/" + name)
+	target := path.Join(root, cleaned)
+	if target != root && !strings.HasPrefix(target, strings.TrimSuffix(root, "/")+"/") {
+		return "", fmt.Errorf("tar entry escapes destination: %s", name)
+	}
+	return target, nil
+}
+
+// checkArchiveParents refuses an entry below a symlink, so it cannot be written through a link
+// extracted before it (x -> ../.., then x/f), outside of root.
+func checkArchiveParents(client *sftp.Client, root, target string) error {
+	dir := strings.TrimSuffix(root, "/")
+	rel := strings.TrimPrefix(path.Dir(target), dir)
+	for _, part := range strings.Split(strings.Trim(rel, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		dir += "/" + part
+		fi, err := client.Lstat(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			// created by MkdirAll as a real directory
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("lstat %s: %w", dir, err)
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("tar entry %s is below a symlink: %s", target, dir)
+		}
+	}
+	return nil
+}
+
+func extractTarEntry(client *sftp.Client, tr *tar.Reader, hdr *tar.Header, root, target string, allowOverwrite bool) error {
+	if err := checkArchiveParents(client, root, target); err != nil {
+		return err
+	}
+	// an existing symlink is replaced, never written (or chmod-ed) through
+	if fi, err := client.Lstat(target); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		if !allowOverwrite {
+			return fmt.Errorf("overwrite is forbidden, file already exists: %s", target)
+		}
+		if err := client.Remove(target); err != nil {
+			return fmt.Errorf("remove %s: %w", target, err)
+		}
+	}
+
+	isDir := hdr.Typeflag == tar.TypeDir
+	fileExists, err := remoteFileExists(client, target, isDir)
+	if err != nil {
+		return err
+	}
+	if fileExists && !allowOverwrite {
+		return fmt.Errorf("overwrite is forbidden, file already exists: %s", target)
+	}
+
+	if err := client.MkdirAll(path.Dir(target)); err != nil {
+		return fmt.Errorf("mkdir remote: %w", err)
+	}
+
+	slog.Debug("unpack entry", slog.String("remote", target))
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := client.MkdirAll(target); err != nil {
+			return fmt.Errorf("mkdir remote: %w", err)
+		}
+	case tar.TypeReg, tar.TypeRegA: //nolint:staticcheck // old archivers still write TypeRegA
+		f, err := client.Create(target)
+		if err != nil {
+			return fmt.Errorf("create remote: %w", err)
+		}
+		_, err = io.Copy(f, tr)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("copy file %s: %w", target, err)
+		}
+	case tar.TypeSymlink:
+		if fileExists {
+			if err := client.Remove(target); err != nil {
+				return fmt.Errorf("remove %s: %w", target, err)
+			}
+		}
+		if err := client.Symlink(hdr.Linkname, target); err != nil {
+			return fmt.Errorf("symlink %s: %w", target, err)
+		}
+		// ownership and times of the link would be applied to its target
+		return nil
+	case tar.TypeLink:
+		oldname, err := archiveTarget(root, hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		if fileExists {
+			if err := client.Remove(target); err != nil {
+				return fmt.Errorf("remove %s: %w", target, err)
+			}
+		}
+		if err := client.Link(oldname, target); err != nil {
+			return fmt.Errorf("link %s: %w", target, err)
+		}
+		return nil
+	default:
+		slog.Warn("skipping unsupported tar entry",
+			slog.String("name", hdr.Name),
+			slog.String("type", string(hdr.Typeflag)),
+		)
+		return nil
+	}
+
+	// chown before chmod, chown clears the setuid/setgid bits
+	if err := client.Chown(target, hdr.Uid, hdr.Gid); err != nil {
+		return fmt.Errorf("chown %s: %w", target, err)
+	}
+	if err := client.Chmod(target, hdr.FileInfo().Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+		return fmt.Errorf("chmod %s: %w", target, err)
+	}
+	if hdr.Typeflag != tar.TypeDir {
+		if err := client.Chtimes(target, hdr.ModTime, hdr.ModTime); err != nil {
+			return fmt.Errorf("chtimes %s: %w", target, err)
+		}
+	}
+	return nil
+}
+
+// streams
+
+func openArchiveSink(opts *dto.JobOpts) (io.WriteCloser, error) {
+	var out io.WriteCloser
+	if opts.Local == stdioPath {
+		if opts.Out == nil {
+			return nil, fmt.Errorf("(internal-error). output stream was not set")
+		}
+		out = nopWriteCloser{opts.Out}
+	} else {
+		f, err := os.Create(opts.Local)
+		if err != nil {
+			return nil, fmt.Errorf("create archive: %w", err)
+		}
+		out = f
+	}
+
+	switch opts.Format {
+	case dto.FormatTar:
+		return out, nil
+	case dto.FormatTarGz:
+		return &chainWriteCloser{WriteCloser: gzip.NewWriter(out), next: out}, nil
+	case dto.FormatTarZst:
+		zw, err := newZstdWriter(out)
+		if err != nil {
+			out.Close()
+			return nil, err
+		}
+		return &chainWriteCloser{WriteCloser: zw, next: out}, nil
+	default:
+		out.Close()
+		return nil, ValidateFormat(opts.Format)
+	}
+}
+
+func openArchiveSource(opts *dto.JobOpts) (io.ReadCloser, error) {
+	var in io.ReadCloser
+	if opts.Local == stdioPath {
+		if opts.In == nil {
+			return nil, fmt.Errorf("(internal-error). input stream was not set")
+		}
+		in = io.NopCloser(opts.In)
+	} else {
+		f, err := os.Open(opts.Local)
+		if err != nil {
+			return nil, fmt.Errorf("open archive: %w", err)
+		}
+		in = f
+	}
+
+	switch opts.Format {
+	case dto.FormatTar:
+		return in, nil
+	case dto.FormatTarGz:
+		zr, err := gzip.NewReader(in)
+		if err != nil {
+			in.Close()
+			return nil, fmt.Errorf("open gzip stream: %w", err)
+		}
+		return &chainReadCloser{ReadCloser: zr, next: in}, nil
+	case dto.FormatTarZst:
+		zr, err := newZstdReader(in)
+		if err != nil {
+			in.Close()
+			return nil, err
+		}
+		return &chainReadCloser{ReadCloser: zr, next: in}, nil
+	default:
+		in.Close()
+		return nil, ValidateFormat(opts.Format)
+	}
+}
+
+// zstd is not in the standard library, streams are (de)compressed in-process with klauspost/compress.
+
+func newZstdWriter(w io.Writer) (io.WriteCloser, error) {
+	zw, err := zstd.NewWriter(w)
+	if err != nil {
+		return nil, fmt.Errorf("zstd: %w", err)
+	}
+	return zw, nil
+}
+
+func newZstdReader(r io.Reader) (io.ReadCloser, error) {
+	// one stream at a time, the decoder would otherwise read ahead with a goroutine per core
+	zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, fmt.Errorf("zstd: %w", err)
+	}
+	return zr.IOReadCloser(), nil
+}
+
+// chainWriteCloser closes the wrapped writer, then the underlying one.
+type chainWriteCloser struct {
+	io.WriteCloser
+	next io.Closer
+}
+
+func (c *chainWriteCloser) Close() error {
+	err := c.WriteCloser.Close()
+	if nextErr := c.next.Close(); err == nil {
+		err = nextErr
+	}
+	return err
+}
+
+// chainReadCloser closes the wrapped reader, then the underlying one.
+type chainReadCloser struct {
+	io.ReadCloser
+	next io.Closer
+}
+
+func (c *chainReadCloser) Close() error {
+	err := c.ReadCloser.Close()
+	if nextErr := c.next.Close(); err == nil {
+		err = nextErr
+	}
+	return err
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,190 @@
+package pipe
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestSFTPClient serves the local filesystem over an in-process SFTP session.
+func newTestSFTPClient(t *testing.T) *sftp.Client {
+	t.Helper()
+	serverConn, clientConn := net.Pipe()
+	server, err := sftp.NewServer(serverConn)
+	require.NoError(t, err)
+	go func() { _ = server.Serve() }()
+
+	client, err := sftp.NewClientPipe(clientConn, clientConn)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+	return client
+}
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	body     string
+	linkname string
+}
+
+func buildTar(t *testing.T, entries []tarEntry) *tar.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:     e.name,
+			Typeflag: e.typeflag,
+			Linkname: e.linkname,
+			Size:     int64(len(e.body)),
+			Mode:     0o644,
+			Uid:      os.Getuid(),
+			Gid:      os.Getgid(),
+			ModTime:  time.Unix(1700000000, 0),
+		}
+		if e.typeflag == tar.TypeDir {
+			hdr.Mode = 0o755
+		}
+		require.NoError(t, tw.WriteHeader(hdr))
+		if e.body != "" {
+			_, err := tw.Write([]byte(e.body))
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, tw.Close())
+	return tar.NewReader(&buf)
+}
+
+func TestArchiveTarget(t *testing.T) {
+	tests := []struct {
+		root string
+		name string
+		want string
+	}{
+		{root: "/data", name: "a/b.txt", want: "/data/a/b.txt"},
+		{root: "/data", name: "./a/", want: "/data/a"},
+		{root: "/data", name: ".", want: "/data"},
+		{root: "/data/", name: "a", want: "/data/a"},
+		{root: "/data", name: "/etc/passwd", want: "/data/etc/passwd"},
+		{root: "/data", name: "../etc/passwd", want: "/data/etc/passwd"},
+		{root: "/data", name: "a/../../b", want: "/data/b"},
+		{root: "/", name: "a", want: "/a"},
+	}
+	for _, tt := range tests {
+		// names are rooted before they are joined, so no entry can escape
+		got, err := archiveTarget(tt.root, tt.name)
+		require.NoError(t, err, tt.name)
+		require.Equal(t, tt.want, got, tt.name)
+	}
+}
+
+func TestExtractTarStream(t *testing.T) {
+	client := newTestSFTPClient(t)
+	root := filepath.ToSlash(t.TempDir())
+
+	tr := buildTar(t, []tarEntry{
+		{name: "dir/", typeflag: tar.TypeDir},
+		{name: "dir/a.txt", typeflag: tar.TypeReg, body: "hello"},
+		{name: "link", typeflag: tar.TypeSymlink, linkname: "dir/a.txt"},
+		{name: "hard", typeflag: tar.TypeLink, linkname: "dir/a.txt"},
+	})
+	files, err := extractTarStream(context.Background(), client, tr, root, false)
+	require.NoError(t, err)
+	require.Equal(t, 4, files)
+
+	data, err := os.ReadFile(filepath.Join(root, "dir/a.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data))
+
+	linkname, err := os.Readlink(filepath.Join(root, "link"))
+	require.NoError(t, err)
+	require.Equal(t, "dir/a.txt", linkname)
+
+	data, err = os.ReadFile(filepath.Join(root, "hard"))
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data))
+
+	fi, err := os.Stat(filepath.Join(root, "dir"))
+	require.NoError(t, err)
+	require.Equal(t, time.Unix(1700000000, 0), fi.ModTime())
+}
+
+func TestExtractTarStreamSymlinkedParent(t *testing.T) {
+	client := newTestSFTPClient(t)
+	base := t.TempDir()
+	root := filepath.ToSlash(filepath.Join(base, "pvc"))
+	outside := filepath.Join(base, "sibling")
+	require.NoError(t, os.MkdirAll(outside, 0o750))
+
+	tr := buildTar(t, []tarEntry{
+		{name: "x", typeflag: tar.TypeSymlink, linkname: "../sibling"},
+		{name: "x/f", typeflag: tar.TypeReg, body: "escaped"},
+	})
+	_, err := extractTarStream(context.Background(), client, tr, root, true)
+	require.ErrorContains(t, err, "below a symlink")
+
+	_, err = os.Stat(filepath.Join(outside, "f"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestExtractTarStreamReplacesSymlink(t *testing.T) {
+	client := newTestSFTPClient(t)
+	base := t.TempDir()
+	root := filepath.ToSlash(filepath.Join(base, "pvc"))
+	outside := filepath.Join(base, "outside.txt")
+
+	// a dangling link to a file outside of root, then a regular file of the same name
+	tr := buildTar(t, []tarEntry{
+		{name: "f", typeflag: tar.TypeSymlink, linkname: outside},
+		{name: "f", typeflag: tar.TypeReg, body: "inside"},
+	})
+	_, err := extractTarStream(context.Background(), client, tr, root, true)
+	require.NoError(t, err)
+
+	_, err = os.Stat(outside)
+	require.ErrorIs(t, err, os.ErrNotExist)
+	fi, err := os.Lstat(filepath.Join(root, "f"))
+	require.NoError(t, err)
+	require.True(t, fi.Mode().IsRegular())
+
+	// without overwrite, the link is not replaced
+	root2 := filepath.ToSlash(filepath.Join(base, "pvc2"))
+	tr = buildTar(t, []tarEntry{
+		{name: "f", typeflag: tar.TypeSymlink, linkname: outside},
+		{name: "f", typeflag: tar.TypeReg, body: "inside"},
+	})
+	_, err = extractTarStream(context.Background(), client, tr, root2, false)
+	require.ErrorContains(t, err, "overwrite is forbidden")
+	_, err = os.Stat(outside)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestZstdRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	zw, err := newZstdWriter(&buf)
+	require.NoError(t, err)
+	payload := bytes.Repeat([]byte("syncpod "), 4096)
+	_, err = zw.Write(payload)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	require.Less(t, buf.Len(), len(payload))
+
+	zr, err := newZstdReader(&buf)
+	require.NoError(t, err)
+	got, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	require.NoError(t, zr.Close())
+	require.Equal(t, payload, got)
+}
@@ -0,0 +1,168 @@
+package pipe
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+const (
+	attachDoneFile = "/tmp/syncpod.done"
+	// ephemeral containers cannot be removed from a pod, so the one we inject
+	// has to exit by itself: either when the job is done, or after the deadline.
+	attachRunCmd = `
+apk update;
+apk add openssh-sftp-server;
+i=0;
+while [ ! -f ` + attachDoneFile + ` ] && [ "$i" -lt "${DEADLINE_SECONDS}" ]; do sleep 1; i=$((i+1)); done;
+`
+)
+
+// startAttached prepares a job that goes through an ephemeral container injected into an
+// already running pod. The ephemeral container shares the volume mounts of the target container,
+// so there is no need for a second mount of the PVC (ReadWriteOnce, CSI drivers that refuse it, etc...).
+// The data goes through the exec transport, since ephemeral containers cannot expose ports.
+func startAttached(ctx context.Context, opts *dto.RunOpts) (*dto.JobOpts, func(), error) {
+	if opts.Transport == dto.TransportNodePort {
+		slog.Info("attach mode always uses the exec transport")
+	}
+
+	config, client, err := initConfigAndClientForContext(opts.KubeContext)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	jobOpts := jobOptsForRun(&dto.JobOpts{
+		ObjName:    opts.ObjName,
+		PodName:    opts.AttachPod,
+		Container:  opts.ObjName,
+		Namespace:  opts.Namespace,
+		Transport:  dto.TransportExec,
+		User:       kubeconfigUser(opts.KubeContext),
+		Client:     client,
+		RestConfig: config,
+	}, opts, opts.MountPath)
+	teardown := func() {
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := stopEphemeralContainer(cleanupCtx, jobOpts); err != nil {
+			slog.Error("cannot stop ephemeral container", slog.Any("err", err))
+		} else {
+			slog.Info("ephemeral container stopped", slog.String("name", opts.ObjName))
+		}
+	}
+
+	slog.Info("injecting ephemeral container",
+		slog.String("pod", opts.AttachPod),
+		slog.String("name", opts.ObjName),
+	)
+	if err := createEphemeralContainer(ctx, client, opts.Namespace, opts.AttachPod, opts.Container, opts.ObjName); err != nil {
+		teardown()
+		return nil, nil, err
+	}
+	slog.Info("ephemeral container is running", slog.String("name", opts.ObjName))
+
+	return jobOpts, teardown, nil
+}
+
+func createEphemeralContainer(
+	ctx context.Context,
+	client *kubernetes.Clientset,
+	namespace, podName, targetContainer, objName string,
+) error {
+	pod, err := client.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("get pod: %w", err)
+	}
+	if pod.Status.Phase != corev1.PodRunning {
+		return fmt.Errorf("pod %s is not running (phase: %s)", podName, pod.Status.Phase)
+	}
+
+	target, err := findContainer(pod, targetContainer)
+	if err != nil {
+		return err
+	}
+
+	// subPath mounts are forbidden for ephemeral containers
+	mounts := make([]corev1.VolumeMount, 0, len(target.VolumeMounts))
+	for _, m := range target.VolumeMounts {
+		if m.SubPath != "" || m.SubPathExpr != "" {
+			slog.Warn("skipping subPath volume mount, not allowed in ephemeral containers",
+				slog.String("volume", m.Name),
+				slog.String("mount-path", m.MountPath),
+			)
+			continue
+		}
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:             m.Name,
+			MountPath:        m.MountPath,
+			ReadOnly:         m.ReadOnly,
+			MountPropagation: m.MountPropagation,
+		})
+	}
+
+	pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, corev1.EphemeralContainer{
+		EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+			Name:            objName,
+			Image:           helperImage,
+			ImagePullPolicy: corev1.PullIfNotPresent,
+			Command:         []string{"sh", "-c", attachRunCmd},
+			Env: []corev1.EnvVar{
+				{
+					Name:  "DEADLINE_SECONDS",
+					Value: fmt.Sprintf("%d", activeDeadlineSeconds),
+				},
+			},
+			VolumeMounts: mounts,
+		},
+	})
+
+	_, err = client.CoreV1().Pods(namespace).UpdateEphemeralContainers(ctx, podName, pod, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("add ephemeral container: %w", err)
+	}
+
+	_, err = waitHelperContainer(ctx, client, namespace, podName, objName, helperStartTimeout)
+	return err
+}
+
+func findContainer(pod *corev1.Pod, name string) (*corev1.Container, error) {
+	if len(pod.Spec.Containers) == 0 {
+		return nil, fmt.Errorf("pod %s has no containers", pod.Name)
+	}
+	if name == "" {
+		return &pod.Spec.Containers[0], nil
+	}
+	for i := range pod.Spec.Containers {
+		if pod.Spec.Containers[i].Name == name {
+			return &pod.Spec.Containers[i], nil
+		}
+	}
+	return nil, fmt.Errorf("container %q not found in pod %s", name, pod.Name)
+}
+
+// stopEphemeralContainer signals the injected container to exit, it stays in the pod spec as terminated.
+func stopEphemeralContainer(ctx context.Context, opts *dto.JobOpts) error {
+	executor, err := newPodExecutor(opts.RestConfig, podExecURL(opts, []string{"touch", attachDoneFile}, false))
+	if err != nil {
+		return err
+	}
+	var stderrBuf bytes.Buffer
+	err = executor.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdout: &bytes.Buffer{},
+		Stderr: &stderrBuf,
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, stderrBuf.String())
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package pipe
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// transfers are recorded as Kubernetes events of their PVC (and StatefulSet), so there is an
+// in-cluster trail of who moved what. Events are best effort, a transfer never fails because of them.
+
+const auditTimeout = 10 * time.Second
+
+// warned once, e.g. when RBAC does not allow to create events
+var auditWarning sync.Once
+
+// auditTransfer runs transfer, and records when it starts, and when it succeeds or fails.
+// A successful upload with jobOpts.Annotate records the restore on the PVC.
+func auditTransfer(ctx context.Context, mode string, jobOpts *dto.JobOpts, transfer func() error) error {
+	jobOpts.Stats = &dto.TransferStats{}
+	desc := describeTransfer(mode, jobOpts)
+
+	ref, refErr := transferRef(ctx, jobOpts)
+	if refErr != nil {
+		warnAudit(refErr)
+	}
+	recordAuditEvent(ctx, jobOpts.Client, ref, corev1.EventTypeNormal, kub.ReasonTransferStarted, mode, desc)
+
+	err := transfer()
+	if err != nil {
+		recordAuditEvent(ctx, jobOpts.Client, ref, corev1.EventTypeWarning, kub.ReasonTransferFailed, mode,
+			fmt.Sprintf("%s: %v", desc, err))
+		return err
+	}
+	recordAuditEvent(ctx, jobOpts.Client, ref, corev1.EventTypeNormal, kub.ReasonTransferSucceeded, mode,
+		fmt.Sprintf("%s: %d files, %s", desc, jobOpts.Stats.Files(), formatSize(jobOpts.Stats.Bytes())))
+
+	if jobOpts.Annotate && mode == "upload" && jobOpts.PVC != "" {
+		annotateRestore(ctx, jobOpts)
+	}
+	return nil
+}
+
+// auditStatefulSet runs a multi-volume transfer of a StatefulSet, and records it in the events of the StatefulSet.
+func auditStatefulSet(
+	ctx context.Context,
+	client kubernetes.Interface,
+	kubeContext, namespace, name, mode, local string,
+	transfer func() error,
+) error {
+	ref, refErr := kub.StatefulSetRef(ctx, client, namespace, name)
+	if refErr != nil {
+		warnAudit(refErr)
+	}
+	desc := fmt.Sprintf("%s of statefulset %s by %s, local %s", mode, name, kubeconfigUser(kubeContext), local)
+	recordAuditEvent(ctx, client, ref, corev1.EventTypeNormal, kub.ReasonTransferStarted, mode, desc)
+
+	err := transfer()
+	if err != nil {
+		recordAuditEvent(ctx, client, ref, corev1.EventTypeWarning, kub.ReasonTransferFailed, mode, fmt.Sprintf("%s: %v", desc, err))
+		return err
+	}
+	recordAuditEvent(ctx, client, ref, corev1.EventTypeNormal, kub.ReasonTransferSucceeded, mode, desc)
+	return nil
+}
+
+// describeTransfer tells who moves what from where to where, through which helper pod.
+func describeTransfer(mode string, jobOpts *dto.JobOpts) string {
+	target := "pvc " + jobOpts.PVC
+	if jobOpts.PVC == "" {
+		target = "pod " + jobOpts.PodName
+	}
+	remote := fmt.Sprintf("%s:%s", target, path.Join(jobOpts.MountPath, path.Clean(jobOpts.Remote)))
+	local := jobOpts.Local
+	if local == stdioPath {
+		local = "stdio"
+	}
+
+	from, to := local, remote
+	if mode == "download" || mode == "copy-out" {
+		from, to = remote, local
+	}
+	return fmt.Sprintf("%s by %s: %s -> %s (helper pod %s)", mode, jobOpts.User, from, to, jobOpts.PodName)
+}
+
+// transferRef is the object the events of a transfer belong to: its PVC, or the pod it is attached to.
+func transferRef(ctx context.Context, jobOpts *dto.JobOpts) (*corev1.ObjectReference, error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), auditTimeout)
+	defer cancel()
+	if jobOpts.PVC == "" {
+		return kub.PodRef(ctx, jobOpts.Client, jobOpts.Namespace, jobOpts.PodName)
+	}
+	return kub.PVCRef(ctx, jobOpts.Client, jobOpts.Namespace, jobOpts.PVC)
+}
+
+// recordAuditEvent records an event, also when ctx was canceled (a failed transfer is recorded after Ctrl-C).
+func recordAuditEvent(ctx context.Context, client kubernetes.Interface, ref *corev1.ObjectReference, eventType, reason, action, message string) {
+	if ref == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), auditTimeout)
+	defer cancel()
+	if err := kub.RecordEvent(ctx, client, ref, eventType, reason, action, message); err != nil {
+		warnAudit(err)
+	}
+}
+
+// annotateRestore records the last restore on the PVC.
+func annotateRestore(ctx context.Context, jobOpts *dto.JobOpts) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), auditTimeout)
+	defer cancel()
+	err := kub.AnnotatePVC(ctx, jobOpts.Client, jobOpts.Namespace, jobOpts.PVC, map[string]string{
+		kub.AnnotationLastRestoreAt:     time.Now().UTC().Format(time.RFC3339),
+		kub.AnnotationLastRestoreSource: jobOpts.Local,
+		kub.AnnotationLastRestoreBy:     jobOpts.User,
+	})
+	if err != nil {
+		slog.Warn("cannot record the restore on the PVC", slog.String("pvc", jobOpts.PVC), slog.Any("err", err))
+	}
+}
+
+func warnAudit(err error) {
+	auditWarning.Do(func() {
+		slog.Warn("cannot record audit events", slog.Any("err", err))
+	})
+}
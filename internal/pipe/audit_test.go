@@ -0,0 +1,94 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newAuditClient is a fake cluster with the given claims, it names the events created without a name.
+func newAuditClient(namespace string, claims ...string) *fake.Clientset {
+	objects := make([]runtime.Object, 0, len(claims))
+	for _, name := range claims {
+		objects = append(objects, &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}})
+	}
+	client := fake.NewClientset(objects...)
+	n := 0
+	client.PrependReactor("create", "events", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		ev := action.(k8stesting.CreateAction).GetObject().(*corev1.Event)
+		if ev.Name == "" {
+			n++
+			ev.Name = ev.GenerateName + string(rune('a'+n))
+		}
+		return false, nil, nil
+	})
+	return client
+}
+
+func auditEvents(t *testing.T, client *fake.Clientset, namespace, object string) []corev1.Event {
+	t.Helper()
+	events, err := client.CoreV1().Events(namespace).List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	var result []corev1.Event
+	for i := range events.Items {
+		if events.Items[i].InvolvedObject.Name == object {
+			result = append(result, events.Items[i])
+		}
+	}
+	return result
+}
+
+func TestDescribeTransfer(t *testing.T) {
+	job := &dto.JobOpts{PVC: "data", MountPath: "/data", Remote: "sub", Local: "./backup", User: "alice", PodName: "syncpod-1"}
+	tests := map[string]string{
+		"download": "download by alice: pvc data:/data/sub -> ./backup (helper pod syncpod-1)",
+		"upload":   "upload by alice: ./backup -> pvc data:/data/sub (helper pod syncpod-1)",
+		"copy-out": "copy-out by alice: pvc data:/data/sub -> ./backup (helper pod syncpod-1)",
+		"copy-in":  "copy-in by alice: ./backup -> pvc data:/data/sub (helper pod syncpod-1)",
+	}
+	for mode, want := range tests {
+		require.Equal(t, want, describeTransfer(mode, job), mode)
+	}
+}
+
+func TestAuditCopy(t *testing.T) {
+	client := newAuditClient("ns", "src", "dst")
+	srcJob := &dto.JobOpts{PVC: "src", Namespace: "ns", Client: client, MountPath: "/mnt/src", Remote: "."}
+	dstJob := &dto.JobOpts{PVC: "dst", Namespace: "ns", Client: client, MountPath: "/mnt/dst", Remote: "."}
+	srcJob.Local = copyPeer(dstJob, "/mnt/dst")
+	dstJob.Local = copyPeer(srcJob, "/mnt/src")
+
+	err := auditTransfer(context.Background(), "copy-out", srcJob, func() error {
+		return auditTransfer(context.Background(), "copy-in", dstJob, func() error {
+			dstJob.Stats.Add(2, 2048)
+			srcJob.Stats.Add(dstJob.Stats.Files(), dstJob.Stats.Bytes())
+			return nil
+		})
+	})
+	require.NoError(t, err)
+
+	for pvc, peer := range map[string]string{"src": "-> ns/pvc dst:/mnt/dst", "dst": "ns/pvc src:/mnt/src ->"} {
+		events := auditEvents(t, client, "ns", pvc)
+		require.Len(t, events, 2, pvc)
+		require.Equal(t, kub.ReasonTransferStarted, events[0].Reason)
+		require.Equal(t, kub.ReasonTransferSucceeded, events[1].Reason)
+		require.Contains(t, events[1].Message, peer)
+		require.Contains(t, events[1].Message, "2 files, 2.0KiB")
+	}
+
+	failed := errors.New("boom")
+	err = auditTransfer(context.Background(), "copy-in", dstJob, func() error { return failed })
+	require.ErrorIs(t, err, failed)
+	events := auditEvents(t, client, "ns", "dst")
+	require.Equal(t, kub.ReasonTransferFailed, events[len(events)-1].Reason)
+	require.Equal(t, corev1.EventTypeWarning, events[len(events)-1].Type)
+}
@@ -0,0 +1,442 @@
+package pipe
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
+)
+
+// A backup archive is a single tar stream holding the manifest and all <pod>/<volume> subtrees.
+//
+// Each of them is compressed as a separate frame (gzip member, zstd frame), so the file is still
+// an ordinary .tar.gz/.tar.zst for standard tools, while a single volume can be read by seeking
+// to its frame. The last frame holds index.json (offsets of all frames) and the end of the tar stream,
+// followed by a small trailer that points to it: a zstd skippable frame (also used for plain tar,
+// where it follows the end-of-archive marker), or an empty gzip member carrying the offset in its extra field.
+
+const (
+	backupArchiveIndexName    = "index.json"
+	backupArchiveManifestName = "manifest.json"
+	backupArchiveVersion      = 1
+
+	zstdSkippableMagic = 0x184D2A50
+	trailerMagic       = "SYNCPOD1"
+)
+
+type archiveFrame struct {
+	Name   string `json:"name"`
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+	Files  int    `json:"files"`
+}
+
+type archiveIndex struct {
+	Version int            `json:"version"`
+	Format  string         `json:"format"`
+	Frames  []archiveFrame `json:"frames"`
+}
+
+// ArchiveFormatFromPath resolves the format of a backup archive by its extension.
+func ArchiveFormatFromPath(p string) (string, error) {
+	switch {
+	case strings.HasSuffix(p, ".tar"):
+		return dto.FormatTar, nil
+	case strings.HasSuffix(p, ".tar.gz"), strings.HasSuffix(p, ".tgz"):
+		return dto.FormatTarGz, nil
+	case strings.HasSuffix(p, ".tar.zst"), strings.HasSuffix(p, ".tzst"):
+		return dto.FormatTarZst, nil
+	default:
+		return "", fmt.Errorf("cannot detect archive format of %s (expected .tar, .tar.gz or .tar.zst)", p)
+	}
+}
+
+// writer
+
+type backupArchiveWriter struct {
+	path   string
+	f      *os.File
+	offset int64
+	index  archiveIndex
+}
+
+func createBackupArchive(p string) (*backupArchiveWriter, error) {
+	format, err := ArchiveFormatFromPath(p)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return nil, fmt.Errorf("create archive: %w", err)
+	}
+	return &backupArchiveWriter{
+		path:  p,
+		f:     f,
+		index: archiveIndex{Version: backupArchiveVersion, Format: format},
+	}, nil
+}
+
+// writeFrame compresses everything fn writes into tw as a separate frame.
+func (w *backupArchiveWriter) writeFrame(name string, fn func(tw *tar.Writer) (int, error)) error {
+	files, size, err := w.appendFrame(func(tw *tar.Writer) (int, error) {
+		files, err := fn(tw)
+		if err != nil {
+			return files, err
+		}
+		return files, tw.Flush()
+	})
+	if err != nil {
+		return fmt.Errorf("write frame %s: %w", name, err)
+	}
+	w.index.Frames = append(w.index.Frames, archiveFrame{
+		Name:   name,
+		Offset: w.offset - size,
+		Size:   size,
+		Files:  files,
+	})
+	return nil
+}
+
+func (w *backupArchiveWriter) appendFrame(fn func(tw *tar.Writer) (int, error)) (int, int64, error) {
+	cw := &countingWriter{w: w.f}
+	comp, err := newFrameWriter(cw, w.index.Format)
+	if err != nil {
+		return 0, 0, err
+	}
+	files, err := fn(tar.NewWriter(comp))
+	if closeErr := comp.Close(); err == nil {
+		err = closeErr
+	}
+	w.offset += cw.n
+	return files, cw.n, err
+}
+
+// Close writes the index frame with the end of the tar stream, and the trailer.
+func (w *backupArchiveWriter) Close() error {
+	indexOffset := w.offset
+	_, _, err := w.appendFrame(func(tw *tar.Writer) (int, error) {
+		data, err := json.MarshalIndent(&w.index, "", "  ")
+		if err != nil {
+			return 0, err
+		}
+		if err := writeTarFile(tw, backupArchiveIndexName, data); err != nil {
+			return 0, err
+		}
+		return 1, tw.Close()
+	})
+	if err != nil {
+		w.f.Close()
+		return fmt.Errorf("write archive index: %w", err)
+	}
+
+	trailer, err := encodeArchiveTrailer(w.index.Format, indexOffset)
+	if err == nil {
+		_, err = w.f.Write(trailer)
+	}
+	if closeErr := w.f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("write archive trailer: %w", err)
+	}
+	return nil
+}
+
+// abort removes the incomplete archive.
+func (w *backupArchiveWriter) abort() {
+	w.f.Close()
+	if err := os.Remove(w.path); err != nil {
+		slog.Error("cannot remove incomplete archive", slog.String("path", w.path), slog.Any("err", err))
+	}
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Mode:     0o644,
+		Size:     int64(len(data)),
+		Typeflag: tar.TypeReg,
+	}); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+func newFrameWriter(w io.Writer, format string) (io.WriteCloser, error) {
+	switch format {
+	case dto.FormatTar:
+		return nopWriteCloser{w}, nil
+	case dto.FormatTarGz:
+		return gzip.NewWriter(w), nil
+	case dto.FormatTarZst:
+		return newZstdWriter(w)
+	default:
+		return nil, ValidateFormat(format)
+	}
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// reader
+
+type backupArchiveReader struct {
+	path  string
+	f     *os.File
+	index archiveIndex
+}
+
+func openBackupArchive(p string) (*backupArchiveReader, error) {
+	format, err := ArchiveFormatFromPath(p)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, fmt.Errorf("open archive: %w", err)
+	}
+	r := &backupArchiveReader{path: p, f: f}
+	if err := r.readIndex(format); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("read archive index of %s: %w", p, err)
+	}
+	return r, nil
+}
+
+func (r *backupArchiveReader) Close() error {
+	return r.f.Close()
+}
+
+func (r *backupArchiveReader) readIndex(format string) error {
+	st, err := r.f.Stat()
+	if err != nil {
+		return err
+	}
+	sample, err := encodeArchiveTrailer(format, 0)
+	if err != nil {
+		return err
+	}
+	trailerSize := int64(len(sample))
+	if st.Size() < trailerSize {
+		return fmt.Errorf("file is too small")
+	}
+	trailer := make([]byte, trailerSize)
+	if _, err := r.f.ReadAt(trailer, st.Size()-trailerSize); err != nil {
+		return err
+	}
+	indexOffset, err := decodeArchiveTrailer(format, trailer)
+	if err != nil {
+		return err
+	}
+	if indexOffset < 0 || indexOffset > st.Size()-trailerSize {
+		return fmt.Errorf("index offset out of range: %d", indexOffset)
+	}
+
+	tr, closer, err := r.openFrameReader(format, indexOffset, st.Size()-trailerSize-indexOffset)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	hdr, err := tr.Next()
+	if err != nil {
+		return err
+	}
+	if hdr.Name != backupArchiveIndexName {
+		return fmt.Errorf("unexpected entry %q", hdr.Name)
+	}
+	if err := json.NewDecoder(tr).Decode(&r.index); err != nil {
+		return fmt.Errorf("decode index: %w", err)
+	}
+	if r.index.Version != backupArchiveVersion {
+		return fmt.Errorf("unsupported archive version %d", r.index.Version)
+	}
+	if r.index.Format != format {
+		return fmt.Errorf("archive format mismatch: index=%q extension=%q", r.index.Format, format)
+	}
+	return nil
+}
+
+func (r *backupArchiveReader) frame(name string) (archiveFrame, bool) {
+	for _, fr := range r.index.Frames {
+		if fr.Name == name {
+			return fr, true
+		}
+	}
+	return archiveFrame{}, false
+}
+
+func (r *backupArchiveReader) openFrame(name string) (*tar.Reader, io.Closer, error) {
+	fr, ok := r.frame(name)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s not found in archive %s", name, r.path)
+	}
+	return r.openFrameReader(r.index.Format, fr.Offset, fr.Size)
+}
+
+func (r *backupArchiveReader) openFrameReader(format string, offset, size int64) (*tar.Reader, io.Closer, error) {
+	section := io.NewSectionReader(r.f, offset, size)
+	switch format {
+	case dto.FormatTar:
+		return tar.NewReader(section), io.NopCloser(section), nil
+	case dto.FormatTarGz:
+		zr, err := gzip.NewReader(section)
+		if err != nil {
+			return nil, nil, fmt.Errorf("open gzip frame: %w", err)
+		}
+		zr.Multistream(false)
+		return tar.NewReader(zr), zr, nil
+	case dto.FormatTarZst:
+		zr, err := newZstdReader(section)
+		if err != nil {
+			return nil, nil, err
+		}
+		return tar.NewReader(zr), zr, nil
+	default:
+		return nil, nil, ValidateFormat(format)
+	}
+}
+
+func (r *backupArchiveReader) readManifest() (*kub.StatefulSetBackupManifest, error) {
+	tr, closer, err := r.openFrame(backupArchiveManifestName)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	if _, err := tr.Next(); err != nil {
+		return nil, fmt.Errorf("read manifest: %w", err)
+	}
+	return kub.DecodeStatefulSetBackupManifest(tr)
+}
+
+// openVolume returns the frame of a volume as a plain tar stream, with entries relative to the volume root.
+func (r *backupArchiveReader) openVolume(localPath string) (io.ReadCloser, error) {
+	tr, closer, err := r.openFrame(localPath)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer closer.Close()
+		pw.CloseWithError(stripTarPrefix(tr, tar.NewWriter(pw), localPath))
+	}()
+	return pr, nil
+}
+
+func stripTarPrefix(tr *tar.Reader, tw *tar.Writer, prefix string) error {
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return tw.Close()
+		}
+		if err != nil {
+			return err
+		}
+
+		name, ok := trimTarPrefix(hdr.Name, prefix)
+		if !ok {
+			return fmt.Errorf("entry %q is outside of %s", hdr.Name, prefix)
+		}
+		if name == "" {
+			continue
+		}
+		hdr.Name = name
+		if hdr.Typeflag == tar.TypeDir {
+			hdr.Name += "/"
+		}
+		if hdr.Typeflag == tar.TypeLink {
+			if hdr.Linkname, ok = trimTarPrefix(hdr.Linkname, prefix); !ok {
+				return fmt.Errorf("hard link %q points outside of %s", hdr.Name, prefix)
+			}
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+}
+
+func trimTarPrefix(name, prefix string) (string, bool) {
+	cleaned := path.Clean(name)
+	if cleaned == prefix {
+		return "", true
+	}
+	rest, ok := strings.CutPrefix(cleaned, prefix+"/")
+	return rest, ok
+}
+
+// trailer
+
+func encodeArchiveTrailer(format string, indexOffset int64) ([]byte, error) {
+	payload := make([]byte, 0, len(trailerMagic)+8)
+	payload = append(payload, trailerMagic...)
+	payload = binary.LittleEndian.AppendUint64(payload, uint64(indexOffset))
+
+	if format == dto.FormatTarGz {
+		// garbage after the last member breaks gzip tools, so the trailer is an empty member
+		var buf bytes.Buffer
+		zw, err := gzip.NewWriterLevel(&buf, gzip.NoCompression)
+		if err != nil {
+			return nil, err
+		}
+		extra := []byte{'S', 'P', byte(len(payload)), 0}
+		zw.Extra = append(extra, payload...)
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	trailer := binary.LittleEndian.AppendUint32(nil, zstdSkippableMagic)
+	trailer = binary.LittleEndian.AppendUint32(trailer, uint32(len(payload)))
+	return append(trailer, payload...), nil
+}
+
+func decodeArchiveTrailer(format string, trailer []byte) (int64, error) {
+	var payload []byte
+	if format == dto.FormatTarGz {
+		zr, err := gzip.NewReader(bytes.NewReader(trailer))
+		if err != nil {
+			return 0, fmt.Errorf("read trailer: %w", err)
+		}
+		if len(zr.Extra) < 4 || zr.Extra[0] != 'S' || zr.Extra[1] != 'P' {
+			return 0, fmt.Errorf("trailer not found, not a syncpod archive")
+		}
+		payload = zr.Extra[4:]
+	} else {
+		if len(trailer) < 8 || binary.LittleEndian.Uint32(trailer) != zstdSkippableMagic {
+			return 0, fmt.Errorf("trailer not found, not a syncpod archive")
+		}
+		payload = trailer[8:]
+	}
+
+	if len(payload) != len(trailerMagic)+8 || string(payload[:len(trailerMagic)]) != trailerMagic {
+		return 0, fmt.Errorf("trailer not found, not a syncpod archive")
+	}
+	return int64(binary.LittleEndian.Uint64(payload[len(trailerMagic):])), nil //nolint:gosec // checked by the caller
+}
@@ -0,0 +1,141 @@
+package pipe
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrimTarPrefix(t *testing.T) {
+	tests := []struct {
+		name   string
+		want   string
+		wantOK bool
+	}{
+		{name: "db-0/data", want: "", wantOK: true},
+		{name: "db-0/data/", want: "", wantOK: true},
+		{name: "db-0/data/pg/base", want: "pg/base", wantOK: true},
+		{name: "./db-0/data/a", want: "a", wantOK: true},
+		// entries of other volumes
+		{name: "db-0/data2/a"},
+		{name: "db-0/a"},
+		{name: "db-0/data/../../etc"},
+	}
+	for _, tt := range tests {
+		got, ok := trimTarPrefix(tt.name, "db-0/data")
+		require.Equal(t, tt.wantOK, ok, tt.name)
+		if ok {
+			require.Equal(t, tt.want, got, tt.name)
+		}
+	}
+}
+
+func TestArchiveTrailer(t *testing.T) {
+	for _, format := range []string{dto.FormatTar, dto.FormatTarGz, dto.FormatTarZst} {
+		trailer, err := encodeArchiveTrailer(format, 1<<40+7)
+		require.NoError(t, err, format)
+		offset, err := decodeArchiveTrailer(format, trailer)
+		require.NoError(t, err, format)
+		require.Equal(t, int64(1<<40+7), offset, format)
+
+		// the tail of an archive that was not written by syncpod
+		_, err = decodeArchiveTrailer(format, bytes.Repeat([]byte{0}, len(trailer)))
+		require.Error(t, err, format)
+	}
+
+	// a gzip trailer is an empty member, so gzip tools read over it
+	trailer, err := encodeArchiveTrailer(dto.FormatTarGz, 42)
+	require.NoError(t, err)
+	zr, err := gzip.NewReader(bytes.NewReader(trailer))
+	require.NoError(t, err)
+	data, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	require.Empty(t, data)
+
+	// a skippable frame with a foreign payload
+	_, err = decodeArchiveTrailer(dto.FormatTarZst, append([]byte{0x50, 0x2A, 0x4D, 0x18, 4, 0, 0, 0}, "ABCD"...))
+	require.ErrorContains(t, err, "not a syncpod archive")
+}
+
+func TestBackupArchiveRoundTrip(t *testing.T) {
+	for _, ext := range []string{".tar", ".tar.gz", ".tar.zst"} {
+		t.Run(ext, func(t *testing.T) {
+			p := filepath.Join(t.TempDir(), "backup"+ext)
+			w, err := createBackupArchive(p)
+			require.NoError(t, err)
+			for _, vol := range []string{"db-0/data", "db-1/data"} {
+				err := w.writeFrame(vol, func(tw *tar.Writer) (int, error) {
+					require.NoError(t, tw.WriteHeader(&tar.Header{Name: vol + "/", Typeflag: tar.TypeDir, Mode: 0o755}))
+					return 2, writeTarFile(tw, vol+"/PG_VERSION", []byte(vol))
+				})
+				require.NoError(t, err)
+			}
+			manifest := kub.BuildStatefulSetBackupManifest("ns", "db", nil)
+			err = w.writeFrame(backupArchiveManifestName, func(tw *tar.Writer) (int, error) {
+				var buf bytes.Buffer
+				require.NoError(t, kub.EncodeStatefulSetBackupManifest(&buf, manifest))
+				return 1, writeTarFile(tw, backupArchiveManifestName, buf.Bytes())
+			})
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+
+			r, err := openBackupArchive(p)
+			require.NoError(t, err)
+			defer r.Close()
+
+			got, err := r.readManifest()
+			require.NoError(t, err)
+			require.Equal(t, "db", got.StatefulSet)
+
+			// the frame of one volume, relative to the volume root
+			in, err := r.openVolume("db-1/data")
+			require.NoError(t, err)
+			defer in.Close()
+			tr := tar.NewReader(in)
+			hdr, err := tr.Next()
+			require.NoError(t, err)
+			require.Equal(t, "PG_VERSION", hdr.Name)
+			data, err := io.ReadAll(tr)
+			require.NoError(t, err)
+			require.Equal(t, "db-1/data", string(data))
+			_, err = tr.Next()
+			require.ErrorIs(t, err, io.EOF)
+
+			_, err = r.openVolume("db-2/data")
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestBackupArchiveIsPlainTar(t *testing.T) {
+	tarBin, err := exec.LookPath("tar")
+	if err != nil {
+		t.Skip("tar is not installed")
+	}
+	p := filepath.Join(t.TempDir(), "backup.tar.gz")
+	w, err := createBackupArchive(p)
+	require.NoError(t, err)
+	require.NoError(t, w.writeFrame("db-0/data", func(tw *tar.Writer) (int, error) {
+		return 1, writeTarFile(tw, "db-0/data/a.txt", []byte("a"))
+	}))
+	require.NoError(t, w.Close())
+
+	// standard tools read over the frames and the trailer
+	out := t.TempDir()
+	cmd := exec.Command(tarBin, "-xzf", p, "-C", out)
+	output, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(output))
+	data, err := os.ReadFile(filepath.Join(out, "db-0", "data", "a.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "a", string(data))
+	require.FileExists(t, filepath.Join(out, backupArchiveIndexName), "the index is a member of the tar stream")
+}
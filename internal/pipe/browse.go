@@ -0,0 +1,356 @@
+package pipe
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+
+	"github.com/pkg/sftp"
+)
+
+// withHelper starts a helper for the PVC, runs fn on its SFTP session, and removes the helper.
+func withHelper(ctx context.Context, opts *dto.RunOpts, fn func(client *sftp.Client) error) error {
+	jobOpts, teardown, err := startHelper(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer teardown()
+
+	client, err := connectSFTP(ctx, jobOpts)
+	if err != nil {
+		return err
+	}
+	defer closeSFTPClient(client)
+
+	return fn(client.SFTPClient())
+}
+
+// browseTargets resolves paths inside the mount path, no paths means the mount path itself.
+// Paths outside of the mount path are refused, also when a symlink inside the PVC points out of it
+// (followLast is false for commands that do not follow a symlink in the last component).
+func browseTargets(client *sftp.Client, mountPath string, paths []string, followLast bool) ([]string, error) {
+	root, err := realRemotePath(client, mountPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return []string{root}, nil
+	}
+	targets := make([]string, 0, len(paths))
+	for _, p := range paths {
+		target := path.Join(root, p)
+		if _, err := confinePath(client, root, root, target, followLast); err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// RunLs lists remote directories (or single files) inside the mount path.
+func RunLs(ctx context.Context, opts *dto.RunOpts, paths []string, output string) error {
+	return withHelper(ctx, opts, func(client *sftp.Client) error {
+		var entries []dto.RemoteEntry
+		targets, err := browseTargets(client, opts.MountPath, paths, true)
+		if err != nil {
+			return err
+		}
+		for _, target := range targets {
+			fi, err := client.Stat(target)
+			if err != nil {
+				return fmt.Errorf("ls %s: %w", target, err)
+			}
+			if !fi.IsDir() {
+				entries = append(entries, remoteEntry(client, target, fi))
+				continue
+			}
+			infos, err := client.ReadDir(target)
+			if err != nil {
+				return fmt.Errorf("ls %s: %w", target, err)
+			}
+			sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+			for _, info := range infos {
+				entries = append(entries, remoteEntry(client, path.Join(target, info.Name()), info))
+			}
+		}
+
+		if output == dto.OutputJSON {
+			return writeJSON(opts.Out, entries)
+		}
+		tw := tabwriter.NewWriter(opts.Out, 0, 0, 2, ' ', 0)
+		for i := range entries {
+			e := &entries[i]
+			name := e.Path
+			switch e.Type {
+			case "dir":
+				name += "/"
+			case "symlink":
+				name += " -> " + e.Link
+			}
+			fmt.Fprintf(tw, "%s\t%d:%d\t%d\t%s\t%s\n", e.Mode, e.UID, e.GID, e.Size, e.ModTime.Local().Format("2006-01-02 15:04"), name)
+		}
+		return tw.Flush()
+	})
+}
+
+// RunStat prints the attributes of remote paths, symlinks are not followed.
+// It fails when a path does not exist.
+func RunStat(ctx context.Context, opts *dto.RunOpts, paths []string, output string) error {
+	return withHelper(ctx, opts, func(client *sftp.Client) error {
+		var entries []dto.RemoteEntry
+		targets, err := browseTargets(client, opts.MountPath, paths, false)
+		if err != nil {
+			return err
+		}
+		for _, target := range targets {
+			fi, err := client.Lstat(target)
+			if err != nil {
+				return fmt.Errorf("stat %s: %w", target, err)
+			}
+			entries = append(entries, remoteEntry(client, target, fi))
+		}
+
+		if output == dto.OutputJSON {
+			return writeJSON(opts.Out, entries)
+		}
+		for i := range entries {
+			e := &entries[i]
+			if i > 0 {
+				fmt.Fprintln(opts.Out)
+			}
+			fmt.Fprintf(opts.Out, "  Path: %s\n", e.Path)
+			if e.Link != "" {
+				fmt.Fprintf(opts.Out, "  Link: %s\n", e.Link)
+			}
+			fmt.Fprintf(opts.Out, "  Type: %s\n  Size: %d\n  Mode: %s\n   Uid: %d\n   Gid: %d\nModify: %s\n",
+				e.Type, e.Size, e.Mode, e.UID, e.GID, e.ModTime.Local().Format("2006-01-02 15:04:05 -0700"))
+		}
+		return nil
+	})
+}
+
+// RunDu prints the size of remote directory trees, directories are read concurrently.
+// Sizes are apparent sizes (the sum of file sizes), not allocated blocks.
+func RunDu(ctx context.Context, opts *dto.RunOpts, paths []string, duOpts *dto.DuOpts, output string) error {
+	return withHelper(ctx, opts, func(client *sftp.Client) error {
+		var usages []dto.DiskUsage
+		targets, err := browseTargets(client, opts.MountPath, paths, true)
+		if err != nil {
+			return err
+		}
+		for _, target := range targets {
+			u, err := diskUsage(ctx, client, target, duOpts)
+			if err != nil {
+				return err
+			}
+			usages = append(usages, u...)
+		}
+
+		if output == dto.OutputJSON {
+			return writeJSON(opts.Out, usages)
+		}
+		tw := tabwriter.NewWriter(opts.Out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "SIZE\tFILES\tPATH")
+		for i := range usages {
+			fmt.Fprintf(tw, "%s\t%d\t%s\n", formatSize(usages[i].Bytes), usages[i].Files, usages[i].Path)
+		}
+		return tw.Flush()
+	})
+}
+
+// RunCat writes the contents of remote files to the output, one after another.
+func RunCat(ctx context.Context, opts *dto.RunOpts, paths []string) error {
+	return withHelper(ctx, opts, func(client *sftp.Client) error {
+		targets, err := browseTargets(client, opts.MountPath, paths, true)
+		if err != nil {
+			return err
+		}
+		for _, target := range targets {
+			if err := catRemoteFile(ctx, client, target, opts.Out); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func catRemoteFile(ctx context.Context, client *sftp.Client, target string, w io.Writer) error {
+	f, err := client.Open(target)
+	if err != nil {
+		return fmt.Errorf("cat %s: %w", target, err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(w, &ctxReader{ctx: ctx, r: f}); err != nil {
+		return fmt.Errorf("cat %s: %w", target, err)
+	}
+	return nil
+}
+
+// ctxReader stops reading once the context is cancelled.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+func remoteEntry(client *sftp.Client, p string, fi os.FileInfo) dto.RemoteEntry {
+	e := dto.RemoteEntry{
+		Name:    fi.Name(),
+		Path:    p,
+		Size:    fi.Size(),
+		Mode:    fi.Mode().String(),
+		ModTime: fi.ModTime().UTC(),
+	}
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		e.Type = "symlink"
+		if link, err := client.ReadLink(p); err == nil {
+			e.Link = link
+		}
+	case fi.IsDir():
+		e.Type = "dir"
+	case fi.Mode().IsRegular():
+		e.Type = "file"
+	default:
+		e.Type = "other"
+	}
+	if st, ok := fi.Sys().(*sftp.FileStat); ok {
+		e.UID, e.GID = st.UID, st.GID
+	}
+	return e
+}
+
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// du
+
+type duWalker struct {
+	ctx    context.Context
+	client *sftp.Client
+	depth  int
+	sem    chan struct{}
+
+	mu     sync.Mutex
+	usages []dto.DiskUsage
+	errs   []error
+}
+
+// diskUsage returns the usage of root and its directories up to the depth, largest first.
+func diskUsage(ctx context.Context, client *sftp.Client, root string, opts *dto.DuOpts) ([]dto.DiskUsage, error) {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	fi, err := client.Stat(root)
+	if err != nil {
+		return nil, fmt.Errorf("du %s: %w", root, err)
+	}
+	if !fi.IsDir() {
+		return []dto.DiskUsage{{Path: root, Bytes: fi.Size(), Files: 1}}, nil
+	}
+
+	d := &duWalker{
+		ctx:    ctx,
+		client: client,
+		depth:  opts.Depth,
+		// the walking goroutine itself is one of the workers
+		sem: make(chan struct{}, workers-1),
+	}
+	d.walk(root, 0)
+	if len(d.errs) > 0 {
+		return nil, joinErrors(d.errs)
+	}
+
+	// the root first, then its largest directories
+	sort.SliceStable(d.usages, func(i, j int) bool {
+		if (d.usages[i].Path == root) != (d.usages[j].Path == root) {
+			return d.usages[i].Path == root
+		}
+		return d.usages[i].Bytes > d.usages[j].Bytes
+	})
+	if opts.Top > 0 && len(d.usages) > opts.Top+1 {
+		d.usages = d.usages[:opts.Top+1]
+	}
+	return d.usages, nil
+}
+
+// walk sums up dir, subdirectories are walked in new goroutines while workers are free.
+func (d *duWalker) walk(dir string, level int) dto.DiskUsage {
+	usage := dto.DiskUsage{Path: dir}
+	if d.ctx.Err() != nil {
+		d.fail(d.ctx.Err())
+		return usage
+	}
+	infos, err := d.client.ReadDir(dir)
+	if err != nil {
+		d.fail(fmt.Errorf("du %s: %w", dir, err))
+		return usage
+	}
+
+	var mu sync.Mutex
+	add := func(u *dto.DiskUsage) {
+		mu.Lock()
+		defer mu.Unlock()
+		usage.Bytes += u.Bytes
+		usage.Files += u.Files
+		usage.Dirs += u.Dirs + 1
+	}
+
+	var wg sync.WaitGroup
+	for _, info := range infos {
+		if !info.IsDir() {
+			mu.Lock()
+			usage.Files++
+			usage.Bytes += info.Size()
+			mu.Unlock()
+			continue
+		}
+		sub := path.Join(dir, info.Name())
+		select {
+		case d.sem <- struct{}{}:
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-d.sem }()
+				u := d.walk(sub, level+1)
+				add(&u)
+			}()
+		default:
+			u := d.walk(sub, level+1)
+			add(&u)
+		}
+	}
+	wg.Wait()
+
+	if level <= d.depth {
+		d.mu.Lock()
+		d.usages = append(d.usages, usage)
+		d.mu.Unlock()
+	}
+	return usage
+}
+
+func (d *duWalker) fail(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.errs = append(d.errs, err)
+}
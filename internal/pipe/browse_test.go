@@ -0,0 +1,127 @@
+package pipe
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+	"github.com/stretchr/testify/require"
+)
+
+func writeSizedFile(t *testing.T, p string, size int) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+	require.NoError(t, os.WriteFile(p, []byte(strings.Repeat("x", size)), 0o600))
+}
+
+func TestDiskUsage(t *testing.T) {
+	root := t.TempDir()
+	writeSizedFile(t, filepath.Join(root, "a.txt"), 10)
+	writeSizedFile(t, filepath.Join(root, "big", "f1"), 1000)
+	writeSizedFile(t, filepath.Join(root, "big", "sub", "f2"), 500)
+	writeSizedFile(t, filepath.Join(root, "small", "f3"), 100)
+	require.NoError(t, os.Mkdir(filepath.Join(root, "empty"), 0o755))
+	client := newTestSFTPClient(t)
+
+	tests := []struct {
+		name string
+		opts dto.DuOpts
+		want []dto.DiskUsage
+	}{
+		{
+			name: "root only",
+			opts: dto.DuOpts{Workers: 1},
+			want: []dto.DiskUsage{{Path: root, Bytes: 1610, Files: 4, Dirs: 4}},
+		},
+		{
+			name: "depth 1, largest first",
+			opts: dto.DuOpts{Depth: 1, Workers: 4},
+			want: []dto.DiskUsage{
+				{Path: root, Bytes: 1610, Files: 4, Dirs: 4},
+				{Path: root + "/big", Bytes: 1500, Files: 2, Dirs: 1},
+				{Path: root + "/small", Bytes: 100, Files: 1},
+				{Path: root + "/empty"},
+			},
+		},
+		{
+			name: "depth 2",
+			opts: dto.DuOpts{Depth: 2, Workers: 2},
+			want: []dto.DiskUsage{
+				{Path: root, Bytes: 1610, Files: 4, Dirs: 4},
+				{Path: root + "/big", Bytes: 1500, Files: 2, Dirs: 1},
+				{Path: root + "/big/sub", Bytes: 500, Files: 1},
+				{Path: root + "/small", Bytes: 100, Files: 1},
+				{Path: root + "/empty"},
+			},
+		},
+		{
+			name: "top",
+			opts: dto.DuOpts{Depth: 2, Top: 2},
+			want: []dto.DiskUsage{
+				{Path: root, Bytes: 1610, Files: 4, Dirs: 4},
+				{Path: root + "/big", Bytes: 1500, Files: 2, Dirs: 1},
+				{Path: root + "/big/sub", Bytes: 500, Files: 1},
+			},
+		},
+		{
+			name: "top above the count",
+			opts: dto.DuOpts{Depth: 1, Top: 10},
+			want: []dto.DiskUsage{
+				{Path: root, Bytes: 1610, Files: 4, Dirs: 4},
+				{Path: root + "/big", Bytes: 1500, Files: 2, Dirs: 1},
+				{Path: root + "/small", Bytes: 100, Files: 1},
+				{Path: root + "/empty"},
+			},
+		},
+	}
+	for i := range tests {
+		tt := &tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := diskUsage(context.Background(), client, root, &tt.opts)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+
+	got, err := diskUsage(context.Background(), client, root+"/big/f1", &dto.DuOpts{})
+	require.NoError(t, err)
+	require.Equal(t, []dto.DiskUsage{{Path: root + "/big/f1", Bytes: 1000, Files: 1}}, got)
+
+	_, err = diskUsage(context.Background(), client, root+"/missing", &dto.DuOpts{})
+	require.ErrorContains(t, err, "du "+root+"/missing")
+}
+
+func TestBrowseTargets(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "pvc")
+	writeSizedFile(t, filepath.Join(root, "data", "a.txt"), 1)
+	writeSizedFile(t, filepath.Join(base, "secret"), 1)
+	require.NoError(t, os.Symlink(base, filepath.Join(root, "escape")))
+	require.NoError(t, os.Symlink("data", filepath.Join(root, "current")))
+	client := newTestSFTPClient(t)
+
+	got, err := browseTargets(client, root, nil, true)
+	require.NoError(t, err)
+	require.Equal(t, []string{root}, got)
+
+	got, err = browseTargets(client, root, []string{"data", "/data/a.txt", "current/a.txt", "missing"}, true)
+	require.NoError(t, err)
+	require.Equal(t, []string{root + "/data", root + "/data/a.txt", root + "/current/a.txt", root + "/missing"}, got)
+
+	_, err = browseTargets(client, root, []string{"../../etc"}, true)
+	require.ErrorContains(t, err, "outside of the mount path")
+	_, err = browseTargets(client, root, []string{"data", "../secret"}, true)
+	require.ErrorContains(t, err, "outside of the mount path")
+
+	// a symlink pointing out of the PVC is refused when followed, stat shows the link itself
+	_, err = browseTargets(client, root, []string{"escape"}, true)
+	require.ErrorContains(t, err, "resolves to "+base)
+	_, err = browseTargets(client, root, []string{"escape/secret"}, false)
+	require.ErrorContains(t, err, "resolves to "+base+"/secret")
+	got, err = browseTargets(client, root, []string{"escape"}, false)
+	require.NoError(t, err)
+	require.Equal(t, []string{root + "/escape"}, got)
+}
@@ -0,0 +1,232 @@
+package pipe
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/clients"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// the Go SSH client has no transport compression, so file contents are gzipped
+// by a command in the helper pod, and sent through an SSH session or pods/exec.
+
+const (
+	// each compressed file costs a command round-trip, not worth it for small files
+	compressMinSize = 64 * 1024
+
+	compressProbeCmd    = `command -v gzip`
+	compressDownloadCmd = `exec gzip -c -- "$1"`
+	compressUploadCmd   = `gzip -dc > "$1"`
+)
+
+// already compressed data, skipped in auto mode
+var compressedExtensions = map[string]bool{
+	".gz": true, ".tgz": true, ".zst": true, ".tzst": true, ".xz": true, ".txz": true,
+	".bz2": true, ".lz4": true, ".lzma": true, ".br": true, ".snappy": true,
+	".zip": true, ".7z": true, ".rar": true, ".jar": true,
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".avif": true,
+	".mp3": true, ".mp4": true, ".mkv": true, ".mov": true, ".webm": true, ".ogg": true,
+	".pdf": true, ".docx": true, ".xlsx": true, ".pptx": true,
+}
+
+// ValidateCompress checks a compression mode given by the user.
+func ValidateCompress(mode string) error {
+	switch mode {
+	case "", dto.CompressOff, dto.CompressOn, dto.CompressAuto:
+		return nil
+	default:
+		return fmt.Errorf("unknown compression mode: %s (expected %s, %s or %s)", mode, dto.CompressOff, dto.CompressOn, dto.CompressAuto)
+	}
+}
+
+type wireCompression struct {
+	ctx    context.Context
+	opts   *dto.JobOpts
+	client *clients.SFTPClient
+	mode   string
+
+	// the helper is checked for gzip once, without it files go uncompressed
+	probe       sync.Once
+	unavailable bool
+
+	files   atomic.Int64
+	skipped atomic.Int64
+	raw     atomic.Int64
+	wire    atomic.Int64
+}
+
+// newWireCompression returns nil when compression is off, all methods are nil-safe.
+func newWireCompression(ctx context.Context, opts *dto.JobOpts, client *clients.SFTPClient) *wireCompression {
+	if opts.Compress == "" || opts.Compress == dto.CompressOff {
+		return nil
+	}
+	return &wireCompression{
+		ctx:    ctx,
+		opts:   opts,
+		client: client,
+		mode:   opts.Compress,
+	}
+}
+
+// applies reports whether the file should go compressed.
+func (c *wireCompression) applies(name string, size int64) bool {
+	if c == nil {
+		return false
+	}
+	if c.mode == dto.CompressAuto {
+		if size < compressMinSize || compressedExtensions[strings.ToLower(path.Ext(name))] {
+			c.skipped.Add(1)
+			return false
+		}
+	}
+	return c.available()
+}
+
+// available reports whether the helper can compress, a helper image without gzip only logs a warning.
+func (c *wireCompression) available() bool {
+	c.probe.Do(func() {
+		if err := c.run([]string{"sh", "-c", compressProbeCmd}, nil, io.Discard); err != nil {
+			slog.Warn("the helper has no gzip, files are transferred uncompressed", slog.Any("err", err))
+			c.unavailable = true
+		}
+	})
+	return !c.unavailable
+}
+
+// download writes the contents of remotePath into w.
+func (c *wireCompression) download(remotePath string, w io.Writer) error {
+	pr, pw := io.Pipe()
+	wire := &countingWriter{w: c.opts.Limiter.Writer(c.ctx, pw)}
+
+	errCh := make(chan error, 1)
+	go func() {
+		err := c.run([]string{"sh", "-c", compressDownloadCmd, "syncpod", remotePath}, nil, wire)
+		pw.CloseWithError(err)
+		errCh <- err
+	}()
+
+	zr, err := gzip.NewReader(pr)
+	if err == nil {
+		raw := &countingWriter{w: w}
+		_, err = io.Copy(raw, zr) //nolint:gosec // the stream comes from our own helper
+		c.raw.Add(raw.n)
+	}
+	pr.CloseWithError(err)
+	runErr := <-errCh
+	c.wire.Add(wire.n)
+	c.files.Add(1)
+
+	if runErr != nil {
+		return fmt.Errorf("compressed download of %s: %w", remotePath, runErr)
+	}
+	if err != nil {
+		return fmt.Errorf("compressed download of %s: %w", remotePath, err)
+	}
+	return nil
+}
+
+// upload writes r into remotePath.
+func (c *wireCompression) upload(r io.Reader, remotePath string) error {
+	pr, pw := io.Pipe()
+	wire := &countingReader{r: c.opts.Limiter.Reader(c.ctx, pr)}
+
+	go func() {
+		raw := &countingReader{r: r}
+		zw := gzip.NewWriter(pw)
+		_, err := io.Copy(zw, raw)
+		if closeErr := zw.Close(); err == nil {
+			err = closeErr
+		}
+		c.raw.Add(raw.n)
+		pw.CloseWithError(err)
+	}()
+
+	err := c.run([]string{"sh", "-c", compressUploadCmd, "syncpod", remotePath}, wire, io.Discard)
+	pr.CloseWithError(err)
+	c.wire.Add(wire.n)
+	c.files.Add(1)
+
+	if err != nil {
+		return fmt.Errorf("compressed upload of %s: %w", remotePath, err)
+	}
+	return nil
+}
+
+// run executes cmd in the helper, see runInHelper.
+func (c *wireCompression) run(cmd []string, stdin io.Reader, stdout io.Writer) error {
+	var stderrBuf bytes.Buffer
+	if err := runInHelper(c.ctx, c.opts, c.client, cmd, stdin, stdout, &stderrBuf); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderrBuf.String()))
+	}
+	return nil
+}
+
+// runInHelper executes cmd in the helper: over the SSH connection when there is one, via pods/exec otherwise.
+func runInHelper(
+	ctx context.Context,
+	opts *dto.JobOpts,
+	client *clients.SFTPClient,
+	cmd []string,
+	stdin io.Reader,
+	stdout, stderr io.Writer,
+) error {
+	if client.CanRun() {
+		quoted := make([]string, 0, len(cmd))
+		for _, arg := range cmd {
+			quoted = append(quoted, shellQuote(arg))
+		}
+		return client.Run(strings.Join(quoted, " "), stdin, stdout, stderr)
+	}
+
+	executor, err := newPodExecutor(opts.RestConfig, podExecURL(opts, cmd, stdin != nil))
+	if err != nil {
+		return err
+	}
+	return executor.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdin:  stdin,
+		Stdout: stdout,
+		Stderr: stderr,
+	})
+}
+
+// report logs how much the compression saved.
+func (c *wireCompression) report() {
+	if c == nil {
+		return
+	}
+	raw, wire := c.raw.Load(), c.wire.Load()
+	ratio := 0.0
+	if wire > 0 {
+		ratio = float64(raw) / float64(wire)
+	}
+	slog.Info("wire compression",
+		slog.String("mode", c.mode),
+		slog.Int64("files", c.files.Load()),
+		slog.Int64("skipped", c.skipped.Load()),
+		slog.Int64("raw-bytes", raw),
+		slog.Int64("wire-bytes", wire),
+		slog.String("ratio", fmt.Sprintf("%.2f", ratio)),
+	)
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,214 @@
+package pipe
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/clients"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// newTestHelper starts an SSH server standing in for a helper pod: the sftp subsystem and the helper's
+// sftp-server serve the local filesystem, exec requests run in a local shell with PATH set to path
+// (inherited when empty).
+func newTestHelper(t *testing.T, path string) *clients.SFTPClient {
+	t.Helper()
+	keyPair, err := clients.GenerateEd25519Keys()
+	require.NoError(t, err)
+	hostKey, err := ssh.NewSignerFromKey(keyPair.PrivateKey)
+	require.NoError(t, err)
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(ssh.ConnMetadata, []byte) (*ssh.Permissions, error) { return nil, nil },
+	}
+	config.AddHostKey(hostKey)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestHelperConn(conn, config, path)
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	client, err := clients.NewSFTPClient(&clients.SFTPConfig{Host: addr.IP.String(), Port: addr.Port, User: "root", Pass: "x"})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func serveTestHelperConn(conn net.Conn, config *ssh.ServerConfig, path string) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newCh := range chans {
+		ch, chReqs, err := newCh.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer ch.Close()
+			for req := range chReqs {
+				switch req.Type {
+				case "subsystem":
+					_ = req.Reply(true, nil)
+					server, err := sftp.NewServer(ch)
+					if err == nil {
+						_ = server.Serve()
+					}
+					return
+				case "exec":
+					var payload struct{ Command string }
+					_ = ssh.Unmarshal(req.Payload, &payload)
+					_ = req.Reply(true, nil)
+					if dir, ok := strings.CutPrefix(payload.Command, shellQuote(sftpServerPath)+" '-d' "); ok {
+						// the sftp-server of the helper image, serving the mount path
+						server, err := sftp.NewServer(ch, sftp.WithServerWorkingDirectory(strings.Trim(dir, "'")))
+						if err == nil {
+							_ = server.Serve()
+						}
+						_, _ = ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+						return
+					}
+					cmd := exec.Command("sh", "-c", payload.Command)
+					if path != "" {
+						cmd.Env = append(os.Environ(), "PATH="+path)
+					}
+					cmd.Stdin, cmd.Stdout, cmd.Stderr = ch, ch, ch.Stderr()
+					status := uint32(0)
+					if err := cmd.Run(); err != nil {
+						status = 255
+						var exitErr *exec.ExitError
+						if errors.As(err, &exitErr) {
+							status = uint32(exitErr.ExitCode()) //nolint:gosec // exit codes are 0-255
+						}
+					}
+					_ = ch.CloseWrite()
+					_, _ = ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+					return
+				default:
+					_ = req.Reply(false, nil)
+				}
+			}
+		}()
+	}
+}
+
+func TestValidateCompress(t *testing.T) {
+	for _, mode := range []string{"", dto.CompressOff, dto.CompressOn, dto.CompressAuto} {
+		require.NoError(t, ValidateCompress(mode), mode)
+	}
+	require.ErrorContains(t, ValidateCompress("zstd"), "unknown compression mode")
+}
+
+func TestCompressApplies(t *testing.T) {
+	require.Nil(t, newWireCompression(context.Background(), &dto.JobOpts{Compress: dto.CompressOff}, nil))
+	require.Nil(t, newWireCompression(context.Background(), &dto.JobOpts{}, nil))
+	var off *wireCompression
+	require.False(t, off.applies("dump.sql", 1<<30))
+
+	tests := []struct {
+		mode string
+		name string
+		size int64
+		want bool
+	}{
+		{mode: dto.CompressOn, name: "dump.sql", size: 1, want: true},
+		{mode: dto.CompressOn, name: "dump.sql.gz", size: 1 << 20, want: true},
+		{mode: dto.CompressAuto, name: "dump.sql", size: compressMinSize, want: true},
+		{mode: dto.CompressAuto, name: "dump.sql", size: compressMinSize - 1},
+		{mode: dto.CompressAuto, name: "dump.sql.gz", size: 1 << 20},
+		{mode: dto.CompressAuto, name: "photo.JPG", size: 1 << 20},
+		{mode: dto.CompressAuto, name: "dir.zst/data", size: 1 << 20, want: true},
+		{mode: dto.CompressAuto, name: "noext", size: 1 << 20, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode+" "+tt.name, func(t *testing.T) {
+			c := newWireCompression(context.Background(), &dto.JobOpts{Compress: tt.mode}, nil)
+			c.probe.Do(func() {}) // the helper is not asked
+			require.Equal(t, tt.want, c.applies(tt.name, tt.size))
+			if !tt.want {
+				require.Equal(t, int64(1), c.skipped.Load())
+			}
+		})
+	}
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	if _, err := exec.LookPath("gzip"); err != nil {
+		t.Skip("no gzip")
+	}
+	client := newTestHelper(t, "")
+	ctx := context.Background()
+
+	local := filepath.Join(t.TempDir(), "dump.sql")
+	data := bytes.Repeat([]byte("INSERT INTO t VALUES (1, 'compressible');\n"), 10000)
+	require.NoError(t, os.WriteFile(local, data, 0o600))
+	past := time.Unix(1700000000, 0)
+	require.NoError(t, os.Chtimes(local, past, past))
+
+	comp := newWireCompression(ctx, &dto.JobOpts{Compress: dto.CompressAuto}, client)
+	remote := filepath.ToSlash(filepath.Join(t.TempDir(), "dump.sql"))
+	require.NoError(t, uploadFile(ctx, client.SFTPClient(), comp, nil, dto.WorkerJob{
+		LocalPath: local, RemotePath: remote, Size: int64(len(data)), ModTime: past,
+	}))
+	got, err := os.ReadFile(filepath.FromSlash(remote))
+	require.NoError(t, err)
+	require.Equal(t, data, got)
+
+	back := filepath.Join(t.TempDir(), "dump.sql")
+	require.NoError(t, downloadFile(ctx, client.SFTPClient(), comp, nil, nil, dto.WorkerJob{
+		RemotePath: remote, LocalPath: back, Size: int64(len(data)), ModTime: past,
+	}))
+	got, err = os.ReadFile(back)
+	require.NoError(t, err)
+	require.Equal(t, data, got)
+	fi, err := os.Stat(back)
+	require.NoError(t, err)
+	require.True(t, fi.ModTime().Equal(past))
+
+	require.Equal(t, int64(2), comp.files.Load())
+	require.Equal(t, int64(2*len(data)), comp.raw.Load())
+	require.Less(t, comp.wire.Load(), comp.raw.Load()/10)
+}
+
+func TestCompressWithoutGzip(t *testing.T) {
+	// an empty PATH: sh runs, gzip cannot be found
+	client := newTestHelper(t, t.TempDir())
+	ctx := context.Background()
+
+	local := filepath.Join(t.TempDir(), "dump.sql")
+	data := []byte(strings.Repeat("x", compressMinSize))
+	require.NoError(t, os.WriteFile(local, data, 0o600))
+
+	comp := newWireCompression(ctx, &dto.JobOpts{Compress: dto.CompressOn}, client)
+	require.False(t, comp.applies("dump.sql", int64(len(data))))
+
+	// the file goes uncompressed
+	remote := filepath.ToSlash(filepath.Join(t.TempDir(), "dump.sql"))
+	require.NoError(t, uploadFile(ctx, client.SFTPClient(), comp, nil, dto.WorkerJob{
+		LocalPath: local, RemotePath: remote, Size: int64(len(data)),
+	}))
+	got, err := os.ReadFile(filepath.FromSlash(remote))
+	require.NoError(t, err)
+	require.Equal(t, data, got)
+	require.Zero(t, comp.files.Load())
+}
@@ -0,0 +1,477 @@
+package pipe
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/bwlimit"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/clients"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
+
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+const (
+	copySrcMountPath = "/mnt/src"
+	copyDstMountPath = "/mnt/dst"
+
+	// runs in the destination helper: pulls a tar stream from the source helper over ssh.
+	// $1 - source host, $2 - remote tar command, $3 - destination dir; the private key is read from stdin.
+	pullScript = `
+set -eo pipefail;
+umask 077;
+cat > /tmp/syncpod.key;
+trap 'rm -f /tmp/syncpod.key' EXIT;
+mkdir -p "$3";
+ssh -i /tmp/syncpod.key -p 2525 \
+  -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null -o BatchMode=yes -o ConnectTimeout=10 \
+  root@"$1" "$2" | tar -C "$3" -xpf -;
+`
+)
+
+type copyJob struct {
+	src   string
+	dst   string
+	isDir bool
+	info  os.FileInfo // of the source, its owner, mode and mtime are copied
+}
+
+// RunCopy copies a directory from one PVC to another without routing data through local disk.
+// By default the destination helper pulls a tar stream from the source helper in-cluster.
+// When that's not possible (exec transport, no connectivity between helpers, --relay, --bwlimit),
+// the CLI relays the data between two SFTP sessions.
+func RunCopy(ctx context.Context, opts *dto.CopyOpts) error {
+	transport := opts.Transport
+	if transport == "" {
+		transport = dto.TransportNodePort
+	}
+
+	srcJob, srcTeardown, err := startHelper(ctx, &dto.RunOpts{
+		PVC:         opts.FromPVC,
+		Namespace:   opts.FromNamespace,
+		KubeContext: opts.KubeContext,
+		Remote:      opts.Src,
+		MountPath:   copySrcMountPath,
+		Workers:     opts.Workers,
+		ObjName:     kub.NewObjName(),
+		Transport:   transport,
+		Limiter:     opts.Limiter,
+	})
+	if err != nil {
+		return fmt.Errorf("start source helper: %w", err)
+	}
+	defer srcTeardown()
+
+	dstJob, dstTeardown, err := startHelper(ctx, &dto.RunOpts{
+		PVC:            opts.ToPVC,
+		Namespace:      opts.ToNamespace,
+		KubeContext:    opts.KubeContext,
+		Remote:         opts.Dst,
+		MountPath:      copyDstMountPath,
+		Workers:        opts.Workers,
+		AllowOverwrite: opts.AllowOverwrite,
+		Owner:          opts.Owner,
+		ObjName:        kub.NewObjName(),
+		Transport:      transport,
+		Limiter:        opts.Limiter,
+	})
+	if err != nil {
+		return fmt.Errorf("start destination helper: %w", err)
+	}
+	defer dstTeardown()
+
+	return copyBetweenHelpers(ctx, srcJob, dstJob, opts.Relay, opts.Verify)
+}
+
+// copyBetweenHelpers transfers srcJob.Remote into dstJob.Remote, both helpers must be running.
+// With verify, the copy fails when the trees differ afterwards.
+func copyBetweenHelpers(ctx context.Context, srcJob, dstJob *dto.JobOpts, relay, verify bool) error {
+	srcPath := filepath.ToSlash(filepath.Join(srcJob.MountPath, filepath.Clean(srcJob.Remote)))
+	dstPath := filepath.ToSlash(filepath.Join(dstJob.MountPath, filepath.Clean(dstJob.Remote)))
+
+	dstClient, err := connectSFTP(ctx, dstJob)
+	if err != nil {
+		return err
+	}
+	defer closeSFTPClient(dstClient)
+
+	// preserve original directory, same as upload
+	if !isRemoteRoot(dstJob.Remote) {
+		if err := renameRemoteDirIfExists(dstClient.SFTPClient(), dstPath); err != nil {
+			return err
+		}
+	}
+
+	inCluster := !relay && srcJob.Transport == dto.TransportNodePort && dstJob.Transport == dto.TransportNodePort
+	if inCluster && dstJob.Limiter != nil {
+		// the tar stream between the helpers cannot be limited
+		slog.Info("bandwidth is limited, relaying through the CLI")
+		inCluster = false
+	}
+	if inCluster {
+		if err := checkHelpersConnectivity(ctx, srcJob, dstJob); err != nil {
+			slog.Warn("helpers cannot reach each other, falling back to relay through the CLI", slog.Any("err", err))
+			inCluster = false
+		}
+	}
+
+	slog.Info("begin to copy files",
+		slog.String("from", srcJob.Namespace+"/"+srcPath),
+		slog.String("to", dstJob.Namespace+"/"+dstPath),
+		slog.Bool("in-cluster", inCluster),
+	)
+
+	copyFiles := func() error {
+		if inCluster {
+			if !dstJob.AllowOverwrite {
+				if err := ensureRemoteDirEmpty(dstClient.SFTPClient(), dstPath); err != nil {
+					return err
+				}
+			}
+			if err := pullInCluster(ctx, srcJob, dstJob, srcPath, dstPath); err != nil {
+				return err
+			}
+			// the tar stream is not counted, the destination is summed up instead
+			if usages, err := diskUsage(ctx, dstClient.SFTPClient(), dstPath, &dto.DuOpts{Workers: dstJob.Workers}); err == nil {
+				dstJob.Stats.Add(usages[0].Files, usages[0].Bytes)
+			}
+			return nil
+		}
+		srcClient, err := connectSFTP(ctx, srcJob)
+		if err != nil {
+			return err
+		}
+		defer closeSFTPClient(srcClient)
+		return relayFiles(ctx, srcClient.SFTPClient(), dstClient.SFTPClient(), dstJob.Limiter, dstJob.Stats, srcPath, dstPath, dstJob.Workers, dstJob.AllowOverwrite)
+	}
+
+	// the copy is recorded in the events of both PVCs, each one names the other as its peer
+	srcJob.Local = copyPeer(dstJob, dstPath)
+	dstJob.Local = copyPeer(srcJob, srcPath)
+	return auditTransfer(ctx, "copy-out", srcJob, func() error {
+		return auditTransfer(ctx, "copy-in", dstJob, func() error {
+			err := copyFiles()
+			srcJob.Stats.Add(dstJob.Stats.Files(), dstJob.Stats.Bytes())
+			if err != nil {
+				slog.Error("error while copying files", slog.Any("err", err))
+				return err
+			}
+			slog.Info("copy job completed successfully")
+
+			if dstJob.Owner != "" {
+				if err := runChownInPod(ctx, dstJob, dstPath); err != nil {
+					slog.Error("error while running chown", slog.Any("err", err))
+					return err
+				}
+			}
+			if verify {
+				return verifyCopy(ctx, srcJob, dstJob, dstClient, srcPath, dstPath)
+			}
+			return nil
+		})
+	})
+}
+
+// copyPeer names the other side of a copy in the events of a PVC.
+func copyPeer(job *dto.JobOpts, p string) string {
+	return fmt.Sprintf("%s/pvc %s:%s", job.Namespace, job.PVC, p)
+}
+
+func closeSFTPClient(client *clients.SFTPClient) {
+	if err := client.Close(); err != nil {
+		slog.Error("error closing SFTP client", slog.Any("err", err))
+	} else {
+		slog.Info("SFTP connection closed")
+	}
+}
+
+// in-cluster
+
+func helperServiceHost(job *dto.JobOpts) string {
+	return fmt.Sprintf("%s.%s.svc", job.ObjName, job.Namespace)
+}
+
+// checkHelpersConnectivity retries until sshd of the source helper is reachable from the destination helper.
+func checkHelpersConnectivity(ctx context.Context, srcJob, dstJob *dto.JobOpts) error {
+	deadline := time.Now().Add(sshWaitTimeout)
+	var err error
+	for time.Now().Before(deadline) {
+		if err = execPull(ctx, srcJob, dstJob, "true", "/tmp"); err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		time.Sleep(1 * time.Second)
+	}
+	return err
+}
+
+func pullInCluster(ctx context.Context, srcJob, dstJob *dto.JobOpts, srcPath, dstPath string) error {
+	return execPull(ctx, srcJob, dstJob, "tar -C "+shellQuote(srcPath)+" -cf - .", dstPath)
+}
+
+func execPull(ctx context.Context, srcJob, dstJob *dto.JobOpts, remoteCmd, dstPath string) error {
+	key, err := srcJob.KeyPair.PrivateKeyToOpenSSH()
+	if err != nil {
+		return err
+	}
+
+	cmd := []string{"sh", "-c", pullScript, "syncpod", helperServiceHost(srcJob), remoteCmd, dstPath}
+	executor, err := newPodExecutor(dstJob.RestConfig, podExecURL(dstJob, cmd, true))
+	if err != nil {
+		return err
+	}
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+	err = executor.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdin:  bytes.NewReader(key),
+		Stdout: &stdoutBuf,
+		Stderr: &stderrBuf,
+		Tty:    false,
+	})
+	if err != nil {
+		return fmt.Errorf("pull from %s: %w: %s", helperServiceHost(srcJob), err, strings.TrimSpace(stderrBuf.String()))
+	}
+	return nil
+}
+
+func ensureRemoteDirEmpty(client *sftp.Client, remotePath string) error {
+	entries, err := client.ReadDir(remotePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("read remote dir: %w", err)
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("overwrite is forbidden, destination is not empty: %s", remotePath)
+	}
+	return nil
+}
+
+// shellQuote wraps s in single quotes for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// relay
+
+func getFilesToRelay(src, dst *sftp.Client, srcPath, dstPath string, allowOverwrite bool) ([]copyJob, error) {
+	var jobs []copyJob
+	walker := src.Walk(srcPath)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return nil, err
+		}
+		rel, err := filepath.Rel(srcPath, walker.Path())
+		if err != nil {
+			return nil, err
+		}
+		target := path.Join(dstPath, filepath.ToSlash(rel))
+		isDir := walker.Stat().IsDir()
+
+		fileExists, err := remoteFileExists(dst, target, isDir)
+		if err != nil {
+			return nil, err
+		}
+		if fileExists && !allowOverwrite && !(isDir && target == dstPath) {
+			return nil, fmt.Errorf("overwrite is forbidden, file already exists: %s", target)
+		}
+
+		jobs = append(jobs, copyJob{
+			src:   walker.Path(),
+			dst:   target,
+			isDir: isDir,
+			info:  walker.Stat(),
+		})
+	}
+	return jobs, nil
+}
+
+func relayFiles(
+	ctx context.Context,
+	src, dst *sftp.Client,
+	lim *bwlimit.Limiter,
+	stats *dto.TransferStats,
+	srcPath, dstPath string,
+	workers int,
+	allowOverwrite bool,
+) error {
+	files, err := getFilesToRelay(src, dst, srcPath, dstPath, allowOverwrite)
+	if err != nil {
+		return err
+	}
+	if workers <= 0 {
+		workers = 1
+	}
+
+	slog.Info("starting concurrent file relay",
+		slog.Int("workers", workers),
+		slog.Int("files", len(files)),
+	)
+
+	jobs := make(chan copyJob, len(files))
+	errCh := make(chan error, len(files))
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for jb := range jobs {
+				if ctx.Err() != nil {
+					return
+				}
+				n, err := relayFile(ctx, src, dst, lim, jb)
+				if err != nil {
+					select {
+					case errCh <- err:
+					default:
+					}
+				} else if !jb.isDir {
+					stats.Add(1, n)
+				}
+			}
+		}()
+	}
+
+	for _, jb := range files {
+		jobs <- jb
+	}
+	close(jobs)
+
+	go func() {
+		wg.Wait()
+		close(errCh)
+	}()
+
+	var lastErr error
+	for e := range errCh {
+		slog.Error("file relay error", slog.Any("err", e))
+		lastErr = e
+	}
+	if lastErr != nil {
+		return lastErr
+	}
+
+	// directories last, and the deepest first, so writing their entries does not change their mtime
+	for i := len(files) - 1; i >= 0; i-- {
+		if files[i].isDir {
+			if err := copyAttrs(dst, files[i].dst, files[i].info); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func relayFile(ctx context.Context, src, dst *sftp.Client, lim *bwlimit.Limiter, jb copyJob) (int64, error) {
+	if jb.isDir {
+		return 0, dst.MkdirAll(jb.dst)
+	}
+
+	slog.Debug("relay file",
+		slog.String("src", jb.src),
+		slog.String("dst", jb.dst),
+	)
+
+	srcFile, err := src.Open(jb.src)
+	if err != nil {
+		return 0, fmt.Errorf("open source: %w", err)
+	}
+	defer srcFile.Close()
+
+	if err := dst.MkdirAll(path.Dir(jb.dst)); err != nil {
+		return 0, fmt.Errorf("mkdir destination: %w", err)
+	}
+
+	dstFile, err := dst.Create(jb.dst)
+	if err != nil {
+		return 0, fmt.Errorf("create destination: %w", err)
+	}
+	defer dstFile.Close()
+
+	n, err := io.Copy(dstFile, lim.Reader(ctx, srcFile))
+	if err != nil {
+		return n, fmt.Errorf("copy file: %w", err)
+	}
+	if err := dstFile.Close(); err != nil {
+		return n, fmt.Errorf("close destination: %w", err)
+	}
+	return n, copyAttrs(dst, jb.dst, jb.info)
+}
+
+// copyAttrs gives target the owner, mode and mtime of the source file.
+func copyAttrs(client *sftp.Client, target string, info os.FileInfo) error {
+	// chown before chmod, chown clears the setuid/setgid bits
+	if st, ok := info.Sys().(*sftp.FileStat); ok {
+		if err := client.Chown(target, int(st.UID), int(st.GID)); err != nil {
+			return fmt.Errorf("chown %s: %w", target, err)
+		}
+	}
+	if err := client.Chmod(target, info.Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+		return fmt.Errorf("chmod %s: %w", target, err)
+	}
+	if err := client.Chtimes(target, info.ModTime(), info.ModTime()); err != nil {
+		return fmt.Errorf("chtimes %s: %w", target, err)
+	}
+	return nil
+}
+
+// verifyCopy compares the copied tree with the source, by type, size and checksum.
+func verifyCopy(ctx context.Context, srcJob, dstJob *dto.JobOpts, dstClient *clients.SFTPClient, srcPath, dstPath string) error {
+	srcClient, err := connectSFTP(ctx, srcJob)
+	if err != nil {
+		return err
+	}
+	defer closeSFTPClient(srcClient)
+
+	want, err := remoteInventory(srcClient.SFTPClient(), srcPath)
+	if err != nil {
+		return fmt.Errorf("list source: %w", err)
+	}
+	var idx []int
+	for i := range want {
+		if inventoryKind(&want[i]) == "file" {
+			idx = append(idx, i)
+		}
+	}
+	if err := hashRemoteFiles(ctx, srcJob, srcClient, srcPath, want, idx); err != nil {
+		return err
+	}
+
+	got, err := remoteInventory(dstClient.SFTPClient(), dstPath)
+	if err != nil {
+		return fmt.Errorf("list destination: %w", err)
+	}
+	if err := hashRemoteFiles(ctx, dstJob, dstClient, dstPath, got, hashCandidates(want, got)); err != nil {
+		return err
+	}
+
+	problems := compareInventory("pvc/"+dstJob.PVC, verifyWhereCluster, want, got)
+	for i := range problems {
+		slog.Error("copy differs from the source",
+			slog.String("path", problems[i].Path),
+			slog.String("problem", problems[i].Problem),
+			slog.String("detail", problems[i].Detail),
+		)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("copy verification failed: %d problem(s)", len(problems))
+	}
+	slog.Info("copy verified", slog.Int("files", len(want)))
+	return nil
+}
@@ -0,0 +1,49 @@
+package pipe
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/bwlimit"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRelayFilesKeepsAttributes(t *testing.T) {
+	client := newTestSFTPClient(t)
+	base := t.TempDir()
+	src := filepath.Join(base, "src")
+	dst := filepath.Join(base, "dst")
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "dir"), 0o750))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "dir", "a.txt"), []byte("hello"), 0o600))
+	require.NoError(t, os.Chmod(filepath.Join(src, "dir", "a.txt"), 0o640))
+
+	mtime := time.Unix(1700000000, 0)
+	require.NoError(t, os.Chtimes(filepath.Join(src, "dir", "a.txt"), mtime, mtime))
+	require.NoError(t, os.Chtimes(filepath.Join(src, "dir"), mtime, mtime))
+
+	stats := &dto.TransferStats{}
+	err := relayFiles(context.Background(), client, client, bwlimit.New(1<<20), stats,
+		filepath.ToSlash(src), filepath.ToSlash(dst), 2, false)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), stats.Files())
+	require.Equal(t, int64(5), stats.Bytes())
+
+	fi, err := os.Stat(filepath.Join(dst, "dir", "a.txt"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o640), fi.Mode().Perm())
+	require.Equal(t, mtime, fi.ModTime())
+
+	fi, err = os.Stat(filepath.Join(dst, "dir"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o750), fi.Mode().Perm())
+	require.Equal(t, mtime, fi.ModTime(), "directory mtime is set after its entries")
+
+	// the destination is not empty anymore
+	err = relayFiles(context.Background(), client, client, nil, &dto.TransferStats{},
+		filepath.ToSlash(src), filepath.ToSlash(dst), 2, false)
+	require.ErrorContains(t, err, "overwrite is forbidden")
+}
@@ -0,0 +1,339 @@
+package pipe
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/clients"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+)
+
+// ErrTreesDiffer is returned (wrapped) by RunDiff when the compared trees differ.
+var ErrTreesDiffer = errors.New("trees differ")
+
+// RunDiff compares a local tree with a remote one inside the mount path.
+// It fails with ErrTreesDiffer when the trees differ, so it can gate a CI job.
+func RunDiff(ctx context.Context, runOpts *dto.RunOpts, opts *dto.DiffOpts, output string) error {
+	local := filepath.Clean(opts.Local)
+	if _, err := os.Stat(local); err != nil {
+		return err
+	}
+
+	jobOpts, teardown, err := startHelper(ctx, runOpts)
+	if err != nil {
+		return err
+	}
+	defer teardown()
+
+	client, err := connectSFTP(ctx, jobOpts)
+	if err != nil {
+		return err
+	}
+	defer closeSFTPClient(client)
+
+	diff, err := diffTrees(ctx, jobOpts, client, local, path.Join(runOpts.MountPath, opts.Src), opts)
+	if err != nil {
+		return err
+	}
+	if err := printDiff(runOpts.Out, diff, output); err != nil {
+		return err
+	}
+	if diff.Added+diff.Removed+diff.Changed > 0 {
+		return fmt.Errorf("%w: %d added, %d removed, %d changed", ErrTreesDiffer, diff.Added, diff.Removed, diff.Changed)
+	}
+	return nil
+}
+
+// diffTrees walks both trees concurrently, with the same walkers as upload and download, and
+// compares files by size and mtime, or by size and sha256 with opts.Checksum.
+func diffTrees(ctx context.Context, jobOpts *dto.JobOpts, client *clients.SFTPClient, local, remote string, opts *dto.DiffOpts) (*dto.Diff, error) {
+	var localJobs, remoteJobs []dto.WorkerJob
+	var localErr, remoteErr error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		localJobs, localErr = listLocalTree(local, remote)
+	}()
+	go func() {
+		defer wg.Done()
+		remoteJobs, remoteErr = getFilesToDownload(client.SFTPClient(), remote, local)
+	}()
+	wg.Wait()
+	if localErr != nil {
+		return nil, fmt.Errorf("walk local tree: %w", localErr)
+	}
+	if remoteErr != nil {
+		return nil, fmt.Errorf("walk remote tree %s: %w", remote, remoteErr)
+	}
+
+	// both walkers key their entries by the remote path
+	remoteByPath := make(map[string]*dto.WorkerJob, len(remoteJobs))
+	for i := range remoteJobs {
+		remoteByPath[remoteJobs[i].RemotePath] = &remoteJobs[i]
+	}
+	prefix := strings.TrimSuffix(remote, "/") + "/"
+	rel := func(p string) string {
+		if p == remote {
+			return "."
+		}
+		return strings.TrimPrefix(p, prefix)
+	}
+
+	diff := &dto.Diff{Local: local, Remote: remote, Checksum: opts.Checksum}
+	var toVerify [][2]*dto.WorkerJob
+	for i := range localJobs {
+		l := &localJobs[i]
+		r, ok := remoteByPath[l.RemotePath]
+		delete(remoteByPath, l.RemotePath)
+		switch {
+		case l.RemotePath == remote && ok && l.IsDir && r.IsDir:
+		case !ok:
+			diff.Entries = append(diff.Entries, dto.DiffEntry{
+				Path: rel(l.RemotePath), Status: dto.DiffRemoved, IsDir: l.IsDir, LocalSize: l.Size, LocalMtime: l.ModTime,
+			})
+		default:
+			reason := compareFiles(l, r, opts.Checksum)
+			if reason == "" && opts.Checksum && !l.IsDir && !r.IsDir {
+				toVerify = append(toVerify, [2]*dto.WorkerJob{l, r})
+			}
+			if reason != "" {
+				diff.Entries = append(diff.Entries, changedEntry(rel(l.RemotePath), reason, l, r))
+			}
+		}
+	}
+	for i := range remoteJobs {
+		r := &remoteJobs[i]
+		if _, ok := remoteByPath[r.RemotePath]; !ok {
+			continue
+		}
+		diff.Entries = append(diff.Entries, dto.DiffEntry{
+			Path: rel(r.RemotePath), Status: dto.DiffAdded, IsDir: r.IsDir, RemoteSize: r.Size, RemoteMtime: r.ModTime,
+		})
+	}
+
+	if len(toVerify) > 0 {
+		changed, err := verifyChecksums(ctx, jobOpts, client, toVerify, opts.Workers)
+		if err != nil {
+			return nil, err
+		}
+		for _, pair := range changed {
+			diff.Entries = append(diff.Entries, changedEntry(rel(pair[0].RemotePath), "checksum", pair[0], pair[1]))
+		}
+	}
+
+	sort.Slice(diff.Entries, func(i, j int) bool { return diff.Entries[i].Path < diff.Entries[j].Path })
+	for i := range diff.Entries {
+		switch diff.Entries[i].Status {
+		case dto.DiffAdded:
+			diff.Added++
+		case dto.DiffRemoved:
+			diff.Removed++
+		default:
+			diff.Changed++
+		}
+	}
+	return diff, nil
+}
+
+// compareFiles returns why l and r differ, checksums are compared later.
+func compareFiles(l, r *dto.WorkerJob, checksum bool) string {
+	switch {
+	case l.IsDir != r.IsDir:
+		return "type"
+	case l.IsDir:
+		return ""
+	case l.Size != r.Size:
+		return "size"
+	case checksum:
+		return ""
+	// SFTP carries whole seconds
+	case !l.ModTime.Truncate(time.Second).Equal(r.ModTime.Truncate(time.Second)):
+		return "mtime"
+	}
+	return ""
+}
+
+func changedEntry(p, reason string, l, r *dto.WorkerJob) dto.DiffEntry {
+	return dto.DiffEntry{
+		Path:        p,
+		Status:      dto.DiffChanged,
+		Reason:      reason,
+		IsDir:       l.IsDir && r.IsDir,
+		LocalSize:   l.Size,
+		RemoteSize:  r.Size,
+		LocalMtime:  l.ModTime,
+		RemoteMtime: r.ModTime,
+	}
+}
+
+// verifyChecksums hashes the local files with workers, and the remote ones inside the helper,
+// so their contents are not transferred. It returns the pairs whose contents differ.
+func verifyChecksums(
+	ctx context.Context,
+	jobOpts *dto.JobOpts,
+	client *clients.SFTPClient,
+	pairs [][2]*dto.WorkerJob,
+	workers int,
+) ([][2]*dto.WorkerJob, error) {
+	localPaths := make([]string, 0, len(pairs))
+	remotePaths := make([]string, 0, len(pairs))
+	for _, pair := range pairs {
+		localPaths = append(localPaths, pair[0].LocalPath)
+		remotePaths = append(remotePaths, pair[1].RemotePath)
+	}
+
+	var localSums []string
+	var remoteSums map[string]string
+	var localErr, remoteErr error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		localSums, localErr = localChecksums(ctx, localPaths, workers)
+	}()
+	go func() {
+		defer wg.Done()
+		remoteSums, remoteErr = remoteChecksums(ctx, jobOpts, client, remotePaths)
+	}()
+	wg.Wait()
+	if localErr != nil {
+		return nil, localErr
+	}
+	if remoteErr != nil {
+		return nil, remoteErr
+	}
+
+	var changed [][2]*dto.WorkerJob
+	for i, pair := range pairs {
+		if localSums[i] != remoteSums[pair[1].RemotePath] {
+			changed = append(changed, pair)
+		}
+	}
+	return changed, nil
+}
+
+func localChecksums(ctx context.Context, paths []string, workers int) ([]string, error) {
+	sums := make([]string, len(paths))
+	jobs := make(chan int)
+	var mu sync.Mutex
+	var errs []error
+	var wg sync.WaitGroup
+	for range max(workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				sum, err := localChecksum(ctx, paths[i])
+				if err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+					continue
+				}
+				sums[i] = sum
+			}
+		}()
+	}
+	for i := range paths {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	if len(errs) > 0 {
+		return nil, joinErrors(errs)
+	}
+	return sums, nil
+}
+
+func localChecksum(ctx context.Context, p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, &ctxReader{ctx: ctx, r: f}); err != nil {
+		return "", fmt.Errorf("checksum %s: %w", p, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// remoteChecksums runs sha256sum in the helper, paths are passed NUL separated on stdin.
+func remoteChecksums(ctx context.Context, jobOpts *dto.JobOpts, client *clients.SFTPClient, paths []string) (map[string]string, error) {
+	var in bytes.Buffer
+	for _, p := range paths {
+		in.WriteString(p)
+		in.WriteByte(0)
+	}
+	var out, stderr bytes.Buffer
+	cmd := []string{"xargs", "-0", "sha256sum", "--"}
+	if err := runInHelper(ctx, jobOpts, client, cmd, &in, &out, &stderr); err != nil {
+		return nil, fmt.Errorf("remote checksums: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	sums := make(map[string]string, len(paths))
+	for _, line := range strings.Split(out.String(), "\n") {
+		// names with a backslash or a newline are escaped, and the line starts with a backslash
+		line, escaped := strings.CutPrefix(line, `\`)
+		sum, name, ok := strings.Cut(line, "  ")
+		if !ok {
+			continue
+		}
+		if escaped {
+			name = strings.NewReplacer(`\\`, `\`, `\n`, "\n").Replace(name)
+		}
+		sums[name] = sum
+	}
+	return sums, nil
+}
+
+// printDiff writes the diff as JSON, or one line per entry: + only on the PVC, - only local, ~ changed.
+func printDiff(w io.Writer, diff *dto.Diff, output string) error {
+	if output == dto.OutputJSON {
+		return writeJSON(w, diff)
+	}
+
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", diff.Local, diff.Remote)
+	for i := range diff.Entries {
+		e := &diff.Entries[i]
+		name := e.Path
+		if e.IsDir {
+			name += "/"
+		}
+		switch e.Status {
+		case dto.DiffAdded:
+			fmt.Fprintf(w, "+ %s\n", name)
+		case dto.DiffRemoved:
+			fmt.Fprintf(w, "- %s\n", name)
+		default:
+			fmt.Fprintf(w, "~ %s (%s)\n", name, diffDetail(e))
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d added, %d removed, %d changed\n", diff.Added, diff.Removed, diff.Changed)
+	return err
+}
+
+func diffDetail(e *dto.DiffEntry) string {
+	switch e.Reason {
+	case "size":
+		return fmt.Sprintf("size %d -> %d", e.LocalSize, e.RemoteSize)
+	case "mtime":
+		return fmt.Sprintf("mtime %s -> %s", e.LocalMtime.UTC().Format(time.RFC3339), e.RemoteMtime.UTC().Format(time.RFC3339))
+	default:
+		return e.Reason
+	}
+}
@@ -0,0 +1,137 @@
+package pipe
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/clients"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareFiles(t *testing.T) {
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	file := dto.WorkerJob{Size: 10, ModTime: now}
+	dir := dto.WorkerJob{IsDir: true, Size: 4096, ModTime: now}
+
+	tests := []struct {
+		name     string
+		l, r     dto.WorkerJob
+		checksum bool
+		want     string
+	}{
+		{name: "same", l: file, r: file},
+		{name: "file and dir", l: file, r: dir, want: "type"},
+		{name: "dirs are not compared", l: dir, r: dto.WorkerJob{IsDir: true, ModTime: now.Add(time.Hour)}},
+		{name: "size", l: file, r: dto.WorkerJob{Size: 11, ModTime: now}, want: "size"},
+		{name: "size with checksum", l: file, r: dto.WorkerJob{Size: 11, ModTime: now}, checksum: true, want: "size"},
+		{name: "mtime", l: file, r: dto.WorkerJob{Size: 10, ModTime: now.Add(time.Second)}, want: "mtime"},
+		{name: "mtime with checksum", l: file, r: dto.WorkerJob{Size: 10, ModTime: now.Add(time.Second)}, checksum: true},
+		{name: "sub-second mtime", l: dto.WorkerJob{Size: 10, ModTime: now.Add(900 * time.Millisecond)}, r: file},
+	}
+	for i := range tests {
+		tt := &tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, compareFiles(&tt.l, &tt.r, tt.checksum))
+		})
+	}
+}
+
+func TestDiffTrees(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	server, err := sftp.NewServer(serverConn)
+	require.NoError(t, err)
+	go func() { _ = server.Serve() }()
+	client, err := clients.NewSFTPClientPipe(clientConn, clientConn, server.Close)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = client.Close() })
+
+	local, remote := t.TempDir(), t.TempDir()
+	past := time.Unix(1700000000, 0)
+	write := func(root, name, body string) {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o750))
+		require.NoError(t, os.WriteFile(p, []byte(body), 0o600))
+		require.NoError(t, os.Chtimes(p, past, past))
+	}
+	for _, root := range []string{local, remote} {
+		write(root, "same", "data")
+		write(root, "dir/same", "data")
+	}
+	write(local, "removed", "data")
+	write(remote, "added", "data")
+	write(local, "size", "data")
+	write(remote, "size", "longer")
+	write(local, "mtime", "data")
+	write(remote, "mtime", "data")
+	require.NoError(t, os.Chtimes(filepath.Join(remote, "mtime"), past, past.Add(time.Minute)))
+	write(local, "type", "data")
+	write(remote, "type/file", "data")
+
+	diff, err := diffTrees(context.Background(), &dto.JobOpts{}, client, local, remote, &dto.DiffOpts{})
+	require.NoError(t, err)
+
+	got := make([]string, 0, len(diff.Entries))
+	for i := range diff.Entries {
+		e := &diff.Entries[i]
+		got = append(got, e.Status+" "+e.Path+" "+e.Reason)
+	}
+	require.Equal(t, []string{
+		dto.DiffAdded + " added ",
+		dto.DiffChanged + " mtime mtime",
+		dto.DiffRemoved + " removed ",
+		dto.DiffChanged + " size size",
+		dto.DiffChanged + " type type",
+		dto.DiffAdded + " type/file ",
+	}, got)
+	require.Equal(t, 2, diff.Added)
+	require.Equal(t, 1, diff.Removed)
+	require.Equal(t, 3, diff.Changed)
+}
+
+func TestDiffAfterTransfer(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	server, err := sftp.NewServer(serverConn)
+	require.NoError(t, err)
+	go func() { _ = server.Serve() }()
+	client, err := clients.NewSFTPClientPipe(clientConn, clientConn, server.Close)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = client.Close() })
+
+	local := t.TempDir()
+	past := time.Unix(1700000000, 0)
+	for _, name := range []string{"a", "dir/b", "dir/sub/c"} {
+		p := filepath.Join(local, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o750))
+		require.NoError(t, os.WriteFile(p, []byte(name), 0o600))
+		require.NoError(t, os.Chtimes(p, past, past))
+	}
+	ctx := context.Background()
+
+	// upload
+	remote := filepath.ToSlash(filepath.Join(t.TempDir(), "pvc"))
+	jobs, err := listLocalTree(local, remote)
+	require.NoError(t, err)
+	for _, jb := range jobs {
+		require.NoError(t, uploadFile(ctx, client.SFTPClient(), nil, nil, jb))
+	}
+	diff, err := diffTrees(ctx, &dto.JobOpts{}, client, local, remote, &dto.DiffOpts{})
+	require.NoError(t, err)
+	require.Empty(t, diff.Entries)
+
+	// and download it back
+	restored := filepath.Join(t.TempDir(), "restored")
+	jobs, err = getFilesToDownload(client.SFTPClient(), remote, restored)
+	require.NoError(t, err)
+	for _, jb := range jobs {
+		require.NoError(t, downloadFile(ctx, client.SFTPClient(), nil, nil, nil, jb))
+	}
+	diff, err = diffTrees(ctx, &dto.JobOpts{}, client, restored, remote, &dto.DiffOpts{})
+	require.NoError(t, err)
+	require.Empty(t, diff.Entries)
+}
@@ -0,0 +1,302 @@
+package pipe
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
+
+	authv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// doctor checks what the transfers need from the cluster: the permissions of the user,
+// whether the helper image can be pulled, and whether a NodePort can be reached from here.
+
+const (
+	doctorPort        = 2525
+	doctorDialTimeout = 15 * time.Second
+)
+
+// accessCheck is a permission the tool uses, as asked with a SelfSubjectAccessReview.
+type accessCheck struct {
+	verb        string
+	group       string
+	resource    string
+	subresource string
+	cluster     bool   // cluster-scoped resource
+	neededFor   string // set for permissions only some commands or flags need
+	transport   string // the permission is required with this transport
+}
+
+var doctorAccessChecks = []accessCheck{
+	{verb: "create", resource: "pods"},
+	{verb: "get", resource: "pods"},
+	{verb: "list", resource: "pods"},
+	{verb: "delete", resource: "pods"},
+	{verb: "create", resource: "pods", subresource: "exec"},
+	{verb: "create", resource: "services", neededFor: "--transport nodeport", transport: dto.TransportNodePort},
+	{verb: "get", resource: "services", neededFor: "--transport nodeport", transport: dto.TransportNodePort},
+	{verb: "delete", resource: "services", neededFor: "--transport nodeport", transport: dto.TransportNodePort},
+	{verb: "get", resource: "nodes", cluster: true},
+	{verb: "get", resource: "persistentvolumeclaims"},
+	{verb: "list", resource: "persistentvolumeclaims"},
+	{verb: "get", resource: "persistentvolumes", cluster: true},
+	{verb: "get", group: "apps", resource: "statefulsets", neededFor: "download-sts/upload-sts"},
+	{verb: "get", group: "apps", resource: "statefulsets", subresource: "scale", neededFor: "--quiesce"},
+	{verb: "update", group: "apps", resource: "statefulsets", subresource: "scale", neededFor: "--quiesce"},
+	{verb: "get", group: "apps", resource: "deployments", neededFor: "download-workload/upload-workload"},
+	{verb: "get", group: "apps", resource: "daemonsets", neededFor: "download-workload/upload-workload"},
+	{verb: "update", resource: "pods", subresource: "ephemeralcontainers", neededFor: "--attach-pod"},
+	{verb: "create", resource: "persistentvolumeclaims", neededFor: "upload-ns --create-missing"},
+	{verb: "patch", resource: "persistentvolumeclaims", neededFor: "--annotate"},
+	{verb: "create", resource: "events", neededFor: "audit events"},
+}
+
+// RunDoctor prints a checklist of the prerequisites, and fails when a required one is not met.
+func RunDoctor(ctx context.Context, opts *dto.DoctorOpts) error {
+	_, client, err := initConfigAndClientForContext(opts.KubeContext)
+	if err != nil {
+		return err
+	}
+
+	report := &dto.DoctorReport{Namespace: opts.Namespace, User: kubeconfigUser(opts.KubeContext)}
+	for i := range doctorAccessChecks {
+		report.Checks = append(report.Checks, checkAccess(ctx, client, opts, &doctorAccessChecks[i]))
+	}
+	report.Checks = append(report.Checks, checkHelper(ctx, client, opts, report)...)
+
+	if err := printDoctorReport(opts.Out, report, opts.Output); err != nil {
+		return err
+	}
+	failed := 0
+	for i := range report.Checks {
+		if report.Checks[i].Result == dto.DoctorFail {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d check(s) failed", failed)
+	}
+	return nil
+}
+
+func (a *accessCheck) name() string {
+	return a.verb + " " + a.hintResource()
+}
+
+func checkAccess(ctx context.Context, client kubernetes.Interface, opts *dto.DoctorOpts, a *accessCheck) dto.DoctorCheck {
+	check := dto.DoctorCheck{Name: a.name()}
+	attrs := &authv1.ResourceAttributes{
+		Verb:        a.verb,
+		Group:       a.group,
+		Resource:    a.resource,
+		Subresource: a.subresource,
+	}
+	if !a.cluster {
+		attrs.Namespace = opts.Namespace
+	}
+	review, err := client.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authv1.SelfSubjectAccessReview{
+		Spec: authv1.SelfSubjectAccessReviewSpec{ResourceAttributes: attrs},
+	}, metav1.CreateOptions{})
+	switch {
+	case err != nil:
+		check.Result = dto.DoctorFail
+		check.Detail = err.Error()
+		check.Hint = "the user must be allowed to create selfsubjectaccessreviews.authorization.k8s.io"
+		return check
+	case review.Status.Allowed:
+		check.Result = dto.DoctorPass
+		return check
+	}
+
+	check.Result = dto.DoctorFail
+	if a.neededFor != "" && a.transport != opts.Transport {
+		check.Result = dto.DoctorWarn
+		check.Detail = "needed for " + a.neededFor
+	}
+	if review.Status.Reason != "" {
+		check.Detail = strings.TrimPrefix(check.Detail+"; "+review.Status.Reason, "; ")
+	}
+	if a.cluster {
+		check.Hint = fmt.Sprintf("kubectl create clusterrole syncpod --verb=%s --resource=%s, and bind it to the user", a.verb, a.hintResource())
+	} else {
+		check.Hint = fmt.Sprintf("kubectl create role syncpod -n %s --verb=%s --resource=%s, and bind it to the user", opts.Namespace, a.verb, a.hintResource())
+	}
+	return check
+}
+
+// hintResource is the resource in the form of `kubectl create role --resource`.
+func (a *accessCheck) hintResource() string {
+	resource := a.resource
+	if a.group != "" {
+		resource += "." + a.group
+	}
+	if a.subresource != "" {
+		resource += "/" + a.subresource
+	}
+	return resource
+}
+
+// checkHelper starts a helper pod like a transfer would (on the node of the PVC, when given),
+// and connects to it through a NodePort service.
+func checkHelper(ctx context.Context, client *kubernetes.Clientset, opts *dto.DoctorOpts, report *dto.DoctorReport) []dto.DoctorCheck {
+	var checks []dto.DoctorCheck
+	node := &nodeInfo{}
+	if opts.PVC != "" {
+		check := dto.DoctorCheck{Name: "node of pvc " + opts.PVC}
+		var err error
+		node, err = getNodeInfo(ctx, client, opts.Namespace, opts.PVC)
+		switch {
+		case err != nil:
+			check.Result, check.Detail = dto.DoctorFail, err.Error()
+			check.Hint = "the PVC must exist, and be bound to a PV with node affinity or used by a scheduled pod"
+			return append(checks, check)
+		case node.name == "":
+			check.Result, check.Detail = dto.DoctorPass, "not bound yet, the scheduler decides"
+		default:
+			check.Result, check.Detail = dto.DoctorPass, node.name
+		}
+		checks = append(checks, check)
+	}
+
+	objName := kub.NewObjName()
+	defer func() {
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := deleteHelperService(cleanupCtx, client, opts.Namespace, objName); err != nil {
+			slog.Error("cannot delete service", slog.Any("err", err))
+		}
+		if err := deleteHelperPod(cleanupCtx, client, opts.Namespace, objName); err != nil {
+			slog.Error("cannot delete pod", slog.Any("err", err))
+		}
+	}()
+
+	imageCheck, scheduledNode := checkHelperImage(ctx, client, opts, node.name, objName)
+	checks = append(checks, imageCheck)
+	report.Node = scheduledNode
+
+	portCheck := dto.DoctorCheck{Name: "nodeport reachable"}
+	switch {
+	case opts.Transport == dto.TransportExec:
+		portCheck.Result, portCheck.Detail = dto.DoctorSkip, "not used with --transport exec"
+	case imageCheck.Result != dto.DoctorPass:
+		portCheck.Result, portCheck.Detail = dto.DoctorSkip, "the helper pod is not running"
+	default:
+		portCheck = checkNodePort(ctx, client, opts, scheduledNode, objName)
+	}
+	return append(checks, portCheck)
+}
+
+// checkHelperImage waits until a pod of the helper image runs, and returns the node it runs on.
+func checkHelperImage(ctx context.Context, client *kubernetes.Clientset, opts *dto.DoctorOpts, nodeName, objName string) (dto.DoctorCheck, string) {
+	check := dto.DoctorCheck{Name: "helper image " + helperImage}
+	hint := "the nodes must be able to pull " + helperImage + " (registry access, proxy, or a mirror)"
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      objName,
+			Namespace: opts.Namespace,
+			Labels:    labels(objName),
+		},
+		Spec: corev1.PodSpec{
+			NodeName:              nodeName,
+			ActiveDeadlineSeconds: &activeDeadlineSeconds,
+			RestartPolicy:         corev1.RestartPolicyNever,
+			Containers: []corev1.Container{
+				{
+					Name:            objName,
+					Image:           helperImage,
+					ImagePullPolicy: corev1.PullIfNotPresent,
+					// a listener for the NodePort check
+					Command: []string{"sh", "-c", "exec nc -l -p " + strconv.Itoa(doctorPort)},
+					Ports:   []corev1.ContainerPort{{ContainerPort: doctorPort}},
+				},
+			},
+		},
+	}
+	if _, err := client.CoreV1().Pods(opts.Namespace).Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+		check.Result, check.Detail = dto.DoctorFail, err.Error()
+		check.Hint = "see the permission checks for pods"
+		return check, ""
+	}
+
+	p, err := waitHelperPod(ctx, client, opts.Namespace, objName, opts.Timeout)
+	if err != nil {
+		check.Result, check.Detail = dto.DoctorFail, err.Error()
+		check.Hint = hint + "; the pod must be schedulable on the node"
+		return check, ""
+	}
+	check.Result, check.Detail = dto.DoctorPass, "running on node "+p.Spec.NodeName
+	return check, p.Spec.NodeName
+}
+
+// checkNodePort exposes the helper pod through a NodePort service, and dials it from here.
+func checkNodePort(ctx context.Context, client *kubernetes.Clientset, opts *dto.DoctorOpts, nodeName, objName string) dto.DoctorCheck {
+	check := dto.DoctorCheck{Name: "nodeport reachable"}
+	port, err := createNodePortService(ctx, client, opts.Namespace, objName)
+	if err != nil {
+		check.Result, check.Detail = dto.DoctorFail, err.Error()
+		check.Hint = "see the permission checks for services, or use --transport exec"
+		return check
+	}
+	node, err := getNodeInfoByName(ctx, client, nodeName)
+	if err != nil {
+		check.Result, check.Detail = dto.DoctorFail, err.Error()
+		check.Hint = "the user must be allowed to get nodes, or use --transport exec"
+		return check
+	}
+
+	addr := net.JoinHostPort(node.addr, strconv.Itoa(int(port)))
+	deadline := time.Now().Add(doctorDialTimeout)
+	for {
+		var d net.Dialer
+		dialCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+		conn, err := d.DialContext(dialCtx, "tcp", addr)
+		cancel()
+		if err == nil {
+			conn.Close()
+			check.Result, check.Detail = dto.DoctorPass, addr
+			return check
+		}
+		if time.Now().After(deadline) || ctx.Err() != nil {
+			check.Result, check.Detail = dto.DoctorFail, err.Error()
+			check.Hint = fmt.Sprintf("allow TCP from this machine to %s (NodePort range, usually 30000-32767), or use --transport exec", node.addr)
+			return check
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+func printDoctorReport(w io.Writer, report *dto.DoctorReport, output string) error {
+	if output == dto.OutputJSON {
+		return writeJSON(w, report)
+	}
+
+	fmt.Fprintf(w, "namespace %s, user %s\n\n", report.Namespace, report.User)
+	counts := map[string]int{}
+	for i := range report.Checks {
+		c := &report.Checks[i]
+		counts[c.Result]++
+		line := fmt.Sprintf("[%s] %s", strings.ToUpper(c.Result), c.Name)
+		if c.Detail != "" {
+			line += " (" + c.Detail + ")"
+		}
+		fmt.Fprintln(w, line)
+		if c.Hint != "" && (c.Result == dto.DoctorFail || c.Result == dto.DoctorWarn) {
+			fmt.Fprintf(w, "       hint: %s\n", c.Hint)
+		}
+	}
+	_, err := fmt.Fprintf(w, "\n%d passed, %d failed, %d warning(s), %d skipped\n",
+		counts[dto.DoctorPass], counts[dto.DoctorFail], counts[dto.DoctorWarn], counts[dto.DoctorSkip])
+	return err
+}
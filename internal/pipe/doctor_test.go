@@ -0,0 +1,152 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+	"github.com/stretchr/testify/require"
+	authv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newAccessReviewClient answers SelfSubjectAccessReviews, allowing the verbs on the resources in allowed
+// (as "verb resource", in the form of hintResource), and records the reviewed attributes.
+func newAccessReviewClient(allowed []string, reviewed *[]authv1.ResourceAttributes) *fake.Clientset {
+	client := fake.NewClientset()
+	client.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authv1.SelfSubjectAccessReview).DeepCopy()
+		attrs := review.Spec.ResourceAttributes
+		*reviewed = append(*reviewed, *attrs)
+		a := accessCheck{verb: attrs.Verb, group: attrs.Group, resource: attrs.Resource, subresource: attrs.Subresource}
+		for _, name := range allowed {
+			if name == a.name() {
+				review.Status.Allowed = true
+				return true, review, nil
+			}
+		}
+		review.Status.Reason = "RBAC: denied"
+		return true, review, nil
+	})
+	return client
+}
+
+func TestCheckAccess(t *testing.T) {
+	pods := accessCheck{verb: "create", resource: "pods"}
+	nodes := accessCheck{verb: "get", resource: "nodes", cluster: true}
+	quiesce := accessCheck{verb: "update", group: "apps", resource: "statefulsets", subresource: "scale", neededFor: "--quiesce"}
+	services := accessCheck{verb: "create", resource: "services", neededFor: "--transport nodeport", transport: dto.TransportNodePort}
+
+	tests := []struct {
+		name      string
+		check     accessCheck
+		transport string
+		allowed   []string
+		want      dto.DoctorCheck
+	}{
+		{
+			name:    "allowed",
+			check:   pods,
+			allowed: []string{"create pods"},
+			want:    dto.DoctorCheck{Name: "create pods", Result: dto.DoctorPass},
+		},
+		{
+			name:  "required",
+			check: pods,
+			want: dto.DoctorCheck{
+				Name: "create pods", Result: dto.DoctorFail, Detail: "RBAC: denied",
+				Hint: "kubectl create role syncpod -n prod --verb=create --resource=pods, and bind it to the user",
+			},
+		},
+		{
+			name:  "cluster-scoped",
+			check: nodes,
+			want: dto.DoctorCheck{
+				Name: "get nodes", Result: dto.DoctorFail, Detail: "RBAC: denied",
+				Hint: "kubectl create clusterrole syncpod --verb=get --resource=nodes, and bind it to the user",
+			},
+		},
+		{
+			name:      "optional",
+			check:     quiesce,
+			transport: dto.TransportNodePort,
+			want: dto.DoctorCheck{
+				Name: "update statefulsets.apps/scale", Result: dto.DoctorWarn, Detail: "needed for --quiesce; RBAC: denied",
+				Hint: "kubectl create role syncpod -n prod --verb=update --resource=statefulsets.apps/scale, and bind it to the user",
+			},
+		},
+		{
+			name:      "needed by the transport",
+			check:     services,
+			transport: dto.TransportNodePort,
+			want: dto.DoctorCheck{
+				Name: "create services", Result: dto.DoctorFail, Detail: "RBAC: denied",
+				Hint: "kubectl create role syncpod -n prod --verb=create --resource=services, and bind it to the user",
+			},
+		},
+		{
+			name:      "not needed by the transport",
+			check:     services,
+			transport: dto.TransportExec,
+			want: dto.DoctorCheck{
+				Name: "create services", Result: dto.DoctorWarn, Detail: "needed for --transport nodeport; RBAC: denied",
+				Hint: "kubectl create role syncpod -n prod --verb=create --resource=services, and bind it to the user",
+			},
+		},
+	}
+	for i := range tests {
+		tt := &tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			var reviewed []authv1.ResourceAttributes
+			client := newAccessReviewClient(tt.allowed, &reviewed)
+			opts := &dto.DoctorOpts{Namespace: "prod", Transport: tt.transport}
+			require.Equal(t, tt.want, checkAccess(context.Background(), client, opts, &tt.check))
+
+			require.Len(t, reviewed, 1)
+			want := authv1.ResourceAttributes{
+				Verb: tt.check.verb, Group: tt.check.group, Resource: tt.check.resource, Subresource: tt.check.subresource,
+			}
+			if !tt.check.cluster {
+				want.Namespace = "prod"
+			}
+			require.Equal(t, want, reviewed[0])
+		})
+	}
+
+	t.Run("review fails", func(t *testing.T) {
+		client := fake.NewClientset()
+		client.PrependReactor("create", "selfsubjectaccessreviews", func(k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, errors.New("forbidden")
+		})
+		got := checkAccess(context.Background(), client, &dto.DoctorOpts{Namespace: "prod"}, &pods)
+		require.Equal(t, dto.DoctorFail, got.Result)
+		require.Equal(t, "forbidden", got.Detail)
+		require.Contains(t, got.Hint, "selfsubjectaccessreviews.authorization.k8s.io")
+	})
+}
+
+func TestHintResource(t *testing.T) {
+	tests := []struct {
+		check accessCheck
+		want  string
+	}{
+		{check: accessCheck{resource: "pods"}, want: "pods"},
+		{check: accessCheck{resource: "pods", subresource: "exec"}, want: "pods/exec"},
+		{check: accessCheck{group: "apps", resource: "deployments"}, want: "deployments.apps"},
+		{check: accessCheck{group: "apps", resource: "statefulsets", subresource: "scale"}, want: "statefulsets.apps/scale"},
+	}
+	for i := range tests {
+		require.Equal(t, tests[i].want, tests[i].check.hintResource())
+	}
+
+	// every check of the doctor names a distinct permission
+	seen := map[string]bool{}
+	for i := range doctorAccessChecks {
+		name := doctorAccessChecks[i].name()
+		require.False(t, seen[name], name)
+		seen[name] = true
+	}
+}
@@ -2,14 +2,19 @@ package pipe
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"sync"
 
+	"github.com/hashmap-kz/kubectl-syncpod/internal/bwlimit"
 	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
 
 	"github.com/hashmap-kz/kubectl-syncpod/internal/clients"
 
@@ -17,13 +22,7 @@ import (
 )
 
 func Download(ctx context.Context, opts *dto.JobOpts) error {
-	slog.Info("waiting while SSHD is ready")
-	if err := waitForSSHReady(opts.KeyPair, opts.Host, opts.Port, sshWaitTimeout); err != nil {
-		return err
-	}
-
-	slog.Info("init SSH client")
-	client, err := newSFTPClient(opts.KeyPair, opts.Host, opts.Port)
+	client, err := connectSFTP(ctx, opts)
 	if err != nil {
 		return err
 	}
@@ -36,9 +35,27 @@ func Download(ctx context.Context, opts *dto.JobOpts) error {
 		}
 	}(client)
 
+	return downloadWithClient(ctx, client, opts)
+}
+
+func downloadWithClient(ctx context.Context, client *clients.SFTPClient, opts *dto.JobOpts) error {
 	remotePath := filepath.ToSlash(filepath.Join(opts.MountPath, filepath.Clean(opts.Remote)))
 	local := filepath.ToSlash(filepath.Clean(opts.Local))
 
+	if opts.Format != "" {
+		slog.Info("begin to download archive",
+			slog.String("remote", remotePath),
+			slog.String("format", opts.Format),
+		)
+		err := downloadArchive(ctx, client.SFTPClient(), remotePath, opts)
+		if err != nil {
+			slog.Error("error while downloading archive", slog.Any("err", err))
+		} else {
+			slog.Info("download job completed successfully")
+		}
+		return err
+	}
+
 	// ensure destination dir
 	if err := os.MkdirAll(filepath.ToSlash(local), 0o750); err != nil {
 		return err
@@ -48,7 +65,9 @@ func Download(ctx context.Context, opts *dto.JobOpts) error {
 		slog.String("remote", remotePath),
 		slog.String("local", local),
 	)
-	err = downloadFiles(ctx, client.SFTPClient(), remotePath, local, opts.Workers)
+	comp := newWireCompression(ctx, opts, client)
+	err := downloadFiles(ctx, client.SFTPClient(), comp, opts.Limiter, opts.Inventory, opts.Stats, remotePath, local, opts.Workers)
+	comp.report()
 	if err != nil {
 		slog.Error("error while downloading files", slog.Any("err", err))
 	} else {
@@ -72,13 +91,26 @@ func getFilesToDownload(client *sftp.Client, remotePath, localPath string) ([]dt
 		jobs = append(jobs, dto.WorkerJob{
 			RemotePath: walker.Path(),
 			LocalPath:  localFilePath,
+			RelPath:    filepath.ToSlash(relPath),
 			IsDir:      walker.Stat().IsDir(),
+			Size:       walker.Stat().Size(),
+			Mode:       walker.Stat().Mode(),
+			ModTime:    walker.Stat().ModTime(),
 		})
 	}
 	return jobs, nil
 }
 
-func downloadFiles(ctx context.Context, client *sftp.Client, remotePath, localPath string, workers int) error {
+func downloadFiles(
+	ctx context.Context,
+	client *sftp.Client,
+	comp *wireCompression,
+	lim *bwlimit.Limiter,
+	inv *kub.FileInventory,
+	stats *dto.TransferStats,
+	remotePath, localPath string,
+	workers int,
+) error {
 	files, err := getFilesToDownload(client, remotePath, localPath)
 	if err != nil {
 		return err
@@ -106,12 +138,14 @@ func downloadFiles(ctx context.Context, client *sftp.Client, remotePath, localPa
 				if ctx.Err() != nil {
 					return
 				}
-				err := downloadFile(client, jb)
+				err := downloadFile(ctx, client, comp, lim, inv, jb)
 				if err != nil {
 					select {
 					case errorChan <- err:
 					default:
 					}
+				} else if !jb.IsDir {
+					stats.Add(1, jb.Size)
 				}
 			}
 		}()
@@ -139,12 +173,17 @@ func downloadFiles(ctx context.Context, client *sftp.Client, remotePath, localPa
 	return lastErr
 }
 
-func downloadFile(client *sftp.Client, jb dto.WorkerJob) error {
+// downloadFile copies a single file, and records it in the inventory (hashing what is written).
+func downloadFile(ctx context.Context, client *sftp.Client, comp *wireCompression, lim *bwlimit.Limiter, inv *kub.FileInventory, jb dto.WorkerJob) error {
 	remotePath := filepath.ToSlash(jb.RemotePath)
 	localPath := filepath.ToSlash(jb.LocalPath)
 
 	if jb.IsDir {
-		return os.MkdirAll(localPath, 0o750)
+		if err := os.MkdirAll(localPath, 0o750); err != nil {
+			return err
+		}
+		inv.Add(kub.ManifestFile{Path: jb.RelPath, Mode: jb.Mode.String()})
+		return nil
 	}
 
 	slog.Debug("download file",
@@ -152,24 +191,52 @@ func downloadFile(client *sftp.Client, jb dto.WorkerJob) error {
 		slog.String("local", localPath),
 	)
 
-	srcFile, err := client.Open(remotePath)
-	if err != nil {
-		return fmt.Errorf("open remote: %w", err)
-	}
-	defer srcFile.Close()
-
 	if err := os.MkdirAll(filepath.ToSlash(filepath.Dir(localPath)), 0o750); err != nil {
 		return fmt.Errorf("mkdir for file: %w", err)
 	}
 
+	var srcFile *sftp.File
+	if !comp.applies(remotePath, jb.Size) {
+		var err error
+		srcFile, err = client.Open(remotePath)
+		if err != nil {
+			return fmt.Errorf("open remote: %w", err)
+		}
+		defer srcFile.Close()
+	}
+
 	dstFile, err := os.Create(localPath)
 	if err != nil {
 		return fmt.Errorf("create local: %w", err)
 	}
 	defer dstFile.Close()
 
-	if _, err := io.Copy(dstFile, srcFile); err != nil {
+	var w io.Writer = dstFile
+	var h hash.Hash
+	if inv != nil {
+		h = sha256.New()
+		w = io.MultiWriter(dstFile, h)
+	}
+	cw := &countingWriter{w: w}
+
+	if srcFile == nil {
+		if err := comp.download(remotePath, cw); err != nil {
+			return err
+		}
+	} else if _, err := io.Copy(cw, lim.Reader(ctx, srcFile)); err != nil {
 		return fmt.Errorf("copy file: %w", err)
 	}
+
+	if err := dstFile.Close(); err != nil {
+		return fmt.Errorf("close local: %w", err)
+	}
+	// keeps the mtime, so diff finds no changes in a tree right after it was downloaded
+	if err := os.Chtimes(localPath, jb.ModTime, jb.ModTime); err != nil {
+		return fmt.Errorf("chtimes %s: %w", localPath, err)
+	}
+
+	if inv != nil {
+		inv.Add(kub.ManifestFile{Path: jb.RelPath, Size: cw.n, Mode: jb.Mode.String(), SHA256: hex.EncodeToString(h.Sum(nil))})
+	}
 	return nil
 }
@@ -5,85 +5,92 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"sync"
+	"time"
 
 	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
 	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
+	"k8s.io/client-go/kubernetes"
 )
 
 func RunDownloadSTS(ctx context.Context, runOpts *dto.DownloadSTSOpts) error {
-	_, client, err := initConfigAndClient()
+	_, client, err := initConfigAndClientForContext(runOpts.KubeContext)
 	if err != nil {
 		return err
 	}
+	local := runOpts.Dst
+	if runOpts.Archive != "" {
+		local = runOpts.Archive
+	}
+	return auditStatefulSet(ctx, client, runOpts.KubeContext, runOpts.Namespace, runOpts.StsName, "download", local, func() error {
+		return downloadSTS(ctx, client, runOpts)
+	})
+}
 
-	vols, err := kub.DiscoverStatefulSetPVCs(ctx, client, runOpts.Namespace, runOpts.StsName)
+func downloadSTS(ctx context.Context, client *kubernetes.Clientset, runOpts *dto.DownloadSTSOpts) (err error) {
+	vols, err := discoverSTSVolumes(ctx, client, runOpts)
 	if err != nil {
 		return err
 	}
-	if len(vols) == 0 {
-		return fmt.Errorf("no PVC-backed volumes found for StatefulSet %q", runOpts.StsName)
-	}
 
-	if err := os.MkdirAll(runOpts.Dst, 0o755); err != nil {
-		return fmt.Errorf("create destination root: %w", err)
+	if runOpts.Archive == "" {
+		if err := os.MkdirAll(runOpts.Dst, 0o755); err != nil {
+			return fmt.Errorf("create destination root: %w", err)
+		}
 	}
 
-	type result struct {
-		vol kub.PodVolume
-		err error
+	// volumes are discovered while pods are running, node affinity of PVs is used after scale-down
+	if runOpts.Quiesce {
+		var restore func() error
+		restore, err = quiesceStatefulSet(ctx, client, runOpts.Namespace, runOpts.StsName, runOpts.QuiesceTimeout)
+		if err != nil {
+			return err
+		}
+		defer restoreAfterQuiesce(restore, &err)
 	}
 
-	jobs := make(chan kub.PodVolume)
-	results := make(chan result, len(vols))
-
-	var wg sync.WaitGroup
-	for i := 0; i < runOpts.VolumeWorkers; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for vol := range jobs {
-				localDst := filepath.Join(runOpts.Dst, vol.PodName, vol.VolumeName)
-
-				err := Run(ctx, &dto.RunOpts{
-					Mode:      "download",
-					PVC:       vol.PVCName,
-					Namespace: runOpts.Namespace,
-					Remote:    ".",
-					Local:     localDst,
-					MountPath: vol.MountPath,
-					Workers:   runOpts.FileWorkers,
-					ObjName:   kub.NewObjName(),
-				})
-
-				results <- result{vol: vol, err: err}
-			}
-		}()
+	p := &volumeJobOpts{
+		namespace:     runOpts.Namespace,
+		kubeContext:   runOpts.KubeContext,
+		volumeWorkers: runOpts.VolumeWorkers,
+		fileWorkers:   runOpts.FileWorkers,
+		transport:     runOpts.Transport,
+		compress:      runOpts.Compress,
+		limiter:       runOpts.Limiter,
 	}
+	manifest := kub.BuildStatefulSetBackupManifest(runOpts.Namespace, runOpts.StsName, vols)
+	if !runOpts.Selection.IsZero() {
+		manifest.Selection = &runOpts.Selection
+	}
+	recordClaimSpecs(ctx, client, manifest, runOpts.StsName)
 
-	go func() {
-		for _, vol := range vols {
-			jobs <- vol
-		}
-		close(jobs)
-		wg.Wait()
-		close(results)
-	}()
-
-	var errs []error
-	for r := range results {
-		if r.err != nil {
-			errs = append(errs, fmt.Errorf("%s/%s (%s -> %s): %w",
-				r.vol.PodName, r.vol.VolumeName, r.vol.PVCName, r.vol.MountPath, r.err))
-		}
+	if runOpts.Archive != "" {
+		return downloadPodVolumesToArchive(ctx, p, runOpts.Archive, manifest, vols)
 	}
 
-	if len(errs) > 0 {
-		return joinErrors(errs)
+	inventories, err := downloadPodVolumes(ctx, p, runOpts.Dst, vols)
+	if err != nil {
+		return err
 	}
+	recordInventories(manifest, inventories)
+	manifest.FinishedAt = time.Now().UTC()
 
-	manifest := kub.BuildStatefulSetBackupManifest(runOpts.Namespace, runOpts.StsName, vols)
 	err = kub.WriteStatefulSetBackupManifest(filepath.Join(runOpts.Dst, "manifest.json"), manifest)
 
 	return err
 }
+
+// discoverSTSVolumes returns the volumes of the StatefulSet that are backed up.
+func discoverSTSVolumes(ctx context.Context, client kubernetes.Interface, runOpts *dto.DownloadSTSOpts) ([]kub.PodVolume, error) {
+	vols, err := kub.DiscoverStatefulSetPVCs(ctx, client, runOpts.Namespace, runOpts.StsName)
+	if err != nil {
+		return nil, err
+	}
+	if len(vols) == 0 {
+		return nil, fmt.Errorf("no PVC-backed volumes found for StatefulSet %q", runOpts.StsName)
+	}
+	vols, err = kub.SelectPodVolumes(vols, &runOpts.Selection)
+	if err != nil {
+		return nil, fmt.Errorf("statefulset %q: %w", runOpts.StsName, err)
+	}
+	return vols, nil
+}
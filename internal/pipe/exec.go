@@ -0,0 +1,160 @@
+package pipe
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/clients"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+const (
+	sftpServerPath = "/usr/lib/ssh/sftp-server"
+	// execCloseTimeout bounds how long we wait for sftp-server to exit after stdin is closed
+	execCloseTimeout = 5 * time.Second
+)
+
+// newPodExecutor prefers the WebSocket exec protocol and falls back to SPDY
+// when the API server (or a proxy in between) does not support it.
+func newPodExecutor(config *rest.Config, u *url.URL) (remotecommand.Executor, error) {
+	wsExec, err := remotecommand.NewWebSocketExecutor(config, "GET", u.String())
+	if err != nil {
+		return nil, fmt.Errorf("error creating WebSocket executor: %w", err)
+	}
+	spdyExec, err := remotecommand.NewSPDYExecutor(config, "POST", u)
+	if err != nil {
+		return nil, fmt.Errorf("error creating SPDY executor: %w", err)
+	}
+	return remotecommand.NewFallbackExecutor(wsExec, spdyExec, func(err error) bool {
+		return httpstream.IsUpgradeFailure(err) || httpstream.IsHTTPSProxyError(err)
+	})
+}
+
+func podExecURL(opts *dto.JobOpts, cmd []string, stdin bool) *url.URL {
+	req := opts.Client.CoreV1().RESTClient().
+		Post().
+		Resource("pods").
+		Name(opts.PodName).
+		Namespace(opts.Namespace).
+		SubResource("exec").
+		Param("container", opts.Container).
+		Param("stdout", "true").
+		Param("stderr", "true").
+		Param("stdin", fmt.Sprintf("%t", stdin)).
+		Param("tty", "false")
+
+	for _, c := range cmd {
+		req.Param("command", c)
+	}
+	return req.URL()
+}
+
+// syncBuffer is a bytes.Buffer that is safe to read while the exec stream writes into it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// newExecSFTPClient starts sftp-server inside the helper pod via pods/exec,
+// and speaks SFTP over the stdin/stdout of that stream.
+func newExecSFTPClient(ctx context.Context, opts *dto.JobOpts) (*clients.SFTPClient, error) {
+	executor, err := newPodExecutor(opts.RestConfig, podExecURL(opts, []string{sftpServerPath}, true))
+	if err != nil {
+		return nil, err
+	}
+
+	stdinR, stdinW := io.Pipe()
+	stdoutR, stdoutW := io.Pipe()
+	stderr := &syncBuffer{}
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() {
+		err := executor.StreamWithContext(streamCtx, remotecommand.StreamOptions{
+			Stdin:  stdinR,
+			Stdout: stdoutW,
+			Stderr: stderr,
+			Tty:    false,
+		})
+		if err == nil {
+			err = io.EOF
+		}
+		// unblock the SFTP reader and any pending writer
+		stdoutW.CloseWithError(err)
+		stdinR.CloseWithError(err)
+		done <- err
+	}()
+
+	var closeOnce sync.Once
+	var closeErr error
+	closeStream := func() error {
+		closeOnce.Do(func() {
+			// stdin is already closed by the SFTP client, give sftp-server a chance to exit gracefully
+			_ = stdinW.Close()
+			select {
+			case err := <-done:
+				if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, context.Canceled) {
+					closeErr = fmt.Errorf("exec sftp-server: %w", err)
+				}
+			case <-time.After(execCloseTimeout):
+				slog.Warn("sftp-server did not exit in time, cancelling exec stream")
+				cancel()
+				<-done
+			}
+			cancel()
+		})
+		return closeErr
+	}
+
+	client, err := clients.NewSFTPClientPipe(stdoutR, stdinW, closeStream)
+	if err != nil {
+		cancel()
+		<-done
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("start sftp-server via exec: %w: %s", err, msg)
+		}
+		return nil, fmt.Errorf("start sftp-server via exec: %w", err)
+	}
+	return client, nil
+}
+
+func waitForExecSFTPReady(ctx context.Context, opts *dto.JobOpts, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	var lastErr error
+	for time.Now().Before(deadline) {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		client, err := newExecSFTPClient(ctx, opts)
+		if err == nil {
+			return client.Close()
+		}
+		lastErr = err
+		time.Sleep(500 * time.Millisecond)
+	}
+	return fmt.Errorf("sftp-server not ready in pod %s after %v: %w", opts.PodName, timeout, lastErr)
+}
@@ -0,0 +1,198 @@
+package pipe
+
+import (
+	"context"
+	"log/slog"
+	"path"
+	"sync"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/clients"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
+	"k8s.io/client-go/kubernetes"
+)
+
+// volumes of a multi-volume command that are reachable from one node share a helper pod,
+// which mounts all of their PVCs, and a single session to it.
+
+// PVCs of a shared helper are mounted at <groupMountRoot>/<pvc>, so their mount paths cannot collide
+const groupMountRoot = "/pvc"
+
+// helperJob is one volume of a multi-volume command.
+type helperJob struct {
+	pvc       string
+	mountPath string
+	pod       string // pod that mounts the volume, groups the volumes of claims not bound to a node yet
+	// run transfers the volume through the helper of its group, where the PVC is mounted at mountPath
+	run func(ctx context.Context, client *clients.SFTPClient, helper *dto.JobOpts, mountPath string) error
+}
+
+// groupHelperJobs groups the jobs by node, and by pod for the claims whose node is not known yet.
+// Groups are in the order of their first job.
+func groupHelperJobs(ctx context.Context, client kubernetes.Interface, namespace string, jobs []helperJob) ([][]int, error) {
+	pvcs := make([]string, 0, len(jobs))
+	for i := range jobs {
+		pvcs = append(pvcs, jobs[i].pvc)
+	}
+	nodes, err := pvcNodeNames(ctx, client, namespace, pvcs)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups [][]int
+	index := map[string]int{}
+	for i := range jobs {
+		key := "pvc/" + jobs[i].pvc
+		switch {
+		case nodes[jobs[i].pvc] != "":
+			key = "node/" + nodes[jobs[i].pvc]
+		case jobs[i].pod != "":
+			key = "pod/" + jobs[i].pod
+		}
+		g, ok := index[key]
+		if !ok {
+			g = len(groups)
+			index[key] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], i)
+	}
+	return groups, nil
+}
+
+// groupMounts returns the PVCs to mount into the helper of a group, and where each job finds its PVC.
+// A helper of a single PVC mounts it at the mount path of the job.
+func groupMounts(jobs []helperJob, group []int) (mounts []helperMount, mountPaths []string) {
+	seen := map[string]string{}
+	for _, i := range group {
+		if _, ok := seen[jobs[i].pvc]; !ok {
+			seen[jobs[i].pvc] = path.Join(groupMountRoot, jobs[i].pvc)
+			mounts = append(mounts, helperMount{pvc: jobs[i].pvc, mountPath: seen[jobs[i].pvc]})
+		}
+	}
+	if len(mounts) == 1 {
+		mounts[0].mountPath = jobs[group[0]].mountPath
+		seen[mounts[0].pvc] = mounts[0].mountPath
+	}
+	for _, i := range group {
+		mountPaths = append(mountPaths, seen[jobs[i].pvc])
+	}
+	return mounts, mountPaths
+}
+
+// runHelperJobs runs the jobs with one helper pod per group, up to volumeWorkers helpers,
+// and up to volumeWorkers volumes at a time. The errors are in the order of the jobs.
+func runHelperJobs(ctx context.Context, p *volumeJobOpts, jobs []helperJob) []error {
+	_, client, err := initConfigAndClientForContext(p.kubeContext)
+	if err != nil {
+		return repeatError(err, len(jobs))
+	}
+	groups, err := groupHelperJobs(ctx, client, p.namespace, jobs)
+	if err != nil {
+		return repeatError(err, len(jobs))
+	}
+	return runHelperGroups(ctx, p, jobs, groups)
+}
+
+func repeatError(err error, n int) []error {
+	errs := make([]error, n)
+	for i := range errs {
+		errs[i] = err
+	}
+	return errs
+}
+
+// runHelperGroups runs the groups on up to volumeWorkers helpers at a time.
+func runHelperGroups(ctx context.Context, p *volumeJobOpts, jobs []helperJob, groups [][]int) []error {
+	errs := make([]error, len(jobs))
+	workers := max(p.volumeWorkers, 1)
+	if len(groups) < len(jobs) {
+		slog.Info("volumes share helper pods", slog.Int("volumes", len(jobs)), slog.Int("helpers", len(groups)))
+	}
+
+	groupCh := make(chan []int)
+	volumes := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for group := range groupCh {
+				runHelperGroup(ctx, p, jobs, group, volumes, errs)
+			}
+		}()
+	}
+	for _, group := range groups {
+		groupCh <- group
+	}
+	close(groupCh)
+	wg.Wait()
+	return errs
+}
+
+// runHelperGroup starts the helper of a group and runs its jobs over one session.
+func runHelperGroup(ctx context.Context, p *volumeJobOpts, jobs []helperJob, group []int, volumes chan struct{}, errs []error) {
+	fail := func(err error) {
+		for _, i := range group {
+			errs[i] = err
+		}
+	}
+
+	mounts, mountPaths := groupMounts(jobs, group)
+	helper, teardown, err := startHelperWithMounts(ctx, &dto.RunOpts{
+		PVC:         mounts[0].pvc,
+		Namespace:   p.namespace,
+		KubeContext: p.kubeContext,
+		Remote:      ".",
+		MountPath:   mounts[0].mountPath,
+		Workers:     p.fileWorkers,
+		ObjName:     kub.NewObjName(),
+		Transport:   p.transport,
+		Compress:    p.compress,
+		Limiter:     p.limiter,
+	}, mounts)
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer teardown()
+
+	client, err := connectSFTP(ctx, helper)
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer closeSFTPClient(client)
+
+	runGroupJobs(ctx, jobs, group, mountPaths, client, helper, volumes, errs)
+}
+
+// runGroupJobs runs the jobs of a group concurrently over the session of its helper,
+// a volume waits for a slot in volumes before it is transferred.
+func runGroupJobs(
+	ctx context.Context,
+	jobs []helperJob,
+	group []int,
+	mountPaths []string,
+	client *clients.SFTPClient,
+	helper *dto.JobOpts,
+	volumes chan struct{},
+	errs []error,
+) {
+	var wg sync.WaitGroup
+	for k, i := range group {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case volumes <- struct{}{}:
+			case <-ctx.Done():
+				errs[i] = ctx.Err()
+				return
+			}
+			defer func() { <-volumes }()
+			errs[i] = jobs[i].run(ctx, client, helper, mountPaths[k])
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,220 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/clients"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestGroupMounts(t *testing.T) {
+	jobs := []helperJob{
+		{pvc: "data-db-0", mountPath: "/var/lib/data"},
+		{pvc: "wal-db-0", mountPath: "/var/lib/wal"},
+		{pvc: "data-db-1", mountPath: "/var/lib/data"},
+		// a second volume of the same claim, e.g. mounted by two containers
+		{pvc: "data-db-0", mountPath: "/backup"},
+	}
+
+	tests := []struct {
+		name           string
+		group          []int
+		wantMounts     []helperMount
+		wantMountPaths []string
+	}{
+		{
+			name:           "single pvc keeps its mount path",
+			group:          []int{2},
+			wantMounts:     []helperMount{{pvc: "data-db-1", mountPath: "/var/lib/data"}},
+			wantMountPaths: []string{"/var/lib/data"},
+		},
+		{
+			name:           "same pvc twice is mounted once",
+			group:          []int{0, 3},
+			wantMounts:     []helperMount{{pvc: "data-db-0", mountPath: "/var/lib/data"}},
+			wantMountPaths: []string{"/var/lib/data", "/var/lib/data"},
+		},
+		{
+			name:  "shared helper mounts each pvc by name",
+			group: []int{0, 1, 2, 3},
+			wantMounts: []helperMount{
+				{pvc: "data-db-0", mountPath: "/pvc/data-db-0"},
+				{pvc: "wal-db-0", mountPath: "/pvc/wal-db-0"},
+				{pvc: "data-db-1", mountPath: "/pvc/data-db-1"},
+			},
+			wantMountPaths: []string{"/pvc/data-db-0", "/pvc/wal-db-0", "/pvc/data-db-1", "/pvc/data-db-0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mounts, mountPaths := groupMounts(jobs, tt.group)
+			require.Equal(t, tt.wantMounts, mounts)
+			require.Equal(t, tt.wantMountPaths, mountPaths)
+		})
+	}
+}
+
+// groupClaim is a claim bound to a PV on the node, or pending (WaitForFirstConsumer) when node is empty.
+func groupClaim(name, node string) []runtime.Object {
+	claim := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: name},
+		Status:     corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimPending},
+	}
+	if node == "" {
+		return []runtime.Object{claim}
+	}
+	claim.Spec.VolumeName = "pv-" + name
+	claim.Status.Phase = corev1.ClaimBound
+	pv := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv-" + name},
+		Spec: corev1.PersistentVolumeSpec{
+			NodeAffinity: &corev1.VolumeNodeAffinity{Required: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchExpressions: []corev1.NodeSelectorRequirement{{
+					Key: "kubernetes.io/hostname", Operator: corev1.NodeSelectorOpIn, Values: []string{node},
+				}}}},
+			}},
+		},
+	}
+	return []runtime.Object{claim, pv}
+}
+
+func TestGroupHelperJobs(t *testing.T) {
+	// a scheduled pod decides the node of its claims, without a PV lookup
+	running := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "db-0"},
+		Spec: corev1.PodSpec{
+			NodeName: "node-a",
+			Volumes: []corev1.Volume{{
+				Name:         "data",
+				VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data-db-0"}},
+			}},
+		},
+	}
+	objects := []runtime.Object{running}
+	for _, c := range []struct{ name, node string }{
+		{"wal-db-0", "node-a"},
+		{"data-db-1", "node-b"},
+		{"data-db-2", ""},
+		{"wal-db-2", ""},
+		{"cache", ""},
+		{"tmp", ""},
+	} {
+		objects = append(objects, groupClaim(c.name, c.node)...)
+	}
+	client := fake.NewClientset(objects...)
+
+	jobs := []helperJob{
+		{pvc: "data-db-0", pod: "db-0"},
+		{pvc: "data-db-2", pod: "db-2"},
+		{pvc: "cache"},
+		{pvc: "wal-db-0", pod: "db-0"},
+		{pvc: "data-db-1", pod: "db-1"},
+		{pvc: "wal-db-2", pod: "db-2"},
+		{pvc: "tmp"},
+		{pvc: "cache"},
+	}
+	groups, err := groupHelperJobs(context.Background(), client, "prod", jobs)
+	require.NoError(t, err)
+	require.Equal(t, [][]int{
+		{0, 3}, // node-a, by the pod and by the PV
+		{1, 5}, // pending claims of pod db-2
+		{2, 7}, // pending claim without a pod, on its own
+		{4},    // node-b
+		{6},
+	}, groups)
+
+	client.PrependReactor("list", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("forbidden")
+	})
+	_, err = groupHelperJobs(context.Background(), client, "prod", jobs)
+	require.ErrorContains(t, err, "listing pods: forbidden")
+}
+
+func TestRunHelperGroupsStartFails(t *testing.T) {
+	var ran atomic.Int32
+	run := func(context.Context, *clients.SFTPClient, *dto.JobOpts, string) error {
+		ran.Add(1)
+		return nil
+	}
+	jobs := []helperJob{
+		{pvc: "data-db-0", mountPath: "/data", run: run},
+		{pvc: "data-db-1", mountPath: "/data", run: run},
+		{pvc: "wal-db-0", mountPath: "/wal", run: run},
+	}
+	// the helper cannot be started, every job of every group gets the error
+	p := &volumeJobOpts{namespace: "prod", volumeWorkers: 2, transport: "ftp"}
+	errs := runHelperGroups(context.Background(), p, jobs, [][]int{{0, 2}, {1}})
+	require.Len(t, errs, 3)
+	for _, err := range errs {
+		require.EqualError(t, err, "unknown transport: ftp")
+	}
+	require.Zero(t, ran.Load())
+}
+
+func TestRunGroupJobs(t *testing.T) {
+	t.Run("one volume at a time, errors in job order", func(t *testing.T) {
+		var active, peak atomic.Int32
+		var mu sync.Mutex
+		got := map[int]string{}
+		job := func(i int) helperJob {
+			return helperJob{run: func(_ context.Context, _ *clients.SFTPClient, _ *dto.JobOpts, mountPath string) error {
+				n := active.Add(1)
+				defer active.Add(-1)
+				for {
+					p := peak.Load()
+					if n <= p || peak.CompareAndSwap(p, n) {
+						break
+					}
+				}
+				time.Sleep(10 * time.Millisecond)
+				mu.Lock()
+				got[i] = mountPath
+				mu.Unlock()
+				if i == 1 {
+					return nil
+				}
+				return fmt.Errorf("job %d", i)
+			}}
+		}
+		jobs := []helperJob{job(0), job(1), job(2)}
+		errs := make([]error, len(jobs))
+		volumes := make(chan struct{}, 1)
+		runGroupJobs(context.Background(), jobs, []int{2, 0, 1}, []string{"/pvc/c", "/pvc/a", "/pvc/b"}, nil, nil, volumes, errs)
+
+		require.Equal(t, int32(1), peak.Load())
+		require.Equal(t, map[int]string{0: "/pvc/a", 1: "/pvc/b", 2: "/pvc/c"}, got)
+		require.EqualError(t, errs[0], "job 0")
+		require.NoError(t, errs[1])
+		require.EqualError(t, errs[2], "job 2")
+		require.Empty(t, volumes, "slots are released")
+	})
+
+	t.Run("cancelled while waiting for a slot", func(t *testing.T) {
+		run := func(context.Context, *clients.SFTPClient, *dto.JobOpts, string) error {
+			t.Error("a job ran without a slot")
+			return nil
+		}
+		jobs := []helperJob{{run: run}, {run: run}}
+		errs := make([]error, len(jobs))
+		volumes := make(chan struct{}, 1)
+		volumes <- struct{}{} // taken by a volume of another group
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(20*time.Millisecond, cancel)
+		runGroupJobs(ctx, jobs, []int{0, 1}, []string{"/pvc/a", "/pvc/b"}, nil, nil, volumes, errs)
+		require.ErrorIs(t, errs[0], context.Canceled)
+		require.ErrorIs(t, errs[1], context.Canceled)
+	})
+}
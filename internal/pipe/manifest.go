@@ -0,0 +1,91 @@
+package pipe
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
+)
+
+// UpgradeManifest rewrites the manifest of a backup directory (or a manifest file) in the current
+// version, into out, or in place when out is empty. Entries without a file inventory (version 1
+// manifests of download-sts) get one by hashing their volume directory next to the manifest.
+func UpgradeManifest(ctx context.Context, src, out string, workers int) error {
+	p := src
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		p = filepath.Join(src, "manifest.json")
+	} else if _, err := ArchiveFormatFromPath(src); err == nil {
+		return fmt.Errorf("%s is a backup archive, its manifest cannot be upgraded in place", src)
+	}
+
+	manifest, err := kub.ReadStatefulSetBackupManifest(p)
+	if err != nil {
+		return err
+	}
+	from := manifest.Version
+	if manifest.Version != kub.ManifestVersion {
+		if err := hashManifestVolumes(ctx, filepath.Dir(p), manifest, workers); err != nil {
+			return err
+		}
+	}
+	if !kub.UpgradeManifest(manifest) && out == "" {
+		slog.Info("manifest is up to date", slog.String("path", p), slog.Int("version", from))
+		return nil
+	}
+
+	if out == "" {
+		out = p
+	}
+	// written next to the target first, so an interrupted upgrade leaves the old manifest intact
+	tmp := out + ".tmp"
+	if err := kub.WriteStatefulSetBackupManifest(tmp, manifest); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, out); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("replace manifest: %w", err)
+	}
+
+	withInventory := 0
+	for i := range manifest.Entries {
+		if manifest.Entries[i].Digest != "" {
+			withInventory++
+		}
+	}
+	slog.Info("manifest upgraded",
+		slog.String("path", out),
+		slog.Int("from", from),
+		slog.Int("to", manifest.Version),
+		slog.Int("entries", len(manifest.Entries)),
+		slog.Int("with-inventory", withInventory),
+	)
+	return nil
+}
+
+// hashManifestVolumes builds the inventories of the entries which have none from the volume
+// directories under root. Volumes missing from root are left without inventory.
+func hashManifestVolumes(ctx context.Context, root string, manifest *kub.StatefulSetBackupManifest, workers int) error {
+	for i := range manifest.Entries {
+		e := &manifest.Entries[i]
+		if len(e.Files) > 0 {
+			continue
+		}
+		files, err := hashLocalTree(ctx, filepath.Join(root, filepath.FromSlash(e.LocalPath)), workers)
+		if err != nil {
+			return fmt.Errorf("hash %s: %w", e.LocalPath, err)
+		}
+		if files == nil {
+			slog.Warn("volume not found in the backup, left without inventory", slog.String("local-path", e.LocalPath))
+			continue
+		}
+		e.Files = files
+	}
+	return nil
+}
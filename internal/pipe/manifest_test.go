@@ -0,0 +1,122 @@
+package pipe
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
+	"github.com/stretchr/testify/require"
+)
+
+// manifestV1 has an entry with an inventory (as recorded by verify before version 2), and one without.
+const manifestV1 = `{
+  "version": 1,
+  "kind": "StatefulSetBackupManifest",
+  "namespace": "prod",
+  "statefulset": "db",
+  "captured_at": "2025-01-02T03:04:05Z",
+  "entries": [
+    {
+      "pod_name": "db-0", "ordinal": 0, "volume_name": "data", "pvc_name": "data-db-0",
+      "mount_path": "/var/lib/data", "local_path": "db-0/data",
+      "files": [
+        {"path": "pg", "size": 4096, "mode": "drwxr-xr-x"},
+        {"path": "pg/PG_VERSION", "size": 3, "mode": "-rw-------", "sha256": "abc"},
+        {"path": "pg/base", "size": 10, "mode": "-rw-------", "sha256": "def"}
+      ]
+    },
+    {
+      "pod_name": "db-1", "ordinal": 1, "volume_name": "data", "pvc_name": "data-db-1",
+      "mount_path": "/var/lib/data", "local_path": "db-1/data"
+    }
+  ]
+}
+`
+
+func TestUpgradeManifest(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "manifest.json")
+	require.NoError(t, os.WriteFile(p, []byte(manifestV1), 0o600))
+
+	// into another file, the backup is left as it is
+	out := filepath.Join(t.TempDir(), "upgraded.json")
+	require.NoError(t, UpgradeManifest(context.Background(), dir, out, 2))
+	data, err := os.ReadFile(p)
+	require.NoError(t, err)
+	require.Equal(t, manifestV1, string(data))
+
+	m, err := kub.ReadStatefulSetBackupManifest(out)
+	require.NoError(t, err)
+	require.Equal(t, kub.ManifestVersion, m.Version)
+	require.Equal(t, int64(13), m.Entries[0].Bytes)
+	require.Equal(t, 2, m.Entries[0].FileCount)
+	require.Equal(t, kub.InventoryDigest(m.Entries[0].Files), m.Entries[0].Digest)
+	require.Empty(t, m.Entries[1].Digest, "entries without inventory have no totals")
+
+	// in place
+	require.NoError(t, UpgradeManifest(context.Background(), p, "", 2))
+	m, err = kub.ReadStatefulSetBackupManifest(p)
+	require.NoError(t, err)
+	require.Equal(t, kub.ManifestVersion, m.Version)
+	_, err = os.Stat(p + ".tmp")
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// an up-to-date manifest is not rewritten
+	past := time.Unix(1700000000, 0)
+	require.NoError(t, os.Chtimes(p, past, past))
+	require.NoError(t, UpgradeManifest(context.Background(), dir, "", 2))
+	fi, err := os.Stat(p)
+	require.NoError(t, err)
+	require.Equal(t, past, fi.ModTime())
+}
+
+func TestUpgradeManifestHashesBackup(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "manifest.json"), []byte(manifestV1Baseline), 0o600))
+	vol := filepath.Join(dir, "db-0", "data")
+	require.NoError(t, os.MkdirAll(filepath.Join(vol, "base"), 0o750))
+	require.NoError(t, os.WriteFile(filepath.Join(vol, "PG_VERSION"), []byte("17\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(vol, "base", "1"), []byte("0123456789"), 0o600))
+	// db-1/data was not downloaded
+
+	require.NoError(t, UpgradeManifest(context.Background(), dir, "", 2))
+	m, err := kub.ReadStatefulSetBackupManifest(filepath.Join(dir, "manifest.json"))
+	require.NoError(t, err)
+	require.Equal(t, kub.ManifestVersion, m.Version)
+
+	e := &m.Entries[0]
+	require.Equal(t, int64(13), e.Bytes)
+	require.Equal(t, 2, e.FileCount)
+	require.Len(t, e.Files, 4)
+	sum := sha256.Sum256([]byte("17\n"))
+	require.Equal(t, hex.EncodeToString(sum[:]), e.Files[1].SHA256)
+	require.Equal(t, "PG_VERSION", e.Files[1].Path)
+	require.Equal(t, kub.InventoryDigest(e.Files), e.Digest)
+	require.Empty(t, m.Entries[1].Digest)
+
+	// the backup now passes verify for the hashed volume
+	var out strings.Builder
+	err = RunVerify(context.Background(), &dto.VerifyOpts{Src: dir, FileWorkers: 1, Out: &out})
+	require.ErrorContains(t, err, "1 volume(s) without file inventory")
+	require.NotContains(t, out.String(), "db-0/data")
+}
+
+func TestUpgradeManifestErrors(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "backup.tar.zst")
+	require.NoError(t, os.WriteFile(archive, []byte("frames"), 0o600))
+	require.ErrorContains(t, UpgradeManifest(context.Background(), archive, "", 2), "cannot be upgraded in place")
+
+	require.Error(t, UpgradeManifest(context.Background(), filepath.Join(dir, "missing"), "", 2))
+
+	p := filepath.Join(dir, "manifest.json")
+	require.NoError(t, os.WriteFile(p, []byte(`{"version": 3, "kind": "StatefulSetBackupManifest"}`), 0o600))
+	require.ErrorContains(t, UpgradeManifest(context.Background(), p, "", 2), "unsupported manifest version 3")
+}
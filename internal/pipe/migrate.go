@@ -0,0 +1,163 @@
+package pipe
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
+	"k8s.io/client-go/kubernetes"
+)
+
+// RunMigrate moves the contents of a PVC (or of all PVCs of a StatefulSet) into another cluster.
+// Missing destination PVCs are created with the same size and access modes.
+// Data is relayed by the CLI between the helper pods of both clusters, nothing lands on local disk.
+func RunMigrate(ctx context.Context, opts *dto.MigrateOpts) error {
+	if opts.FromContext == opts.ToContext && opts.FromNamespace == opts.ToNamespace {
+		return fmt.Errorf("source and destination are the same (context %q, namespace %q)", opts.FromContext, opts.FromNamespace)
+	}
+
+	_, srcClient, err := initConfigAndClientForContext(opts.FromContext)
+	if err != nil {
+		return fmt.Errorf("source cluster: %w", err)
+	}
+	_, dstClient, err := initConfigAndClientForContext(opts.ToContext)
+	if err != nil {
+		return fmt.Errorf("destination cluster: %w", err)
+	}
+
+	pvcs, err := prepareMigration(ctx, opts, srcClient, dstClient)
+	if err != nil {
+		return err
+	}
+
+	type result struct {
+		pvc string
+		err error
+	}
+
+	jobs := make(chan string)
+	results := make(chan result, len(pvcs))
+
+	volumeWorkers := opts.VolumeWorkers
+	if volumeWorkers <= 0 {
+		volumeWorkers = 1
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < volumeWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pvc := range jobs {
+				results <- result{pvc: pvc, err: migratePVC(ctx, opts, pvc)}
+			}
+		}()
+	}
+
+	go func() {
+		for _, pvc := range pvcs {
+			jobs <- pvc
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	var errs []error
+	for r := range results {
+		if r.err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", r.pvc, r.err))
+		}
+	}
+	if len(errs) > 0 {
+		return joinErrors(errs)
+	}
+	return nil
+}
+
+// prepareMigration lists the claims to migrate and creates the missing ones in the destination cluster,
+// upfront, so a missing storage class fails fast.
+func prepareMigration(ctx context.Context, opts *dto.MigrateOpts, srcClient, dstClient kubernetes.Interface) ([]string, error) {
+	var pvcs []string
+	if opts.StsName != "" {
+		vols, err := kub.DiscoverStatefulSetPVCs(ctx, srcClient, opts.FromNamespace, opts.StsName)
+		if err != nil {
+			return nil, err
+		}
+		if len(vols) == 0 {
+			return nil, fmt.Errorf("no PVC-backed volumes found for StatefulSet %q", opts.StsName)
+		}
+		// one entry per mount, a claim mounted by a sidecar as well is migrated once
+		seen := map[string]bool{}
+		for i := range vols {
+			if !seen[vols[i].PVCName] {
+				seen[vols[i].PVCName] = true
+				pvcs = append(pvcs, vols[i].PVCName)
+			}
+		}
+	} else {
+		pvcs = []string{opts.PVC}
+	}
+
+	for _, name := range pvcs {
+		spec, err := kub.GetPVCSpec(ctx, srcClient, opts.FromNamespace, name)
+		if err != nil {
+			return nil, err
+		}
+		if opts.StorageClass != "" {
+			spec.StorageClass = opts.StorageClass
+		}
+		created, err := kub.EnsurePVC(ctx, dstClient, opts.ToNamespace, name, spec)
+		if err != nil {
+			return nil, err
+		}
+		if created {
+			slog.Info("pvc created in destination cluster",
+				slog.String("pvc", name),
+				slog.String("size", spec.Size),
+				slog.String("storage-class", spec.StorageClass),
+			)
+		}
+	}
+	return pvcs, nil
+}
+
+func migratePVC(ctx context.Context, opts *dto.MigrateOpts, pvc string) error {
+	srcJob, srcTeardown, err := startHelper(ctx, &dto.RunOpts{
+		PVC:         pvc,
+		Namespace:   opts.FromNamespace,
+		Remote:      ".",
+		MountPath:   copySrcMountPath,
+		Workers:     opts.FileWorkers,
+		ObjName:     kub.NewObjName(),
+		Transport:   opts.Transport,
+		KubeContext: opts.FromContext,
+	})
+	if err != nil {
+		return fmt.Errorf("start source helper: %w", err)
+	}
+	defer srcTeardown()
+
+	dstJob, dstTeardown, err := startHelper(ctx, &dto.RunOpts{
+		PVC:            pvc,
+		Namespace:      opts.ToNamespace,
+		Remote:         ".",
+		MountPath:      copyDstMountPath,
+		Workers:        opts.FileWorkers,
+		AllowOverwrite: opts.AllowOverwrite,
+		Owner:          opts.Owner,
+		ObjName:        kub.NewObjName(),
+		Transport:      opts.Transport,
+		KubeContext:    opts.ToContext,
+	})
+	if err != nil {
+		return fmt.Errorf("start destination helper: %w", err)
+	}
+	defer dstTeardown()
+
+	// helpers live in different clusters, so the data always goes through the CLI
+	return copyBetweenHelpers(ctx, srcJob, dstJob, true, false)
+}
@@ -0,0 +1,165 @@
+package pipe
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// migrateClaim is a bound claim of the given size in the storage class standard.
+func migrateClaim(namespace, name, size string) *corev1.PersistentVolumeClaim {
+	sc := "standard"
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: map[string]string{"app": "db"}},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			StorageClassName: &sc,
+			AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(size)},
+			},
+		},
+		Status: corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound},
+	}
+}
+
+func createdClaims(client *fake.Clientset) []string {
+	var result []string
+	for _, a := range client.Actions() {
+		if a.GetVerb() == "create" && a.GetResource().Resource == "persistentvolumeclaims" {
+			result = append(result, a.(k8stesting.CreateAction).GetObject().(*corev1.PersistentVolumeClaim).Name)
+		}
+	}
+	return result
+}
+
+func TestRunMigrateSameSourceAndDestination(t *testing.T) {
+	err := RunMigrate(context.Background(), &dto.MigrateOpts{
+		FromContext: "prod", ToContext: "prod", FromNamespace: "db", ToNamespace: "db", PVC: "data",
+	})
+	require.ErrorContains(t, err, `source and destination are the same (context "prod", namespace "db")`)
+}
+
+func TestPrepareMigration(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("clones the spec", func(t *testing.T) {
+		src := fake.NewClientset(migrateClaim("prod", "data", "10Gi"))
+		dst := fake.NewClientset()
+		opts := &dto.MigrateOpts{FromNamespace: "prod", ToNamespace: "staging", PVC: "data"}
+		pvcs, err := prepareMigration(ctx, opts, src, dst)
+		require.NoError(t, err)
+		require.Equal(t, []string{"data"}, pvcs)
+
+		got, err := dst.CoreV1().PersistentVolumeClaims("staging").Get(ctx, "data", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Equal(t, "standard", *got.Spec.StorageClassName)
+		require.Equal(t, "10Gi", got.Spec.Resources.Requests.Storage().String())
+		require.Equal(t, []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}, got.Spec.AccessModes)
+		require.Equal(t, map[string]string{"app": "db"}, got.Labels)
+	})
+
+	t.Run("storage class override", func(t *testing.T) {
+		src := fake.NewClientset(migrateClaim("prod", "data", "10Gi"))
+		dst := fake.NewClientset()
+		opts := &dto.MigrateOpts{FromNamespace: "prod", ToNamespace: "prod", PVC: "data", StorageClass: "fast"}
+		_, err := prepareMigration(ctx, opts, src, dst)
+		require.NoError(t, err)
+
+		got, err := dst.CoreV1().PersistentVolumeClaims("prod").Get(ctx, "data", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Equal(t, "fast", *got.Spec.StorageClassName)
+		require.Equal(t, "10Gi", got.Spec.Resources.Requests.Storage().String())
+	})
+
+	t.Run("existing claim", func(t *testing.T) {
+		src := fake.NewClientset(migrateClaim("prod", "data", "10Gi"))
+		dst := fake.NewClientset(migrateClaim("staging", "data", "20Gi"))
+		opts := &dto.MigrateOpts{FromNamespace: "prod", ToNamespace: "staging", PVC: "data", StorageClass: "fast"}
+		pvcs, err := prepareMigration(ctx, opts, src, dst)
+		require.NoError(t, err)
+		require.Equal(t, []string{"data"}, pvcs)
+		require.Empty(t, createdClaims(dst))
+
+		got, err := dst.CoreV1().PersistentVolumeClaims("staging").Get(ctx, "data", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Equal(t, "standard", *got.Spec.StorageClassName, "an existing claim is left as it is")
+		require.Equal(t, "20Gi", got.Spec.Resources.Requests.Storage().String())
+	})
+
+	t.Run("statefulset", func(t *testing.T) {
+		sts := &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "db"},
+			Spec: appsv1.StatefulSetSpec{
+				Selector:             &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+				VolumeClaimTemplates: []corev1.PersistentVolumeClaim{{ObjectMeta: metav1.ObjectMeta{Name: "data"}}},
+			},
+		}
+		src := fake.NewClientset(sts, migrateClaim("prod", "data-db-0", "10Gi"), migrateClaim("prod", "data-db-1", "10Gi"))
+		dst := fake.NewClientset(migrateClaim("staging", "data-db-0", "10Gi"))
+		opts := &dto.MigrateOpts{FromNamespace: "prod", ToNamespace: "staging", StsName: "db"}
+		pvcs, err := prepareMigration(ctx, opts, src, dst)
+		require.NoError(t, err)
+		require.Equal(t, []string{"data-db-0", "data-db-1"}, pvcs)
+		require.Equal(t, []string{"data-db-1"}, createdClaims(dst))
+	})
+
+	t.Run("claim mounted by a sidecar", func(t *testing.T) {
+		sts := &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "db"},
+			Spec: appsv1.StatefulSetSpec{
+				Selector:             &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+				VolumeClaimTemplates: []corev1.PersistentVolumeClaim{{ObjectMeta: metav1.ObjectMeta{Name: "data"}}},
+			},
+		}
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "db-0", Labels: map[string]string{"app": "db"}},
+			Spec: corev1.PodSpec{
+				Volumes: []corev1.Volume{{
+					Name: "data",
+					VolumeSource: corev1.VolumeSource{
+						PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data-db-0"},
+					},
+				}},
+				Containers: []corev1.Container{
+					{Name: "db", VolumeMounts: []corev1.VolumeMount{{Name: "data", MountPath: "/var/lib/data"}}},
+					{Name: "backup", VolumeMounts: []corev1.VolumeMount{{Name: "data", MountPath: "/backup", ReadOnly: true}}},
+				},
+			},
+		}
+		src := fake.NewClientset(sts, pod, migrateClaim("prod", "data-db-0", "10Gi"), migrateClaim("prod", "data-db-1", "10Gi"))
+		vols, err := kub.DiscoverStatefulSetPVCs(ctx, src, "prod", "db")
+		require.NoError(t, err)
+		require.Len(t, vols, 3, "one entry per mount")
+
+		dst := fake.NewClientset()
+		opts := &dto.MigrateOpts{FromNamespace: "prod", ToNamespace: "staging", StsName: "db"}
+		pvcs, err := prepareMigration(ctx, opts, src, dst)
+		require.NoError(t, err)
+		require.Equal(t, []string{"data-db-0", "data-db-1"}, pvcs)
+		require.Equal(t, []string{"data-db-0", "data-db-1"}, createdClaims(dst))
+	})
+
+	t.Run("statefulset without claims", func(t *testing.T) {
+		src := newRestoreTarget("prod", "db", 0, "data")
+		opts := &dto.MigrateOpts{FromNamespace: "prod", ToNamespace: "staging", StsName: "db"}
+		_, err := prepareMigration(ctx, opts, src, fake.NewClientset())
+		require.ErrorContains(t, err, `no PVC-backed volumes found for StatefulSet "db"`)
+	})
+
+	t.Run("missing source claim", func(t *testing.T) {
+		dst := fake.NewClientset()
+		opts := &dto.MigrateOpts{FromNamespace: "prod", ToNamespace: "staging", PVC: "data"}
+		_, err := prepareMigration(ctx, opts, fake.NewClientset(), dst)
+		require.ErrorContains(t, err, "get pvc")
+		require.Empty(t, createdClaims(dst))
+	})
+}
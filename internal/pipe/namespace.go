@@ -0,0 +1,117 @@
+package pipe
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
+	"k8s.io/client-go/kubernetes"
+)
+
+func RunDownloadNS(ctx context.Context, opts *dto.DownloadNSOpts) error {
+	_, client, err := initConfigAndClientForContext(opts.KubeContext)
+	if err != nil {
+		return err
+	}
+
+	vols, err := kub.DiscoverNamespacePVCs(ctx, client, opts.Namespace)
+	if err != nil {
+		return err
+	}
+	if len(vols) == 0 {
+		return fmt.Errorf("no bound PVCs found in namespace %q", opts.Namespace)
+	}
+
+	if err := os.MkdirAll(opts.Dst, 0o755); err != nil {
+		return fmt.Errorf("create destination root: %w", err)
+	}
+
+	inventories, err := downloadPodVolumes(ctx, &volumeJobOpts{
+		namespace:     opts.Namespace,
+		kubeContext:   opts.KubeContext,
+		volumeWorkers: opts.VolumeWorkers,
+		fileWorkers:   opts.FileWorkers,
+		transport:     opts.Transport,
+		compress:      opts.Compress,
+		limiter:       opts.Limiter,
+	}, opts.Dst, vols)
+	if err != nil {
+		return err
+	}
+
+	manifest := kub.BuildNamespaceBackupManifest(opts.Namespace, vols)
+	recordInventories(manifest, inventories)
+	manifest.FinishedAt = time.Now().UTC()
+	return kub.WriteStatefulSetBackupManifest(filepath.Join(opts.Dst, "manifest.json"), manifest)
+}
+
+func RunUploadNS(ctx context.Context, opts *dto.UploadNSOpts) error {
+	manifest, err := kub.ReadStatefulSetBackupManifest(filepath.Join(opts.Src, "manifest.json"))
+	if err != nil {
+		return fmt.Errorf("read manifest: %w", err)
+	}
+	if manifest.Kind != kub.ManifestKindNamespace {
+		return fmt.Errorf("expected manifest of kind %q, got %q", kub.ManifestKindNamespace, manifest.Kind)
+	}
+	if manifest.Namespace != opts.Namespace {
+		slog.Info("restoring into a different namespace",
+			slog.String("from", manifest.Namespace),
+			slog.String("to", opts.Namespace),
+		)
+	}
+
+	sources, err := validateManifestSources(opts.Src, manifest, opts.SkipMissing)
+	if err != nil {
+		return fmt.Errorf("validate manifest sources: %w", err)
+	}
+	if err := checkRestoreCapacity(ctx, opts.KubeContext, opts.Namespace, sources); err != nil {
+		return err
+	}
+
+	if opts.CreateMissing {
+		_, client, err := initConfigAndClientForContext(opts.KubeContext)
+		if err != nil {
+			return err
+		}
+		if err := createMissingClaims(ctx, client, opts.Namespace, sources); err != nil {
+			return err
+		}
+	}
+
+	return uploadRestoreSources(ctx, &volumeJobOpts{
+		namespace:      opts.Namespace,
+		kubeContext:    opts.KubeContext,
+		volumeWorkers:  opts.VolumeWorkers,
+		fileWorkers:    opts.FileWorkers,
+		allowOverwrite: opts.AllowOverwrite,
+		annotate:       opts.Annotate,
+		owner:          opts.Owner,
+		transport:      opts.Transport,
+		compress:       opts.Compress,
+		limiter:        opts.Limiter,
+	}, sources)
+}
+
+// createMissingClaims creates the claims of sources that do not exist in namespace, from the specs in the manifest.
+func createMissingClaims(ctx context.Context, client kubernetes.Interface, namespace string, sources []restoreSource) error {
+	for i := range sources {
+		entry := &sources[i].entry
+		created, err := kub.EnsurePVC(ctx, client, namespace, entry.PVCName, entry.PVC)
+		if err != nil {
+			return err
+		}
+		if created {
+			slog.Info("pvc created from manifest",
+				slog.String("pvc", entry.PVCName),
+				slog.String("size", entry.PVC.Size),
+				slog.String("storage-class", entry.PVC.StorageClass),
+			)
+		}
+	}
+	return nil
+}
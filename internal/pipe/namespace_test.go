@@ -0,0 +1,46 @@
+package pipe
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCreateMissingClaims(t *testing.T) {
+	ctx := context.Background()
+	spec := &kub.PVCSpec{StorageClass: "standard", Size: "5Gi", AccessModes: []string{"ReadWriteOnce"}, Labels: map[string]string{"app": "web"}}
+	sources := []restoreSource{
+		{entry: kub.StatefulSetVolume{PVCName: "data", LocalPath: "data", PVC: spec}},
+		{entry: kub.StatefulSetVolume{PVCName: "cache", LocalPath: "cache", PVC: spec}},
+	}
+
+	t.Run("creates the missing claims", func(t *testing.T) {
+		client := fake.NewClientset(migrateClaim("staging", "data", "20Gi"))
+		require.NoError(t, createMissingClaims(ctx, client, "staging", sources))
+		require.Equal(t, []string{"cache"}, createdClaims(client))
+
+		got, err := client.CoreV1().PersistentVolumeClaims("staging").Get(ctx, "cache", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Equal(t, "standard", *got.Spec.StorageClassName)
+		require.Equal(t, "5Gi", got.Spec.Resources.Requests.Storage().String())
+		require.Equal(t, []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}, got.Spec.AccessModes)
+		require.Equal(t, map[string]string{"app": "web"}, got.Labels)
+
+		got, err = client.CoreV1().PersistentVolumeClaims("staging").Get(ctx, "data", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Equal(t, "20Gi", got.Spec.Resources.Requests.Storage().String(), "an existing claim is left as it is")
+	})
+
+	t.Run("no spec in the manifest", func(t *testing.T) {
+		client := fake.NewClientset()
+		noSpec := []restoreSource{{entry: kub.StatefulSetVolume{PVCName: "data", LocalPath: "data"}}}
+		err := createMissingClaims(ctx, client, "staging", noSpec)
+		require.ErrorContains(t, err, `pvc "data" does not exist, and the manifest has no spec to create it from`)
+		require.Empty(t, createdClaims(client))
+	})
+}
@@ -0,0 +1,496 @@
+package pipe
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
+
+	"github.com/pkg/sftp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// a dry run resolves everything a transfer would, without moving any data:
+// client mode never writes to the cluster, server mode also starts the helpers
+// to list the remote side, find conflicts and the dirs that would be renamed.
+
+// conflicts printed per volume in a table, JSON has all of them
+const maxPlanConflicts = 20
+
+// ValidateDryRun checks a dry-run mode and output format given by the user, and the positional
+// arguments of the command.
+func ValidateDryRun(mode, output string, args []string) error {
+	switch mode {
+	case dto.DryRunNone, dto.DryRunClient, dto.DryRunServer:
+	default:
+		return fmt.Errorf("unknown dry-run mode: %s (expected %s, %s or %s)", mode, dto.DryRunNone, dto.DryRunClient, dto.DryRunServer)
+	}
+	// a bare --dry-run means client, so "--dry-run server" leaves the mode as a positional argument
+	if mode == dto.DryRunClient {
+		for _, arg := range args {
+			if arg == dto.DryRunClient || arg == dto.DryRunServer {
+				return fmt.Errorf("unexpected argument %q, pass the mode as --dry-run=%s", arg, arg)
+			}
+		}
+	}
+	return ValidateOutput(output)
+}
+
+// ValidateOutput checks the output format of plans and remote listings.
+func ValidateOutput(output string) error {
+	switch output {
+	case dto.OutputTable, dto.OutputJSON:
+		return nil
+	default:
+		return fmt.Errorf("unknown output format: %s (expected %s or %s)", output, dto.OutputTable, dto.OutputJSON)
+	}
+}
+
+// PlanRun is the dry run of a single download or upload.
+func PlanRun(ctx context.Context, opts *dto.RunOpts, dryRun string) (*dto.Plan, error) {
+	plan := &dto.Plan{
+		Command:   opts.Mode,
+		DryRun:    dryRun,
+		Namespace: opts.Namespace,
+		Volumes:   []dto.VolumePlan{*planVolume(ctx, opts, dryRun)},
+	}
+	return plan, planResult(plan, opts.Mode, opts.AllowOverwrite)
+}
+
+// PlanDownloadSTS is the dry run of download-sts.
+func PlanDownloadSTS(ctx context.Context, runOpts *dto.DownloadSTSOpts, dryRun string) (*dto.Plan, error) {
+	_, client, err := initConfigAndClientForContext(runOpts.KubeContext)
+	if err != nil {
+		return nil, err
+	}
+
+	vols, err := discoverSTSVolumes(ctx, client, runOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &dto.Plan{
+		Command:   "download-sts",
+		DryRun:    dryRun,
+		Namespace: runOpts.Namespace,
+	}
+	if runOpts.Quiesce {
+		plan.Notes = append(plan.Notes, quiescePlanNote(ctx, runOpts.KubeContext, runOpts.Namespace, runOpts.StsName))
+	}
+
+	p := &volumeJobOpts{
+		namespace:   runOpts.Namespace,
+		kubeContext: runOpts.KubeContext,
+		fileWorkers: runOpts.FileWorkers,
+		transport:   runOpts.Transport,
+		compress:    runOpts.Compress,
+	}
+	runs := make([]*dto.RunOpts, 0, len(vols))
+	names := make([]string, 0, len(vols))
+	for i := range vols {
+		name := kub.VolumeLocalPath(&vols[i])
+		var run *dto.RunOpts
+		if runOpts.Archive != "" {
+			// a frame of the archive, not a file of its own
+			run = volumeDownloadOpts(p, stdioPath, &vols[i])
+			run.Format = dto.FormatTar
+		} else {
+			run = volumeDownloadOpts(p, filepath.Join(runOpts.Dst, filepath.FromSlash(name)), &vols[i])
+		}
+		runs = append(runs, run)
+		names = append(names, name)
+	}
+
+	if runOpts.Archive != "" {
+		if _, err := os.Stat(runOpts.Archive); err == nil {
+			plan.Notes = append(plan.Notes, fmt.Sprintf("archive %s exists and would be replaced", runOpts.Archive))
+		}
+		plan.Notes = append(plan.Notes, fmt.Sprintf("volumes would be written one at a time into %s", runOpts.Archive))
+	} else {
+		plan.Notes = append(plan.Notes, fmt.Sprintf("manifest would be written to %s", filepath.Join(runOpts.Dst, "manifest.json")))
+	}
+
+	plan.Volumes = planVolumes(ctx, runs, names, runOpts.VolumeWorkers, dryRun)
+	for i := range plan.Volumes {
+		if runOpts.Archive != "" {
+			plan.Volumes[i].Local = runOpts.Archive + ":" + names[i]
+		}
+	}
+	return plan, planResult(plan, "download", false)
+}
+
+// PlanUploadSTS is the dry run of upload-sts.
+func PlanUploadSTS(ctx context.Context, ropts *dto.UploadSTSOpts, dryRun string) (*dto.Plan, error) {
+	sources, closeSources, err := openRestoreSources(ctx, ropts)
+	if err != nil {
+		return nil, err
+	}
+	defer closeSources()
+
+	plan := &dto.Plan{
+		Command:   "upload-sts",
+		DryRun:    dryRun,
+		Namespace: ropts.Namespace,
+	}
+	if ropts.Quiesce {
+		plan.Notes = append(plan.Notes, quiescePlanNote(ctx, ropts.KubeContext, ropts.Namespace, ropts.StsName))
+	}
+
+	p := restoreJobOpts(ropts)
+	runs := make([]*dto.RunOpts, 0, len(sources))
+	names := make([]string, 0, len(sources))
+	for i := range sources {
+		run, closeSource, err := restoreSourceOpts(p, &sources[i])
+		if err != nil {
+			return nil, err
+		}
+		defer closeSource()
+		runs = append(runs, run)
+		// the backup dir is in the local column, so a remapped restore shows where each one goes
+		names = append(names, "pvc/"+sources[i].entry.PVCName)
+	}
+
+	plan.Volumes = planVolumes(ctx, runs, names, ropts.VolumeWorkers, dryRun)
+	for i := range plan.Volumes {
+		plan.Volumes[i].Local = sources[i].localSrc
+	}
+	err = planResult(plan, "upload", ropts.AllowOverwrite)
+	if capErr := checkRestoreCapacity(ctx, ropts.KubeContext, ropts.Namespace, sources); capErr != nil {
+		plan.Notes = append(plan.Notes, capErr.Error())
+		if err == nil {
+			err = fmt.Errorf("dry run: %w", capErr)
+		}
+	}
+	return plan, err
+}
+
+// planVolumes plans up to workers volumes at a time, keeping the order of runs.
+func planVolumes(ctx context.Context, runs []*dto.RunOpts, names []string, workers int, dryRun string) []dto.VolumePlan {
+	if workers <= 0 {
+		workers = 1
+	}
+	result := make([]dto.VolumePlan, len(runs))
+	sem := make(chan struct{}, workers)
+
+	var wg sync.WaitGroup
+	for i := range runs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			vp := planVolume(ctx, runs[i], dryRun)
+			vp.Name = names[i]
+			result[i] = *vp
+		}()
+	}
+	wg.Wait()
+	return result
+}
+
+// planVolume never fails, a problem is recorded in the plan of the volume.
+func planVolume(ctx context.Context, opts *dto.RunOpts, dryRun string) *dto.VolumePlan {
+	vp := &dto.VolumePlan{
+		PVC:       opts.PVC,
+		Pod:       opts.AttachPod,
+		MountPath: opts.MountPath,
+		Remote:    filepath.ToSlash(filepath.Join(opts.MountPath, filepath.Clean(opts.Remote))),
+		Local:     opts.Local,
+	}
+	if err := inspectVolume(ctx, opts, dryRun, vp); err != nil {
+		vp.Error = err.Error()
+	}
+	return vp
+}
+
+func inspectVolume(ctx context.Context, opts *dto.RunOpts, dryRun string, vp *dto.VolumePlan) error {
+	_, client, err := initConfigAndClientForContext(opts.KubeContext)
+	if err != nil {
+		return err
+	}
+
+	if opts.AttachPod != "" {
+		pod, err := client.CoreV1().Pods(opts.Namespace).Get(ctx, opts.AttachPod, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		vp.Node = pod.Spec.NodeName
+	} else {
+		node, err := getNodeInfo(ctx, client, opts.Namespace, opts.PVC)
+		if err != nil {
+			return err
+		}
+		vp.Node = node.name
+	}
+
+	// the local side of an upload is known without a helper
+	var local []dto.WorkerJob
+	if opts.Mode == "upload" {
+		local, err = listUploadSource(opts, vp.Remote)
+		if err != nil {
+			return err
+		}
+		summarizePlanEntries(vp, local)
+	}
+
+	if dryRun != dto.DryRunServer {
+		return nil
+	}
+
+	jobOpts, teardown, err := startHelper(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer teardown()
+
+	sftpClient, err := connectSFTP(ctx, jobOpts)
+	if err != nil {
+		return err
+	}
+	defer closeSFTPClient(sftpClient)
+
+	if opts.Mode == "upload" {
+		return inspectUploadTarget(sftpClient.SFTPClient(), opts, vp, local)
+	}
+	return inspectDownloadSource(sftpClient.SFTPClient(), opts, vp)
+}
+
+// listUploadSource lists a local tree, or the entries of an archive, mapped to their remote targets.
+func listUploadSource(opts *dto.RunOpts, remotePath string) ([]dto.WorkerJob, error) {
+	if opts.Format == "" {
+		return listLocalTree(filepath.Clean(opts.Local), remotePath)
+	}
+
+	src, err := openArchiveSource(&dto.JobOpts{Local: opts.Local, Format: opts.Format, In: opts.In})
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	var jobs []dto.WorkerJob
+	tr := tar.NewReader(src)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return jobs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read tar stream: %w", err)
+		}
+		target, err := archiveTarget(remotePath, hdr.Name)
+		if err != nil {
+			return nil, err
+		}
+		if target == remotePath {
+			continue
+		}
+		jobs = append(jobs, dto.WorkerJob{
+			LocalPath:  hdr.Name,
+			RemotePath: target,
+			IsDir:      hdr.Typeflag == tar.TypeDir,
+			Size:       hdr.Size,
+		})
+	}
+}
+
+// inspectUploadTarget does the checks of Upload without changing anything.
+func inspectUploadTarget(client *sftp.Client, opts *dto.RunOpts, vp *dto.VolumePlan, local []dto.WorkerJob) error {
+	vp.Checked = true
+	if !isRemoteRoot(opts.Remote) {
+		rename, err := remoteDirRenameTarget(client, vp.Remote)
+		if err != nil {
+			return err
+		}
+		if rename != "" {
+			// the existing dir is moved away first, nothing can conflict
+			vp.Rename = rename
+			return nil
+		}
+	}
+	for i := range local {
+		exists, err := remoteFileExists(client, local[i].RemotePath, local[i].IsDir)
+		if err != nil {
+			return err
+		}
+		if exists {
+			vp.Conflicts = append(vp.Conflicts, local[i].RemotePath)
+		}
+	}
+	return nil
+}
+
+// inspectDownloadSource lists the remote tree, local files that would be overwritten are conflicts.
+func inspectDownloadSource(client *sftp.Client, opts *dto.RunOpts, vp *dto.VolumePlan) error {
+	localPath := filepath.Clean(opts.Local)
+	remote, err := getFilesToDownload(client, vp.Remote, localPath)
+	if err != nil {
+		return err
+	}
+	summarizePlanEntries(vp, remote)
+
+	vp.Checked = true
+	if opts.Format != "" {
+		if opts.Local != stdioPath {
+			if _, err := os.Stat(opts.Local); err == nil {
+				vp.Conflicts = append(vp.Conflicts, opts.Local)
+			}
+		}
+		return nil
+	}
+	for i := range remote {
+		if remote[i].IsDir {
+			continue
+		}
+		if _, err := os.Lstat(remote[i].LocalPath); err == nil {
+			vp.Conflicts = append(vp.Conflicts, remote[i].LocalPath)
+		}
+	}
+	return nil
+}
+
+func summarizePlanEntries(vp *dto.VolumePlan, entries []dto.WorkerJob) {
+	vp.Listed = true
+	vp.Files, vp.Dirs, vp.Bytes = 0, 0, 0
+	for i := range entries {
+		switch {
+		case entries[i].RemotePath == vp.Remote:
+			// the root itself
+		case entries[i].IsDir:
+			vp.Dirs++
+		default:
+			vp.Files++
+			vp.Bytes += entries[i].Size
+		}
+	}
+}
+
+func quiescePlanNote(ctx context.Context, kubeContext, namespace, stsName string) string {
+	_, client, err := initConfigAndClientForContext(kubeContext)
+	if err == nil {
+		var replicas int32
+		replicas, err = kub.GetStatefulSetReplicas(ctx, client, namespace, stsName)
+		if err == nil {
+			return fmt.Sprintf("statefulset %s would be scaled from %d to 0 replicas, and restored afterwards", stsName, replicas)
+		}
+	}
+	return fmt.Sprintf("statefulset %s would be scaled to 0 replicas, and restored afterwards (%v)", stsName, err)
+}
+
+// planResult adds the notes that depend on the whole plan, and fails when a volume could not be planned.
+func planResult(plan *dto.Plan, mode string, allowOverwrite bool) error {
+	conflicts, failed := 0, 0
+	for i := range plan.Volumes {
+		conflicts += len(plan.Volumes[i].Conflicts)
+		if plan.Volumes[i].Error != "" {
+			failed++
+		}
+	}
+	switch {
+	case plan.DryRun != dto.DryRunServer:
+		plan.Notes = append(plan.Notes, "the remote side was not inspected, use --dry-run=server to list it and check for conflicts")
+	case conflicts > 0 && mode == "upload" && !allowOverwrite:
+		plan.Notes = append(plan.Notes, fmt.Sprintf("%d existing remote file(s) would fail the upload without --allow-overwrite", conflicts))
+	case conflicts > 0 && mode == "download":
+		plan.Notes = append(plan.Notes, fmt.Sprintf("%d existing local file(s) would be overwritten", conflicts))
+	}
+	if failed > 0 {
+		return fmt.Errorf("dry run: %d of %d volume(s) cannot be transferred", failed, len(plan.Volumes))
+	}
+	return nil
+}
+
+// PrintPlan writes the plan as a table or JSON.
+func PrintPlan(w io.Writer, plan *dto.Plan, output string) error {
+	if output == dto.OutputJSON {
+		return writeJSON(w, plan)
+	}
+
+	fmt.Fprintf(w, "%s (dry run: %s), namespace %s\n\n", plan.Command, plan.DryRun, plan.Namespace)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VOLUME\tNODE\tREMOTE\tLOCAL\tFILES\tSIZE\tRENAME\tCONFLICTS")
+	for i := range plan.Volumes {
+		vp := &plan.Volumes[i]
+		files, size := "-", "-"
+		if vp.Listed {
+			files = strconv.Itoa(vp.Files)
+			size = formatSize(vp.Bytes)
+		}
+		rename, conflicts := "-", "-"
+		if vp.Checked {
+			conflicts = strconv.Itoa(len(vp.Conflicts))
+			if vp.Rename != "" {
+				rename = vp.Rename
+			}
+		}
+		node := vp.Node
+		if node == "" {
+			node = "<scheduler>"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			planVolumeName(vp), node, vp.Remote, vp.Local, files, size, rename, conflicts)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for i := range plan.Volumes {
+		vp := &plan.Volumes[i]
+		if len(vp.Conflicts) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\nconflicts of %s:\n", planVolumeName(vp))
+		for j, c := range vp.Conflicts {
+			if j == maxPlanConflicts {
+				fmt.Fprintf(w, "  ... and %d more\n", len(vp.Conflicts)-j)
+				break
+			}
+			fmt.Fprintf(w, "  %s\n", c)
+		}
+	}
+	for i := range plan.Volumes {
+		if plan.Volumes[i].Error != "" {
+			fmt.Fprintf(w, "\nerror of %s: %s\n", planVolumeName(&plan.Volumes[i]), plan.Volumes[i].Error)
+		}
+	}
+	if len(plan.Notes) > 0 {
+		fmt.Fprintln(w)
+		for _, n := range plan.Notes {
+			fmt.Fprintf(w, "note: %s\n", n)
+		}
+	}
+	return nil
+}
+
+func planVolumeName(vp *dto.VolumePlan) string {
+	switch {
+	case vp.Name != "":
+		return vp.Name
+	case vp.Pod != "":
+		return "pod/" + vp.Pod
+	default:
+		return "pvc/" + vp.PVC
+	}
+}
+
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return strconv.FormatInt(n, 10) + "B"
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%s", float64(n)/float64(div), []string{"KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}[exp])
+}
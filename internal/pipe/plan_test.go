@@ -0,0 +1,211 @@
+package pipe
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateDryRun(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   string
+		output string
+		args   []string
+		err    string
+	}{
+		{name: "off", mode: dto.DryRunNone, output: dto.OutputTable},
+		{name: "client", mode: dto.DryRunClient, output: dto.OutputJSON, args: []string{"db"}},
+		{name: "server", mode: dto.DryRunServer, output: dto.OutputTable, args: []string{"db"}},
+		{name: "unknown mode", mode: "all", output: dto.OutputTable, err: "unknown dry-run mode"},
+		{name: "unknown output", mode: dto.DryRunClient, output: "yaml", err: "unknown output format"},
+		// --dry-run server parses as --dry-run=client and a positional "server"
+		{name: "mode after a space", mode: dto.DryRunClient, output: dto.OutputTable, args: []string{"server"}, err: "--dry-run=server"},
+		{name: "mode after a space with a name", mode: dto.DryRunClient, output: dto.OutputTable, args: []string{"db", "client"}, err: "--dry-run=client"},
+		{name: "a statefulset named server", mode: dto.DryRunServer, output: dto.OutputTable, args: []string{"server"}},
+		{name: "no dry run", mode: dto.DryRunNone, output: dto.OutputTable, args: []string{"server"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateDryRun(tt.mode, tt.output, tt.args)
+			if tt.err == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, tt.err)
+		})
+	}
+}
+
+func TestSummarizePlanEntries(t *testing.T) {
+	vp := &dto.VolumePlan{Remote: "/mnt/data/pg", Files: 7}
+	summarizePlanEntries(vp, []dto.WorkerJob{
+		{RemotePath: "/mnt/data/pg", IsDir: true, Size: 4096},
+		{RemotePath: "/mnt/data/pg/base", IsDir: true, Size: 4096},
+		{RemotePath: "/mnt/data/pg/base/1", Size: 10},
+		{RemotePath: "/mnt/data/pg/PG_VERSION", Size: 3},
+	})
+	require.True(t, vp.Listed)
+	require.Equal(t, 2, vp.Files)
+	require.Equal(t, 1, vp.Dirs)
+	require.Equal(t, int64(13), vp.Bytes)
+}
+
+func TestPlanResult(t *testing.T) {
+	tests := []struct {
+		name           string
+		plan           dto.Plan
+		mode           string
+		allowOverwrite bool
+		note           string
+		err            string
+	}{
+		{
+			name: "client",
+			plan: dto.Plan{DryRun: dto.DryRunClient, Volumes: []dto.VolumePlan{{}}},
+			mode: "upload",
+			note: "the remote side was not inspected, use --dry-run=server to list it and check for conflicts",
+		},
+		{
+			name: "upload conflicts",
+			plan: dto.Plan{DryRun: dto.DryRunServer, Volumes: []dto.VolumePlan{{Conflicts: []string{"a", "b"}}}},
+			mode: "upload",
+			note: "2 existing remote file(s) would fail the upload without --allow-overwrite",
+		},
+		{
+			name:           "upload conflicts with --allow-overwrite",
+			plan:           dto.Plan{DryRun: dto.DryRunServer, Volumes: []dto.VolumePlan{{Conflicts: []string{"a"}}}},
+			mode:           "upload",
+			allowOverwrite: true,
+		},
+		{
+			name: "download conflicts",
+			plan: dto.Plan{DryRun: dto.DryRunServer, Volumes: []dto.VolumePlan{{Conflicts: []string{"a"}}}},
+			mode: "download",
+			note: "1 existing local file(s) would be overwritten",
+		},
+		{
+			name: "failed volume",
+			plan: dto.Plan{DryRun: dto.DryRunServer, Volumes: []dto.VolumePlan{{}, {Error: "pvc not found"}}},
+			mode: "download",
+			err:  "1 of 2 volume(s) cannot be transferred",
+		},
+	}
+	for i := range tests {
+		tt := &tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			err := planResult(&tt.plan, tt.mode, tt.allowOverwrite)
+			if tt.err != "" {
+				require.ErrorContains(t, err, tt.err)
+			} else {
+				require.NoError(t, err)
+			}
+			if tt.note == "" {
+				require.Empty(t, tt.plan.Notes)
+			} else {
+				require.Equal(t, []string{tt.note}, tt.plan.Notes)
+			}
+		})
+	}
+}
+
+func TestPrintPlan(t *testing.T) {
+	conflicts := make([]string, maxPlanConflicts+5)
+	for i := range conflicts {
+		conflicts[i] = fmt.Sprintf("/mnt/data/f%02d", i)
+	}
+	plan := &dto.Plan{
+		Command:   "upload-sts",
+		DryRun:    dto.DryRunServer,
+		Namespace: "prod",
+		Notes:     []string{"something to know"},
+		Volumes: []dto.VolumePlan{
+			{Name: "db-0/data", Node: "node-1", Remote: "/mnt/data", Local: "./b/db-0/data", Listed: true, Files: 3, Bytes: 2048, Checked: true, Conflicts: conflicts},
+			{PVC: "data-db-1", Remote: "/mnt/data", Local: "./b/db-1/data", Checked: true, Rename: "/mnt/data-original"},
+			{Pod: "db-2", Remote: "/mnt/data", Local: "./b/db-2/data", Error: "pod not running"},
+		},
+	}
+
+	var out strings.Builder
+	require.NoError(t, PrintPlan(&out, plan, dto.OutputTable))
+	s := out.String()
+	require.Contains(t, s, "upload-sts (dry run: server), namespace prod")
+	lines := strings.Split(s, "\n")
+	require.Regexp(t, `^db-0/data\s+node-1\s+/mnt/data\s+\./b/db-0/data\s+3\s+2\.0KiB\s+-\s+25$`, lines[3])
+	require.Regexp(t, `^pvc/data-db-1\s+<scheduler>\s+/mnt/data\s+\./b/db-1/data\s+-\s+-\s+/mnt/data-original\s+0$`, lines[4])
+	require.Regexp(t, `^pod/db-2\s+<scheduler>\s+/mnt/data\s+\./b/db-2/data\s+-\s+-\s+-\s+-$`, lines[5])
+	require.Contains(t, s, "conflicts of db-0/data:\n  /mnt/data/f00\n")
+	require.Contains(t, s, "  ... and 5 more\n")
+	require.NotContains(t, s, fmt.Sprintf("f%02d", maxPlanConflicts))
+	require.Contains(t, s, "error of pod/db-2: pod not running")
+	require.Contains(t, s, "note: something to know")
+
+	out.Reset()
+	require.NoError(t, PrintPlan(&out, plan, dto.OutputJSON))
+	require.Contains(t, out.String(), `"dry_run": "server"`)
+	require.Contains(t, out.String(), fmt.Sprintf("f%02d", maxPlanConflicts+4))
+}
+
+func TestFormatSize(t *testing.T) {
+	require.Equal(t, "0B", formatSize(0))
+	require.Equal(t, "1023B", formatSize(1023))
+	require.Equal(t, "1.0KiB", formatSize(1024))
+	require.Equal(t, "1.5MiB", formatSize(3<<19))
+	require.Equal(t, "2.0GiB", formatSize(2<<30))
+}
+
+func TestInspectUploadTarget(t *testing.T) {
+	client := newTestSFTPClient(t)
+	local, remote := t.TempDir(), t.TempDir()
+	for _, name := range []string{"a", "b"} {
+		require.NoError(t, os.WriteFile(filepath.Join(local, name), []byte(name), 0o600))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(remote, "a"), []byte("a"), 0o600))
+	jobs, err := listLocalTree(local, filepath.ToSlash(remote))
+	require.NoError(t, err)
+
+	// into the mount path, existing entries are conflicts (as in getFilesToUpload, the root too)
+	vp := &dto.VolumePlan{Remote: filepath.ToSlash(remote)}
+	require.NoError(t, inspectUploadTarget(client, &dto.RunOpts{Remote: "."}, vp, jobs))
+	require.True(t, vp.Checked)
+	require.Equal(t, []string{filepath.ToSlash(remote), filepath.ToSlash(filepath.Join(remote, "a"))}, vp.Conflicts)
+	require.Empty(t, vp.Rename)
+
+	// into a subdirectory, an existing one is moved away first
+	vp = &dto.VolumePlan{Remote: filepath.ToSlash(remote)}
+	require.NoError(t, inspectUploadTarget(client, &dto.RunOpts{Remote: "sub"}, vp, jobs))
+	require.Contains(t, vp.Rename, filepath.ToSlash(remote)+"-original-")
+	require.Empty(t, vp.Conflicts)
+}
+
+func TestInspectDownloadSource(t *testing.T) {
+	client := newTestSFTPClient(t)
+	local, remote := t.TempDir(), t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(remote, "dir"), 0o750))
+	require.NoError(t, os.WriteFile(filepath.Join(remote, "dir", "a"), []byte("abc"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(remote, "b"), []byte("b"), 0o600))
+	require.NoError(t, os.MkdirAll(filepath.Join(local, "dir"), 0o750))
+	require.NoError(t, os.WriteFile(filepath.Join(local, "dir", "a"), []byte("old"), 0o600))
+
+	vp := &dto.VolumePlan{Remote: filepath.ToSlash(remote)}
+	require.NoError(t, inspectDownloadSource(client, &dto.RunOpts{Local: local}, vp))
+	require.Equal(t, 2, vp.Files)
+	require.Equal(t, 1, vp.Dirs)
+	require.Equal(t, int64(4), vp.Bytes)
+	require.Equal(t, []string{filepath.Join(local, "dir", "a")}, vp.Conflicts)
+
+	// an archive conflicts with an existing file only
+	archive := filepath.Join(local, "pg.tar")
+	vp = &dto.VolumePlan{Remote: filepath.ToSlash(remote)}
+	require.NoError(t, inspectDownloadSource(client, &dto.RunOpts{Local: archive, Format: dto.FormatTar}, vp))
+	require.Empty(t, vp.Conflicts)
+	require.NoError(t, os.WriteFile(archive, nil, 0o600))
+	vp = &dto.VolumePlan{Remote: filepath.ToSlash(remote)}
+	require.NoError(t, inspectDownloadSource(client, &dto.RunOpts{Local: archive, Format: dto.FormatTar}, vp))
+	require.Equal(t, []string{archive}, vp.Conflicts)
+}
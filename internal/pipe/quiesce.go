@@ -0,0 +1,80 @@
+package pipe
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// quiesceStatefulSet scales the StatefulSet to zero and waits until its pods are gone.
+// The returned func restores the original replica count, and must be called
+// regardless of the transfer result (it is a no-op when nothing was scaled).
+func quiesceStatefulSet(
+	ctx context.Context,
+	client kubernetes.Interface,
+	namespace, stsName string,
+	timeout time.Duration,
+) (func() error, error) {
+	noop := func() error { return nil }
+
+	replicas, err := kub.GetStatefulSetReplicas(ctx, client, namespace, stsName)
+	if err != nil {
+		return noop, err
+	}
+
+	restore := func() error {
+		// the parent context may be canceled at this point (Ctrl+C), the workload has to be restored anyway
+		restoreCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		slog.Info("restoring statefulset replicas",
+			slog.String("statefulset", stsName),
+			slog.Int("replicas", int(replicas)),
+		)
+		if err := kub.ScaleStatefulSet(restoreCtx, client, namespace, stsName, replicas); err != nil {
+			return fmt.Errorf("restore replicas of statefulset %q to %d: %w", stsName, replicas, err)
+		}
+		return nil
+	}
+
+	if replicas == 0 {
+		slog.Info("statefulset is already scaled to zero", slog.String("statefulset", stsName))
+	} else {
+		slog.Info("scaling statefulset to zero",
+			slog.String("statefulset", stsName),
+			slog.Int("replicas", int(replicas)),
+		)
+		if err := kub.ScaleStatefulSet(ctx, client, namespace, stsName, 0); err != nil {
+			return noop, err
+		}
+	}
+
+	slog.Info("waiting for statefulset pods to terminate", slog.String("statefulset", stsName))
+	if err := kub.WaitStatefulSetPodsGone(ctx, client, namespace, stsName, timeout); err != nil {
+		if restoreErr := restore(); restoreErr != nil {
+			slog.Error("cannot restore statefulset replicas", slog.Any("err", restoreErr))
+		}
+		return noop, err
+	}
+
+	if replicas == 0 {
+		return noop, nil
+	}
+	return restore, nil
+}
+
+// restoreAfterQuiesce is meant to be deferred: it runs restore, and reports its error
+// through errp unless the transfer already failed.
+func restoreAfterQuiesce(restore func() error, errp *error) {
+	if err := restore(); err != nil {
+		slog.Error("cannot restore statefulset replicas", slog.Any("err", err))
+		if *errp == nil {
+			*errp = err
+		}
+	}
+}
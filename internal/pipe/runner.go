@@ -36,12 +36,20 @@ echo "ChallengeResponseAuthentication no" >> /etc/ssh/sshd_config;
 echo "PermitRootLogin prohibit-password" >> /etc/ssh/sshd_config;
 ssh-keygen -A;
 /usr/sbin/sshd -D -p 2525;
+`
+	// sftp-server is started on demand via pods/exec, the container just has to stay alive
+	execRunCmd = `
+apk update;
+apk add openssh-sftp-server;
+exec tail -f /dev/null;
 `
 )
 
 var (
 	activeDeadlineSeconds int64 = 86400 / 2 // TODO: configure
-	gracePeriodSeconds    int64
+	// helper pods that are not running by then fail the transfer (unschedulable, unpullable image, unmountable PVC)
+	helperStartTimeout = 5 * time.Minute
+	gracePeriodSeconds int64
 )
 
 type nodeInfo struct {
@@ -50,44 +58,96 @@ type nodeInfo struct {
 }
 
 func Run(ctx context.Context, opts *dto.RunOpts) error {
+	jobOpts, teardown, err := startHelper(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer teardown()
+
+	return auditTransfer(ctx, opts.Mode, jobOpts, func() error {
+		return runJob(ctx, opts.Mode, jobOpts)
+	})
+}
+
+// helperMount is a PVC mounted into a helper pod.
+type helperMount struct {
+	pvc       string
+	mountPath string
+}
+
+// startHelper creates everything needed to reach the PVC (helper pod, service, keys),
+// and waits until the helper pod is running. On success, the caller owns the returned
+// teardown func, which removes the helper objects.
+func startHelper(ctx context.Context, opts *dto.RunOpts) (jobOpts *dto.JobOpts, teardown func(), err error) {
+	return startHelperWithMounts(ctx, opts, []helperMount{{pvc: opts.PVC, mountPath: opts.MountPath}})
+}
+
+// startHelperWithMounts is startHelper for a helper pod that mounts several PVCs, which must be
+// reachable from one node. The node is decided by the first of them.
+func startHelperWithMounts(ctx context.Context, opts *dto.RunOpts, mounts []helperMount) (jobOpts *dto.JobOpts, teardown func(), err error) {
 	objName := opts.ObjName
 	if strings.TrimSpace(objName) == "" {
-		return fmt.Errorf("(internal-error). object-name for pod was not set")
+		return nil, nil, fmt.Errorf("(internal-error). object-name for pod was not set")
+	}
+	transport := opts.Transport
+	if transport == "" {
+		transport = dto.TransportNodePort
+	}
+	if transport != dto.TransportNodePort && transport != dto.TransportExec {
+		return nil, nil, fmt.Errorf("unknown transport: %s", transport)
+	}
+	if err := ValidateCompress(opts.Compress); err != nil {
+		return nil, nil, err
+	}
+	if opts.AttachPod != "" {
+		return startAttached(ctx, opts)
 	}
 
+	var cleanups []func()
+	teardown = func() {
+		for i := len(cleanups) - 1; i >= 0; i-- {
+			cleanups[i]()
+		}
+	}
+	defer func() {
+		if err != nil {
+			teardown()
+			teardown = nil
+		}
+	}()
+
 	// config routine
 
-	slog.Info("init k8s config")
-	config, client, err := initConfigAndClient()
+	config, client, err := initConfigAndClientForContext(opts.KubeContext)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	// node
 
 	slog.Info("fetching target node to schedule pod on")
-	node, err := getNodeInfo(ctx, client, opts.Namespace, opts.PVC)
+	node, err := getNodeInfo(ctx, client, opts.Namespace, mounts[0].pvc)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	// auth
+	// auth (exec transport is authorized by the k8s API itself)
 
-	slog.Info("create ssh key-pair")
-	ed25519Keys, err := clients.GenerateEd25519Keys()
-	if err != nil {
-		return err
+	var ed25519Keys *clients.KeyPair
+	if transport == dto.TransportNodePort {
+		slog.Info("create ssh key-pair")
+		ed25519Keys, err = clients.GenerateEd25519Keys()
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 
 	// pod
 
-	slog.Info("creating pod")
-	err = createHelperPod(ctx, client, ed25519Keys, opts.Namespace, opts.PVC, opts.MountPath, node.name, opts.ObjName)
-	if err != nil {
-		return err
-	}
-	slog.Info("pod created", slog.String("name", objName))
-	defer func() {
+	slog.Info("creating pod", slog.String("transport", transport))
+	scheduledNode, err := createHelperPod(ctx, client, ed25519Keys, opts.Namespace, mounts, node.name, opts.ObjName)
+	// the pod may exist even if it never became ready
+	cleanups = append(cleanups, func() {
 		cleanupCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 		if err := deleteHelperPod(cleanupCtx, client, opts.Namespace, opts.ObjName); err != nil {
@@ -95,51 +155,98 @@ func Run(ctx context.Context, opts *dto.RunOpts) error {
 		} else {
 			slog.Info("pod deleted", slog.String("name", objName))
 		}
-	}()
-
-	// service
-
-	slog.Info("creating service")
-	port, err := createNodePortService(ctx, client, opts.Namespace, opts.ObjName)
+	})
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	slog.Info("service created",
-		slog.String("name", objName),
-		slog.Int64("port", int64(port)),
-	)
-	defer func() {
-		cleanupCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-		if err := deleteHelperService(cleanupCtx, client, opts.Namespace, objName); err != nil {
-			slog.Error("cannot delete service", slog.Any("err", err))
-		} else {
-			slog.Info("service deleted", slog.String("name", objName))
+	slog.Info("pod created", slog.String("name", objName))
+	if node.name == "" {
+		node, err = getNodeInfoByName(ctx, client, scheduledNode)
+		if err != nil {
+			return nil, nil, err
 		}
-	}()
+	}
+
+	// service (not needed when the data goes through the exec stream)
+
+	var port int32
+	if transport == dto.TransportNodePort {
+		slog.Info("creating service")
+		port, err = createNodePortService(ctx, client, opts.Namespace, opts.ObjName)
+		if err != nil {
+			return nil, nil, err
+		}
+		slog.Info("service created",
+			slog.String("name", objName),
+			slog.Int64("port", int64(port)),
+		)
+		cleanups = append(cleanups, func() {
+			cleanupCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := deleteHelperService(cleanupCtx, client, opts.Namespace, objName); err != nil {
+				slog.Error("cannot delete service", slog.Any("err", err))
+			} else {
+				slog.Info("service deleted", slog.String("name", objName))
+			}
+		})
+	}
+
+	helper := &dto.JobOpts{
+		Host:       node.addr,
+		Port:       int(port),
+		KeyPair:    ed25519Keys,
+		ObjName:    objName,
+		PodName:    objName,
+		Container:  objName,
+		Namespace:  opts.Namespace,
+		Transport:  transport,
+		User:       kubeconfigUser(opts.KubeContext),
+		Client:     client,
+		RestConfig: config,
+	}
+	return jobOptsForRun(helper, opts, opts.MountPath), teardown, nil
+}
+
+// jobOptsForRun returns the options of a transfer through the given helper, with the PVC mounted at mountPath.
+func jobOptsForRun(helper *dto.JobOpts, opts *dto.RunOpts, mountPath string) *dto.JobOpts {
+	jobOpts := *helper
+	jobOpts.Remote = filepath.ToSlash(opts.Remote)
+	jobOpts.Local = filepath.ToSlash(opts.Local)
+	jobOpts.MountPath = filepath.ToSlash(mountPath)
+	jobOpts.Workers = opts.Workers
+	jobOpts.AllowOverwrite = opts.AllowOverwrite
+	jobOpts.Owner = opts.Owner
+	jobOpts.Format = opts.Format
+	jobOpts.Compress = opts.Compress
+	jobOpts.Limiter = opts.Limiter
+	jobOpts.Inventory = opts.Inventory
+	jobOpts.In = opts.In
+	jobOpts.Out = opts.Out
+	jobOpts.PVC = opts.PVC
+	jobOpts.Annotate = opts.Annotate
+	return &jobOpts
+}
 
-	jobOpts := &dto.JobOpts{
-		Host:           node.addr,
-		Port:           int(port),
-		Remote:         filepath.ToSlash(opts.Remote),
-		Local:          filepath.ToSlash(opts.Local),
-		MountPath:      filepath.ToSlash(opts.MountPath),
-		Workers:        opts.Workers,
-		KeyPair:        ed25519Keys,
-		AllowOverwrite: opts.AllowOverwrite,
-		ObjName:        objName,
-		Owner:          opts.Owner,
-		Namespace:      opts.Namespace,
-		Client:         client,
-		RestConfig:     config,
-	}
-	switch opts.Mode {
+func runJob(ctx context.Context, mode string, jobOpts *dto.JobOpts) error {
+	switch mode {
 	case "upload":
 		return Upload(ctx, jobOpts)
 	case "download":
 		return Download(ctx, jobOpts)
 	default:
-		return fmt.Errorf("unknown mode: %s", opts.Mode)
+		return fmt.Errorf("unknown mode: %s", mode)
+	}
+}
+
+// runJobWithClient is runJob over an open session, shared by the transfers through one helper.
+func runJobWithClient(ctx context.Context, mode string, client *clients.SFTPClient, jobOpts *dto.JobOpts) error {
+	switch mode {
+	case "upload":
+		return uploadWithClient(ctx, client, jobOpts)
+	case "download":
+		return downloadWithClient(ctx, client, jobOpts)
+	default:
+		return fmt.Errorf("unknown mode: %s", mode)
 	}
 }
 
@@ -149,8 +256,50 @@ func createHelperPod(
 	ctx context.Context,
 	client *kubernetes.Clientset,
 	keyPair *clients.KeyPair,
-	namespace, pvc, mountPath, pvcNodeName, objName string,
-) error {
+	namespace string,
+	mounts []helperMount,
+	pvcNodeName, objName string,
+) (string, error) {
+	// keyPair is only given for the sshd (NodePort) transport
+	command := execRunCmd
+	var env []corev1.EnvVar
+	var ports []corev1.ContainerPort
+	if keyPair != nil {
+		command = runCmd
+		env = []corev1.EnvVar{
+			{
+				Name:  "PUB_KEY",
+				Value: keyPair.PublicKeyEncodedToString,
+			},
+		}
+		ports = []corev1.ContainerPort{
+			{
+				ContainerPort: 2525,
+			},
+		}
+	}
+
+	volumeMounts := make([]corev1.VolumeMount, 0, len(mounts))
+	volumes := make([]corev1.Volume, 0, len(mounts))
+	for i, m := range mounts {
+		name := "data"
+		if i > 0 {
+			name = fmt.Sprintf("data-%d", i)
+		}
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{
+			Name:      name,
+			MountPath: m.mountPath,
+		})
+		volumes = append(volumes, corev1.Volume{
+			Name: name,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: m.pvc,
+				},
+			},
+		})
+	}
+
 	pod := &corev1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      objName,
@@ -166,58 +315,117 @@ func createHelperPod(
 					Name:            objName,
 					Image:           helperImage,
 					ImagePullPolicy: corev1.PullIfNotPresent,
-					Command:         []string{"sh", "-c", runCmd},
-
-					VolumeMounts: []corev1.VolumeMount{
-						{
-							Name:      "data",
-							MountPath: mountPath,
-						},
-					},
-					Env: []corev1.EnvVar{
-						{
-							Name:  "PUB_KEY",
-							Value: keyPair.PublicKeyEncodedToString,
-						},
-					},
-					Ports: []corev1.ContainerPort{
-						{
-							ContainerPort: 2525,
-						},
-					},
-				},
-			},
-			Volumes: []corev1.Volume{
-				{
-					Name: "data",
-					VolumeSource: corev1.VolumeSource{
-						PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
-							ClaimName: pvc,
-						},
-					},
+					Command:         []string{"sh", "-c", command},
+
+					VolumeMounts: volumeMounts,
+					Env:          env,
+					Ports:        ports,
 				},
 			},
+			Volumes: volumes,
 		},
 	}
 	_, err := client.CoreV1().Pods(namespace).Create(ctx, pod, metav1.CreateOptions{})
 	if err != nil {
-		return err
+		return "", err
 	}
 
+	p, err := waitHelperPod(ctx, client, namespace, objName, helperStartTimeout)
+	if err != nil {
+		return "", err
+	}
+	return p.Spec.NodeName, nil
+}
+
+// waitHelperPod waits until the pod is running, and fails early when it will not start.
+func waitHelperPod(ctx context.Context, client kubernetes.Interface, namespace, objName string, timeout time.Duration) (*corev1.Pod, error) {
+	// the container of a helper pod is named after it
+	return waitHelperContainer(ctx, client, namespace, objName, objName, timeout)
+}
+
+// waitHelperContainer waits until a container (or ephemeral container) of the pod is running,
+// and fails early when it will not start.
+func waitHelperContainer(ctx context.Context, client kubernetes.Interface, namespace, podName, container string, timeout time.Duration) (*corev1.Pod, error) {
+	what := "pod " + podName
+	if container != podName {
+		what = "container " + container + " in pod " + podName
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	last := "pending"
 	for {
-		p, err := client.CoreV1().Pods(namespace).Get(ctx, objName, metav1.GetOptions{})
-		if err != nil {
-			return err
+		p, err := client.CoreV1().Pods(namespace).Get(waitCtx, podName, metav1.GetOptions{})
+		switch {
+		case err != nil && waitCtx.Err() == nil:
+			return nil, err
+		case err == nil:
+			if st := containerStatus(p, container); st != nil && st.State.Running != nil {
+				return p, nil
+			}
+			if problem, failed := helperPodProblem(p, container); failed {
+				return nil, fmt.Errorf("%s will not start: %s", what, problem)
+			} else if problem != "" {
+				last = problem
+			}
 		}
-		if p.Status.Phase == corev1.PodRunning {
-			break
+		select {
+		case <-waitCtx.Done():
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("%s is not running after %s: %s", what, timeout, last)
+		case <-time.After(time.Second):
 		}
-		time.Sleep(1 * time.Second)
 	}
+}
 
+// containerStatus returns the status of a container or ephemeral container of the pod.
+func containerStatus(p *corev1.Pod, name string) *corev1.ContainerStatus {
+	for i := range p.Status.ContainerStatuses {
+		if p.Status.ContainerStatuses[i].Name == name {
+			return &p.Status.ContainerStatuses[i]
+		}
+	}
+	for i := range p.Status.EphemeralContainerStatuses {
+		if p.Status.EphemeralContainerStatuses[i].Name == name {
+			return &p.Status.EphemeralContainerStatuses[i]
+		}
+	}
 	return nil
 }
 
+// helperPodProblem tells why the container of a pod is not running, failed is set when it will not recover.
+func helperPodProblem(p *corev1.Pod, container string) (problem string, failed bool) {
+	if p.Status.Phase == corev1.PodFailed {
+		return "pod failed: " + p.Status.Message, true
+	}
+	if st := containerStatus(p, container); st != nil {
+		switch {
+		case st.State.Terminated != nil:
+			return "terminated: " + st.State.Terminated.Reason, true
+		case st.State.Waiting != nil:
+			return waitingProblem(st.State.Waiting)
+		}
+	}
+	for i := range p.Status.Conditions {
+		c := &p.Status.Conditions[i]
+		if c.Type == corev1.PodScheduled && c.Status == corev1.ConditionFalse {
+			return c.Reason + ": " + c.Message, false
+		}
+	}
+	return "", false
+}
+
+// waitingProblem tells why a container is waiting, failed is set when it will not recover.
+func waitingProblem(w *corev1.ContainerStateWaiting) (problem string, failed bool) {
+	switch w.Reason {
+	case "ErrImagePull", "ImagePullBackOff", "InvalidImageName", "ErrImageNeverPull":
+		return w.Reason + ": " + w.Message, true
+	}
+	return w.Reason, false
+}
+
 func createNodePortService(ctx context.Context, client *kubernetes.Clientset, namespace, objName string) (int32, error) {
 	svc := &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
@@ -285,7 +493,14 @@ func getNodeInfo(ctx context.Context, client *kubernetes.Clientset, namespace, p
 	if err != nil {
 		return nil, err
 	}
+	if pvcNodeName == "" {
+		slog.Info("PVC is not bound yet, node will be decided by the scheduler", slog.String("pvc", pvc))
+		return &nodeInfo{}, nil
+	}
+	return getNodeInfoByName(ctx, client, pvcNodeName)
+}
 
+func getNodeInfoByName(ctx context.Context, client *kubernetes.Clientset, pvcNodeName string) (*nodeInfo, error) {
 	// get node IP addr
 	node, err := client.CoreV1().Nodes().Get(ctx, pvcNodeName, metav1.GetOptions{})
 	if err != nil {
@@ -329,24 +544,67 @@ func getPVCNodeName(ctx context.Context, client *kubernetes.Clientset, namespace
 	if err != nil {
 		return "", fmt.Errorf("listing pods: %w", err)
 	}
+	if nodeName := podNodeOfPVC(pods.Items, pvcName); nodeName != "" {
+		return nodeName, nil // Fast path
+	}
+
+	// 2) Fallback - check PVC -> PV -> NodeAffinity
+	return pvNodeOfPVC(ctx, client, namespace, pvcName)
+}
+
+// pvcNodeNames resolves the nodes of several PVCs with a single list of pods.
+// Claims whose node cannot be decided (yet) are left out.
+func pvcNodeNames(ctx context.Context, client kubernetes.Interface, namespace string, pvcNames []string) (map[string]string, error) {
+	pods, err := client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing pods: %w", err)
+	}
+
+	result := make(map[string]string, len(pvcNames))
+	for _, pvcName := range pvcNames {
+		if _, ok := result[pvcName]; ok {
+			continue
+		}
+		nodeName := podNodeOfPVC(pods.Items, pvcName)
+		if nodeName == "" {
+			nodeName, err = pvNodeOfPVC(ctx, client, namespace, pvcName)
+			if err != nil {
+				slog.Debug("cannot decide node of PVC", slog.String("pvc", pvcName), slog.Any("err", err))
+			}
+		}
+		if nodeName != "" {
+			result[pvcName] = nodeName
+		}
+	}
+	return result, nil
+}
 
-	for pi := range pods.Items {
-		pod := pods.Items[pi]
+// podNodeOfPVC returns the node of a scheduled pod that uses the PVC.
+func podNodeOfPVC(pods []corev1.Pod, pvcName string) string {
+	for pi := range pods {
+		pod := &pods[pi]
 		for vi := range pod.Spec.Volumes {
 			vol := pod.Spec.Volumes[vi]
 			if vol.PersistentVolumeClaim != nil && vol.PersistentVolumeClaim.ClaimName == pvcName {
 				if pod.Spec.NodeName != "" {
-					return pod.Spec.NodeName, nil // Fast path
+					return pod.Spec.NodeName
 				}
 			}
 		}
 	}
+	return ""
+}
 
-	// 2) Fallback - check PVC -> PV -> NodeAffinity
+// pvNodeOfPVC returns the node of a local PV from its node affinity, or "" when the claim is not bound yet.
+func pvNodeOfPVC(ctx context.Context, client kubernetes.Interface, namespace, pvcName string) (string, error) {
 	pvc, err := client.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, pvcName, metav1.GetOptions{})
 	if err != nil {
 		return "", fmt.Errorf("get PVC: %w", err)
 	}
+	if pvc.Status.Phase == corev1.ClaimPending && pvc.Spec.VolumeName == "" {
+		// e.g. WaitForFirstConsumer: the helper pod is the first consumer
+		return "", nil
+	}
 	if pvc.Status.Phase != corev1.ClaimBound || pvc.Spec.VolumeName == "" {
 		return "", fmt.Errorf("PVC %s is not bound to any PV", pvcName)
 	}
@@ -373,11 +631,20 @@ func getPVCNodeName(ctx context.Context, client *kubernetes.Clientset, namespace
 
 // client
 
-func initConfigAndClient() (*rest.Config, *kubernetes.Clientset, error) {
-	slog.Info("init k8s config")
-	config, err := rest.InClusterConfig()
-	if err != nil {
-		config, err = clientcmd.BuildConfigFromFlags("", clientcmd.RecommendedHomeFile)
+// initConfigAndClientForContext builds a client for the given kubeconfig context,
+// an empty context means in-cluster config, or the current context of the kubeconfig.
+func initConfigAndClientForContext(kubeContext string) (*rest.Config, *kubernetes.Clientset, error) {
+	slog.Info("init k8s config", slog.String("context", kubeContext))
+	var config *rest.Config
+	var err error
+	if kubeContext == "" {
+		config, err = rest.InClusterConfig()
+	}
+	if kubeContext != "" || err != nil {
+		config, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+			clientcmd.NewDefaultClientConfigLoadingRules(),
+			&clientcmd.ConfigOverrides{CurrentContext: kubeContext},
+		).ClientConfig()
 		if err != nil {
 			return nil, nil, fmt.Errorf("load kubeconfig: %w", err)
 		}
@@ -392,6 +659,29 @@ func initConfigAndClient() (*rest.Config, *kubernetes.Clientset, error) {
 	return config, client, nil
 }
 
+// kubeconfigUser returns the user of the kubeconfig context, as recorded in the audit events.
+func kubeconfigUser(kubeContext string) string {
+	if kubeContext == "" {
+		if _, err := rest.InClusterConfig(); err == nil {
+			return "in-cluster service account"
+		}
+	}
+	raw, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(),
+		&clientcmd.ConfigOverrides{CurrentContext: kubeContext},
+	).RawConfig()
+	if err != nil {
+		return "unknown"
+	}
+	if kubeContext == "" {
+		kubeContext = raw.CurrentContext
+	}
+	if c, ok := raw.Contexts[kubeContext]; ok && c.AuthInfo != "" {
+		return c.AuthInfo
+	}
+	return "unknown"
+}
+
 // utils
 
 func labels(objName string) map[string]string {
@@ -0,0 +1,109 @@
+package pipe
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func waitingStatus(name, reason string) corev1.ContainerStatus {
+	return corev1.ContainerStatus{
+		Name:  name,
+		State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: reason, Message: "msg"}},
+	}
+}
+
+func TestHelperPodProblem(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     corev1.PodStatus
+		wantText   string
+		wantFailed bool
+	}{
+		{
+			name:   "pending",
+			status: corev1.PodStatus{Phase: corev1.PodPending},
+		},
+		{
+			name:       "pod failed",
+			status:     corev1.PodStatus{Phase: corev1.PodFailed, Message: "evicted"},
+			wantText:   "pod failed: evicted",
+			wantFailed: true,
+		},
+		{
+			name:     "creating",
+			status:   corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{waitingStatus("helper", "ContainerCreating")}},
+			wantText: "ContainerCreating",
+		},
+		{
+			name:       "image pull",
+			status:     corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{waitingStatus("helper", "ImagePullBackOff")}},
+			wantText:   "ImagePullBackOff: msg",
+			wantFailed: true,
+		},
+		{
+			name:       "ephemeral image",
+			status:     corev1.PodStatus{EphemeralContainerStatuses: []corev1.ContainerStatus{waitingStatus("helper", "InvalidImageName")}},
+			wantText:   "InvalidImageName: msg",
+			wantFailed: true,
+		},
+		{
+			name:   "other container",
+			status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{waitingStatus("app", "ErrImagePull")}},
+		},
+		{
+			name: "terminated",
+			status: corev1.PodStatus{EphemeralContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "helper",
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "Error"}},
+			}}},
+			wantText:   "terminated: Error",
+			wantFailed: true,
+		},
+		{
+			name: "unschedulable",
+			status: corev1.PodStatus{Conditions: []corev1.PodCondition{{
+				Type: corev1.PodScheduled, Status: corev1.ConditionFalse, Reason: "Unschedulable", Message: "0/3 nodes",
+			}}},
+			wantText: "Unschedulable: 0/3 nodes",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problem, failed := helperPodProblem(&corev1.Pod{Status: tt.status}, "helper")
+			require.Equal(t, tt.wantText, problem)
+			require.Equal(t, tt.wantFailed, failed)
+		})
+	}
+}
+
+func TestWaitHelperContainer(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "app-0"},
+		Status: corev1.PodStatus{
+			Phase:                      corev1.PodRunning,
+			EphemeralContainerStatuses: []corev1.ContainerStatus{waitingStatus("helper", "ErrImagePull")},
+		},
+	}
+	client := fake.NewClientset(pod)
+	_, err := waitHelperContainer(context.Background(), client, "ns", "app-0", "helper", time.Minute)
+	require.ErrorContains(t, err, "container helper in pod app-0 will not start: ErrImagePull")
+
+	pod.Status.EphemeralContainerStatuses = []corev1.ContainerStatus{waitingStatus("helper", "ContainerCreating")}
+	client = fake.NewClientset(pod)
+	_, err = waitHelperContainer(context.Background(), client, "ns", "app-0", "helper", 10*time.Millisecond)
+	require.ErrorContains(t, err, "is not running after 10ms: ContainerCreating")
+
+	pod.Status.EphemeralContainerStatuses = []corev1.ContainerStatus{{
+		Name:  "helper",
+		State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+	}}
+	client = fake.NewClientset(pod)
+	_, err = waitHelperContainer(context.Background(), client, "ns", "app-0", "helper", time.Minute)
+	require.NoError(t, err)
+}
@@ -0,0 +1,349 @@
+package pipe
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/clients"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// serve runs a local SSH server, authenticated by local keys, that proxies
+// the sftp subsystem and exec requests into the helper. The helper is reached
+// through the configured transport, so the node does not have to be reachable by
+// the third-party tools, only by us.
+
+const serveInstallRsyncCmd = `command -v rsync >/dev/null || apk add --no-cache rsync`
+
+// RunServe starts a helper for the PVC, and serves it on a local SSH/SFTP endpoint until
+// the context is cancelled, or nobody is connected for the idle timeout.
+func RunServe(ctx context.Context, runOpts *dto.RunOpts, opts *dto.ServeOpts) error {
+	authorized, identity, err := serveClientKeys(opts)
+	if err != nil {
+		return err
+	}
+	hostKey, err := serveHostKey(opts.HostKey)
+	if err != nil {
+		return err
+	}
+
+	var lc net.ListenConfig
+	ln, err := lc.Listen(ctx, "tcp", opts.Listen)
+	if err != nil {
+		return fmt.Errorf("listen on %s: %w", opts.Listen, err)
+	}
+	defer ln.Close()
+
+	jobOpts, teardown, err := startHelper(ctx, runOpts)
+	if err != nil {
+		return err
+	}
+	defer teardown()
+
+	client, err := connectSFTP(ctx, jobOpts)
+	if err != nil {
+		return err
+	}
+	defer closeSFTPClient(client)
+
+	if opts.InstallRsync {
+		var stderr bytes.Buffer
+		if err := runInHelper(ctx, jobOpts, client, []string{"sh", "-c", serveInstallRsyncCmd}, nil, io.Discard, &stderr); err != nil {
+			slog.Warn("cannot install rsync in the helper", slog.Any("err", err), slog.String("stderr", stderr.String()))
+		}
+	}
+
+	if identity != "" {
+		if err := writeServeIdentity(identity, opts.IdentityFile); err != nil {
+			return err
+		}
+		defer os.Remove(opts.IdentityFile)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			for _, k := range authorized {
+				if bytes.Equal(k.Marshal(), key.Marshal()) {
+					return nil, nil
+				}
+			}
+			return nil, fmt.Errorf("unknown public key for %s", meta.User())
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	g := &gateway{
+		ctx:     ctx,
+		jobOpts: jobOpts,
+		client:  client,
+		config:  config,
+		touched: time.Now(),
+	}
+
+	serveCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-serveCtx.Done()
+		ln.Close()
+	}()
+	if opts.IdleTimeout > 0 {
+		go g.watchIdle(serveCtx, cancel, opts.IdleTimeout)
+	}
+
+	printServeUsage(runOpts.Out, opts, hostKey, ln.Addr())
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if serveCtx.Err() != nil {
+				break
+			}
+			return fmt.Errorf("accept: %w", err)
+		}
+		go g.handleConn(conn)
+	}
+
+	g.closeConns()
+	if ctx.Err() == nil {
+		slog.Info("no connections for the idle timeout, stopping", slog.Duration("idle-timeout", opts.IdleTimeout))
+	}
+	return nil
+}
+
+// serveClientKeys returns the keys allowed to connect. Without --authorized-keys, a key pair is
+// generated, and its private key is returned to be written into the identity file.
+func serveClientKeys(opts *dto.ServeOpts) ([]ssh.PublicKey, string, error) {
+	if opts.AuthorizedKeys != "" {
+		data, err := os.ReadFile(opts.AuthorizedKeys)
+		if err != nil {
+			return nil, "", fmt.Errorf("read authorized keys: %w", err)
+		}
+		var keys []ssh.PublicKey
+		for len(bytes.TrimSpace(data)) > 0 {
+			key, _, _, rest, err := ssh.ParseAuthorizedKey(data)
+			if err != nil {
+				return nil, "", fmt.Errorf("parse authorized keys %s: %w", opts.AuthorizedKeys, err)
+			}
+			keys = append(keys, key)
+			data = rest
+		}
+		if len(keys) == 0 {
+			return nil, "", fmt.Errorf("no keys in %s", opts.AuthorizedKeys)
+		}
+		return keys, "", nil
+	}
+
+	keyPair, err := clients.GenerateEd25519Keys()
+	if err != nil {
+		return nil, "", err
+	}
+	pub, err := ssh.NewPublicKey(keyPair.PublicKey)
+	if err != nil {
+		return nil, "", err
+	}
+	private, err := keyPair.PrivateKeyToOpenSSH()
+	if err != nil {
+		return nil, "", err
+	}
+	return []ssh.PublicKey{pub}, string(private), nil
+}
+
+func writeServeIdentity(private, p string) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(p, []byte(private), 0o600); err != nil {
+		return fmt.Errorf("write identity file: %w", err)
+	}
+	return nil
+}
+
+// serveHostKey loads the host key from p, or generates one (and stores it in p, when set),
+// so clients can keep the host key between runs.
+func serveHostKey(p string) (ssh.Signer, error) {
+	if p != "" {
+		data, err := os.ReadFile(p)
+		if err == nil {
+			return ssh.ParsePrivateKey(data)
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("read host key: %w", err)
+		}
+	}
+
+	keyPair, err := clients.GenerateEd25519Keys()
+	if err != nil {
+		return nil, err
+	}
+	if p != "" {
+		private, err := keyPair.PrivateKeyToOpenSSH()
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(p, private, 0o600); err != nil {
+			return nil, fmt.Errorf("write host key: %w", err)
+		}
+	}
+	return ssh.NewSignerFromKey(keyPair.PrivateKey)
+}
+
+func printServeUsage(w io.Writer, opts *dto.ServeOpts, hostKey ssh.Signer, addr net.Addr) {
+	host, port, _ := net.SplitHostPort(addr.String())
+	identity := ""
+	if opts.AuthorizedKeys == "" {
+		identity = fmt.Sprintf("-i %s ", opts.IdentityFile)
+	}
+
+	slog.Info("serving PVC over SFTP",
+		slog.String("listen", addr.String()),
+		slog.String("mount-path", opts.MountPath),
+		slog.String("host-key", ssh.FingerprintSHA256(hostKey.PublicKey())),
+	)
+	if opts.AuthorizedKeys == "" {
+		slog.Info("generated client key", slog.String("identity-file", opts.IdentityFile))
+	}
+	fmt.Fprintf(w, "\n  sftp %s-P %s -o UserKnownHostsFile=/dev/null -o StrictHostKeyChecking=no root@%s\n\n", identity, port, host)
+}
+
+type gateway struct {
+	ctx     context.Context
+	jobOpts *dto.JobOpts
+	client  *clients.SFTPClient
+	config  *ssh.ServerConfig
+
+	mu      sync.Mutex
+	conns   map[*ssh.ServerConn]struct{}
+	touched time.Time // last time a connection was opened or closed
+}
+
+func (g *gateway) handleConn(conn net.Conn) {
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, g.config)
+	if err != nil {
+		slog.Warn("ssh handshake failed", slog.String("remote", conn.RemoteAddr().String()), slog.Any("err", err))
+		conn.Close()
+		return
+	}
+	g.track(sconn, true)
+	defer g.track(sconn, false)
+
+	slog.Info("client connected", slog.String("remote", conn.RemoteAddr().String()), slog.String("user", sconn.User()))
+	go ssh.DiscardRequests(reqs)
+
+	for newCh := range chans {
+		if newCh.ChannelType() != "session" {
+			_ = newCh.Reject(ssh.UnknownChannelType, "only session channels are supported")
+			continue
+		}
+		ch, chReqs, err := newCh.Accept()
+		if err != nil {
+			slog.Warn("cannot accept channel", slog.Any("err", err))
+			continue
+		}
+		go g.handleSession(ch, chReqs)
+	}
+	slog.Info("client disconnected", slog.String("remote", conn.RemoteAddr().String()))
+}
+
+// handleSession serves the first subsystem/exec request of a session, there is no interactive shell.
+func (g *gateway) handleSession(ch ssh.Channel, reqs <-chan *ssh.Request) {
+	defer ch.Close()
+
+	for req := range reqs {
+		var cmd []string
+		switch req.Type {
+		case "subsystem":
+			var payload struct{ Name string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil || payload.Name != "sftp" {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			cmd = []string{sftpServerPath, "-d", g.jobOpts.MountPath}
+		case "exec":
+			var payload struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			cmd = []string{"sh", "-c", "cd " + shellQuote(g.jobOpts.MountPath) + " && " + payload.Command}
+		default:
+			// env, pty-req, shell, ...
+			_ = req.Reply(false, nil)
+			continue
+		}
+		_ = req.Reply(true, nil)
+
+		go ssh.DiscardRequests(reqs)
+		err := runInHelper(g.ctx, g.jobOpts, g.client, cmd, ch, ch, ch.Stderr())
+		_ = ch.CloseWrite()
+		_, _ = ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{serveExitStatus(err)}))
+		if err != nil && serveExitStatus(err) == 255 {
+			slog.Warn("proxied command failed", slog.String("type", req.Type), slog.Any("err", err))
+		}
+		return
+	}
+}
+
+// serveExitStatus maps the result of a proxied command to its exit status, 255 when it did not run at all.
+func serveExitStatus(err error) uint32 {
+	if err == nil {
+		return 0
+	}
+	var exitErr interface{ ExitStatus() int }
+	if errors.As(err, &exitErr) {
+		return uint32(exitErr.ExitStatus()) //nolint:gosec // exit codes are 0-255
+	}
+	return 255
+}
+
+func (g *gateway) track(conn *ssh.ServerConn, open bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.conns == nil {
+		g.conns = make(map[*ssh.ServerConn]struct{})
+	}
+	if open {
+		g.conns[conn] = struct{}{}
+	} else {
+		delete(g.conns, conn)
+	}
+	g.touched = time.Now()
+}
+
+func (g *gateway) closeConns() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for conn := range g.conns {
+		conn.Close()
+	}
+}
+
+// watchIdle stops the gateway when no connection was open for the whole timeout.
+func (g *gateway) watchIdle(ctx context.Context, stop context.CancelFunc, timeout time.Duration) {
+	ticker := time.NewTicker(max(min(timeout/4, time.Minute), time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			g.mu.Lock()
+			idle := len(g.conns) == 0 && time.Since(g.touched) >= timeout
+			g.mu.Unlock()
+			if idle {
+				stop()
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package pipe
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// newTestGateway serves the mount path of a test helper, and returns a connected client.
+func newTestGateway(t *testing.T, mountPath string) *ssh.Client {
+	t.Helper()
+	authorized, identity, err := serveClientKeys(&dto.ServeOpts{})
+	require.NoError(t, err)
+	hostKey, err := serveHostKey("")
+	require.NoError(t, err)
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(authorized[0].Marshal(), key.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown public key")
+		},
+	}
+	config.AddHostKey(hostKey)
+	g := &gateway{
+		ctx:     context.Background(),
+		jobOpts: &dto.JobOpts{MountPath: mountPath},
+		client:  newTestHelper(t, ""),
+		config:  config,
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go g.handleConn(conn)
+		}
+	}()
+
+	signer, err := ssh.ParsePrivateKey([]byte(identity))
+	require.NoError(t, err)
+	client, err := ssh.Dial("tcp", ln.Addr().String(), &ssh.ClientConfig{
+		User:            "root",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.FixedHostKey(hostKey.PublicKey()),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = client.Close() })
+
+	// a key that is not authorized is refused
+	_, other, err := serveClientKeys(&dto.ServeOpts{})
+	require.NoError(t, err)
+	otherSigner, err := ssh.ParsePrivateKey([]byte(other))
+	require.NoError(t, err)
+	_, err = ssh.Dial("tcp", ln.Addr().String(), &ssh.ClientConfig{
+		User:            "root",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(otherSigner)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(), //nolint:gosec // test
+	})
+	require.ErrorContains(t, err, "unable to authenticate")
+	return client
+}
+
+func TestGatewayExec(t *testing.T) {
+	mountPath := t.TempDir()
+	client := newTestGateway(t, mountPath)
+
+	run := func(cmd string) (string, error) {
+		session, err := client.NewSession()
+		require.NoError(t, err)
+		defer session.Close()
+		out, err := session.CombinedOutput(cmd)
+		return string(out), err
+	}
+
+	// commands run in the mount path
+	out, err := run("pwd && printf hello > greeting")
+	require.NoError(t, err)
+	require.Equal(t, mountPath+"\n", out)
+	data, err := os.ReadFile(filepath.Join(mountPath, "greeting"))
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data))
+
+	// the exit status of the command is passed through
+	out, err = run("echo failing >&2; exit 3")
+	var exitErr *ssh.ExitError
+	require.ErrorAs(t, err, &exitErr)
+	require.Equal(t, 3, exitErr.ExitStatus())
+	require.Equal(t, "failing\n", out)
+}
+
+func TestGatewaySFTP(t *testing.T) {
+	mountPath := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(mountPath, "a.txt"), []byte("a"), 0o600))
+	client := newTestGateway(t, mountPath)
+
+	sc, err := sftp.NewClient(client)
+	require.NoError(t, err)
+	defer sc.Close()
+	wd, err := sc.Getwd()
+	require.NoError(t, err)
+	require.Equal(t, mountPath, wd)
+	entries, err := sc.ReadDir(".")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "a.txt", entries[0].Name())
+}
+
+func TestGatewayRejects(t *testing.T) {
+	client := newTestGateway(t, t.TempDir())
+
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	require.Error(t, session.RequestSubsystem("scp"), "only the sftp subsystem")
+	require.Error(t, session.RequestPty("xterm", 24, 80, ssh.TerminalModes{}))
+	require.Error(t, session.Shell(), "there is no interactive shell")
+	session.Close()
+
+	_, _, err = client.OpenChannel("direct-tcpip", nil)
+	var openErr *ssh.OpenChannelError
+	require.ErrorAs(t, err, &openErr)
+	require.Equal(t, ssh.UnknownChannelType, openErr.Reason)
+}
+
+type exitStatusError int
+
+func (e exitStatusError) Error() string   { return fmt.Sprintf("exit status %d", int(e)) }
+func (e exitStatusError) ExitStatus() int { return int(e) }
+
+func TestServeExitStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want uint32
+	}{
+		{err: nil, want: 0},
+		{err: exitStatusError(1), want: 1},
+		{err: fmt.Errorf("run: %w", exitStatusError(127)), want: 127},
+		{err: errors.New("connection lost"), want: 255},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, serveExitStatus(tt.err), "%v", tt.err)
+	}
+}
@@ -0,0 +1,708 @@
+package pipe
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/term"
+)
+
+const shellHelp = `commands:
+  ls [-l] [path...]         list remote directories
+  cd [dir]                  change the remote directory (default: mount path)
+  pwd                       print the remote directory
+  get <remote> [local]      download a file or directory
+  put [-f] <local> [remote] upload a file or directory, -f overwrites existing files
+  rm [-r] <path...>         remove files, -r removes directories recursively
+  mkdir <dir...>            create directories (with parents)
+  mv <from> <to>            rename a remote path
+  du [path...]              total size and file count
+  help                      this help
+  exit                      leave the shell (or Ctrl-D)
+`
+
+var shellCommands = []string{"cd", "du", "exit", "get", "help", "ls", "mkdir", "mv", "put", "pwd", "quit", "rm"}
+
+// RunShell starts a helper for the PVC, and runs an interactive SFTP shell on top of it.
+// The helper is removed when the shell exits.
+func RunShell(ctx context.Context, opts *dto.RunOpts) error {
+	jobOpts, teardown, err := startHelper(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer teardown()
+
+	client, err := connectSFTP(ctx, jobOpts)
+	if err != nil {
+		return err
+	}
+	defer closeSFTPClient(client)
+
+	// paths are confined to the mount path with its symlinks resolved
+	root, err := realRemotePath(client.SFTPClient(), jobOpts.MountPath)
+	if err != nil {
+		return err
+	}
+	sh := &shell{
+		ctx:     ctx,
+		client:  client.SFTPClient(),
+		root:    root,
+		cwd:     root,
+		workers: jobOpts.Workers,
+		out:     opts.Out,
+	}
+	return sh.loop(opts.In)
+}
+
+type shell struct {
+	ctx     context.Context
+	client  *sftp.Client
+	root    string
+	cwd     string
+	workers int
+	out     io.Writer
+}
+
+// loop reads commands until exit or EOF, with line editing and completion on a terminal.
+func (sh *shell) loop(in io.Reader) error {
+	if f, ok := in.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		return sh.loopTerminal(f)
+	}
+
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		if done := sh.exec(scanner.Text()); done {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+func (sh *shell) loopTerminal(f *os.File) error {
+	fd := int(f.Fd())
+	t := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{f, sh.out}, sh.prompt())
+	t.AutoCompleteCallback = func(line string, pos int, key rune) (string, int, bool) {
+		if key != '\t' {
+			return "", 0, false
+		}
+		return sh.complete(t, line, pos)
+	}
+
+	fmt.Fprintf(sh.out, "connected to %s, type help for commands\n", sh.root)
+	for {
+		if sh.ctx.Err() != nil {
+			return sh.ctx.Err()
+		}
+
+		// raw mode only while editing the line, so command output and logs are printed as usual
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return err
+		}
+		t.SetPrompt(sh.prompt())
+		line, err := t.ReadLine()
+		if restoreErr := term.Restore(fd, state); restoreErr != nil {
+			return restoreErr
+		}
+		if errors.Is(err, io.EOF) {
+			fmt.Fprintln(sh.out)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if done := sh.exec(line); done {
+			return nil
+		}
+	}
+}
+
+func (sh *shell) prompt() string {
+	return "syncpod:" + sh.cwd + "> "
+}
+
+// exec runs a single command line, and reports whether the shell should exit.
+func (sh *shell) exec(line string) bool {
+	args, err := splitShellArgs(line)
+	if err != nil {
+		fmt.Fprintf(sh.out, "error: %v\n", err)
+		return false
+	}
+	if len(args) == 0 {
+		return false
+	}
+
+	switch args[0] {
+	case "exit", "quit":
+		return true
+	case "help":
+		fmt.Fprint(sh.out, shellHelp)
+	case "pwd":
+		fmt.Fprintln(sh.out, sh.cwd)
+	default:
+		if err := sh.run(args[0], args[1:]); err != nil {
+			fmt.Fprintf(sh.out, "error: %v\n", err)
+		}
+	}
+	return false
+}
+
+func (sh *shell) run(name string, args []string) error {
+	switch name {
+	case "cd":
+		return sh.cd(args)
+	case "ls":
+		return sh.ls(args)
+	case "get":
+		return sh.get(args)
+	case "put":
+		return sh.put(args)
+	case "rm":
+		return sh.rm(args)
+	case "mkdir":
+		return sh.mkdir(args)
+	case "mv":
+		return sh.mv(args)
+	case "du":
+		return sh.du(args)
+	default:
+		return fmt.Errorf("unknown command: %s (type help for commands)", name)
+	}
+}
+
+// resolve makes a remote path absolute, relative paths start at the current directory, and
+// resolves its symlinks. Paths outside of the mount path are refused, also when a symlink inside the
+// PVC points out of it, so the shell cannot reach (or remove) more than the PVC.
+func (sh *shell) resolve(p string) (string, error) {
+	return sh.resolvePath(p, true)
+}
+
+// resolveEntry is resolve for commands acting on a directory entry itself (rm, mv):
+// a symlink in the last component is kept, not followed.
+func (sh *shell) resolveEntry(p string) (string, error) {
+	return sh.resolvePath(p, false)
+}
+
+func (sh *shell) resolvePath(p string, followLast bool) (string, error) {
+	return confinePath(sh.client, sh.root, sh.cwd, p, followLast)
+}
+
+// confinePath resolves p (relative to cwd) and its symlinks, and refuses it when it is outside of root.
+func confinePath(client *sftp.Client, root, cwd, p string, followLast bool) (string, error) {
+	resolved := path.Join(cwd, p)
+	if path.IsAbs(p) {
+		resolved = path.Clean(p)
+	}
+	if !withinRoot(root, resolved) {
+		return "", fmt.Errorf("outside of the mount path %s: %s", root, p)
+	}
+
+	var real string
+	var err error
+	if followLast || resolved == root {
+		real, err = realRemotePath(client, resolved)
+	} else {
+		real, err = realRemotePath(client, path.Dir(resolved))
+		real = path.Join(real, path.Base(resolved))
+	}
+	if err != nil {
+		return "", err
+	}
+	if !withinRoot(root, real) {
+		return "", fmt.Errorf("outside of the mount path %s: %s (resolves to %s)", root, p, real)
+	}
+	return real, nil
+}
+
+// realRemotePath resolves the symlinks of an absolute remote path, like realpath(3), components
+// that do not exist are kept as they are. The path is walked with Lstat and ReadLink, the realpath
+// request of SFTP servers does not resolve symlinks everywhere (the one of pkg/sftp only cleans).
+func realRemotePath(client *sftp.Client, p string) (string, error) {
+	const maxLinks = 40
+
+	links := 0
+	resolved := "/"
+	rest := strings.Split(path.Clean(p), "/")
+	for len(rest) > 0 {
+		name := rest[0]
+		rest = rest[1:]
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			resolved = path.Dir(resolved)
+			continue
+		}
+
+		next := path.Join(resolved, name)
+		fi, err := client.Lstat(next)
+		if errors.Is(err, os.ErrNotExist) {
+			resolved = next
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		links++
+		if links > maxLinks {
+			return "", fmt.Errorf("too many levels of symbolic links: %s", p)
+		}
+		target, err := client.ReadLink(next)
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(target) {
+			resolved = "/"
+		}
+		rest = append(strings.Split(target, "/"), rest...)
+	}
+	return resolved, nil
+}
+
+func (sh *shell) within(p string) bool {
+	return withinRoot(sh.root, p)
+}
+
+func withinRoot(root, p string) bool {
+	return root == "/" || p == root || strings.HasPrefix(p, root+"/")
+}
+
+func (sh *shell) cd(args []string) error {
+	target := sh.root
+	if len(args) > 0 {
+		var err error
+		if target, err = sh.resolve(args[0]); err != nil {
+			return err
+		}
+	}
+	fi, err := sh.client.Stat(target)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("not a directory: %s", target)
+	}
+	sh.cwd = target
+	return nil
+}
+
+func (sh *shell) ls(args []string) error {
+	long := false
+	if len(args) > 0 && args[0] == "-l" {
+		long = true
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+
+	for i, arg := range args {
+		target, err := sh.resolve(arg)
+		if err != nil {
+			return err
+		}
+		fi, err := sh.client.Lstat(target)
+		if err != nil {
+			return err
+		}
+		entries := []os.FileInfo{fi}
+		if fi.IsDir() {
+			if entries, err = sh.client.ReadDir(target); err != nil {
+				return err
+			}
+			if len(args) > 1 {
+				if i > 0 {
+					fmt.Fprintln(sh.out)
+				}
+				fmt.Fprintf(sh.out, "%s:\n", target)
+			}
+		}
+		sort.Slice(entries, func(a, b int) bool { return entries[a].Name() < entries[b].Name() })
+
+		for _, e := range entries {
+			name := e.Name()
+			if e.IsDir() {
+				name += "/"
+			}
+			if long {
+				fmt.Fprintf(sh.out, "%s %12d %s %s\n", e.Mode(), e.Size(), e.ModTime().Format("2006-01-02 15:04"), name)
+			} else {
+				fmt.Fprintln(sh.out, name)
+			}
+		}
+	}
+	return nil
+}
+
+func (sh *shell) get(args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return fmt.Errorf("usage: get <remote> [local]")
+	}
+	remote, err := sh.resolve(args[0])
+	if err != nil {
+		return err
+	}
+	local := path.Base(remote)
+	if len(args) == 2 {
+		local = args[1]
+	}
+
+	fi, err := sh.client.Stat(remote)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		err = downloadFiles(sh.ctx, sh.client, nil, nil, nil, nil, remote, local, sh.workers)
+	} else {
+		if st, statErr := os.Stat(local); statErr == nil && st.IsDir() {
+			local = filepath.Join(local, path.Base(remote))
+		}
+		err = downloadFile(sh.ctx, sh.client, nil, nil, nil, dto.WorkerJob{RemotePath: remote, LocalPath: local, Size: fi.Size()})
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(sh.out, "%s -> %s\n", remote, local)
+	return nil
+}
+
+func (sh *shell) put(args []string) error {
+	force := false
+	if len(args) > 0 && args[0] == "-f" {
+		force = true
+		args = args[1:]
+	}
+	if len(args) == 0 || len(args) > 2 {
+		return fmt.Errorf("usage: put [-f] <local> [remote]")
+	}
+	local := filepath.Clean(args[0])
+	target := filepath.Base(local)
+	if len(args) == 2 {
+		target = args[1]
+	}
+	remote, err := sh.resolve(target)
+	if err != nil {
+		return err
+	}
+
+	fi, err := os.Stat(local)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		err = uploadFiles(sh.ctx, sh.client, nil, nil, nil, local, remote, sh.workers, force)
+	} else {
+		if st, statErr := sh.client.Stat(remote); statErr == nil && st.IsDir() {
+			remote = path.Join(remote, filepath.Base(local))
+		}
+		exists, existsErr := remoteFileExists(sh.client, remote, false)
+		if existsErr != nil {
+			return existsErr
+		}
+		if exists && !force {
+			return fmt.Errorf("file already exists: %s (use put -f to overwrite)", remote)
+		}
+		err = uploadFile(sh.ctx, sh.client, nil, nil, dto.WorkerJob{LocalPath: local, RemotePath: remote, Size: fi.Size()})
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(sh.out, "%s -> %s\n", local, remote)
+	return nil
+}
+
+func (sh *shell) rm(args []string) error {
+	recursive := false
+	if len(args) > 0 && args[0] == "-r" {
+		recursive = true
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("usage: rm [-r] <path...>")
+	}
+
+	for _, arg := range args {
+		target, err := sh.resolveEntry(arg)
+		if err != nil {
+			return err
+		}
+		if target == sh.root {
+			return fmt.Errorf("refusing to remove the mount path: %s", target)
+		}
+		fi, err := sh.client.Lstat(target)
+		if err != nil {
+			return err
+		}
+		switch {
+		case !fi.IsDir():
+			err = sh.client.Remove(target)
+		case recursive:
+			err = sh.client.RemoveAll(target)
+		default:
+			err = fmt.Errorf("is a directory: %s (use rm -r)", target)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sh *shell) mkdir(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: mkdir <dir...>")
+	}
+	for _, arg := range args {
+		dir, err := sh.resolve(arg)
+		if err != nil {
+			return err
+		}
+		if err := sh.client.MkdirAll(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sh *shell) mv(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: mv <from> <to>")
+	}
+	from, err := sh.resolveEntry(args[0])
+	if err != nil {
+		return err
+	}
+	to, err := sh.resolve(args[1])
+	if err != nil {
+		return err
+	}
+	if st, err := sh.client.Stat(to); err == nil && st.IsDir() {
+		to = path.Join(to, path.Base(from))
+	}
+	return sh.client.Rename(from, to)
+}
+
+func (sh *shell) du(args []string) error {
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+	for _, arg := range args {
+		target, err := sh.resolve(arg)
+		if err != nil {
+			return err
+		}
+		usages, err := diskUsage(sh.ctx, sh.client, target, &dto.DuOpts{Workers: sh.workers})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(sh.out, "%s\t%d files\t%s\n", formatSize(usages[0].Bytes), usages[0].Files, target)
+	}
+	return nil
+}
+
+// complete is the tab completion: command names first, then remote paths
+// (local ones for the first argument of put).
+func (sh *shell) complete(t *term.Terminal, line string, pos int) (string, int, bool) {
+	head := line[:pos]
+	start, word := lastShellWord(head)
+
+	var candidates []string
+	fields := strings.Fields(head[:start])
+	switch {
+	case len(fields) == 0:
+		for _, c := range shellCommands {
+			if strings.HasPrefix(c, word) {
+				candidates = append(candidates, c+" ")
+			}
+		}
+	case fields[0] == "put" && len(shellArgsAfterFlags(fields[1:])) == 0:
+		candidates = completeLocalPath(word)
+	default:
+		candidates = sh.completeRemotePath(word)
+	}
+
+	switch len(candidates) {
+	case 0:
+		return "", 0, false
+	case 1:
+		completed := head[:start] + escapeShellArg(candidates[0])
+		return completed + line[pos:], len(completed), true
+	}
+
+	prefix := commonPrefix(candidates)
+	if len(prefix) > len(word) {
+		completed := head[:start] + escapeShellArg(prefix)
+		return completed + line[pos:], len(completed), true
+	}
+	// nothing more to add, show what matches
+	names := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		names = append(names, strings.TrimSpace(path.Base(strings.TrimSuffix(c, "/"))+suffixOf(c)))
+	}
+	fmt.Fprintf(t, "%s\n", strings.Join(names, "  "))
+	return "", 0, false
+}
+
+func (sh *shell) completeRemotePath(word string) []string {
+	dir, base := path.Split(word)
+	target, err := sh.resolve(dir + ".")
+	if err != nil {
+		return nil
+	}
+	entries, err := sh.client.ReadDir(target)
+	if err != nil {
+		return nil
+	}
+	var candidates []string
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), base) {
+			continue
+		}
+		if e.IsDir() {
+			candidates = append(candidates, dir+e.Name()+"/")
+		} else {
+			candidates = append(candidates, dir+e.Name()+" ")
+		}
+	}
+	sort.Strings(candidates)
+	return candidates
+}
+
+func completeLocalPath(word string) []string {
+	dir, base := filepath.Split(word)
+	entries, err := os.ReadDir(filepath.Join(dir, "."))
+	if err != nil {
+		return nil
+	}
+	var candidates []string
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), base) {
+			continue
+		}
+		if e.IsDir() {
+			candidates = append(candidates, dir+e.Name()+string(filepath.Separator))
+		} else {
+			candidates = append(candidates, dir+e.Name()+" ")
+		}
+	}
+	sort.Strings(candidates)
+	return candidates
+}
+
+// lastShellWord returns where the word under the cursor starts, and the word without escapes.
+func lastShellWord(head string) (int, string) {
+	start := 0
+	escaped := false
+	for i, r := range head {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == ' ' || r == '\t':
+			start = i + 1
+		}
+	}
+	word := strings.NewReplacer(`\ `, " ", `\\`, `\`).Replace(head[start:])
+	return start, word
+}
+
+// escapeShellArg escapes a completed path, the trailing space ends the argument.
+func escapeShellArg(s string) string {
+	trailing := strings.HasSuffix(s, " ")
+	s = strings.TrimSuffix(s, " ")
+	s = strings.NewReplacer(`\`, `\\`, " ", `\ `).Replace(s)
+	if trailing {
+		s += " "
+	}
+	return s
+}
+
+func shellArgsAfterFlags(args []string) []string {
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		args = args[1:]
+	}
+	return args
+}
+
+func suffixOf(candidate string) string {
+	if strings.HasSuffix(candidate, "/") {
+		return "/"
+	}
+	return ""
+}
+
+func commonPrefix(items []string) string {
+	prefix := items[0]
+	for _, s := range items[1:] {
+		for !strings.HasPrefix(s, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// splitShellArgs splits a command line on spaces, quotes and backslashes keep them in an argument.
+func splitShellArgs(line string) ([]string, error) {
+	var args []string
+	var cur strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+
+	for _, r := range line {
+		switch {
+		case escaped:
+			cur.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape")
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
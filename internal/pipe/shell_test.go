@@ -0,0 +1,139 @@
+package pipe
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitShellArgs(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    []string
+		wantErr bool
+	}{
+		{line: "", want: nil},
+		{line: "   ", want: nil},
+		{line: "ls -l dir", want: []string{"ls", "-l", "dir"}},
+		{line: "  ls\t dir  ", want: []string{"ls", "dir"}},
+		{line: `get "my file" local`, want: []string{"get", "my file", "local"}},
+		{line: `get 'my file'`, want: []string{"get", "my file"}},
+		{line: `get my\ file`, want: []string{"get", "my file"}},
+		{line: `rm "a\"b"`, want: []string{"rm", `a"b`}},
+		{line: `rm 'a\b'`, want: []string{"rm", `a\b`}},
+		{line: `rm "it's"`, want: []string{"rm", "it's"}},
+		{line: `rm ""`, want: []string{"rm", ""}},
+		{line: `mv a"b c"d`, want: []string{"mv", "ab cd"}},
+		{line: `rm "unterminated`, wantErr: true},
+		{line: `rm trailing\`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, err := splitShellArgs(tt.line)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestShellWithin(t *testing.T) {
+	sh := &shell{root: "/mnt/data"}
+	require.True(t, sh.within("/mnt/data"))
+	require.True(t, sh.within("/mnt/data/a/b"))
+	require.False(t, sh.within("/mnt/database"))
+	require.False(t, sh.within("/mnt"))
+	require.False(t, sh.within("/etc/passwd"))
+
+	sh = &shell{root: "/"}
+	require.True(t, sh.within("/etc/passwd"))
+}
+
+func TestShellResolve(t *testing.T) {
+	client := newTestSFTPClient(t)
+	base := t.TempDir()
+	pvc, outside := filepath.Join(base, "pvc"), filepath.Join(base, "outside")
+	require.NoError(t, os.MkdirAll(filepath.Join(pvc, "dir", "sub"), 0o750))
+	require.NoError(t, os.MkdirAll(outside, 0o750))
+	require.NoError(t, os.Symlink(outside, filepath.Join(pvc, "abs")))
+	require.NoError(t, os.Symlink("../outside", filepath.Join(pvc, "rel")))
+	require.NoError(t, os.Symlink("dir/sub", filepath.Join(pvc, "inner")))
+	require.NoError(t, os.Symlink("../../rel", filepath.Join(pvc, "dir", "sub", "chain")))
+	require.NoError(t, os.Symlink("loop", filepath.Join(pvc, "loop")))
+
+	root, err := realRemotePath(client, filepath.ToSlash(pvc))
+	require.NoError(t, err)
+	sh := &shell{client: client, root: root, cwd: root + "/dir"}
+
+	tests := []struct {
+		name  string
+		path  string
+		entry bool // resolveEntry
+		want  string
+		err   string
+	}{
+		{name: "relative", path: "sub", want: root + "/dir/sub"},
+		{name: "parent", path: "..", want: root},
+		{name: "absolute", path: root + "/dir", want: root + "/dir"},
+		{name: "missing", path: "new/file", want: root + "/dir/new/file"},
+		{name: "lexically outside", path: "../..", err: "outside of the mount path"},
+		{name: "symlink inside", path: "../inner", want: root + "/dir/sub"},
+		{name: "below a symlink inside", path: "../inner/x", want: root + "/dir/sub/x"},
+		{name: "absolute symlink out", path: "../abs", err: "resolves to"},
+		{name: "relative symlink out", path: "../rel/file", err: "resolves to"},
+		{name: "chained symlinks out", path: "sub/chain", err: "resolves to"},
+		{name: "missing then dotdot into a symlink", path: "new/../../rel", err: "resolves to"},
+		{name: "symlink loop", path: "../loop", err: "too many levels"},
+		{name: "entry keeps the symlink", path: "../abs", entry: true, want: root + "/abs"},
+		{name: "entry below a symlink out", path: "../abs/file", entry: true, err: "resolves to"},
+		{name: "entry of the mount path", path: "..", entry: true, want: root},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolve := sh.resolve
+			if tt.entry {
+				resolve = sh.resolveEntry
+			}
+			got, err := resolve(tt.path)
+			if tt.err != "" {
+				require.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestShellRefusesSymlinkOut(t *testing.T) {
+	client := newTestSFTPClient(t)
+	base := t.TempDir()
+	pvc, outside := filepath.Join(base, "pvc"), filepath.Join(base, "outside")
+	require.NoError(t, os.MkdirAll(pvc, 0o750))
+	require.NoError(t, os.MkdirAll(outside, 0o750))
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "keep"), []byte("data"), 0o600))
+	require.NoError(t, os.Symlink(outside, filepath.Join(pvc, "link")))
+
+	root, err := realRemotePath(client, filepath.ToSlash(pvc))
+	require.NoError(t, err)
+	var out strings.Builder
+	sh := &shell{client: client, root: root, cwd: root, out: &out}
+
+	sh.exec("cd link")
+	require.Equal(t, root, sh.cwd)
+	sh.exec("rm -r link/keep")
+	require.FileExists(t, filepath.Join(outside, "keep"))
+	require.Contains(t, out.String(), "outside of the mount path")
+
+	// the link itself can be removed
+	sh.exec("rm link")
+	_, err = os.Lstat(filepath.Join(pvc, "link"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+	require.FileExists(t, filepath.Join(outside, "keep"))
+}
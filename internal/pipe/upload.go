@@ -13,6 +13,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/hashmap-kz/kubectl-syncpod/internal/bwlimit"
 	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
 
 	"k8s.io/client-go/tools/remotecommand"
@@ -24,13 +25,7 @@ import (
 )
 
 func Upload(ctx context.Context, opts *dto.JobOpts) error {
-	slog.Info("waiting while SSHD is ready")
-	if err := waitForSSHReady(opts.KeyPair, opts.Host, opts.Port, sshWaitTimeout); err != nil {
-		return err
-	}
-
-	slog.Info("init SSH client")
-	client, err := newSFTPClient(opts.KeyPair, opts.Host, opts.Port)
+	client, err := connectSFTP(ctx, opts)
 	if err != nil {
 		return err
 	}
@@ -42,6 +37,10 @@ func Upload(ctx context.Context, opts *dto.JobOpts) error {
 		}
 	}(client)
 
+	return uploadWithClient(ctx, client, opts)
+}
+
+func uploadWithClient(ctx context.Context, client *clients.SFTPClient, opts *dto.JobOpts) error {
 	localPath := filepath.Clean(opts.Local)
 	remotePath := filepath.ToSlash(filepath.Join(opts.MountPath, filepath.Clean(opts.Remote)))
 
@@ -53,15 +52,21 @@ func Upload(ctx context.Context, opts *dto.JobOpts) error {
 	// preserve original directory
 	// TODO:feat/sts-vols-discover-1 - simplify CLI
 	if !isRemoteRoot(opts.Remote) {
-		err = renameRemoteDirIfExists(client.SFTPClient(), remotePath)
-		if err != nil {
+		if err := renameRemoteDirIfExists(client.SFTPClient(), remotePath); err != nil {
 			slog.Error("failed to rename existing remote dir", slog.Any("err", err))
 			return err
 		}
 	}
 
 	// upload
-	err = uploadFiles(ctx, client.SFTPClient(), localPath, remotePath, opts.Workers, opts.AllowOverwrite)
+	var err error
+	if opts.Format != "" {
+		err = uploadArchive(ctx, client.SFTPClient(), remotePath, opts)
+	} else {
+		comp := newWireCompression(ctx, opts, client)
+		err = uploadFiles(ctx, client.SFTPClient(), comp, opts.Limiter, opts.Stats, localPath, remotePath, opts.Workers, opts.AllowOverwrite)
+		comp.report()
+	}
 	if err != nil {
 		slog.Error("error while uploading files", slog.Any("err", err))
 		return err
@@ -88,23 +93,11 @@ func Upload(ctx context.Context, opts *dto.JobOpts) error {
 }
 
 func renameRemoteDirIfExists(client *sftp.Client, remotePath string) error {
-	stat, err := client.Stat(remotePath)
-	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			// Nothing to do
-			return nil
-		}
-		return fmt.Errorf("error stat remote dir: %w", err)
-	}
-
-	if !stat.IsDir() {
-		return fmt.Errorf("remote path exists but is not a directory: %s", remotePath)
+	newName, err := remoteDirRenameTarget(client, remotePath)
+	if err != nil || newName == "" {
+		return err
 	}
 
-	// Build new name
-	ts := time.Now().Format("2006-01-02-150405")
-	newName := fmt.Sprintf("%s-original-%s", remotePath, ts)
-
 	slog.Info("renaming existing remote dir",
 		slog.String("from", remotePath),
 		slog.String("to", newName),
@@ -118,38 +111,42 @@ func renameRemoteDirIfExists(client *sftp.Client, remotePath string) error {
 	return nil
 }
 
+// remoteDirRenameTarget returns where an existing remotePath would be moved, or "" when it does not exist.
+func remoteDirRenameTarget(client *sftp.Client, remotePath string) (string, error) {
+	stat, err := client.Stat(remotePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// Nothing to do
+			return "", nil
+		}
+		return "", fmt.Errorf("error stat remote dir: %w", err)
+	}
+
+	if !stat.IsDir() {
+		return "", fmt.Errorf("remote path exists but is not a directory: %s", remotePath)
+	}
+
+	// Build new name
+	ts := time.Now().Format("2006-01-02-150405")
+	return fmt.Sprintf("%s-original-%s", remotePath, ts), nil
+}
+
 func runChownInPod(ctx context.Context, opts *dto.JobOpts, targetPath string) error {
 	cmd := []string{"chown", "-R", opts.Owner, targetPath}
 
 	slog.Info("exec chown",
-		slog.String("pod", opts.ObjName),
+		slog.String("pod", opts.PodName),
 		slog.String("cmd", fmt.Sprintf("%v", cmd)),
 	)
 
-	req := opts.Client.CoreV1().RESTClient().
-		Post().
-		Resource("pods").
-		Name(opts.ObjName).
-		Namespace(opts.Namespace).
-		SubResource("exec").
-		Param("container", opts.ObjName).
-		Param("stdout", "true").
-		Param("stderr", "true").
-		Param("stdin", "false").
-		Param("tty", "false")
-
-	for _, c := range cmd {
-		req.Param("command", c)
-	}
-
-	execSPDY, err := remotecommand.NewSPDYExecutor(opts.RestConfig, "POST", req.URL())
+	executor, err := newPodExecutor(opts.RestConfig, podExecURL(opts, cmd, false))
 	if err != nil {
-		return fmt.Errorf("error creating SPDY executor: %w", err)
+		return err
 	}
 
 	var stdoutBuf, stderrBuf bytes.Buffer
 
-	err = execSPDY.StreamWithContext(ctx, remotecommand.StreamOptions{
+	err = executor.StreamWithContext(ctx, remotecommand.StreamOptions{
 		Stdout: &stdoutBuf,
 		Stderr: &stderrBuf,
 		Tty:    false,
@@ -166,6 +163,24 @@ func runChownInPod(ctx context.Context, opts *dto.JobOpts, targetPath string) er
 }
 
 func getFilesToUpload(client *sftp.Client, localPath, remotePath string, allowOverwrite bool) ([]dto.WorkerJob, error) {
+	jobs, err := listLocalTree(localPath, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	for i := range jobs {
+		fileExists, err := remoteFileExists(client, jobs[i].RemotePath, jobs[i].IsDir)
+		if err != nil {
+			return nil, err
+		}
+		if fileExists && !allowOverwrite {
+			return nil, fmt.Errorf("overwrite is forbidden, file already exists: %s", jobs[i].RemotePath)
+		}
+	}
+	return jobs, nil
+}
+
+// listLocalTree maps everything under localPath (including itself) to its target under remotePath.
+func listLocalTree(localPath, remotePath string) ([]dto.WorkerJob, error) {
 	var jobs []dto.WorkerJob
 
 	err := filepath.WalkDir(localPath, func(path string, d os.DirEntry, walkErr error) error {
@@ -180,18 +195,27 @@ func getFilesToUpload(client *sftp.Client, localPath, remotePath string, allowOv
 		target := filepath.ToSlash(filepath.Join(remotePath, rel))
 		isDir := d.IsDir()
 
-		fileExists, err := remoteFileExists(client, target, isDir)
-		if err != nil {
-			return err
-		}
-		if fileExists && !allowOverwrite {
-			return fmt.Errorf("overwrite is forbidden, file already exists: %s", target)
+		var size int64
+		var modTime time.Time
+		mode := d.Type()
+		if !isDir {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size = info.Size()
+			modTime = info.ModTime()
+			mode = info.Mode()
 		}
 
 		jobs = append(jobs, dto.WorkerJob{
 			LocalPath:  path,
 			RemotePath: target,
+			RelPath:    rel,
 			IsDir:      isDir,
+			Size:       size,
+			Mode:       mode,
+			ModTime:    modTime,
 		})
 		return nil
 	})
@@ -214,7 +238,16 @@ func remoteFileExists(client *sftp.Client, rpath string, isDir bool) (bool, erro
 	return !stat.IsDir(), nil
 }
 
-func uploadFiles(ctx context.Context, client *sftp.Client, localPath, remotePath string, workers int, allowOverwrite bool) error {
+func uploadFiles(
+	ctx context.Context,
+	client *sftp.Client,
+	comp *wireCompression,
+	lim *bwlimit.Limiter,
+	stats *dto.TransferStats,
+	localPath, remotePath string,
+	workers int,
+	allowOverwrite bool,
+) error {
 	files, err := getFilesToUpload(client, localPath, remotePath, allowOverwrite)
 	if err != nil {
 		return err
@@ -240,11 +273,13 @@ func uploadFiles(ctx context.Context, client *sftp.Client, localPath, remotePath
 				if ctx.Err() != nil {
 					return
 				}
-				if err := uploadFile(client, jb); err != nil {
+				if err := uploadFile(ctx, client, comp, lim, jb); err != nil {
 					select {
 					case errCh <- err:
 					default:
 					}
+				} else if !jb.IsDir {
+					stats.Add(1, jb.Size)
 				}
 			}
 		}()
@@ -268,7 +303,7 @@ func uploadFiles(ctx context.Context, client *sftp.Client, localPath, remotePath
 	return lastErr
 }
 
-func uploadFile(client *sftp.Client, jb dto.WorkerJob) error {
+func uploadFile(ctx context.Context, client *sftp.Client, comp *wireCompression, lim *bwlimit.Limiter, jb dto.WorkerJob) error {
 	localPath := filepath.ToSlash(jb.LocalPath)
 	remotePath := filepath.ToSlash(jb.RemotePath)
 
@@ -291,14 +326,28 @@ func uploadFile(client *sftp.Client, jb dto.WorkerJob) error {
 		return fmt.Errorf("mkdir remote: %w", err)
 	}
 
-	dstFile, err := client.Create(remotePath)
-	if err != nil {
-		return fmt.Errorf("create remote: %w", err)
+	if comp.applies(localPath, jb.Size) {
+		if err := comp.upload(srcFile, remotePath); err != nil {
+			return err
+		}
+	} else {
+		dstFile, err := client.Create(remotePath)
+		if err != nil {
+			return fmt.Errorf("create remote: %w", err)
+		}
+		defer dstFile.Close()
+
+		if _, err := io.Copy(dstFile, lim.Reader(ctx, srcFile)); err != nil {
+			return fmt.Errorf("copy file: %w", err)
+		}
+		if err := dstFile.Close(); err != nil {
+			return fmt.Errorf("close remote: %w", err)
+		}
 	}
-	defer dstFile.Close()
 
-	if _, err := io.Copy(dstFile, srcFile); err != nil {
-		return fmt.Errorf("copy file: %w", err)
+	// keeps the mtime, so diff finds no changes in a tree right after it was uploaded
+	if err := client.Chtimes(remotePath, jb.ModTime, jb.ModTime); err != nil {
+		return fmt.Errorf("chtimes %s: %w", remotePath, err)
 	}
 	return nil
 }
@@ -3,113 +3,295 @@ package pipe
 import (
 	"context"
 	"fmt"
+	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
-	"sync"
 
 	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
 	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
+	"k8s.io/client-go/kubernetes"
 )
 
 type restoreSource struct {
 	entry    kub.StatefulSetVolume
 	localSrc string
+	archive  *backupArchiveReader // set when the volume is restored from a backup archive
 }
 
 func RunUploadSTS(ctx context.Context, ropts *dto.UploadSTSOpts) error {
-	manifestPath := filepath.Join(ropts.Src, "manifest.json")
-	if _, err := os.Stat(manifestPath); err == nil {
-		return runUploadSTSFromManifest(ctx, ropts)
+	sources, closeSources, err := openRestoreSources(ctx, ropts)
+	if err != nil {
+		return err
 	}
-	return fmt.Errorf("cannot upload as no manifest given")
+	defer closeSources()
+
+	_, client, err := initConfigAndClientForContext(ropts.KubeContext)
+	if err != nil {
+		return err
+	}
+	return auditStatefulSet(ctx, client, ropts.KubeContext, ropts.Namespace, ropts.StsName, "upload", ropts.Src, func() error {
+		return uploadSTSSources(ctx, ropts, sources)
+	})
 }
 
-func runUploadSTSFromManifest(ctx context.Context, d *dto.UploadSTSOpts) error {
-	manifestPath := filepath.Join(d.Src, "manifest.json")
+// openRestoreSources reads the manifest of a backup directory or archive, and checks it against the request.
+func openRestoreSources(ctx context.Context, d *dto.UploadSTSOpts) (sources []restoreSource, closeSources func(), err error) {
+	var manifest *kub.StatefulSetBackupManifest
+	closeSources = func() {}
 
-	manifest, err := kub.ReadStatefulSetBackupManifest(manifestPath)
-	if err != nil {
-		return fmt.Errorf("read manifest: %w", err)
+	if info, statErr := os.Stat(d.Src); statErr == nil && !info.IsDir() {
+		// volumes are restored straight from the frames of a backup archive
+		archive, err := openBackupArchive(d.Src)
+		if err != nil {
+			return nil, nil, err
+		}
+		closeSources = func() { archive.Close() }
+		defer func() {
+			if err != nil {
+				closeSources()
+			}
+		}()
+
+		manifest, err = archive.readManifest()
+		if err != nil {
+			return nil, nil, err
+		}
+		sources, err = validateArchiveSources(archive, manifest, d.SkipMissing)
+		if err != nil {
+			return nil, nil, fmt.Errorf("validate archive sources: %w", err)
+		}
+	} else {
+		manifestPath := filepath.Join(d.Src, "manifest.json")
+		if _, err := os.Stat(manifestPath); err != nil {
+			return nil, nil, fmt.Errorf("cannot upload as no manifest given")
+		}
+
+		manifest, err = kub.ReadStatefulSetBackupManifest(manifestPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("read manifest: %w", err)
+		}
+		sources, err = validateManifestSources(d.Src, manifest, d.SkipMissing)
+		if err != nil {
+			return nil, nil, fmt.Errorf("validate manifest sources: %w", err)
+		}
 	}
 
-	if manifest.StatefulSet != d.StsName {
-		return fmt.Errorf(
+	if manifest.Selection != nil {
+		slog.Info("partial backup, only its volumes are restored", slog.Int("volumes", len(manifest.Entries)))
+	}
+
+	sourceSts := d.StsName
+	if d.FromSts != "" {
+		sourceSts = d.FromSts
+	}
+	if manifest.StatefulSet != sourceSts {
+		err = fmt.Errorf(
 			"manifest statefulset mismatch: manifest=%q requested=%q",
-			manifest.StatefulSet, d.StsName,
+			manifest.StatefulSet, sourceSts,
 		)
+		return nil, nil, err
 	}
 
-	sources, err := validateManifestSources(d.Src, manifest, d.SkipMissing)
-	if err != nil {
-		return fmt.Errorf("validate manifest sources: %w", err)
-	}
-
-	type result struct {
-		src restoreSource
-		err error
-	}
-
-	jobs := make(chan restoreSource)
-	results := make(chan result, len(sources))
-
-	var wg sync.WaitGroup
-	for i := 0; i < d.VolumeWorkers; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-
-			for src := range jobs {
-				err := Run(ctx, &dto.RunOpts{
-					Mode:           "upload",
-					PVC:            src.entry.PVCName,
-					Namespace:      d.Namespace,
-					Local:          src.localSrc,
-					Remote:         ".",
-					MountPath:      src.entry.MountPath,
-					Workers:        d.FileWorkers,
-					AllowOverwrite: d.AllowOverwrite,
-					Owner:          d.Owner,
-					ObjName:        kub.NewObjName(),
-				})
-
-				results <- result{
-					src: src,
-					err: err,
-				}
-			}
-		}()
+	if remapsRestore(d) {
+		sources, err = selectRestoreOrdinals(d, sources)
+		if err != nil {
+			return nil, nil, err
+		}
+		_, client, initErr := initConfigAndClientForContext(d.KubeContext)
+		if initErr != nil {
+			return nil, nil, initErr
+		}
+		sources, err = mapRestoreSources(ctx, client, d, manifest, sources)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return sources, closeSources, nil
+}
+
+// remapsRestore tells whether the sources are restored into other PVCs than the ones they were taken from.
+func remapsRestore(d *dto.UploadSTSOpts) bool {
+	return d.FromSts != "" || d.TargetNamespace != "" || len(d.Ordinals) > 0 || len(d.MapOrdinals) > 0 || d.FanOut
+}
+
+// selectRestoreOrdinals applies --ordinals, --map-ordinal and --fan-out-from to the sources: the ordinal
+// of a source is the replica it is restored into. Fan-out sources are copied per replica by mapRestoreSources.
+func selectRestoreOrdinals(d *dto.UploadSTSOpts, sources []restoreSource) ([]restoreSource, error) {
+	inBackup := make(map[int]bool, len(sources))
+	for i := range sources {
+		inBackup[sources[i].entry.Ordinal] = true
+	}
+	selected := d.Ordinals
+	if d.FanOut {
+		selected = []int{d.FanOutFrom}
+	}
+	keep := make(map[int]bool, len(selected))
+	var errs []error
+	for _, o := range selected {
+		keep[o] = true
+		if !inBackup[o] {
+			errs = append(errs, fmt.Errorf("ordinal %d is not in the backup", o))
+		}
+	}
+	for _, from := range slices.Sorted(maps.Keys(d.MapOrdinals)) {
+		if !inBackup[from] || (len(keep) > 0 && !keep[from]) {
+			errs = append(errs, fmt.Errorf("--map-ordinal %d: ordinal %d is not restored", from, from))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, joinErrors(errs)
+	}
+
+	result := make([]restoreSource, 0, len(sources))
+	for i := range sources {
+		src := sources[i]
+		if len(keep) > 0 && !keep[src.entry.Ordinal] {
+			continue
+		}
+		if to, ok := d.MapOrdinals[src.entry.Ordinal]; ok {
+			src.entry.Ordinal = to
+		}
+		result = append(result, src)
 	}
+	sortRestoreSources(result)
+	return result, nil
+}
 
-	go func() {
-		//nolint:gocritic
-		for _, src := range sources {
-			jobs <- src
+// fanOutSources copies the sources into every ordinal of the target.
+func fanOutSources(sources []restoreSource, targets []kub.PodVolume) []restoreSource {
+	ordinals := make(map[int]bool, len(targets))
+	for i := range targets {
+		ordinals[targets[i].Ordinal] = true
+	}
+	result := make([]restoreSource, 0, len(sources)*len(ordinals))
+	for o := range ordinals {
+		for i := range sources {
+			src := sources[i]
+			src.entry.Ordinal = o
+			result = append(result, src)
 		}
-		close(jobs)
-		wg.Wait()
-		close(results)
-	}()
+	}
+	sortRestoreSources(result)
+	return result
+}
+
+// mapRestoreSources points the sources to the PVCs of the target StatefulSet, matched by ordinal and
+// volume name. Sources without a matching volume in the target, or two sources for one volume, fail the restore.
+func mapRestoreSources(
+	ctx context.Context,
+	client kubernetes.Interface,
+	d *dto.UploadSTSOpts,
+	manifest *kub.StatefulSetBackupManifest,
+	sources []restoreSource,
+) ([]restoreSource, error) {
+	targets, err := kub.DiscoverStatefulSetPVCs(ctx, client, d.Namespace, d.StsName)
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no PVC-backed volumes found for target StatefulSet %s/%s", d.Namespace, d.StsName)
+	}
+
+	type key struct {
+		ordinal int
+		volume  string
+	}
+	byKey := make(map[key]*kub.PodVolume, len(targets))
+	for i := range targets {
+		byKey[key{targets[i].Ordinal, targets[i].VolumeName}] = &targets[i]
+	}
+
+	if d.FanOut {
+		sources = fanOutSources(sources, targets)
+	}
 
 	var errs []error
-	for r := range results {
-		if r.err != nil {
+	mapped := make(map[key]string, len(sources))
+	for i := range sources {
+		e := &sources[i].entry
+		k := key{e.Ordinal, e.VolumeName}
+		t, ok := byKey[k]
+		if !ok {
 			errs = append(errs, fmt.Errorf(
-				"%s/%s (%s <- %s): %w",
-				r.src.entry.PodName,
-				r.src.entry.VolumeName,
-				r.src.entry.PVCName,
-				r.src.localSrc,
-				r.err,
+				"backup volume %s (ordinal %d, volume %q) has no counterpart in %s/%s",
+				e.LocalPath, e.Ordinal, e.VolumeName, d.Namespace, d.StsName,
 			))
+			continue
+		}
+		if prev, ok := mapped[k]; ok {
+			errs = append(errs, fmt.Errorf(
+				"backup volumes %s and %s would both be restored into pvc %s",
+				prev, e.LocalPath, t.PVCName,
+			))
+			continue
 		}
+		mapped[k] = e.LocalPath
+		slog.Info("restore mapping",
+			slog.String("from", fmt.Sprintf("%s/%s (%s)", manifest.Namespace, e.PVCName, e.LocalPath)),
+			slog.String("to", fmt.Sprintf("%s/%s", d.Namespace, t.PVCName)),
+		)
+		e.PodName, e.PVCName, e.MountPath, e.Container = t.PodName, t.PVCName, t.MountPath, t.Container
 	}
-
 	if len(errs) > 0 {
-		return joinErrors(errs)
+		return nil, fmt.Errorf("backup of %s/%s does not match the shape of %s/%s: %w",
+			manifest.Namespace, manifest.StatefulSet, d.Namespace, d.StsName, joinErrors(errs))
 	}
 
-	return nil
+	for i := range targets {
+		if _, ok := mapped[key{targets[i].Ordinal, targets[i].VolumeName}]; ok {
+			continue
+		}
+		attrs := []any{
+			slog.String("pod", targets[i].PodName),
+			slog.String("volume", targets[i].VolumeName),
+			slog.String("pvc", targets[i].PVCName),
+		}
+		if manifest.Selection != nil {
+			// expected, a partial backup only holds the selected volumes
+			slog.Debug("target volume is not in the partial backup", attrs...)
+			continue
+		}
+		slog.Warn("target volume gets no data from the backup", attrs...)
+	}
+	return sources, nil
+}
+
+func uploadSTSSources(ctx context.Context, d *dto.UploadSTSOpts, sources []restoreSource) (err error) {
+	if err := checkRestoreCapacity(ctx, d.KubeContext, d.Namespace, sources); err != nil {
+		return err
+	}
+	if d.Quiesce {
+		_, client, initErr := initConfigAndClientForContext(d.KubeContext)
+		if initErr != nil {
+			return initErr
+		}
+		var restore func() error
+		restore, err = quiesceStatefulSet(ctx, client, d.Namespace, d.StsName, d.QuiesceTimeout)
+		if err != nil {
+			return err
+		}
+		defer restoreAfterQuiesce(restore, &err)
+	}
+
+	return uploadRestoreSources(ctx, restoreJobOpts(d), sources)
+}
+
+func restoreJobOpts(d *dto.UploadSTSOpts) *volumeJobOpts {
+	return &volumeJobOpts{
+		namespace:      d.Namespace,
+		kubeContext:    d.KubeContext,
+		volumeWorkers:  d.VolumeWorkers,
+		fileWorkers:    d.FileWorkers,
+		allowOverwrite: d.AllowOverwrite,
+		annotate:       d.Annotate,
+		owner:          d.Owner,
+		transport:      d.Transport,
+		compress:       d.Compress,
+		limiter:        d.Limiter,
+	}
 }
 
 func validateManifestSources(
@@ -143,25 +325,54 @@ func validateManifestSources(
 			continue
 		}
 
-		if entry.PVCName == "" {
-			errs = append(errs, fmt.Errorf(
-				"manifest entry for pod=%q volume=%q has empty pvc_name",
-				entry.PodName, entry.VolumeName,
-			))
+		if err := validateManifestEntry(&entry); err != nil {
+			errs = append(errs, err)
 			continue
 		}
 
-		if entry.MountPath == "" {
+		result = append(result, restoreSource{
+			entry:    entry,
+			localSrc: localSrc,
+		})
+	}
+
+	if len(errs) > 0 {
+		return nil, joinErrors(errs)
+	}
+
+	sortRestoreSources(result)
+	return result, nil
+}
+
+func validateArchiveSources(
+	archive *backupArchiveReader,
+	manifest *kub.StatefulSetBackupManifest,
+	skipMissing bool,
+) ([]restoreSource, error) {
+	var result []restoreSource
+	var errs []error
+
+	for _, entry := range manifest.Entries {
+		if _, ok := archive.frame(entry.LocalPath); !ok {
+			if skipMissing {
+				continue
+			}
 			errs = append(errs, fmt.Errorf(
-				"manifest entry for pod=%q volume=%q has empty mount_path",
-				entry.PodName, entry.VolumeName,
+				"archive has no data for pod=%q volume=%q path=%q",
+				entry.PodName, entry.VolumeName, entry.LocalPath,
 			))
 			continue
 		}
 
+		if err := validateManifestEntry(&entry); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
 		result = append(result, restoreSource{
 			entry:    entry,
-			localSrc: localSrc,
+			localSrc: archive.path + ":" + entry.LocalPath,
+			archive:  archive,
 		})
 	}
 
@@ -169,12 +380,31 @@ func validateManifestSources(
 		return nil, joinErrors(errs)
 	}
 
-	sort.Slice(result, func(i, j int) bool {
-		if result[i].entry.Ordinal != result[j].entry.Ordinal {
-			return result[i].entry.Ordinal < result[j].entry.Ordinal
+	sortRestoreSources(result)
+	return result, nil
+}
+
+func validateManifestEntry(entry *kub.StatefulSetVolume) error {
+	if entry.PVCName == "" {
+		return fmt.Errorf(
+			"manifest entry for pod=%q volume=%q has empty pvc_name",
+			entry.PodName, entry.VolumeName,
+		)
+	}
+	if entry.MountPath == "" {
+		return fmt.Errorf(
+			"manifest entry for pod=%q volume=%q has empty mount_path",
+			entry.PodName, entry.VolumeName,
+		)
+	}
+	return nil
+}
+
+func sortRestoreSources(sources []restoreSource) {
+	sort.Slice(sources, func(i, j int) bool {
+		if sources[i].entry.Ordinal != sources[j].entry.Ordinal {
+			return sources[i].entry.Ordinal < sources[j].entry.Ordinal
 		}
-		return result[i].entry.VolumeName < result[j].entry.VolumeName
+		return sources[i].entry.VolumeName < sources[j].entry.VolumeName
 	})
-
-	return result, nil
 }
@@ -0,0 +1,205 @@
+package pipe
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// newRestoreTarget is a scaled-down StatefulSet with bound claims of its volumeClaimTemplates.
+func newRestoreTarget(namespace, name string, replicas int, volumes ...string) *fake.Clientset {
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec: appsv1.StatefulSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": name}},
+		},
+	}
+	objects := []runtime.Object{sts}
+	for _, v := range volumes {
+		sts.Spec.VolumeClaimTemplates = append(sts.Spec.VolumeClaimTemplates,
+			corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: v}})
+		sts.Spec.Template.Spec.Containers = []corev1.Container{{Name: name}}
+		for o := range replicas {
+			objects = append(objects, &corev1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: fmt.Sprintf("%s-%s-%d", v, name, o)},
+				Status:     corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound},
+			})
+		}
+	}
+	return fake.NewClientset(objects...)
+}
+
+// backupSources are the sources of a backup of the StatefulSet db in namespace prod.
+func backupSources(ordinals []int, volumes ...string) []restoreSource {
+	var sources []restoreSource
+	for _, o := range ordinals {
+		for _, v := range volumes {
+			pod := fmt.Sprintf("db-%d", o)
+			sources = append(sources, restoreSource{
+				entry: kub.StatefulSetVolume{
+					PodName:    pod,
+					Ordinal:    o,
+					VolumeName: v,
+					PVCName:    fmt.Sprintf("%s-%s", v, pod),
+					MountPath:  "/var/lib/" + v,
+					LocalPath:  pod + "/" + v,
+				},
+				localSrc: "backup/" + pod + "/" + v,
+			})
+		}
+	}
+	return sources
+}
+
+func restoredPVCs(sources []restoreSource) []string {
+	result := make([]string, 0, len(sources))
+	for i := range sources {
+		result = append(result, sources[i].entry.LocalPath+" -> "+sources[i].entry.PVCName)
+	}
+	return result
+}
+
+func TestMapRestoreSources(t *testing.T) {
+	manifest := &kub.StatefulSetBackupManifest{Namespace: "prod", StatefulSet: "db"}
+
+	t.Run("renamed", func(t *testing.T) {
+		client := newRestoreTarget("staging", "db-copy", 2, "data")
+		d := &dto.UploadSTSOpts{Namespace: "staging", StsName: "db-copy", FromSts: "db"}
+		got, err := mapRestoreSources(context.Background(), client, d, manifest, backupSources([]int{0, 1}, "data"))
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			"db-0/data -> data-db-copy-0",
+			"db-1/data -> data-db-copy-1",
+		}, restoredPVCs(got))
+		require.Equal(t, "db-copy-1", got[1].entry.PodName)
+		require.Equal(t, kub.DefaultMountPath, got[1].entry.MountPath, "the template does not mount the claim")
+		require.Equal(t, "backup/db-1/data", got[1].localSrc)
+	})
+
+	t.Run("fewer replicas", func(t *testing.T) {
+		client := newRestoreTarget("staging", "db", 1, "data")
+		d := &dto.UploadSTSOpts{Namespace: "staging", StsName: "db"}
+		_, err := mapRestoreSources(context.Background(), client, d, manifest, backupSources([]int{0, 1}, "data"))
+		require.ErrorContains(t, err, "db-1/data (ordinal 1, volume \"data\") has no counterpart in staging/db")
+	})
+
+	t.Run("two sources for one volume", func(t *testing.T) {
+		client := newRestoreTarget("staging", "db", 2, "data")
+		d := &dto.UploadSTSOpts{Namespace: "staging", StsName: "db"}
+		sources := backupSources([]int{0, 1}, "data")
+		sources[1].entry.Ordinal = 0 // e.g. --map-ordinal 1=0 without --ordinals
+		_, err := mapRestoreSources(context.Background(), client, d, manifest, sources)
+		require.ErrorContains(t, err, "backup volumes db-0/data and db-1/data would both be restored into pvc data-db-0")
+	})
+
+	t.Run("fan-out", func(t *testing.T) {
+		client := newRestoreTarget("staging", "db", 3, "data", "wal")
+		d := &dto.UploadSTSOpts{Namespace: "staging", StsName: "db", FanOut: true}
+		got, err := mapRestoreSources(context.Background(), client, d, manifest, backupSources([]int{0}, "data", "wal"))
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			"db-0/data -> data-db-0",
+			"db-0/wal -> wal-db-0",
+			"db-0/data -> data-db-1",
+			"db-0/wal -> wal-db-1",
+			"db-0/data -> data-db-2",
+			"db-0/wal -> wal-db-2",
+		}, restoredPVCs(got))
+	})
+
+	t.Run("no target", func(t *testing.T) {
+		client := fake.NewClientset()
+		d := &dto.UploadSTSOpts{Namespace: "staging", StsName: "db"}
+		_, err := mapRestoreSources(context.Background(), client, d, manifest, backupSources([]int{0}, "data"))
+		require.ErrorContains(t, err, "get statefulset")
+	})
+}
+
+func restoredOrdinals(sources []restoreSource) []string {
+	result := make([]string, 0, len(sources))
+	for i := range sources {
+		result = append(result, fmt.Sprintf("%s -> %d", sources[i].entry.LocalPath, sources[i].entry.Ordinal))
+	}
+	return result
+}
+
+func TestSelectRestoreOrdinals(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    dto.UploadSTSOpts
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "all",
+			want: []string{"db-0/data -> 0", "db-1/data -> 1", "db-2/data -> 2"},
+		},
+		{
+			name: "ordinals",
+			opts: dto.UploadSTSOpts{Ordinals: []int{2, 0}},
+			want: []string{"db-0/data -> 0", "db-2/data -> 2"},
+		},
+		{
+			name: "map ordinal",
+			opts: dto.UploadSTSOpts{Ordinals: []int{2}, MapOrdinals: map[int]int{2: 0}},
+			want: []string{"db-2/data -> 0"},
+		},
+		{
+			name: "swap",
+			opts: dto.UploadSTSOpts{MapOrdinals: map[int]int{0: 1, 1: 0}},
+			want: []string{"db-1/data -> 0", "db-0/data -> 1", "db-2/data -> 2"},
+		},
+		{
+			name: "fan-out",
+			opts: dto.UploadSTSOpts{FanOut: true, FanOutFrom: 1},
+			want: []string{"db-1/data -> 1"},
+		},
+		{
+			name:    "ordinal not in backup",
+			opts:    dto.UploadSTSOpts{Ordinals: []int{0, 5}},
+			wantErr: "ordinal 5 is not in the backup",
+		},
+		{
+			name:    "mapped ordinal not restored",
+			opts:    dto.UploadSTSOpts{Ordinals: []int{0}, MapOrdinals: map[int]int{1: 0}},
+			wantErr: "--map-ordinal 1: ordinal 1 is not restored",
+		},
+		{
+			name:    "fan-out from missing ordinal",
+			opts:    dto.UploadSTSOpts{FanOut: true, FanOutFrom: 3},
+			wantErr: "ordinal 3 is not in the backup",
+		},
+	}
+	for i := range tests {
+		tt := &tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := selectRestoreOrdinals(&tt.opts, backupSources([]int{0, 1, 2}, "data"))
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, restoredOrdinals(got))
+		})
+	}
+}
+
+func TestFanOutSources(t *testing.T) {
+	targets := []kub.PodVolume{
+		{PodName: "db-0", Ordinal: 0, VolumeName: "data"},
+		{PodName: "db-0", Ordinal: 0, VolumeName: "wal"},
+		{PodName: "db-1", Ordinal: 1, VolumeName: "data"},
+	}
+	got := fanOutSources(backupSources([]int{1}, "data"), targets)
+	require.Equal(t, []string{"db-1/data -> 0", "db-1/data -> 1"}, restoredOrdinals(got))
+	require.Empty(t, fanOutSources(backupSources([]int{1}, "data"), nil))
+}
@@ -1,11 +1,14 @@
 package pipe
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"strings"
 	"time"
 
 	"github.com/hashmap-kz/kubectl-syncpod/internal/clients"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
 	"k8s.io/apimachinery/pkg/api/errors"
 )
 
@@ -21,6 +24,26 @@ func waitForSSHReady(keyPair *clients.KeyPair, host string, port int, timeout ti
 	return fmt.Errorf("sshd not ready on %s:%d after %v", host, port, timeout)
 }
 
+// connectSFTP waits until the SFTP server in the helper pod is reachable
+// through the configured transport, and opens a session.
+func connectSFTP(ctx context.Context, opts *dto.JobOpts) (*clients.SFTPClient, error) {
+	if opts.Transport == dto.TransportExec {
+		slog.Info("waiting while sftp-server is ready")
+		if err := waitForExecSFTPReady(ctx, opts, sshWaitTimeout); err != nil {
+			return nil, err
+		}
+		slog.Info("init SFTP client over exec stream")
+		return newExecSFTPClient(ctx, opts)
+	}
+
+	slog.Info("waiting while SSHD is ready")
+	if err := waitForSSHReady(opts.KeyPair, opts.Host, opts.Port, sshWaitTimeout); err != nil {
+		return nil, err
+	}
+	slog.Info("init SSH client")
+	return newSFTPClient(opts.KeyPair, opts.Host, opts.Port)
+}
+
 func newSFTPClient(keyPair *clients.KeyPair, host string, port int) (*clients.SFTPClient, error) {
 	privateKeyToPEM, err := keyPair.PrivateKeyToPEM()
 	if err != nil {
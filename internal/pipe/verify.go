@@ -0,0 +1,433 @@
+package pipe
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/clients"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
+	"github.com/pkg/sftp"
+)
+
+// verify checks a backup directory or archive against the file inventories of its manifest:
+// offline by re-hashing the backup, and optionally against the live PVCs, hashed inside helpers.
+// Symlinks are only checked for existence, downloads follow them while archives keep them.
+
+const (
+	verifyWhereBackup  = "backup"
+	verifyWhereCluster = "cluster"
+)
+
+// RunVerify verifies a backup, and fails when any problem was found.
+func RunVerify(ctx context.Context, opts *dto.VerifyOpts) error {
+	manifest, archive, err := openBackup(opts.Src)
+	if err != nil {
+		return err
+	}
+	if archive != nil {
+		defer archive.Close()
+	}
+
+	report := &dto.VerifyReport{Source: opts.Src, Volumes: len(manifest.Entries), Problems: []dto.VerifyProblem{}}
+	var entries, uninventoried []*kub.StatefulSetVolume
+	for i := range manifest.Entries {
+		e := &manifest.Entries[i]
+		if len(e.Files) == 0 {
+			uninventoried = append(uninventoried, e)
+			continue
+		}
+		report.Files += len(e.Files)
+		entries = append(entries, e)
+	}
+
+	for _, e := range entries {
+		var got []kub.ManifestFile
+		if archive != nil {
+			got, err = archiveInventory(ctx, archive, e.LocalPath)
+		} else {
+			got, err = localInventory(ctx, filepath.Join(opts.Src, filepath.FromSlash(e.LocalPath)), e.Files, opts.FileWorkers)
+		}
+		if err != nil {
+			return fmt.Errorf("verify %s: %w", e.LocalPath, err)
+		}
+		report.Problems = append(report.Problems, compareInventory(e.LocalPath, verifyWhereBackup, e.Files, got)...)
+	}
+
+	// nothing can be checked offline for volumes without an inventory (backups taken before
+	// inventories): with --against-cluster one is built by hashing the backup, otherwise verify fails
+	for _, e := range uninventoried {
+		if !opts.AgainstCluster {
+			report.Notes = append(report.Notes, fmt.Sprintf("%s: no file inventory in the manifest, not verified", e.LocalPath))
+			continue
+		}
+		files, err := buildBackupInventory(ctx, opts, archive, e.LocalPath)
+		if err != nil {
+			return fmt.Errorf("verify %s: %w", e.LocalPath, err)
+		}
+		if files == nil {
+			report.Problems = append(report.Problems, dto.VerifyProblem{
+				Volume: e.LocalPath, Where: verifyWhereBackup, Path: ".", Problem: dto.VerifyMissing,
+			})
+			continue
+		}
+		e.Files = files
+		report.Files += len(files)
+		report.Notes = append(report.Notes, fmt.Sprintf(
+			"%s: no file inventory in the manifest, built one by hashing the backup (compared with the cluster only)", e.LocalPath))
+		entries = append(entries, e)
+	}
+
+	if opts.AgainstCluster {
+		namespace := opts.Namespace
+		if namespace == "" {
+			namespace = manifest.Namespace
+		}
+		problems, err := verifyClusterVolumes(ctx, opts, namespace, entries)
+		if err != nil {
+			return err
+		}
+		report.Problems = append(report.Problems, problems...)
+	}
+
+	if err := printVerifyReport(opts.Out, report, opts.Output); err != nil {
+		return err
+	}
+	if len(report.Problems) > 0 {
+		return fmt.Errorf("backup verification failed: %d problem(s)", len(report.Problems))
+	}
+	if !opts.AgainstCluster && len(uninventoried) > 0 {
+		return fmt.Errorf("backup verification incomplete: %d volume(s) without file inventory were not verified, "+
+			"run 'manifest upgrade' on the backup directory, or use --against-cluster", len(uninventoried))
+	}
+	return nil
+}
+
+// buildBackupInventory hashes the volume at localPath of a backup, nil when the backup has no such volume.
+func buildBackupInventory(ctx context.Context, opts *dto.VerifyOpts, archive *backupArchiveReader, localPath string) ([]kub.ManifestFile, error) {
+	if archive != nil {
+		return archiveInventory(ctx, archive, localPath)
+	}
+	return hashLocalTree(ctx, filepath.Join(opts.Src, filepath.FromSlash(localPath)), opts.FileWorkers)
+}
+
+// openBackup reads the manifest of a backup directory, or of a backup archive (returned open).
+func openBackup(src string) (*kub.StatefulSetBackupManifest, *backupArchiveReader, error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.IsDir() {
+		manifest, err := kub.ReadStatefulSetBackupManifest(filepath.Join(src, "manifest.json"))
+		return manifest, nil, err
+	}
+
+	archive, err := openBackupArchive(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	manifest, err := archive.readManifest()
+	if err != nil {
+		archive.Close()
+		return nil, nil, err
+	}
+	return manifest, archive, nil
+}
+
+// localInventory lists a volume directory of a backup, files that may match the inventory are hashed.
+func localInventory(ctx context.Context, root string, want []kub.ManifestFile, workers int) ([]kub.ManifestFile, error) {
+	got, err := listLocalInventory(root)
+	if err != nil || got == nil {
+		return nil, err
+	}
+	if err := hashLocalFiles(ctx, root, got, hashCandidates(want, got), workers); err != nil {
+		return nil, err
+	}
+	return got, nil
+}
+
+// hashLocalTree builds the inventory of a volume directory of a backup, hashing every regular file.
+// It returns nil when the directory does not exist.
+func hashLocalTree(ctx context.Context, root string, workers int) ([]kub.ManifestFile, error) {
+	got, err := listLocalInventory(root)
+	if err != nil || got == nil {
+		return nil, err
+	}
+	var idx []int
+	for i := range got {
+		if inventoryKind(&got[i]) == "file" {
+			idx = append(idx, i)
+		}
+	}
+	if err := hashLocalFiles(ctx, root, got, idx, workers); err != nil {
+		return nil, err
+	}
+	return got, nil
+}
+
+// listLocalInventory lists the tree under root without checksums, nil when root does not exist.
+func listLocalInventory(root string) ([]kub.ManifestFile, error) {
+	if _, err := os.Stat(root); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	jobs, err := listLocalTree(root, "")
+	if err != nil {
+		return nil, err
+	}
+	got := make([]kub.ManifestFile, 0, len(jobs))
+	for i := range jobs {
+		got = append(got, kub.ManifestFile{Path: jobs[i].RelPath, Size: jobs[i].Size, Mode: jobs[i].Mode.String()})
+	}
+	return got, nil
+}
+
+// hashLocalFiles sets the checksums of the files at idx.
+func hashLocalFiles(ctx context.Context, root string, files []kub.ManifestFile, idx []int, workers int) error {
+	paths := make([]string, 0, len(idx))
+	for _, i := range idx {
+		paths = append(paths, filepath.Join(root, filepath.FromSlash(files[i].Path)))
+	}
+	sums, err := localChecksums(ctx, paths, workers)
+	if err != nil {
+		return err
+	}
+	for n, i := range idx {
+		files[i].SHA256 = sums[n]
+	}
+	return nil
+}
+
+// archiveInventory reads the frame of a volume, hashing all regular files.
+func archiveInventory(ctx context.Context, archive *backupArchiveReader, localPath string) ([]kub.ManifestFile, error) {
+	if _, ok := archive.frame(localPath); !ok {
+		return nil, nil
+	}
+	rc, err := archive.openVolume(localPath)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	got := []kub.ManifestFile{{Path: ".", Mode: os.ModeDir.String()}}
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return got, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		f := kub.ManifestFile{Path: path.Clean(hdr.Name), Mode: hdr.FileInfo().Mode().String()}
+		if hdr.Typeflag == tar.TypeReg {
+			h := sha256.New()
+			n, err := io.Copy(h, &ctxReader{ctx: ctx, r: tr})
+			if err != nil {
+				return nil, err
+			}
+			f.Size, f.SHA256 = n, hex.EncodeToString(h.Sum(nil))
+		}
+		got = append(got, f)
+	}
+}
+
+// verifyClusterVolumes compares the inventories with the PVCs, up to VolumeWorkers helpers at a time.
+func verifyClusterVolumes(ctx context.Context, opts *dto.VerifyOpts, namespace string, entries []*kub.StatefulSetVolume) ([]dto.VerifyProblem, error) {
+	var mu sync.Mutex
+	var problems []dto.VerifyProblem
+	var errs []error
+
+	sem := make(chan struct{}, max(opts.VolumeWorkers, 1))
+	var wg sync.WaitGroup
+	for _, e := range entries {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			p, err := verifyClusterVolume(ctx, opts, namespace, e)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s (%s): %w", e.LocalPath, e.PVCName, err))
+				return
+			}
+			problems = append(problems, p...)
+		}()
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return nil, joinErrors(errs)
+	}
+	return problems, nil
+}
+
+func verifyClusterVolume(ctx context.Context, opts *dto.VerifyOpts, namespace string, e *kub.StatefulSetVolume) ([]dto.VerifyProblem, error) {
+	jobOpts, teardown, err := startHelper(ctx, &dto.RunOpts{
+		PVC:         e.PVCName,
+		Namespace:   namespace,
+		KubeContext: opts.KubeContext,
+		Remote:      ".",
+		MountPath:   e.MountPath,
+		ObjName:     kub.NewObjName(),
+		Transport:   opts.Transport,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer teardown()
+
+	client, err := connectSFTP(ctx, jobOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer closeSFTPClient(client)
+
+	got, err := remoteInventory(client.SFTPClient(), jobOpts.MountPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := hashRemoteFiles(ctx, jobOpts, client, jobOpts.MountPath, got, hashCandidates(e.Files, got)); err != nil {
+		return nil, err
+	}
+	return compareInventory(e.LocalPath, verifyWhereCluster, e.Files, got), nil
+}
+
+// remoteInventory lists the tree under root of a PVC, without checksums.
+func remoteInventory(client *sftp.Client, root string) ([]kub.ManifestFile, error) {
+	jobs, err := getFilesToDownload(client, root, "")
+	if err != nil {
+		return nil, err
+	}
+	files := make([]kub.ManifestFile, 0, len(jobs))
+	for i := range jobs {
+		files = append(files, kub.ManifestFile{Path: jobs[i].RelPath, Size: jobs[i].Size, Mode: jobs[i].Mode.String()})
+	}
+	return files, nil
+}
+
+// hashRemoteFiles sets the checksums of the files at idx, hashed in the helper.
+func hashRemoteFiles(ctx context.Context, jobOpts *dto.JobOpts, client *clients.SFTPClient, root string, files []kub.ManifestFile, idx []int) error {
+	if len(idx) == 0 {
+		return nil
+	}
+	paths := make([]string, 0, len(idx))
+	for _, i := range idx {
+		paths = append(paths, path.Join(root, files[i].Path))
+	}
+	sums, err := remoteChecksums(ctx, jobOpts, client, paths)
+	if err != nil {
+		return err
+	}
+	for n, i := range idx {
+		files[i].SHA256 = sums[paths[n]]
+	}
+	return nil
+}
+
+// inventoryKind tells directories, regular files and everything else (symlinks, devices) apart.
+func inventoryKind(f *kub.ManifestFile) string {
+	mode := f.Mode
+	switch {
+	case mode == "":
+		return "other"
+	case mode[0] == 'd':
+		return "dir"
+	case mode[0] == '-':
+		return "file"
+	default:
+		return "other"
+	}
+}
+
+// hashCandidates returns the indexes of files in got which are regular files of the same size in want.
+func hashCandidates(want, got []kub.ManifestFile) []int {
+	byPath := make(map[string]*kub.ManifestFile, len(want))
+	for i := range want {
+		byPath[want[i].Path] = &want[i]
+	}
+	var idx []int
+	for i := range got {
+		w, ok := byPath[got[i].Path]
+		if ok && w.SHA256 != "" && inventoryKind(&got[i]) == "file" && w.Size == got[i].Size {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
+
+func compareInventory(volume, where string, want, got []kub.ManifestFile) []dto.VerifyProblem {
+	gotByPath := make(map[string]*kub.ManifestFile, len(got))
+	for i := range got {
+		gotByPath[got[i].Path] = &got[i]
+	}
+	problem := func(p, kind, detail string) dto.VerifyProblem {
+		return dto.VerifyProblem{Volume: volume, Where: where, Path: p, Problem: kind, Detail: detail}
+	}
+
+	var problems []dto.VerifyProblem
+	seen := make(map[string]bool, len(want))
+	for i := range want {
+		w := &want[i]
+		seen[w.Path] = true
+		g, ok := gotByPath[w.Path]
+		if !ok {
+			problems = append(problems, problem(w.Path, dto.VerifyMissing, ""))
+			continue
+		}
+		// downloads follow symlinks, so a symlink may come back as a regular file
+		if inventoryKind(w) == "other" || inventoryKind(g) == "other" {
+			continue
+		}
+		switch wk, gk := inventoryKind(w), inventoryKind(g); {
+		case wk != gk:
+			problems = append(problems, problem(w.Path, dto.VerifyType, wk+" -> "+gk))
+		case wk != "file":
+		case w.Size != g.Size:
+			problems = append(problems, problem(w.Path, dto.VerifySize, fmt.Sprintf("%d -> %d", w.Size, g.Size)))
+		case w.SHA256 != g.SHA256:
+			problems = append(problems, problem(w.Path, dto.VerifyChecksum, ""))
+		}
+	}
+	for i := range got {
+		if !seen[got[i].Path] {
+			problems = append(problems, problem(got[i].Path, dto.VerifyUnexpected, ""))
+		}
+	}
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].Path < problems[j].Path })
+	return problems
+}
+
+func printVerifyReport(w io.Writer, report *dto.VerifyReport, output string) error {
+	if output == dto.OutputJSON {
+		return writeJSON(w, report)
+	}
+
+	for _, note := range report.Notes {
+		fmt.Fprintf(w, "note: %s\n", note)
+	}
+	if len(report.Problems) > 0 {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "VOLUME\tWHERE\tPROBLEM\tPATH\tDETAIL")
+		for i := range report.Problems {
+			p := &report.Problems[i]
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", p.Volume, p.Where, p.Problem, p.Path, p.Detail)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d volume(s), %d file(s) in the inventory, %d problem(s)\n", report.Volumes, report.Files, len(report.Problems))
+	return err
+}
@@ -0,0 +1,144 @@
+package pipe
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareInventory(t *testing.T) {
+	want := []kub.ManifestFile{
+		{Path: "dir", Size: 4096, Mode: "drwxr-xr-x"},
+		{Path: "dir/a", Size: 1, Mode: "-rw-r--r--", SHA256: "aa"},
+		{Path: "dir/b", Size: 2, Mode: "-rw-r--r--", SHA256: "bb"},
+		{Path: "dir/c", Size: 3, Mode: "-rw-r--r--", SHA256: "cc"},
+		{Path: "dir/d", Size: 4, Mode: "-rw-r--r--", SHA256: "dd"},
+		{Path: "link", Size: 5, Mode: "Lrwxrwxrwx"},
+		{Path: "sub", Size: 4096, Mode: "drwxr-xr-x"},
+	}
+	got := []kub.ManifestFile{
+		{Path: "dir", Size: 4096, Mode: "drwxr-xr-x"},
+		{Path: "dir/a", Size: 1, Mode: "-rw-------", SHA256: "aa"}, // modes are not compared
+		{Path: "dir/b", Size: 20, Mode: "-rw-r--r--"},
+		{Path: "dir/c", Size: 3, Mode: "-rw-r--r--", SHA256: "xx"},
+		// a downloaded symlink is a regular file
+		{Path: "link", Size: 12, Mode: "-rw-r--r--", SHA256: "ll"},
+		{Path: "sub", Size: 7, Mode: "-rw-r--r--", SHA256: "ss"},
+		{Path: "zzz", Size: 1, Mode: "-rw-r--r--", SHA256: "zz"},
+	}
+
+	problems := compareInventory("db-0/data", verifyWhereBackup, want, got)
+	require.Equal(t, []dto.VerifyProblem{
+		{Volume: "db-0/data", Where: verifyWhereBackup, Path: "dir/b", Problem: dto.VerifySize, Detail: "2 -> 20"},
+		{Volume: "db-0/data", Where: verifyWhereBackup, Path: "dir/c", Problem: dto.VerifyChecksum},
+		{Volume: "db-0/data", Where: verifyWhereBackup, Path: "dir/d", Problem: dto.VerifyMissing},
+		{Volume: "db-0/data", Where: verifyWhereBackup, Path: "sub", Problem: dto.VerifyType, Detail: "dir -> file"},
+		{Volume: "db-0/data", Where: verifyWhereBackup, Path: "zzz", Problem: dto.VerifyUnexpected},
+	}, problems)
+
+	require.Empty(t, compareInventory("db-0/data", verifyWhereBackup, want, want))
+}
+
+func TestHashCandidates(t *testing.T) {
+	want := []kub.ManifestFile{
+		{Path: "a", Size: 1, Mode: "-rw-r--r--", SHA256: "aa"},
+		{Path: "b", Size: 2, Mode: "-rw-r--r--", SHA256: "bb"},
+		{Path: "c", Size: 3, Mode: "Lrwxrwxrwx"},
+	}
+	got := []kub.ManifestFile{
+		{Path: "a", Size: 1, Mode: "-rw-r--r--"},
+		{Path: "b", Size: 3, Mode: "-rw-r--r--"},  // size differs, no need to hash
+		{Path: "c", Size: 3, Mode: "-rw-r--r--"},  // no checksum to compare with
+		{Path: "d", Size: 1, Mode: "-rw-r--r--"},  // unexpected
+		{Path: "a/", Size: 1, Mode: "drwxr-xr-x"}, // not a file
+	}
+	require.Equal(t, []int{0}, hashCandidates(want, got))
+}
+
+func TestLocalInventory(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "dir"), 0o750))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "dir", "a"), []byte("hello"), 0o600))
+	sum := sha256.Sum256([]byte("hello"))
+
+	want := []kub.ManifestFile{
+		{Path: ".", Mode: "drwx------"},
+		{Path: "dir", Mode: "drwxr-x---"},
+		{Path: "dir/a", Size: 5, Mode: "-rw-------", SHA256: hex.EncodeToString(sum[:])},
+	}
+	got, err := localInventory(context.Background(), root, want, 2)
+	require.NoError(t, err)
+	require.Empty(t, compareInventory("v", verifyWhereBackup, want, got))
+
+	// a volume that was not downloaded at all
+	got, err = localInventory(context.Background(), filepath.Join(root, "missing"), want, 2)
+	require.NoError(t, err)
+	require.Len(t, compareInventory("v", verifyWhereBackup, want, got), 3)
+}
+
+func TestHashLocalTree(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "dir"), 0o750))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "dir", "a"), []byte("hello"), 0o600))
+	sum := sha256.Sum256([]byte("hello"))
+
+	got, err := hashLocalTree(context.Background(), root, 2)
+	require.NoError(t, err)
+	require.Len(t, got, 3)
+	require.Equal(t, kub.ManifestFile{Path: "dir/a", Size: 5, Mode: "-rw-------", SHA256: hex.EncodeToString(sum[:])}, got[2])
+
+	got, err = hashLocalTree(context.Background(), filepath.Join(root, "missing"), 2)
+	require.NoError(t, err)
+	require.Nil(t, got)
+}
+
+// manifestV1Baseline is a manifest as written by download-sts before inventories were recorded.
+const manifestV1Baseline = `{
+  "version": 1,
+  "kind": "StatefulSetBackupManifest",
+  "namespace": "prod",
+  "statefulset": "db",
+  "captured_at": "2025-01-02T03:04:05Z",
+  "entries": [
+    {
+      "pod_name": "db-0",
+      "ordinal": 0,
+      "volume_name": "data",
+      "pvc_name": "data-db-0",
+      "mount_path": "/var/lib/data",
+      "container": "postgres",
+      "local_path": "db-0/data"
+    },
+    {
+      "pod_name": "db-1",
+      "ordinal": 1,
+      "volume_name": "data",
+      "pvc_name": "data-db-1",
+      "mount_path": "/var/lib/data",
+      "container": "postgres",
+      "local_path": "db-1/data"
+    }
+  ]
+}
+`
+
+func TestRunVerifyWithoutInventory(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "manifest.json"), []byte(manifestV1Baseline), 0o600))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "db-0", "data"), 0o750))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "db-0", "data", "PG_VERSION"), []byte("17\n"), 0o600))
+
+	var out strings.Builder
+	err := RunVerify(context.Background(), &dto.VerifyOpts{Src: dir, FileWorkers: 1, Out: &out})
+	require.ErrorContains(t, err, "2 volume(s) without file inventory were not verified")
+	require.Contains(t, out.String(), "note: db-0/data: no file inventory in the manifest, not verified")
+	require.Contains(t, out.String(), "0 problem(s)")
+}
@@ -0,0 +1,340 @@
+package pipe
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/bwlimit"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/clients"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
+	"k8s.io/client-go/kubernetes"
+)
+
+// volumeJobOpts holds the options shared by all per-volume jobs of a multi-volume command.
+type volumeJobOpts struct {
+	namespace      string
+	kubeContext    string // kubeconfig context, empty for the current one
+	volumeWorkers  int
+	fileWorkers    int
+	allowOverwrite bool
+	annotate       bool
+	owner          string
+	transport      string
+	compress       string
+	limiter        *bwlimit.Limiter
+}
+
+// downloadPodVolumes downloads each volume into <dstRoot>/<kub.VolumeLocalPath>, with one helper pod
+// per node (see runHelperJobs). It returns the file inventories by local path.
+func downloadPodVolumes(ctx context.Context, p *volumeJobOpts, dstRoot string, vols []kub.PodVolume) (map[string][]kub.ManifestFile, error) {
+	invs := make([]*kub.FileInventory, len(vols))
+	jobs := make([]helperJob, len(vols))
+	for i := range vols {
+		vol := &vols[i]
+		invs[i] = &kub.FileInventory{}
+		jobs[i] = helperJob{
+			pvc:       vol.PVCName,
+			mountPath: vol.MountPath,
+			pod:       vol.PodName,
+			run: func(ctx context.Context, client *clients.SFTPClient, helper *dto.JobOpts, mountPath string) error {
+				localDst := filepath.Join(dstRoot, filepath.FromSlash(kub.VolumeLocalPath(vol)))
+				runOpts := volumeDownloadOpts(p, localDst, vol)
+				runOpts.Inventory = invs[i]
+				jobOpts := jobOptsForRun(helper, runOpts, mountPath)
+				return auditTransfer(ctx, runOpts.Mode, jobOpts, func() error {
+					return runJobWithClient(ctx, runOpts.Mode, client, jobOpts)
+				})
+			},
+		}
+	}
+
+	var errs []error
+	inventories := make(map[string][]kub.ManifestFile, len(vols))
+	for i, err := range runHelperJobs(ctx, p, jobs) {
+		vol := &vols[i]
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s/%s (%s -> %s): %w",
+				vol.PodName, vol.VolumeName, vol.PVCName, vol.MountPath, err))
+			continue
+		}
+		inventories[kub.VolumeLocalPath(vol)] = invs[i].Files()
+	}
+
+	if len(errs) > 0 {
+		return nil, joinErrors(errs)
+	}
+	return inventories, nil
+}
+
+// recordInventories stores the file inventories in the manifest entries, with their totals and digests.
+func recordInventories(manifest *kub.StatefulSetBackupManifest, inventories map[string][]kub.ManifestFile) {
+	for i := range manifest.Entries {
+		kub.SetEntryInventory(&manifest.Entries[i], inventories[manifest.Entries[i].LocalPath])
+	}
+}
+
+// recordClaimSpecs stores the specs of the claims in the manifest entries, and the volumeClaimTemplates
+// of the StatefulSet when stsName is set. Claims that cannot be read are only logged.
+func recordClaimSpecs(ctx context.Context, client kubernetes.Interface, manifest *kub.StatefulSetBackupManifest, stsName string) {
+	for i := range manifest.Entries {
+		e := &manifest.Entries[i]
+		if e.PVC != nil {
+			continue
+		}
+		spec, err := kub.GetPVCSpec(ctx, client, manifest.Namespace, e.PVCName)
+		if err != nil {
+			slog.Warn("cannot record pvc spec", slog.String("pvc", e.PVCName), slog.Any("err", err))
+			continue
+		}
+		e.PVC = spec
+	}
+	if stsName == "" {
+		return
+	}
+	templates, err := kub.GetStatefulSetClaimTemplates(ctx, client, manifest.Namespace, stsName)
+	if err != nil {
+		slog.Warn("cannot record volumeClaimTemplates", slog.String("statefulset", stsName), slog.Any("err", err))
+		return
+	}
+	manifest.VolumeClaimTemplates = templates
+}
+
+// checkRestoreCapacity fails when the data of a volume does not fit into the claim it is restored into.
+// Entries of version 1 manifests have no byte totals, and are not checked.
+func checkRestoreCapacity(ctx context.Context, kubeContext, namespace string, sources []restoreSource) error {
+	_, client, err := initConfigAndClientForContext(kubeContext)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	unchecked := 0
+	for i := range sources {
+		e := &sources[i].entry
+		if e.Digest == "" {
+			unchecked++
+			continue
+		}
+		capacity, ok, err := kub.TargetClaimCapacity(ctx, client, namespace, e.PVCName, e.PVC)
+		if err != nil {
+			return err
+		}
+		if ok && e.Bytes > capacity.Value() {
+			errs = append(errs, fmt.Errorf("%s: %s of data does not fit into pvc %s (%s)",
+				e.LocalPath, formatSize(e.Bytes), e.PVCName, capacity.String()))
+		}
+	}
+	if unchecked > 0 {
+		slog.Info("capacity is not checked for volumes without byte totals, see `manifest upgrade`", slog.Int("volumes", unchecked))
+	}
+	if len(errs) > 0 {
+		return joinErrors(errs)
+	}
+	return nil
+}
+
+// uploadRestoreSources uploads each local source into its PVC, with one helper pod
+// per node (see runHelperJobs).
+func uploadRestoreSources(ctx context.Context, p *volumeJobOpts, sources []restoreSource) error {
+	jobs := make([]helperJob, len(sources))
+	for i := range sources {
+		src := &sources[i]
+		jobs[i] = helperJob{
+			pvc:       src.entry.PVCName,
+			mountPath: src.entry.MountPath,
+			pod:       src.entry.PodName,
+			run: func(ctx context.Context, client *clients.SFTPClient, helper *dto.JobOpts, mountPath string) error {
+				runOpts, closeSource, err := restoreSourceOpts(p, src)
+				if err != nil {
+					return err
+				}
+				defer closeSource()
+				jobOpts := jobOptsForRun(helper, runOpts, mountPath)
+				return auditTransfer(ctx, runOpts.Mode, jobOpts, func() error {
+					return runJobWithClient(ctx, runOpts.Mode, client, jobOpts)
+				})
+			},
+		}
+	}
+
+	var errs []error
+	for i, err := range runHelperJobs(ctx, p, jobs) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf(
+				"%s/%s (%s <- %s): %w",
+				sources[i].entry.PodName,
+				sources[i].entry.VolumeName,
+				sources[i].entry.PVCName,
+				sources[i].localSrc,
+				err,
+			))
+		}
+	}
+
+	if len(errs) > 0 {
+		return joinErrors(errs)
+	}
+
+	return nil
+}
+
+// volumeDownloadOpts describes the download of a whole volume into localDst.
+func volumeDownloadOpts(p *volumeJobOpts, localDst string, vol *kub.PodVolume) *dto.RunOpts {
+	return &dto.RunOpts{
+		Mode:        "download",
+		PVC:         vol.PVCName,
+		Namespace:   p.namespace,
+		KubeContext: p.kubeContext,
+		Remote:      ".",
+		Local:       localDst,
+		MountPath:   vol.MountPath,
+		Workers:     p.fileWorkers,
+		ObjName:     kub.NewObjName(),
+		Transport:   p.transport,
+		Compress:    p.compress,
+		Limiter:     p.limiter,
+	}
+}
+
+// restoreSourceOpts describes the upload of a restore source into its PVC.
+// Volumes of a backup archive are streamed, the returned func closes the stream.
+func restoreSourceOpts(p *volumeJobOpts, src *restoreSource) (*dto.RunOpts, func(), error) {
+	runOpts := &dto.RunOpts{
+		Mode:           "upload",
+		PVC:            src.entry.PVCName,
+		Namespace:      p.namespace,
+		KubeContext:    p.kubeContext,
+		Local:          src.localSrc,
+		Remote:         ".",
+		MountPath:      src.entry.MountPath,
+		Workers:        p.fileWorkers,
+		AllowOverwrite: p.allowOverwrite,
+		Annotate:       p.annotate,
+		Owner:          p.owner,
+		ObjName:        kub.NewObjName(),
+		Transport:      p.transport,
+		Compress:       p.compress,
+		Limiter:        p.limiter,
+	}
+	if src.archive == nil {
+		return runOpts, func() {}, nil
+	}
+	in, err := src.archive.openVolume(src.entry.LocalPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	runOpts.Local = stdioPath
+	runOpts.Format = dto.FormatTar
+	runOpts.In = in
+	return runOpts, func() { in.Close() }, nil
+}
+
+// downloadPodVolumesToArchive writes all volumes and the manifest into a single backup archive.
+// Frames are written sequentially, so volumes are downloaded one at a time. The manifest
+// frame comes last, so it holds the file inventories recorded while the volumes were archived.
+func downloadPodVolumesToArchive(
+	ctx context.Context,
+	p *volumeJobOpts,
+	archivePath string,
+	manifest *kub.StatefulSetBackupManifest,
+	vols []kub.PodVolume,
+) (err error) {
+	if p.volumeWorkers > 1 {
+		slog.Info("volumes are archived one at a time", slog.Int("volume-workers", p.volumeWorkers))
+	}
+
+	archive, err := createBackupArchive(archivePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			archive.abort()
+		}
+	}()
+
+	// frames are written one at a time, the helpers of the groups are started one after another
+	seq := *p
+	seq.volumeWorkers = 1
+	invs := make([]*kub.FileInventory, len(vols))
+	jobs := make([]helperJob, len(vols))
+	for i := range vols {
+		vol := &vols[i]
+		invs[i] = &kub.FileInventory{}
+		jobs[i] = helperJob{
+			pvc:       vol.PVCName,
+			mountPath: vol.MountPath,
+			pod:       vol.PodName,
+			run: func(ctx context.Context, client *clients.SFTPClient, helper *dto.JobOpts, mountPath string) error {
+				name := kub.VolumeLocalPath(vol)
+				jobOpts := jobOptsForRun(helper, &dto.RunOpts{PVC: vol.PVCName, Remote: ".", Local: archivePath + ":" + name}, mountPath)
+				return auditTransfer(ctx, "download", jobOpts, func() error {
+					if err := downloadVolumeToArchive(ctx, p, archive, client, vol, mountPath, invs[i]); err != nil {
+						return err
+					}
+					for _, f := range invs[i].Files() {
+						if f.SHA256 != "" { // regular files
+							jobOpts.Stats.Add(1, f.Size)
+						}
+					}
+					return nil
+				})
+			},
+		}
+	}
+
+	var errs []error
+	inventories := make(map[string][]kub.ManifestFile, len(vols))
+	for i, jobErr := range runHelperJobs(ctx, &seq, jobs) {
+		vol := &vols[i]
+		if jobErr != nil {
+			errs = append(errs, fmt.Errorf("%s/%s (%s -> %s): %w", vol.PodName, vol.VolumeName, vol.PVCName, archivePath, jobErr))
+			continue
+		}
+		inventories[kub.VolumeLocalPath(vol)] = invs[i].Files()
+	}
+	if len(errs) > 0 {
+		return joinErrors(errs)
+	}
+	recordInventories(manifest, inventories)
+	manifest.FinishedAt = time.Now().UTC()
+
+	err = archive.writeFrame(backupArchiveManifestName, func(tw *tar.Writer) (int, error) {
+		var buf bytes.Buffer
+		if err := kub.EncodeStatefulSetBackupManifest(&buf, manifest); err != nil {
+			return 0, err
+		}
+		return 1, writeTarFile(tw, backupArchiveManifestName, buf.Bytes())
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	slog.Info("archive written", slog.String("path", archivePath), slog.Int("volumes", len(vols)))
+	return nil
+}
+
+func downloadVolumeToArchive(
+	ctx context.Context,
+	p *volumeJobOpts,
+	archive *backupArchiveWriter,
+	client *clients.SFTPClient,
+	vol *kub.PodVolume,
+	mountPath string,
+	inv *kub.FileInventory,
+) error {
+	name := kub.VolumeLocalPath(vol)
+	slog.Info("begin to archive volume", slog.String("volume", name), slog.String("pvc", vol.PVCName))
+	return archive.writeFrame(name, func(tw *tar.Writer) (int, error) {
+		return writeTarTree(ctx, client.SFTPClient(), p.limiter, inv, tw, mountPath, name)
+	})
+}
@@ -0,0 +1,95 @@
+package pipe
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hashmap-kz/kubectl-syncpod/internal/dto"
+	"github.com/hashmap-kz/kubectl-syncpod/internal/kub"
+)
+
+func RunDownloadWorkload(ctx context.Context, opts *dto.DownloadWorkloadOpts) error {
+	ref, err := kub.ParseWorkloadRef(opts.Workload, opts.Selector)
+	if err != nil {
+		return err
+	}
+
+	_, client, err := initConfigAndClientForContext(opts.KubeContext)
+	if err != nil {
+		return err
+	}
+
+	vols, err := kub.DiscoverWorkloadPVCs(ctx, client, opts.Namespace, ref)
+	if err != nil {
+		return err
+	}
+	if len(vols) == 0 {
+		return fmt.Errorf("no PVC-backed volumes found for %s", ref)
+	}
+
+	if err := os.MkdirAll(opts.Dst, 0o755); err != nil {
+		return fmt.Errorf("create destination root: %w", err)
+	}
+
+	inventories, err := downloadPodVolumes(ctx, &volumeJobOpts{
+		namespace:     opts.Namespace,
+		kubeContext:   opts.KubeContext,
+		volumeWorkers: opts.VolumeWorkers,
+		fileWorkers:   opts.FileWorkers,
+		transport:     opts.Transport,
+		compress:      opts.Compress,
+		limiter:       opts.Limiter,
+	}, opts.Dst, vols)
+	if err != nil {
+		return err
+	}
+
+	manifest := kub.BuildWorkloadBackupManifest(opts.Namespace, ref, vols)
+	recordInventories(manifest, inventories)
+	recordClaimSpecs(ctx, client, manifest, manifest.StatefulSet)
+	manifest.FinishedAt = time.Now().UTC()
+	return kub.WriteStatefulSetBackupManifest(filepath.Join(opts.Dst, "manifest.json"), manifest)
+}
+
+func RunUploadWorkload(ctx context.Context, opts *dto.UploadWorkloadOpts) error {
+	ref, err := kub.ParseWorkloadRef(opts.Workload, opts.Selector)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := kub.ReadStatefulSetBackupManifest(filepath.Join(opts.Src, "manifest.json"))
+	if err != nil {
+		return fmt.Errorf("read manifest: %w", err)
+	}
+
+	if captured := kub.ManifestWorkload(manifest); !captured.Equal(ref) {
+		return fmt.Errorf(
+			"manifest workload mismatch: manifest=%q requested=%q",
+			captured, ref,
+		)
+	}
+
+	sources, err := validateManifestSources(opts.Src, manifest, opts.SkipMissing)
+	if err != nil {
+		return fmt.Errorf("validate manifest sources: %w", err)
+	}
+	if err := checkRestoreCapacity(ctx, opts.KubeContext, opts.Namespace, sources); err != nil {
+		return err
+	}
+
+	return uploadRestoreSources(ctx, &volumeJobOpts{
+		namespace:      opts.Namespace,
+		kubeContext:    opts.KubeContext,
+		volumeWorkers:  opts.VolumeWorkers,
+		fileWorkers:    opts.FileWorkers,
+		allowOverwrite: opts.AllowOverwrite,
+		annotate:       opts.Annotate,
+		owner:          opts.Owner,
+		transport:      opts.Transport,
+		compress:       opts.Compress,
+		limiter:        opts.Limiter,
+	}, sources)
+}
@@ -0,0 +1,18 @@
+package version
+
+import "runtime/debug"
+
+// Version is set by releases:
+// -ldflags "-X github.com/hashmap-kz/kubectl-syncpod/internal/version.Version=v1.2.3"
+var Version = ""
+
+// String returns the version of the build, the module version for `go install` builds, or "dev".
+func String() string {
+	if Version != "" {
+		return Version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}
@@ -21,7 +21,7 @@ func main() {
 		slog.Error("error executing command", slog.Any("err", err))
 		stop()
 		//nolint:gocritic
-		os.Exit(1)
+		os.Exit(cmd.ExitCode(err))
 	}
 	slog.Info("all jobs completed successfully")
 }
@@ -141,6 +141,124 @@ func TestIntegration_UploadSTS_RestoresFromDownloadedBackup(t *testing.T) {
 	assertNoSyncpodResourcesLeft(t, ns)
 }
 
+func TestIntegration_UploadSTS_RestoresFromArchive(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeoutPerTest)
+	defer cancel()
+
+	ns := fmt.Sprintf("syncpod-sts-it-%d", time.Now().UnixNano())
+	env := newTestEnv(t, ctx, ns)
+	defer env.Cleanup()
+
+	manifest := renderStatefulSetManifest(t, statefulSetManifestOpts{
+		Namespace: ns,
+		Name:      "stateful",
+		MountPath: mountPathInContainer,
+		Replicas:  2,
+	})
+	_, err := runCmdWithStdin(manifest, "kubectl", "apply", "-f", "-")
+	require.NoError(t, err)
+
+	waitStatefulSetReady(t, ns, "stateful")
+	writeRemoteFiles(t, ns, "stateful-0", mountPathInContainer, map[string]string{
+		"seed/a.txt":      "seed zero",
+		"seed/nested.txt": "nested zero",
+		"empty.txt":       "",
+	})
+	writeRemoteFiles(t, ns, "stateful-1", mountPathInContainer, map[string]string{
+		"seed/b.txt":       "seed one",
+		"unicode-файл.txt": "unicode ok",
+	})
+	want0 := readRemoteTree(t, ns, "stateful-0", mountPathInContainer)
+	want1 := readRemoteTree(t, ns, "stateful-1", mountPathInContainer)
+
+	archive := filepath.Join(t.TempDir(), "backup.tar.zst")
+	_, err = runCmd(env.BinPath,
+		"download-sts", "stateful",
+		"--namespace", ns,
+		"--archive", archive,
+		"--volume-workers", "2",
+		"--file-workers", "2",
+	)
+	require.NoError(t, err)
+
+	clearRemoteDir(t, ns, "stateful-0", mountPathInContainer)
+	clearRemoteDir(t, ns, "stateful-1", mountPathInContainer)
+
+	_, err = runCmd(env.BinPath,
+		"upload-sts", "stateful",
+		"--namespace", ns,
+		"--src", archive,
+		"--volume-workers", "2",
+		"--file-workers", "2",
+		"--allow-overwrite",
+	)
+	require.NoError(t, err)
+
+	assertTreeMapsEqual(t, want0, readRemoteTree(t, ns, "stateful-0", mountPathInContainer))
+	assertTreeMapsEqual(t, want1, readRemoteTree(t, ns, "stateful-1", mountPathInContainer))
+	assertNoSyncpodResourcesLeft(t, ns)
+}
+
+func TestIntegration_UploadSTS_RestoresIntoAnotherStatefulSet(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeoutPerTest)
+	defer cancel()
+
+	ns := fmt.Sprintf("syncpod-sts-it-%d", time.Now().UnixNano())
+	env := newTestEnv(t, ctx, ns)
+	defer env.Cleanup()
+	targetNs := ns + "-target"
+	targetEnv := newTestEnv(t, ctx, targetNs)
+	defer targetEnv.Cleanup()
+
+	for _, opts := range []statefulSetManifestOpts{
+		{Namespace: ns, Name: "stateful", MountPath: mountPathInContainer, Replicas: 2},
+		{Namespace: targetNs, Name: "restored", MountPath: mountPathInContainer, Replicas: 2},
+	} {
+		_, err := runCmdWithStdin(renderStatefulSetManifest(t, opts), "kubectl", "apply", "-f", "-")
+		require.NoError(t, err)
+	}
+
+	waitStatefulSetReady(t, ns, "stateful")
+	waitStatefulSetReady(t, targetNs, "restored")
+	writeRemoteFiles(t, ns, "stateful-0", mountPathInContainer, map[string]string{
+		"seed/a.txt":      "seed zero",
+		"seed/nested.txt": "nested zero",
+	})
+	writeRemoteFiles(t, ns, "stateful-1", mountPathInContainer, map[string]string{
+		"seed/b.txt": "seed one",
+	})
+
+	backupDir := t.TempDir()
+	_, err := runCmd(env.BinPath,
+		"download-sts", "stateful",
+		"--namespace", ns,
+		"--dst", backupDir,
+		"--volume-workers", "2",
+		"--file-workers", "2",
+	)
+	require.NoError(t, err)
+
+	// stateful-N/data of the backup goes into data-restored-N of the target namespace
+	_, err = runCmd(env.BinPath,
+		"upload-sts", "restored",
+		"--namespace", ns,
+		"--from-sts", "stateful",
+		"--target-namespace", targetNs,
+		"--src", backupDir,
+		"--volume-workers", "2",
+		"--file-workers", "2",
+		"--allow-overwrite",
+	)
+	require.NoError(t, err)
+
+	want0 := buildLocalTreeMap(t, filepath.Join(backupDir, "stateful-0", "data"))
+	want1 := buildLocalTreeMap(t, filepath.Join(backupDir, "stateful-1", "data"))
+	assertTreeMapsEqual(t, want0, readRemoteTree(t, targetNs, "restored-0", mountPathInContainer))
+	assertTreeMapsEqual(t, want1, readRemoteTree(t, targetNs, "restored-1", mountPathInContainer))
+	assertNoSyncpodResourcesLeft(t, ns)
+	assertNoSyncpodResourcesLeft(t, targetNs)
+}
+
 // sts only related helpers
 
 func readStatefulSetBackupManifest(t *testing.T, path string) *kub.StatefulSetBackupManifest {
@@ -131,3 +131,66 @@ func TestIntegration_DownloadDirectoryTree(t *testing.T) {
 	assertTreeMapsEqual(t, want, got)
 	assertNoSyncpodResourcesLeft(t, ns)
 }
+
+func TestIntegration_ExecTransport_RoundTrip(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeoutPerTest)
+	defer cancel()
+
+	ns := fmt.Sprintf("syncpod-it-%d", time.Now().UnixNano())
+
+	manifest := renderStatePodManifest(t,
+		statePodManifestOpts{
+			Namespace: ns,
+			Name:      statePodName,
+			MountPath: mountPathInContainer,
+		},
+	)
+
+	env := newTestEnv(t, ctx, ns)
+	defer env.Cleanup()
+
+	_, err := runCmdWithStdin(manifest, "kubectl", "apply", "-f", "-")
+	require.NoError(t, err)
+
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	writeTestTree(t, srcDir, map[string]string{
+		"base/a.txt":            "hello over exec",
+		"base/nested/b.txt":     "no nodeport needed",
+		"base/empty.txt":        "",
+		"base/spaced name.txt":  "with spaces",
+		"base/deeper/x/y/z.txt": "deep",
+	})
+
+	// both directions go through pods/exec, the helper gets no service
+	waitPodReady(t, ns, statePodName)
+	_, err = runCmd(env.BinPath,
+		"upload",
+		"--namespace", ns,
+		"--pvc", statePodName,
+		"--mount-path", mountPathInContainer,
+		"--src", filepath.Join(srcDir, "base"),
+		"--dst", "payload",
+		"--workers", "2",
+		"--transport", "exec",
+	)
+	require.NoError(t, err)
+
+	want := buildLocalTreeMap(t, filepath.Join(srcDir, "base"))
+	assertTreeMapsEqual(t, want, readRemoteTree(t, ns, statePodName, "/data/payload"))
+
+	_, err = runCmd(env.BinPath,
+		"download",
+		"--namespace", ns,
+		"--pvc", statePodName,
+		"--mount-path", mountPathInContainer,
+		"--src", "payload",
+		"--dst", dstDir,
+		"--workers", "2",
+		"--transport", "exec",
+	)
+	require.NoError(t, err)
+
+	assertTreeMapsEqual(t, want, buildLocalTreeMap(t, dstDir))
+	assertNoSyncpodResourcesLeft(t, ns)
+}